@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/events"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/joho/godotenv"
+	"github.com/kelseyhightower/envconfig"
+)
+
+const usage = `Usage: dead-letters <inspect|requeue> [flags]
+
+Inspect or requeue invoice and payment updates that the LND invoice consumer
+or the payment finalizer failed to process.
+
+  inspect   print dead lettered deliveries as NDJSON, they stay in the dead letter topic
+  requeue   hand dead lettered deliveries back to the consumer that failed to process them
+
+Flags:
+`
+
+// script to inspect and requeue deliveries from the dead letter topic
+func main() {
+	flags := flag.NewFlagSet("dead-letters", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	consumer := flags.String("consumer", "", "only select deliveries of this consumer, e.g. lnd_invoice_consumer")
+	ids := flags.String("id", "", "comma separated list of delivery ids to select")
+	idleTimeout := flags.Duration("idle-timeout", 5*time.Second, "stop when no delivery came in for this long")
+
+	if len(os.Args) < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	command := os.Args[1]
+	if command != "inspect" && command != "requeue" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	flags.Parse(os.Args[2:])
+
+	c := &service.Config{}
+	// Load configruation from environment variables
+	err := godotenv.Load(".env")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load .env file")
+	}
+	err = envconfig.Process("", c)
+	if err != nil {
+		log.Fatalf("Error loading environment variables: %v", err)
+	}
+	logger := lib.Logger(c.LogFilePath)
+	if c.LogFilePath == "" {
+		// keep stdout clean for the NDJSON output
+		logger.SetOutput(os.Stderr)
+	}
+
+	eventsClient, err := service.InitEventsClient(c, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if eventsClient == nil {
+		logger.Fatal("No message broker configured")
+	}
+	// close the connection gently at the end of the runtime
+	defer eventsClient.Close()
+
+	selectedIDs := map[string]bool{}
+	if *ids != "" {
+		for _, id := range strings.Split(*ids, ",") {
+			selectedIDs[id] = true
+		}
+	}
+	selected := func(failed events.FailedDelivery) bool {
+		if *consumer != "" && failed.Consumer != *consumer {
+			return false
+		}
+		return len(selectedIDs) == 0 || selectedIDs[failed.ID]
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	found, requeued := 0, 0
+	err = eventsClient.WalkDeadLetters(context.Background(), *idleTimeout, func(failed events.FailedDelivery) events.DeadLetterAction {
+		if !selected(failed) {
+			return events.DeadLetterKeep
+		}
+		found++
+		err := encoder.Encode(newOutput(failed))
+		if err != nil {
+			logger.Error(err)
+		}
+		if command == "requeue" {
+			requeued++
+			return events.DeadLetterRequeue
+		}
+		return events.DeadLetterKeep
+	})
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Infof("Found %d dead lettered deliveries, requeued %d", found, requeued)
+}
+
+// output shows the body as JSON instead of base64 when possible
+type output struct {
+	events.FailedDelivery
+	Body interface{} `json:"body"`
+}
+
+func newOutput(failed events.FailedDelivery) output {
+	var body interface{} = string(failed.Body)
+	if json.Valid(failed.Body) {
+		body = json.RawMessage(failed.Body)
+	}
+	return output{FailedDelivery: failed, Body: body}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// checkpoint records the progress of a replay, so an interrupted replay can
+// pick up where it left off
+type checkpoint struct {
+	// LastID is the id of the last invoice that was replayed (successfully or not)
+	LastID int64 `json:"last_id"`
+	// FailedIDs are the ids of invoices that could not be replayed
+	FailedIDs []int64 `json:"failed_ids"`
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	cp := &checkpoint{}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, cp)
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// save writes the checkpoint to a temporary file first and renames it,
+// so a crash never leaves a corrupt checkpoint behind
+func (cp *checkpoint) save(path string) error {
+	content, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
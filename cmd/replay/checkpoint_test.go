@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpointLoadAndSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.json")
+
+	// a missing checkpoint starts from the beginning
+	cp, err := loadCheckpoint(path)
+	assert.NoError(t, err)
+	assert.Equal(t, &checkpoint{}, cp)
+
+	cp.LastID = 42
+	cp.FailedIDs = []int64{7, 13}
+	assert.NoError(t, cp.save(path))
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	loaded, err := loadCheckpoint(path)
+	assert.NoError(t, err)
+	assert.Equal(t, cp, loaded)
+}
+
+func TestCheckpointLoadCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.json")
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0644))
+	_, err := loadCheckpoint(path)
+	assert.Error(t, err)
+}
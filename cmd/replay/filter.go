@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/uptrace/bun"
+)
+
+// filter selects the invoices to replay, zero values are ignored
+type filter struct {
+	UserID    int64
+	Login     string
+	Type      string
+	States    []string
+	RHash     string
+	FromID    int64
+	ToID      int64
+	Since     time.Time
+	Until     time.Time
+	TimeField string
+}
+
+func (f filter) Validate() error {
+	switch f.Type {
+	case "", common.InvoiceTypeIncoming, common.InvoiceTypeOutgoing:
+	default:
+		return fmt.Errorf("invalid invoice type %s, expected incoming or outgoing", f.Type)
+	}
+	for _, state := range f.States {
+		switch state {
+		case common.InvoiceStateSettled, common.InvoiceStateInitialized, common.InvoiceStateOpen, common.InvoiceStateError,
+			common.InvoiceStateAccepted, common.InvoiceStateCanceled, common.InvoiceStateExpired:
+		default:
+			return fmt.Errorf("invalid invoice state %s", state)
+		}
+	}
+	switch f.TimeField {
+	case "created_at", "updated_at", "settled_at":
+	default:
+		return fmt.Errorf("invalid time field %s, expected created_at, updated_at or settled_at", f.TimeField)
+	}
+	if f.ToID > 0 && f.FromID > f.ToID {
+		return fmt.Errorf("from id %d is greater than to id %d", f.FromID, f.ToID)
+	}
+	if !f.Since.IsZero() && !f.Until.IsZero() && !f.Since.Before(f.Until) {
+		return fmt.Errorf("since %s is not before until %s", f.Since, f.Until)
+	}
+	return nil
+}
+
+// Apply adds the conditions of the filter to a select on the invoices table
+func (f filter) Apply(query *bun.SelectQuery) *bun.SelectQuery {
+	if f.UserID > 0 {
+		query = query.Where("invoice.user_id = ?", f.UserID)
+	}
+	if f.Login != "" {
+		query = query.Where("invoice.user_id = (SELECT id FROM users WHERE login = ?)", f.Login)
+	}
+	if f.Type != "" {
+		query = query.Where("invoice.type = ?", f.Type)
+	}
+	if len(f.States) > 0 {
+		query = query.Where("invoice.state IN (?)", bun.In(f.States))
+	}
+	if f.RHash != "" {
+		query = query.Where("invoice.r_hash = ?", f.RHash)
+	}
+	if f.FromID > 0 {
+		query = query.Where("invoice.id >= ?", f.FromID)
+	}
+	if f.ToID > 0 {
+		query = query.Where("invoice.id <= ?", f.ToID)
+	}
+	if !f.Since.IsZero() {
+		query = query.Where("? >= ?", bun.Ident("invoice."+f.TimeField), f.Since)
+	}
+	if !f.Until.IsZero() {
+		query = query.Where("? < ?", bun.Ident("invoice."+f.TimeField), f.Until)
+	}
+	return query
+}
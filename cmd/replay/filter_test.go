@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+func TestFilterValidate(t *testing.T) {
+	assert.NoError(t, filter{TimeField: "created_at"}.Validate())
+	assert.NoError(t, filter{
+		Type:      common.InvoiceTypeIncoming,
+		States:    []string{common.InvoiceStateSettled, common.InvoiceStateError},
+		FromID:    1,
+		ToID:      10,
+		Since:     time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Until:     time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
+		TimeField: "settled_at",
+	}.Validate())
+	assert.NoError(t, filter{States: []string{common.InvoiceStateAccepted, common.InvoiceStateCanceled, common.InvoiceStateExpired}, TimeField: "created_at"}.Validate())
+
+	assert.Error(t, filter{Type: "internal", TimeField: "created_at"}.Validate())
+	assert.Error(t, filter{States: []string{"paid"}, TimeField: "created_at"}.Validate())
+	assert.Error(t, filter{TimeField: "expires_at"}.Validate())
+	assert.Error(t, filter{FromID: 10, ToID: 1, TimeField: "created_at"}.Validate())
+	day := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Error(t, filter{Since: day, Until: day, TimeField: "created_at"}.Validate())
+}
+
+func TestFilterApply(t *testing.T) {
+	// the query is only built, the database is never connected to
+	db := bun.NewDB(sql.OpenDB(pgdriver.NewConnector()), pgdialect.New())
+	query := func(f filter) string {
+		return f.Apply(db.NewSelect().Model((*models.Invoice)(nil))).String()
+	}
+
+	assert.NotContains(t, query(filter{TimeField: "created_at"}), "WHERE")
+
+	sql := query(filter{
+		UserID:    1,
+		Login:     "alice",
+		Type:      common.InvoiceTypeOutgoing,
+		States:    []string{common.InvoiceStateSettled, common.InvoiceStateError},
+		RHash:     "abc",
+		FromID:    5,
+		ToID:      50,
+		Since:     time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Until:     time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
+		TimeField: "settled_at",
+	})
+	assert.Contains(t, sql, `invoice.user_id = 1`)
+	assert.Contains(t, sql, `invoice.user_id = (SELECT id FROM users WHERE login = 'alice')`)
+	assert.Contains(t, sql, `invoice.type = 'outgoing'`)
+	assert.Contains(t, sql, `invoice.state IN ('settled', 'error')`)
+	assert.Contains(t, sql, `invoice.r_hash = 'abc'`)
+	assert.Contains(t, sql, `invoice.id >= 5`)
+	assert.Contains(t, sql, `invoice.id <= 50`)
+	assert.Contains(t, sql, `"invoice"."settled_at" >= '2023-01-01 00:00:00+00:00'`)
+	assert.Contains(t, sql, `"invoice"."settled_at" < '2023-01-02 00:00:00+00:00'`)
+}
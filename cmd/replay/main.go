@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/joho/godotenv"
+	"github.com/kelseyhightower/envconfig"
+	"golang.org/x/time/rate"
+)
+
+// script to replay invoice and payment events from the database to the message
+// broker, the webhook or stdout (as NDJSON). This can be used to backfill
+// consumers that missed events, e.g. after an outage.
+//
+// Examples:
+//
+//	replay -target broker -type incoming -state settled -since 2023-01-01T00:00:00Z -until 2023-01-02T00:00:00Z
+//	replay -target stdout -login 2Rp3xKp2hHgNs6GaTqzE -checkpoint replay.json
+//	replay -target webhook -webhook-url https://example.com/hook -from-id 1000 -to-id 2000 -rate 5
+func main() {
+	opts := options{}
+	flag.StringVar(&opts.target, "target", targetStdout, "where to replay the events to: broker, webhook or stdout")
+	flag.StringVar(&opts.webhookUrl, "webhook-url", "", "url to post the events to when using the webhook target (default WEBHOOK_URL)")
+	flag.Int64Var(&opts.filter.UserID, "user-id", 0, "only replay invoices of this user id")
+	flag.StringVar(&opts.filter.Login, "login", "", "only replay invoices of the user with this login")
+	flag.StringVar(&opts.filter.Type, "type", "", "only replay invoices of this type: incoming or outgoing")
+	states := flag.String("state", "", "comma separated list of invoice states to replay, e.g. settled,error")
+	flag.StringVar(&opts.filter.RHash, "hash", "", "only replay the invoice with this payment hash")
+	flag.Int64Var(&opts.filter.FromID, "from-id", 0, "only replay invoices with an id greater than or equal to this one")
+	flag.Int64Var(&opts.filter.ToID, "to-id", 0, "only replay invoices with an id less than or equal to this one")
+	since := flag.String("since", "", "only replay invoices with a time field at or after this RFC3339 timestamp")
+	until := flag.String("until", "", "only replay invoices with a time field before this RFC3339 timestamp")
+	flag.StringVar(&opts.filter.TimeField, "time-field", "created_at", "time field used by -since and -until: created_at, updated_at or settled_at")
+	flag.Float64Var(&opts.rate, "rate", 0, "maximum number of events per second (0 = unlimited)")
+	flag.IntVar(&opts.batchSize, "batch-size", 500, "number of invoices loaded from the database at once")
+	flag.StringVar(&opts.checkpointFile, "checkpoint", "", "file to store progress in, an interrupted replay resumes from it when run again")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "only log the invoices that would be replayed")
+	flag.Parse()
+
+	c := &service.Config{}
+	// Load configruation from environment variables
+	err := godotenv.Load(".env")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load .env file")
+	}
+	err = envconfig.Process("", c)
+	if err != nil {
+		log.Fatalf("Error loading environment variables: %v", err)
+	}
+
+	logger := lib.Logger(c.LogFilePath)
+	if c.LogFilePath == "" {
+		// keep stdout clean for the NDJSON output
+		logger.SetOutput(os.Stderr)
+	}
+
+	if *states != "" {
+		opts.filter.States = strings.Split(*states, ",")
+	}
+	opts.filter.Since, err = parseTime(*since)
+	if err != nil {
+		logger.Fatalf("Invalid -since: %v", err)
+	}
+	opts.filter.Until, err = parseTime(*until)
+	if err != nil {
+		logger.Fatalf("Invalid -until: %v", err)
+	}
+	err = opts.filter.Validate()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if opts.batchSize <= 0 {
+		logger.Fatal("-batch-size must be positive")
+	}
+
+	// Open a DB connection based on the configured DATABASE_URI
+	dbConn, err := db.Open(c)
+	if err != nil {
+		logger.Fatalf("Error initializing db connection: %v", err)
+	}
+	svc := &service.LndhubService{
+		Config:        c,
+		DB:            dbConn,
+		Logger:        logger,
+		InvoicePubSub: service.NewPubsub(),
+	}
+
+	var t target
+	switch opts.target {
+	case targetBroker:
+		eventsClient, err := service.InitEventsClient(c, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if eventsClient == nil {
+			logger.Fatal("No message broker configured")
+		}
+		// close the connection gently at the end of the runtime
+		defer eventsClient.Close()
+		svc.EventsClient = eventsClient
+		t = &brokerTarget{svc: svc}
+	case targetWebhook:
+		url := opts.webhookUrl
+		if url == "" {
+			url = c.WebhookUrl
+		}
+		if url == "" {
+			logger.Fatal("No webhook url configured, use -webhook-url or WEBHOOK_URL")
+		}
+		t = &webhookTarget{svc: svc, url: url}
+	case targetStdout:
+		t = &stdoutTarget{svc: svc, w: os.Stdout}
+	default:
+		logger.Fatalf("Unknown target %s", opts.target)
+	}
+
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if opts.rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.rate), 1)
+	}
+
+	progress := &checkpoint{}
+	if opts.checkpointFile != "" {
+		progress, err = loadCheckpoint(opts.checkpointFile)
+		if err != nil {
+			logger.Fatalf("Error loading checkpoint: %v", err)
+		}
+		if progress.LastID > 0 {
+			logger.Infof("Resuming after invoice id %d", progress.LastID)
+		}
+	}
+
+	// stop after the current invoice on ctrl-c, the checkpoint is saved on the way out
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	r := &replayer{
+		svc:        svc,
+		source:     &dbSource{db: dbConn, filter: opts.filter},
+		target:     t,
+		limiter:    limiter,
+		batchSize:  opts.batchSize,
+		dryRun:     opts.dryRun,
+		checkpoint: progress,
+		save: func(cp *checkpoint) error {
+			if opts.checkpointFile == "" {
+				return nil
+			}
+			return cp.save(opts.checkpointFile)
+		},
+	}
+	summary, err := r.run(ctx)
+	logger.Infof("Replayed %d invoices to %s: %d succeeded, %d failed", summary.Total(), opts.target, summary.Succeeded, len(summary.FailedIDs))
+	if len(summary.FailedIDs) > 0 {
+		logger.Infof("Failed invoice ids: %v", summary.FailedIDs)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if len(summary.FailedIDs) > 0 {
+		os.Exit(1)
+	}
+}
+
+type options struct {
+	target         string
+	webhookUrl     string
+	filter         filter
+	rate           float64
+	batchSize      int
+	checkpointFile string
+	dryRun         bool
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
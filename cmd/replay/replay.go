@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/uptrace/bun"
+	"golang.org/x/time/rate"
+)
+
+// source loads the invoices to replay in order of their id
+type source interface {
+	Invoices(ctx context.Context, afterID int64, limit int) ([]models.Invoice, error)
+}
+
+// dbSource loads the invoices matching the filter from the database
+type dbSource struct {
+	db     *bun.DB
+	filter filter
+}
+
+func (s *dbSource) Invoices(ctx context.Context, afterID int64, limit int) ([]models.Invoice, error) {
+	invoices := []models.Invoice{}
+	query := s.db.NewSelect().Model(&invoices)
+	err := s.filter.Apply(query).
+		Where("invoice.id > ?", afterID).
+		Order("invoice.id ASC").
+		Limit(limit).
+		Scan(ctx)
+	return invoices, err
+}
+
+type replayer struct {
+	svc        *service.LndhubService
+	source     source
+	target     target
+	limiter    *rate.Limiter
+	batchSize  int
+	dryRun     bool
+	checkpoint *checkpoint
+	save       func(*checkpoint) error
+}
+
+type summary struct {
+	Succeeded int
+	FailedIDs []int64
+}
+
+func (s summary) Total() int {
+	return s.Succeeded + len(s.FailedIDs)
+}
+
+// run replays all invoices matching the filter in order of their id. Invoices
+// are loaded in batches after the last id of the checkpoint, and the checkpoint
+// is saved after every batch and when the replay is interrupted.
+func (r *replayer) run(ctx context.Context) (result summary, err error) {
+	defer func() {
+		saveErr := r.saveCheckpoint()
+		if err == nil {
+			err = saveErr
+		}
+	}()
+
+	for {
+		var invoices []models.Invoice
+		invoices, err = r.source.Invoices(ctx, r.checkpoint.LastID, r.batchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(invoices) == 0 {
+			return result, nil
+		}
+
+		for _, invoice := range invoices {
+			err = r.limiter.Wait(ctx)
+			if err != nil {
+				return result, err
+			}
+			r.svc.Logger.Infof("Replaying invoice id %d with hash %s", invoice.ID, invoice.RHash)
+			if !r.dryRun {
+				sendErr := r.target.Send(ctx, invoice)
+				if sendErr != nil {
+					r.svc.Logger.Errorf("Error replaying invoice id %d: %v", invoice.ID, sendErr)
+					result.FailedIDs = append(result.FailedIDs, invoice.ID)
+					r.checkpoint.FailedIDs = append(r.checkpoint.FailedIDs, invoice.ID)
+					r.checkpoint.LastID = invoice.ID
+					continue
+				}
+			}
+			result.Succeeded++
+			r.checkpoint.LastID = invoice.ID
+		}
+
+		err = r.saveCheckpoint()
+		if err != nil {
+			return result, err
+		}
+	}
+}
+
+// saveCheckpoint saves the progress, a dry run replays nothing and leaves the checkpoint as it was
+func (r *replayer) saveCheckpoint() error {
+	if r.dryRun {
+		return nil
+	}
+	return r.save(r.checkpoint)
+}
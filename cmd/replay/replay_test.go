@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+// fakeSource serves the invoices with the given ids
+type fakeSource struct {
+	ids      []int64
+	afterIDs []int64
+}
+
+func (s *fakeSource) Invoices(ctx context.Context, afterID int64, limit int) ([]models.Invoice, error) {
+	s.afterIDs = append(s.afterIDs, afterID)
+	invoices := []models.Invoice{}
+	for _, id := range s.ids {
+		if id > afterID && len(invoices) < limit {
+			invoices = append(invoices, models.Invoice{ID: id})
+		}
+	}
+	return invoices, nil
+}
+
+// fakeTarget fails for the ids in fail
+type fakeTarget struct {
+	fail map[int64]bool
+	sent []int64
+}
+
+func (t *fakeTarget) Send(ctx context.Context, invoice models.Invoice) error {
+	if t.fail[invoice.ID] {
+		return errors.New("target unavailable")
+	}
+	t.sent = append(t.sent, invoice.ID)
+	return nil
+}
+
+func newTestReplayer(src source, dst target, cp *checkpoint, path string) *replayer {
+	return &replayer{
+		svc:        &service.LndhubService{Logger: lib.Logger("")},
+		source:     src,
+		target:     dst,
+		limiter:    rate.NewLimiter(rate.Inf, 1),
+		batchSize:  2,
+		checkpoint: cp,
+		save: func(cp *checkpoint) error {
+			return cp.save(path)
+		},
+	}
+}
+
+func TestReplayerRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.json")
+	src := &fakeSource{ids: []int64{1, 2, 3, 4, 5}}
+	dst := &fakeTarget{fail: map[int64]bool{2: true, 5: true}}
+	r := newTestReplayer(src, dst, &checkpoint{}, path)
+
+	result, err := r.run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Succeeded)
+	assert.Equal(t, []int64{2, 5}, result.FailedIDs)
+	assert.Equal(t, 5, result.Total())
+	assert.Equal(t, []int64{1, 3, 4}, dst.sent)
+	// batches of 2 are loaded after the last id of the previous one
+	assert.Equal(t, []int64{0, 2, 4, 5}, src.afterIDs)
+
+	cp, err := loadCheckpoint(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), cp.LastID)
+	assert.Equal(t, []int64{2, 5}, cp.FailedIDs)
+}
+
+func TestReplayerResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.json")
+	src := &fakeSource{ids: []int64{1, 2, 3, 4, 5}}
+	dst := &fakeTarget{}
+	r := newTestReplayer(src, dst, &checkpoint{LastID: 3, FailedIDs: []int64{2}}, path)
+
+	result, err := r.run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Succeeded)
+	assert.Empty(t, result.FailedIDs)
+	assert.Equal(t, []int64{4, 5}, dst.sent)
+
+	// the failures of the interrupted replay are kept
+	cp, err := loadCheckpoint(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), cp.LastID)
+	assert.Equal(t, []int64{2}, cp.FailedIDs)
+}
+
+func TestReplayerDryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.json")
+	assert.NoError(t, (&checkpoint{LastID: 1}).save(path))
+	src := &fakeSource{ids: []int64{1, 2, 3}}
+	dst := &fakeTarget{}
+	r := newTestReplayer(src, dst, &checkpoint{LastID: 1}, path)
+	r.dryRun = true
+
+	result, err := r.run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Succeeded)
+	assert.Empty(t, dst.sent)
+
+	// a dry run doesn't advance the checkpoint
+	cp, err := loadCheckpoint(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), cp.LastID)
+}
+
+func TestReplayerCanceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.json")
+	src := &fakeSource{ids: []int64{1, 2, 3}}
+	r := newTestReplayer(src, &fakeTarget{}, &checkpoint{}, path)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := r.run(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	// the checkpoint is saved on the way out
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
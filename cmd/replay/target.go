@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/service"
+)
+
+const (
+	targetBroker  = "broker"
+	targetWebhook = "webhook"
+	targetStdout  = "stdout"
+)
+
+// target receives the replayed invoices
+type target interface {
+	Send(ctx context.Context, invoice models.Invoice) error
+}
+
+// brokerTarget publishes invoices to the lndhub invoice topic, just like the
+// invoice publisher of the server does
+type brokerTarget struct {
+	svc *service.LndhubService
+}
+
+func (t *brokerTarget) Send(ctx context.Context, invoice models.Invoice) error {
+	return t.svc.EventsClient.PublishInvoice(ctx, invoice, t.svc.EncodeInvoiceWithUserLogin)
+}
+
+type webhookTarget struct {
+	svc *service.LndhubService
+	url string
+}
+
+func (t *webhookTarget) Send(ctx context.Context, invoice models.Invoice) error {
+	return t.svc.PostInvoiceToWebhook(ctx, invoice, t.url)
+}
+
+// stdoutTarget writes the webhook payload of every invoice as a line of JSON
+type stdoutTarget struct {
+	svc *service.LndhubService
+	w   io.Writer
+}
+
+func (t *stdoutTarget) Send(ctx context.Context, invoice models.Invoice) error {
+	// encode into a buffer first so a failing lookup doesn't leave half a line behind
+	buf := new(bytes.Buffer)
+	err := t.svc.EncodeInvoiceWithUserLogin(ctx, buf, invoice)
+	if err != nil {
+		return err
+	}
+	_, err = t.w.Write(buf.Bytes())
+	return err
+}
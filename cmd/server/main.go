@@ -11,7 +11,6 @@ import (
 	"time"
 
 	"github.com/getAlby/lndhub.go/lnd"
-	"github.com/getAlby/lndhub.go/rabbitmq"
 	ddEcho "gopkg.in/DataDog/dd-trace-go.v1/contrib/labstack/echo.v4"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 
@@ -19,6 +18,7 @@ import (
 	"github.com/getAlby/lndhub.go/db/migrations"
 	"github.com/getAlby/lndhub.go/docs"
 	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/ratelimit"
 	"github.com/getAlby/lndhub.go/lib/service"
 	"github.com/getAlby/lndhub.go/lib/tokens"
 	"github.com/getAlby/lndhub.go/lib/transport"
@@ -105,40 +105,42 @@ func main() {
 
 	logger.Infof("Connected to %s: %s", lnCfg.LNClientType, lndClient.GetMainPubkey())
 
-	// If no RABBITMQ_URI was provided we will not attempt to create a client
-	// No rabbitmq features will be available in this case.
-	var rabbitmqClient rabbitmq.Client
-	if c.RabbitMQUri != "" {
-		amqpClient, err := rabbitmq.DialAMQP(c.RabbitMQUri, rabbitmq.WithAmqpLogger(logger))
-		if err != nil {
-			logger.Fatal(err)
-		}
-
-		defer amqpClient.Close()
+	// If no message broker was configured we will not attempt to create a client
+	// No broker features will be available in this case.
+	eventsClient, err := service.InitEventsClient(c, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if eventsClient != nil {
+		// close the connection gently at the end of the runtime
+		defer eventsClient.Close()
+	}
 
-		rabbitmqClient, err = rabbitmq.NewClient(amqpClient,
-			rabbitmq.WithLogger(logger),
-			rabbitmq.WithLndInvoiceExchange(c.RabbitMQLndInvoiceExchange),
-			rabbitmq.WithLndHubInvoiceExchange(c.RabbitMQLndhubInvoiceExchange),
-			rabbitmq.WithLndInvoiceConsumerQueueName(c.RabbitMQInvoiceConsumerQueueName),
-			rabbitmq.WithLndPaymentExchange(c.RabbitMQLndPaymentExchange),
-			rabbitmq.WithLndPaymentConsumerQueueName(c.RabbitMQPaymentConsumerQueueName),
-		)
-		if err != nil {
-			logger.Fatal(err)
-		}
+	// Track failed logins to lock out brute-force attacks on /auth
+	loginGuard, err := service.InitLoginGuard(c, dbConn)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
-		// close the connection gently at the end of the runtime
-		defer rabbitmqClient.Close()
+	// Rate limits shared by all replicas if RATE_LIMIT_STORE is postgres or redis
+	rateLimiter, err := service.InitRateLimiter(c, dbConn)
+	if err != nil {
+		logger.Fatal(err)
 	}
 
 	svc := &service.LndhubService{
-		Config:         c,
-		DB:             dbConn,
-		LndClient:      lndClient,
-		Logger:         logger,
-		InvoicePubSub:  service.NewPubsub(),
-		RabbitMQClient: rabbitmqClient,
+		Config:        c,
+		DB:            dbConn,
+		LndClient:     lndClient,
+		Logger:        logger,
+		InvoicePubSub: service.NewPubsub(),
+		EventsClient:  eventsClient,
+		LoginGuard:    loginGuard,
+		RateLimiter:   rateLimiter,
+	}
+	// Publish switches of the active cluster node
+	if cluster, ok := lndClient.(lnd.ClusterAdmin); ok && eventsClient != nil {
+		cluster.SetSwitchHook(svc.PublishNodeSwitch)
 	}
 
 	//init echo server
@@ -152,12 +154,22 @@ func main() {
 
 	logMw := transport.CreateLoggingMiddleware(logger)
 	// strict rate limit for requests for sending payments
-	strictRateLimitMiddleware := transport.CreateRateLimitMiddleware(c.StrictRateLimit, c.BurstRateLimit)
-	secured := e.Group("", tokens.Middleware(c.JWTSecret), svc.ValidateUserMiddleware(), logMw)
-	securedWithStrictRateLimit := e.Group("", tokens.Middleware(c.JWTSecret), svc.ValidateUserMiddleware(), strictRateLimitMiddleware, logMw)
+	strictRateLimitMiddleware := transport.CreateRateLimitMiddleware(rateLimiter, ratelimit.ClassPayments, ratelimit.Limit{Rate: float64(c.StrictRateLimit), Burst: c.BurstRateLimit})
+	// rate limit per user or API key for all other authenticated requests
+	apiRateLimitMiddleware := transport.CreateRateLimitMiddleware(rateLimiter, ratelimit.ClassAPI, ratelimit.Limit{Rate: float64(c.ApiRateLimit), Burst: c.ApiBurstRateLimit})
+	authMiddleware := tokens.Middleware(c.JWTSecret)
+	// requests can also be signed with a linked nostr key instead of an access token
+	if c.NostrAuthEnabled {
+		if c.PublicUrl == "" {
+			logger.Fatal("PUBLIC_URL is required for nostr auth")
+		}
+		authMiddleware = svc.NostrAuth().Middleware(svc.NostrAuthUser, authMiddleware)
+	}
+	secured := e.Group("", authMiddleware, svc.ValidateUserMiddleware(), apiRateLimitMiddleware, logMw)
+	securedWithStrictRateLimit := e.Group("", authMiddleware, svc.ValidateUserMiddleware(), strictRateLimitMiddleware, logMw)
 
-	transport.RegisterLegacyEndpoints(svc, e, secured, securedWithStrictRateLimit, strictRateLimitMiddleware, tokens.AdminTokenMiddleware(c.AdminToken), logMw)
-	transport.RegisterV2Endpoints(svc, e, secured, securedWithStrictRateLimit, strictRateLimitMiddleware, tokens.AdminTokenMiddleware(c.AdminToken), logMw)
+	transport.RegisterLegacyEndpoints(svc, e, secured, securedWithStrictRateLimit, strictRateLimitMiddleware, logMw)
+	transport.RegisterV2Endpoints(svc, e, secured, securedWithStrictRateLimit, strictRateLimitMiddleware, logMw)
 
 	//Swagger API spec
 	docs.SwaggerInfo.Host = c.Host
@@ -192,6 +204,54 @@ func main() {
 		backgroundWg.Done()
 	}()
 
+	// Expire open invoices and clean up payments that never reached LND
+	backgroundWg.Add(1)
+	go func() {
+		err = svc.StartInvoiceSweepRoutine(backGroundCtx)
+		if err != nil {
+			sentry.CaptureException(err)
+			svc.Logger.Error(err)
+		}
+		svc.Logger.Info("Invoice sweep routine done")
+		backgroundWg.Done()
+	}()
+
+	// Make the scheduled payments that are due
+	backgroundWg.Add(1)
+	go func() {
+		err = svc.StartScheduledPaymentRoutine(backGroundCtx)
+		if err != nil {
+			sentry.CaptureException(err)
+			svc.Logger.Error(err)
+		}
+		svc.Logger.Info("Scheduled payment routine done")
+		backgroundWg.Done()
+	}()
+
+	// Forget old failed logins
+	backgroundWg.Add(1)
+	go func() {
+		err = svc.StartLoginGuardPruneRoutine(backGroundCtx)
+		if err != nil {
+			sentry.CaptureException(err)
+			svc.Logger.Error(err)
+		}
+		svc.Logger.Info("Login guard prune routine done")
+		backgroundWg.Done()
+	}()
+
+	// Forget full rate limit buckets
+	backgroundWg.Add(1)
+	go func() {
+		err = svc.StartRateLimitPruneRoutine(backGroundCtx)
+		if err != nil {
+			sentry.CaptureException(err)
+			svc.Logger.Error(err)
+		}
+		svc.Logger.Info("Rate limit prune routine done")
+		backgroundWg.Done()
+	}()
+
 	//Start webhook subscription
 	if svc.Config.WebhookUrl != "" {
 		backgroundWg.Add(1)
@@ -201,11 +261,11 @@ func main() {
 			backgroundWg.Done()
 		}()
 	}
-	//Start rabbit publisher
-	if svc.RabbitMQClient != nil {
+	//Start broker publisher
+	if svc.EventsClient != nil {
 		backgroundWg.Add(1)
 		go func() {
-			err = svc.RabbitMQClient.StartPublishInvoices(backGroundCtx,
+			err = svc.EventsClient.StartPublishInvoices(backGroundCtx,
 				svc.SubscribeIncomingOutgoingInvoices,
 				svc.EncodeInvoiceWithUserLogin,
 			)
@@ -214,7 +274,7 @@ func main() {
 				sentry.CaptureException(err)
 			}
 
-			svc.Logger.Info("Rabbit invoice publisher done")
+			svc.Logger.Info("Broker invoice publisher done")
 			backgroundWg.Done()
 		}()
 	}
@@ -10,11 +10,15 @@ const (
 	InvoiceStateInitialized = "initialized"
 	InvoiceStateOpen        = "open"
 	InvoiceStateError       = "error"
+	InvoiceStateAccepted    = "accepted"
+	InvoiceStateCanceled    = "canceled"
+	InvoiceStateExpired     = "expired"
 
 	AccountTypeIncoming = "incoming"
 	AccountTypeCurrent  = "current"
 	AccountTypeOutgoing = "outgoing"
 	AccountTypeFees     = "fees"
+	AccountTypeOperator = "operator"
 
 	DestinationPubkeyHexSize = 66
 )
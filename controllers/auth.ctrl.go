@@ -1,7 +1,10 @@
 package controllers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/getAlby/lndhub.go/lib/responses"
 	"github.com/getAlby/lndhub.go/lib/service"
@@ -24,21 +27,33 @@ type AuthRequestBody struct {
 	Login        string `json:"login"`
 	Password     string `json:"password"`
 	RefreshToken string `json:"refresh_token"`
+	// Code is the TOTP or a recovery code of users that enrolled two-factor authentication
+	Code string `json:"code"`
 }
 type AuthResponseBody struct {
 	RefreshToken string `json:"refresh_token"`
 	AccessToken  string `json:"access_token"`
 }
 
+// AuthErrorResponseBody tells clients to ask for a captcha before the next attempt after repeated failed logins,
+// or for a TOTP code if the user enrolled two-factor authentication
+type AuthErrorResponseBody struct {
+	responses.ErrorResponse
+	CaptchaRequired bool `json:"captcha_required,omitempty"`
+	TOTPRequired    bool `json:"totp_required,omitempty"`
+}
+
 // Auth godoc
 // @Summary      Authenticate
-// @Description  Exchanges a login + password for a token
+// @Description  Exchanges a login + password for a token, users that enrolled TOTP also need a code
 // @Accept       json
 // @Produce      json
 // @Tags         Account
 // @Param        AuthRequestBody  body      AuthRequestBody  false  "Login and password"
 // @Success      200              {object}  AuthResponseBody
 // @Failure      400              {object}  responses.ErrorResponse
+// @Failure      401              {object}  AuthErrorResponseBody
+// @Failure      429              {object}  AuthErrorResponseBody
 // @Failure      500              {object}  responses.ErrorResponse
 // @Router       /auth [post]
 func (controller *AuthController) Auth(c echo.Context) error {
@@ -79,8 +94,30 @@ func (controller *AuthController) Auth(c echo.Context) error {
 		}
 	}
 
-	accessToken, refreshToken, err := controller.svc.GenerateToken(c.Request().Context(), body.Login, body.Password, body.RefreshToken)
+	ctx := service.WithTOTPCode(service.WithClientIP(c.Request().Context(), c.RealIP()), body.Code)
+	accessToken, refreshToken, err := controller.svc.GenerateToken(ctx, body.Login, body.Password, body.RefreshToken)
 	if err != nil {
+		var attemptErr *service.LoginAttemptError
+		errors.As(err, &attemptErr)
+		if attemptErr != nil && attemptErr.Locked {
+			c.Logger().Errorj(
+				log.JSON{
+					"message":    "login locked",
+					"user_login": body.Login,
+				},
+			)
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(attemptErr.RetryAfter.Seconds()))))
+			return c.JSON(http.StatusTooManyRequests, &AuthErrorResponseBody{
+				ErrorResponse:   responses.LoginLockedError,
+				CaptchaRequired: attemptErr.CaptchaRequired,
+			})
+		}
+		if errors.Is(err, service.ErrTOTPRequired) {
+			return c.JSON(http.StatusUnauthorized, &AuthErrorResponseBody{
+				ErrorResponse: responses.TOTPRequiredError,
+				TOTPRequired:  true,
+			})
+		}
 		if err.Error() == responses.AccountDeactivatedError.Message {
 			c.Logger().Errorj(
 				log.JSON{
@@ -97,7 +134,10 @@ func (controller *AuthController) Auth(c echo.Context) error {
 				"error":      err,
 			},
 		)
-		return c.JSON(http.StatusUnauthorized, responses.BadAuthError)
+		return c.JSON(http.StatusUnauthorized, &AuthErrorResponseBody{
+			ErrorResponse:   responses.BadAuthError,
+			CaptchaRequired: attemptErr != nil && attemptErr.CaptchaRequired,
+		})
 	}
 
 	return c.JSON(http.StatusOK, &AuthResponseBody{
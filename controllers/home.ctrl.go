@@ -65,7 +65,12 @@ func (controller *HomeController) QR(c echo.Context) error {
 	customPath := strings.Replace(c.Request().URL.Path, "/qr", "", 1)
 	encoded := url.QueryEscape(fmt.Sprintf("%s://%s%s", c.Request().URL.Scheme, c.Request().Host, customPath))
 	url := fmt.Sprintf("bluewallet:setlndhuburl?url=%s", encoded)
-	png, err := qrcode.Encode(url, qrcode.Medium, 256)
+	return QRCode(c, url)
+}
+
+// QRCode responds with a PNG image of a QR code of content
+func QRCode(c echo.Context, content string) error {
+	png, err := qrcode.Encode(content, qrcode.Medium, 256)
 	if err != nil {
 		c.Logger().Errorf("Error encoding QR: %v", err)
 		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
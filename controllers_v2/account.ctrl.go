@@ -0,0 +1,242 @@
+package v2controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/security"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// AccountController : Self-service account controller struct
+type AccountController struct {
+	svc *service.LndhubService
+}
+
+func NewAccountController(svc *service.LndhubService) *AccountController {
+	return &AccountController{svc: svc}
+}
+
+type AccountResponseBody struct {
+	ID      int64  `json:"id"`
+	Login   string `json:"login"`
+	Deleted bool   `json:"deleted"`
+}
+
+type ChangePasswordRequestBody struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+type ChangeLoginRequestBody struct {
+	Password string `json:"password" validate:"required"`
+	Login    string `json:"login" validate:"required"`
+}
+
+type DeleteAccountRequestBody struct {
+	Password string `json:"password" validate:"required"`
+	// Invoice receives the balance before the account is deleted, amountless invoices are paid the whole balance
+	Invoice string `json:"invoice"`
+}
+
+type DeleteAccountResponseBody struct {
+	AccountResponseBody
+	Payment *PayInvoiceResponseBody `json:"payment,omitempty"`
+}
+
+// ChangePassword godoc
+// @Summary      Change the password
+// @Description  Sets a new password after checking the current one. The new password must have MIN_PASSWORD_ENTROPY. Users that require TOTP codes on password changes send one in the X-TOTP-Code header.
+// @Accept       json
+// @Produce      json
+// @Tags         Account
+// @Param        ChangePasswordRequestBody  body      ChangePasswordRequestBody  True  "Current and new password"
+// @Success      200                        {object}  AccountResponseBody
+// @Failure      400                        {object}  responses.ErrorResponse
+// @Failure      401                        {object}  responses.ErrorResponse
+// @Failure      403                        {object}  responses.ErrorResponse
+// @Failure      429                        {object}  responses.ErrorResponse
+// @Failure      500                        {object}  responses.ErrorResponse
+// @Router       /v2/account/password [put]
+// @Security     OAuth2Password
+func (controller *AccountController) ChangePassword(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body ChangePasswordRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load change password request body user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid change password request body user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	ctx := service.WithClientIP(c.Request().Context(), c.RealIP())
+	user, err := controller.svc.ChangePassword(ctx, userID, body.CurrentPassword, body.NewPassword, c.Request().Header.Get(service.TOTPCodeHeader))
+	if err != nil {
+		c.Logger().Errorf("Failed to change password user_id:%v error: %v", userID, err)
+		return accountError(c, err)
+	}
+	return c.JSON(http.StatusOK, &AccountResponseBody{ID: user.ID, Login: user.Login, Deleted: user.Deleted})
+}
+
+// ChangeLogin godoc
+// @Summary      Change the login
+// @Description  Sets a new login after checking the password
+// @Accept       json
+// @Produce      json
+// @Tags         Account
+// @Param        ChangeLoginRequestBody  body      ChangeLoginRequestBody  True  "Password and new login"
+// @Success      200                     {object}  AccountResponseBody
+// @Failure      400                     {object}  responses.ErrorResponse
+// @Failure      403                     {object}  responses.ErrorResponse
+// @Failure      429                     {object}  responses.ErrorResponse
+// @Failure      500                     {object}  responses.ErrorResponse
+// @Router       /v2/account/login [put]
+// @Security     OAuth2Password
+func (controller *AccountController) ChangeLogin(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body ChangeLoginRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load change login request body user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid change login request body user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	ctx := service.WithClientIP(c.Request().Context(), c.RealIP())
+	user, err := controller.svc.ChangeLogin(ctx, userID, body.Password, body.Login)
+	if err != nil {
+		c.Logger().Errorf("Failed to change login user_id:%v error: %v", userID, err)
+		return accountError(c, err)
+	}
+	return c.JSON(http.StatusOK, &AccountResponseBody{ID: user.ID, Login: user.Login, Deleted: user.Deleted})
+}
+
+// DeleteAccount godoc
+// @Summary      Delete the account
+// @Description  Deletes the account after checking the password. All wallets must be empty, or the balance of the default wallet is swept to the invoice first. Payments above the TOTP payment threshold need a code in the X-TOTP-Code header.
+// @Accept       json
+// @Produce      json
+// @Tags         Account
+// @Param        DeleteAccountRequestBody  body      DeleteAccountRequestBody  True  "Password and optional invoice for the balance"
+// @Success      200                       {object}  DeleteAccountResponseBody
+// @Failure      400                       {object}  responses.ErrorResponse
+// @Failure      401                       {object}  responses.ErrorResponse
+// @Failure      403                       {object}  responses.ErrorResponse
+// @Failure      429                       {object}  responses.ErrorResponse
+// @Failure      500                       {object}  responses.ErrorResponse
+// @Router       /v2/account/delete [post]
+// @Security     OAuth2Password
+func (controller *AccountController) DeleteAccount(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body DeleteAccountRequestBody
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load delete account request body user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid delete account request body user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	ctx := service.WithClientIP(c.Request().Context(), c.RealIP())
+	if _, err := controller.svc.CheckUserPassword(ctx, userID, body.Password); err != nil {
+		c.Logger().Errorf("Failed to delete account user_id:%v error: %v", userID, err)
+		return accountError(c, err)
+	}
+	responseBody := &DeleteAccountResponseBody{}
+	maxRemainder := int64(0)
+	if body.Invoice != "" {
+		payment, remainder, errResp := controller.sweep(c, userID, strings.ToLower(body.Invoice))
+		if errResp != nil {
+			return c.JSON(errResp.HttpStatusCode, errResp)
+		}
+		responseBody.Payment = payment
+		maxRemainder = remainder
+	}
+	user, err := controller.svc.DeleteAccount(ctx, userID, maxRemainder)
+	if err != nil {
+		c.Logger().Errorf("Failed to delete account user_id:%v error: %v", userID, err)
+		return accountError(c, err)
+	}
+	responseBody.AccountResponseBody = AccountResponseBody{ID: user.ID, Login: user.Login, Deleted: user.Deleted}
+	return c.JSON(http.StatusOK, responseBody)
+}
+
+// sweep pays the balance of the default wallet to the invoice like PayInvoice,
+// it returns the balance that may be left after the payment
+func (controller *AccountController) sweep(c echo.Context, userID int64, paymentRequest string) (*PayInvoiceResponseBody, int64, *responses.ErrorResponse) {
+	ctx := c.Request().Context()
+	lnPayReq, errResp := decodeBolt11(c, controller.svc, paymentRequest, userID)
+	if errResp != nil {
+		return nil, 0, errResp
+	}
+	remainder, err := controller.svc.PrepareSweep(ctx, userID, lnPayReq)
+	if err != nil {
+		c.Logger().Errorf("Failed to sweep balance user_id:%v error: %v", userID, err)
+		return nil, 0, accountErrorResponse(err)
+	}
+	resp, err := controller.svc.CheckOutgoingPaymentAllowed(c, lnPayReq, userID)
+	if err != nil {
+		return nil, 0, &responses.GeneralServerError
+	}
+	if resp != nil {
+		c.Logger().Errorf("Error: %v user_id:%v amount:%v", resp.Message, userID, lnPayReq.PayReq.NumSatoshis)
+		return nil, 0, resp
+	}
+	invoice, errResp := controller.svc.AddOutgoingInvoice(ctx, userID, paymentRequest, lnPayReq)
+	if errResp != nil {
+		return nil, 0, errResp
+	}
+	sendPaymentResponse, err := controller.svc.PayInvoice(ctx, invoice)
+	if err != nil {
+		c.Logger().Errorf("Sweep payment failed invoice_id:%v user_id:%v error: %v", invoice.ID, userID, err)
+		return nil, 0, &responses.ErrorResponse{
+			Error:          true,
+			Code:           10,
+			Message:        err.Error(),
+			HttpStatusCode: http.StatusInternalServerError,
+		}
+	}
+	return &PayInvoiceResponseBody{
+		PaymentRequest:  paymentRequest,
+		Amount:          invoice.Amount,
+		Fee:             invoice.Fee,
+		Description:     invoice.Memo,
+		DescriptionHash: invoice.DescriptionHash,
+		Destination:     invoice.DestinationPubkeyHex,
+		PaymentPreimage: sendPaymentResponse.PaymentPreimageStr,
+		PaymentHash:     sendPaymentResponse.PaymentHashStr,
+	}, remainder, nil
+}
+
+func accountError(c echo.Context, err error) error {
+	errResp := accountErrorResponse(err)
+	return c.JSON(errResp.HttpStatusCode, errResp)
+}
+
+func accountErrorResponse(err error) *responses.ErrorResponse {
+	switch {
+	case errors.Is(err, service.ErrTOTPRequired):
+		return &responses.TOTPRequiredError
+	case errors.Is(err, service.ErrInvalidTOTPCode):
+		return &responses.InvalidTOTPCodeError
+	case errors.Is(err, service.ErrLoginLocked):
+		return &responses.LoginLockedError
+	case errors.Is(err, service.ErrWrongPassword),
+		errors.Is(err, security.ErrWeakPassword),
+		errors.Is(err, service.ErrLoginTaken),
+		errors.Is(err, service.ErrBalanceNotZero),
+		errors.Is(err, service.ErrInvalidSweep):
+		return &responses.ErrorResponse{
+			Error:          true,
+			Code:           8,
+			Message:        err.Error(),
+			HttpStatusCode: http.StatusBadRequest,
+		}
+	}
+	return &responses.GeneralServerError
+}
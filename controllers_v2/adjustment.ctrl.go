@@ -0,0 +1,160 @@
+package v2controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// AdjustmentController : Ledger adjustment admin controller struct
+type AdjustmentController struct {
+	svc *service.LndhubService
+}
+
+func NewAdjustmentController(svc *service.LndhubService) *AdjustmentController {
+	return &AdjustmentController{svc: svc}
+}
+
+type AdjustmentRequestBody struct {
+	UserID    int64  `json:"user_id" validate:"required"`
+	WalletID  int64  `json:"wallet_id" validate:"gte=0"`
+	Type      string `json:"type" validate:"required,oneof=credit debit"`
+	Amount    int64  `json:"amount" validate:"required,gt=0"`
+	Reason    string `json:"reason" validate:"required"`
+	Reference string `json:"reference" validate:"required"`
+}
+
+type RefundRequestBody struct {
+	UserID      int64  `json:"user_id" validate:"required"`
+	PaymentHash string `json:"payment_hash" validate:"required"`
+	Reason      string `json:"reason" validate:"required"`
+	Reference   string `json:"reference" validate:"required"`
+}
+
+// AdjustBalance godoc
+// @Summary      Credit or debit a user
+// @Description  Manually credits or debits the balance of a user from the operator account, with a reason and a reference for the audit trail. A debit can't exceed the balance. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        AdjustmentRequestBody  body      AdjustmentRequestBody  True  "Adjustment to make"
+// @Success      200                    {object}  models.LedgerAdjustment
+// @Failure      400                    {object}  responses.ErrorResponse
+// @Failure      404                    {object}  responses.ErrorResponse
+// @Failure      500                    {object}  responses.ErrorResponse
+// @Router       /v2/admin/adjustments [post]
+func (controller *AdjustmentController) AdjustBalance(c echo.Context) error {
+	reqBody := AdjustmentRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load adjustment request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid adjustment request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if reqBody.Type == models.LedgerAdjustmentTypeDebit {
+		// the DB constraints make sure the balance is enough, this check only gives a better error
+		balance, err := controller.svc.WalletBalance(c.Request().Context(), reqBody.UserID, reqBody.WalletID)
+		if err == nil && balance < reqBody.Amount {
+			return adjustmentError(c, service.ErrNotEnoughBalance)
+		}
+	}
+	adjustment, err := controller.svc.AdjustBalance(c.Request().Context(), service.LedgerAdjustment{
+		UserID:    reqBody.UserID,
+		WalletID:  reqBody.WalletID,
+		Type:      reqBody.Type,
+		Amount:    reqBody.Amount,
+		Reason:    reqBody.Reason,
+		Reference: reqBody.Reference,
+	})
+	if err != nil {
+		c.Logger().Errorf("Failed to adjust balance user_id:%v error: %v", reqBody.UserID, err)
+		return adjustmentError(c, err)
+	}
+	return c.JSON(http.StatusOK, adjustment)
+}
+
+// RefundInvoice godoc
+// @Summary      Refund a payment
+// @Description  Credits the amount and the fee of a settled outgoing invoice back to the user, e.g. when a payment was not delivered. Every invoice can only be refunded once. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        RefundRequestBody  body      RefundRequestBody  True  "Invoice to refund"
+// @Success      200                {object}  models.LedgerAdjustment
+// @Failure      400                {object}  responses.ErrorResponse
+// @Failure      404                {object}  responses.ErrorResponse
+// @Failure      409                {object}  responses.ErrorResponse
+// @Failure      500                {object}  responses.ErrorResponse
+// @Router       /v2/admin/refunds [post]
+func (controller *AdjustmentController) RefundInvoice(c echo.Context) error {
+	reqBody := RefundRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load refund request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid refund request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	adjustment, err := controller.svc.RefundInvoice(c.Request().Context(), reqBody.UserID, reqBody.PaymentHash, reqBody.Reason, reqBody.Reference)
+	if err != nil {
+		c.Logger().Errorf("Failed to refund invoice user_id:%v payment_hash:%v error: %v", reqBody.UserID, reqBody.PaymentHash, err)
+		return adjustmentError(c, err)
+	}
+	return c.JSON(http.StatusOK, adjustment)
+}
+
+// GetAdjustments godoc
+// @Summary      Retrieve the adjustments of a user
+// @Description  Returns the manual credits, debits and refunds of a user, newest first. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        user_id  query     int  true  "User id"
+// @Success      200      {object}  []models.LedgerAdjustment
+// @Failure      400      {object}  responses.ErrorResponse
+// @Failure      500      {object}  responses.ErrorResponse
+// @Router       /v2/admin/adjustments [get]
+func (controller *AdjustmentController) GetAdjustments(c echo.Context) error {
+	userID, err := strconv.ParseInt(c.QueryParam("user_id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	adjustments, err := controller.svc.LedgerAdjustmentsFor(c.Request().Context(), userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to get adjustments user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &adjustments)
+}
+
+func adjustmentError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrWalletNotFound),
+		errors.Is(err, service.ErrInvoiceNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrInvoiceAlreadyRefunded):
+		status = http.StatusConflict
+	case errors.Is(err, service.ErrNotEnoughBalance):
+		return c.JSON(http.StatusBadRequest, responses.NotEnoughBalanceError)
+	case errors.Is(err, service.ErrInvalidAdjustment),
+		errors.Is(err, service.ErrInvoiceNotRefundable):
+	default:
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(status, responses.ErrorResponse{
+		Error:          true,
+		Code:           8,
+		Message:        err.Error(),
+		HttpStatusCode: status,
+	})
+}
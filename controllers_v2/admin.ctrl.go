@@ -0,0 +1,409 @@
+package v2controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// AdminController : User management admin controller struct
+type AdminController struct {
+	svc *service.LndhubService
+}
+
+func NewAdminController(svc *service.LndhubService) *AdminController {
+	return &AdminController{svc: svc}
+}
+
+type PageResponse struct {
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+type AdminUser struct {
+	ID          int64     `json:"id"`
+	Login       string    `json:"login"`
+	Email       string    `json:"email,omitempty"`
+	Deactivated bool      `json:"deactivated"`
+	Deleted     bool      `json:"deleted"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type AdminUsersResponseBody struct {
+	PageResponse
+	Users []AdminUser `json:"users"`
+}
+
+type AdminVolumes struct {
+	PeriodSeconds    int64 `json:"period_seconds"`
+	Sent             int64 `json:"sent"`
+	Received         int64 `json:"received"`
+	MaxSendVolume    int64 `json:"max_send_volume"`
+	MaxReceiveVolume int64 `json:"max_receive_volume"`
+}
+
+type AdminUserResponseBody struct {
+	AdminUser
+	Balance int64        `json:"balance"`
+	Wallets []Wallet     `json:"wallets"`
+	Volumes AdminVolumes `json:"volumes"`
+}
+
+type AdminInvoicesResponseBody struct {
+	PageResponse
+	Invoices []models.Invoice `json:"invoices"`
+}
+
+type AdminTransactionEntry struct {
+	ID              int64     `json:"id"`
+	InvoiceID       int64     `json:"invoice_id"`
+	ParentID        int64     `json:"parent_id,omitempty"`
+	CreditAccountID int64     `json:"credit_account_id"`
+	DebitAccountID  int64     `json:"debit_account_id"`
+	Amount          int64     `json:"amount"`
+	EntryType       string    `json:"entry_type"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type AdminTransactionEntriesResponseBody struct {
+	PageResponse
+	TransactionEntries []AdminTransactionEntry `json:"transaction_entries"`
+}
+
+type BulkUsersRequestBody struct {
+	IDs []int64 `json:"ids" validate:"required,min=1,max=500"`
+}
+
+type UnlockLoginRequestBody struct {
+	Login string `json:"login" validate:"required_without=IP"`
+	IP    string `json:"ip" validate:"required_without=Login,omitempty,ip"`
+}
+
+// SearchUsers godoc
+// @Summary      Search users
+// @Description  Returns the users whose login or email contains the query, all users without a query. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        q       query     string  false  "Login or email to search for"
+// @Param        limit   query     int     false  "Page size, 50 by default and 500 at most"
+// @Param        offset  query     int     false  "Number of users to skip"
+// @Success      200     {object}  AdminUsersResponseBody
+// @Failure      400     {object}  responses.ErrorResponse
+// @Failure      500     {object}  responses.ErrorResponse
+// @Router       /v2/admin/users [get]
+func (controller *AdminController) SearchUsers(c echo.Context) error {
+	page, err := parsePage(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	users, total, err := controller.svc.SearchUsers(c.Request().Context(), c.QueryParam("q"), page)
+	if err != nil {
+		c.Logger().Errorf("Failed to search users: %v", err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &AdminUsersResponseBody{
+		PageResponse: toPageResponse(page, total),
+		Users:        toAdminUsers(users),
+	})
+}
+
+// GetUser godoc
+// @Summary      Inspect a user
+// @Description  Returns a user with the balance of the default wallet, the balances of all wallets and the volumes over the limit period. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        id   path      int  true  "User id"
+// @Success      200  {object}  AdminUserResponseBody
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/admin/users/{id} [get]
+func (controller *AdminController) GetUser(c echo.Context) error {
+	ctx := c.Request().Context()
+	user, err := controller.findUser(c)
+	if err != nil {
+		return adminError(c, err)
+	}
+	balance, err := controller.svc.CurrentUserBalance(ctx, user.ID)
+	if err != nil {
+		c.Logger().Errorf("Failed to get balance user_id:%v error: %v", user.ID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	wallets, err := walletsWithBalances(ctx, controller.svc, user.ID)
+	if err != nil {
+		c.Logger().Errorf("Failed to get wallet balances user_id:%v error: %v", user.ID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	volumes, err := controller.svc.VolumesFor(ctx, user.ID)
+	if err != nil {
+		c.Logger().Errorf("Failed to get volumes user_id:%v error: %v", user.ID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &AdminUserResponseBody{
+		AdminUser: toAdminUser(user),
+		Balance:   balance,
+		Wallets:   wallets,
+		Volumes: AdminVolumes{
+			PeriodSeconds:    int64(volumes.Period.Seconds()),
+			Sent:             volumes.Sent,
+			Received:         volumes.Received,
+			MaxSendVolume:    controller.svc.Config.MaxSendVolume,
+			MaxReceiveVolume: controller.svc.Config.MaxReceiveVolume,
+		},
+	})
+}
+
+// GetUserInvoices godoc
+// @Summary      List the invoices of a user
+// @Description  Returns the invoices of a user in all wallets, newest first. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        id      path      int     true   "User id"
+// @Param        type    query     string  false  "incoming or outgoing"
+// @Param        limit   query     int     false  "Page size, 50 by default and 500 at most"
+// @Param        offset  query     int     false  "Number of invoices to skip"
+// @Success      200     {object}  AdminInvoicesResponseBody
+// @Failure      400     {object}  responses.ErrorResponse
+// @Failure      404     {object}  responses.ErrorResponse
+// @Failure      500     {object}  responses.ErrorResponse
+// @Router       /v2/admin/users/{id}/invoices [get]
+func (controller *AdminController) GetUserInvoices(c echo.Context) error {
+	page, err := parsePage(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	invoiceType := c.QueryParam("type")
+	if invoiceType != "" && invoiceType != common.InvoiceTypeIncoming && invoiceType != common.InvoiceTypeOutgoing {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	user, err := controller.findUser(c)
+	if err != nil {
+		return adminError(c, err)
+	}
+	invoices, total, err := controller.svc.PagedInvoicesFor(c.Request().Context(), user.ID, invoiceType, page)
+	if err != nil {
+		c.Logger().Errorf("Failed to get invoices user_id:%v error: %v", user.ID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &AdminInvoicesResponseBody{
+		PageResponse: toPageResponse(page, total),
+		Invoices:     invoices,
+	})
+}
+
+// GetUserTransactionEntries godoc
+// @Summary      List the ledger entries of a user
+// @Description  Returns the transaction entries of a user in all wallets, newest first. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        id      path      int  true   "User id"
+// @Param        limit   query     int  false  "Page size, 50 by default and 500 at most"
+// @Param        offset  query     int  false  "Number of entries to skip"
+// @Success      200     {object}  AdminTransactionEntriesResponseBody
+// @Failure      400     {object}  responses.ErrorResponse
+// @Failure      404     {object}  responses.ErrorResponse
+// @Failure      500     {object}  responses.ErrorResponse
+// @Router       /v2/admin/users/{id}/transactions [get]
+func (controller *AdminController) GetUserTransactionEntries(c echo.Context) error {
+	page, err := parsePage(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	user, err := controller.findUser(c)
+	if err != nil {
+		return adminError(c, err)
+	}
+	entries, total, err := controller.svc.PagedTransactionEntriesFor(c.Request().Context(), user.ID, page)
+	if err != nil {
+		c.Logger().Errorf("Failed to get transaction entries user_id:%v error: %v", user.ID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	response := &AdminTransactionEntriesResponseBody{
+		PageResponse:       toPageResponse(page, total),
+		TransactionEntries: make([]AdminTransactionEntry, len(entries)),
+	}
+	for i, entry := range entries {
+		response.TransactionEntries[i] = AdminTransactionEntry{
+			ID:              entry.ID,
+			InvoiceID:       entry.InvoiceID,
+			ParentID:        entry.ParentID,
+			CreditAccountID: entry.CreditAccountID,
+			DebitAccountID:  entry.DebitAccountID,
+			Amount:          entry.Amount,
+			EntryType:       entry.EntryType,
+			CreatedAt:       entry.CreatedAt,
+		}
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// DeactivateUsers godoc
+// @Summary      Deactivate users
+// @Description  Deactivates up to 500 users at once, deactivated users can't log in. Returns the updated users. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        BulkUsersRequestBody  body      BulkUsersRequestBody  True  "Users to deactivate"
+// @Success      200                   {object}  []AdminUser
+// @Failure      400                   {object}  responses.ErrorResponse
+// @Failure      500                   {object}  responses.ErrorResponse
+// @Router       /v2/admin/users/deactivate [post]
+func (controller *AdminController) DeactivateUsers(c echo.Context) error {
+	return controller.setUsersDeactivated(c, true)
+}
+
+// ReactivateUsers godoc
+// @Summary      Reactivate users
+// @Description  Reactivates up to 500 users at once, deleted users stay deactivated. Returns the updated users. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        BulkUsersRequestBody  body      BulkUsersRequestBody  True  "Users to reactivate"
+// @Success      200                   {object}  []AdminUser
+// @Failure      400                   {object}  responses.ErrorResponse
+// @Failure      500                   {object}  responses.ErrorResponse
+// @Router       /v2/admin/users/reactivate [post]
+func (controller *AdminController) ReactivateUsers(c echo.Context) error {
+	return controller.setUsersDeactivated(c, false)
+}
+
+func (controller *AdminController) setUsersDeactivated(c echo.Context, deactivated bool) error {
+	reqBody := BulkUsersRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load bulk users request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid bulk users request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	users, err := controller.svc.SetUsersDeactivated(c.Request().Context(), reqBody.IDs, deactivated)
+	if err != nil {
+		c.Logger().Errorf("Failed to update users deactivated:%v error: %v", deactivated, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	response := toAdminUsers(users)
+	return c.JSON(http.StatusOK, &response)
+}
+
+// UnlockLogin godoc
+// @Summary      Unlock a login
+// @Description  Lifts the lockout of a login and/or a client IP after too many failed logins and forgets their failed attempts. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        UnlockLoginRequestBody  body      UnlockLoginRequestBody  True  "Login and/or IP to unlock"
+// @Success      200                     {object}  UnlockLoginRequestBody
+// @Failure      400                     {object}  responses.ErrorResponse
+// @Failure      500                     {object}  responses.ErrorResponse
+// @Router       /v2/admin/logins/unlock [post]
+func (controller *AdminController) UnlockLogin(c echo.Context) error {
+	reqBody := UnlockLoginRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load unlock login request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid unlock login request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := controller.svc.UnlockLogin(c.Request().Context(), reqBody.Login, reqBody.IP); err != nil {
+		c.Logger().Errorf("Failed to unlock login:%s ip:%s error: %v", reqBody.Login, reqBody.IP, err)
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, &reqBody)
+}
+
+var errInvalidUserID = errors.New("invalid user id")
+
+// findUser loads the user of the id path parameter
+func (controller *AdminController) findUser(c echo.Context) (*models.User, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, errInvalidUserID
+	}
+	user, err := controller.svc.FindUser(c.Request().Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrUserNotFound
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to find user id:%v error: %v", id, err)
+		return nil, err
+	}
+	return user, nil
+}
+
+func adminError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errInvalidUserID), errors.Is(err, service.ErrLoginGuardDisabled):
+	default:
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(status, responses.ErrorResponse{
+		Error:          true,
+		Code:           8,
+		Message:        err.Error(),
+		HttpStatusCode: status,
+	})
+}
+
+func parsePage(c echo.Context) (page service.Page, err error) {
+	page.Limit = service.DefaultPageLimit
+	if limit := c.QueryParam("limit"); limit != "" {
+		if page.Limit, err = strconv.Atoi(limit); err != nil || page.Limit <= 0 {
+			return page, errors.New("invalid limit")
+		}
+		if page.Limit > service.MaxPageLimit {
+			page.Limit = service.MaxPageLimit
+		}
+	}
+	if offset := c.QueryParam("offset"); offset != "" {
+		if page.Offset, err = strconv.Atoi(offset); err != nil || page.Offset < 0 {
+			return page, errors.New("invalid offset")
+		}
+	}
+	return page, nil
+}
+
+func toPageResponse(page service.Page, total int) PageResponse {
+	return PageResponse{
+		Total:  total,
+		Limit:  page.Limit,
+		Offset: page.Offset,
+	}
+}
+
+func toAdminUser(user *models.User) AdminUser {
+	return AdminUser{
+		ID:          user.ID,
+		Login:       user.Login,
+		Email:       user.Email.String,
+		Deactivated: user.Deactivated,
+		Deleted:     user.Deleted,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+func toAdminUsers(users []models.User) []AdminUser {
+	response := make([]AdminUser, len(users))
+	for i := range users {
+		response[i] = toAdminUser(&users[i])
+	}
+	return response
+}
@@ -0,0 +1,171 @@
+package v2controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// AdminPrincipalController : Admin principal controller struct
+type AdminPrincipalController struct {
+	svc *service.LndhubService
+}
+
+func NewAdminPrincipalController(svc *service.LndhubService) *AdminPrincipalController {
+	return &AdminPrincipalController{svc: svc}
+}
+
+type CreateAdminPrincipalRequestBody struct {
+	Name string `json:"name" validate:"required"`
+	Role string `json:"role" validate:"required,oneof=support_read_only support_refunds operator superadmin"`
+}
+
+type CreateAdminPrincipalResponseBody struct {
+	models.AdminPrincipal
+	Token string `json:"token"`
+}
+
+type AdminAuditLogResponseBody struct {
+	PageResponse
+	Entries []models.AdminAuditLog `json:"entries"`
+}
+
+// CreateAdminPrincipal godoc
+// @Summary      Create an admin
+// @Description  Creates an admin with a role and returns its token, which is not shown again. support_read_only can inspect users, support_refunds can also refund payments, operator can also adjust balances and manage users and the cluster, superadmin can also manage admins. Requires Authorization header with a superadmin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        CreateAdminPrincipalRequestBody  body      CreateAdminPrincipalRequestBody  True  "Admin to create"
+// @Success      200                              {object}  CreateAdminPrincipalResponseBody
+// @Failure      400                              {object}  responses.ErrorResponse
+// @Failure      401                              {object}  responses.ErrorResponse
+// @Failure      403                              {object}  responses.ErrorResponse
+// @Failure      500                              {object}  responses.ErrorResponse
+// @Router       /v2/admin/principals [post]
+func (controller *AdminPrincipalController) CreateAdminPrincipal(c echo.Context) error {
+	reqBody := CreateAdminPrincipalRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load admin principal request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid admin principal request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	principal, token, err := controller.svc.CreateAdminPrincipal(c.Request().Context(), reqBody.Name, reqBody.Role)
+	if err != nil {
+		c.Logger().Errorf("Failed to create admin principal %s: %v", reqBody.Name, err)
+		return adminPrincipalError(c, err)
+	}
+	return c.JSON(http.StatusOK, &CreateAdminPrincipalResponseBody{
+		AdminPrincipal: *principal,
+		Token:          token,
+	})
+}
+
+// GetAdminPrincipals godoc
+// @Summary      List admins
+// @Description  Returns all admins, including revoked ones. Requires Authorization header with a superadmin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Success      200  {object}  []models.AdminPrincipal
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/admin/principals [get]
+func (controller *AdminPrincipalController) GetAdminPrincipals(c echo.Context) error {
+	principals, err := controller.svc.AdminPrincipals(c.Request().Context())
+	if err != nil {
+		c.Logger().Errorf("Failed to get admin principals: %v", err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &principals)
+}
+
+// RevokeAdminPrincipal godoc
+// @Summary      Revoke an admin
+// @Description  Revokes an admin, its token can't be used anymore. Requires Authorization header with a superadmin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        id   path      int  true  "Admin id"
+// @Success      200  {object}  models.AdminPrincipal
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      401  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/admin/principals/{id} [delete]
+func (controller *AdminPrincipalController) RevokeAdminPrincipal(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	principal, err := controller.svc.RevokeAdminPrincipal(c.Request().Context(), id)
+	if err != nil {
+		c.Logger().Errorf("Failed to revoke admin principal id:%v error: %v", id, err)
+		return adminPrincipalError(c, err)
+	}
+	return c.JSON(http.StatusOK, principal)
+}
+
+// GetAdminAuditLog godoc
+// @Summary      Retrieve the admin audit log
+// @Description  Returns the actions of admins with the state of the target before and after them, newest first. Requires Authorization header with a superadmin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        user_id  query     int  false  "Only actions on this user"
+// @Param        limit    query     int  false  "Page size, 50 by default and 500 at most"
+// @Param        offset   query     int  false  "Number of entries to skip"
+// @Success      200      {object}  AdminAuditLogResponseBody
+// @Failure      400      {object}  responses.ErrorResponse
+// @Failure      401      {object}  responses.ErrorResponse
+// @Failure      403      {object}  responses.ErrorResponse
+// @Failure      500      {object}  responses.ErrorResponse
+// @Router       /v2/admin/audit [get]
+func (controller *AdminPrincipalController) GetAdminAuditLog(c echo.Context) error {
+	page, err := parsePage(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	var userID int64
+	if param := c.QueryParam("user_id"); param != "" {
+		if userID, err = strconv.ParseInt(param, 10, 64); err != nil {
+			return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+		}
+	}
+	entries, total, err := controller.svc.AdminAuditLogs(c.Request().Context(), userID, page)
+	if err != nil {
+		c.Logger().Errorf("Failed to get admin audit log: %v", err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &AdminAuditLogResponseBody{
+		PageResponse: toPageResponse(page, total),
+		Entries:      entries,
+	})
+}
+
+func adminPrincipalError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, service.ErrAdminPrincipalNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidAdminPrincipal):
+	default:
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(status, responses.ErrorResponse{
+		Error:          true,
+		Code:           8,
+		Message:        err.Error(),
+		HttpStatusCode: status,
+	})
+}
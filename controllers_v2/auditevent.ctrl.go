@@ -0,0 +1,173 @@
+package v2controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// AuditEventController : Audit event controller struct
+type AuditEventController struct {
+	svc *service.LndhubService
+}
+
+func NewAuditEventController(svc *service.LndhubService) *AuditEventController {
+	return &AuditEventController{svc: svc}
+}
+
+type AuditEventsResponseBody struct {
+	PageResponse
+	Events []models.AuditEvent `json:"events"`
+}
+
+var auditEventCSVHeader = []string{"id", "created_at", "type", "user_id", "login", "actor", "ip", "details"}
+
+// GetAuditEvents godoc
+// @Summary      Retrieve audit events
+// @Description  Returns security relevant events like logins, failed logins, password and login changes, deactivations, exceeded limits and admin actions, newest first. Requires Authorization header with a superadmin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Admin
+// @Param        user_id  query     int     false  "Only events of this user"
+// @Param        login    query     string  false  "Only events of this login"
+// @Param        type     query     string  false  "Only events of this type"
+// @Param        from     query     string  false  "Only events at or after this RFC3339 time"
+// @Param        to       query     string  false  "Only events before this RFC3339 time"
+// @Param        limit    query     int     false  "Page size, 50 by default and 500 at most"
+// @Param        offset   query     int     false  "Number of events to skip"
+// @Success      200      {object}  AuditEventsResponseBody
+// @Failure      400      {object}  responses.ErrorResponse
+// @Failure      401      {object}  responses.ErrorResponse
+// @Failure      403      {object}  responses.ErrorResponse
+// @Failure      500      {object}  responses.ErrorResponse
+// @Router       /v2/admin/audit/events [get]
+func (controller *AuditEventController) GetAuditEvents(c echo.Context) error {
+	page, err := parsePage(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	filter, err := parseAuditEventFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	events, total, err := controller.svc.AuditEvents(c.Request().Context(), filter, page)
+	if err != nil {
+		c.Logger().Errorf("Failed to get audit events: %v", err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &AuditEventsResponseBody{
+		PageResponse: toPageResponse(page, total),
+		Events:       events,
+	})
+}
+
+// ExportAuditEvents godoc
+// @Summary      Export audit events
+// @Description  Streams all audit events that match the filters oldest first, as CSV or as JSON lines, e.g. for compliance reports. Requires Authorization header with a superadmin token.
+// @Produce      text/csv
+// @Produce      application/x-ndjson
+// @Tags         Admin
+// @Param        format   query     string  false  "csv or jsonl, jsonl by default"
+// @Param        user_id  query     int     false  "Only events of this user"
+// @Param        login    query     string  false  "Only events of this login"
+// @Param        type     query     string  false  "Only events of this type"
+// @Param        from     query     string  false  "Only events at or after this RFC3339 time"
+// @Param        to       query     string  false  "Only events before this RFC3339 time"
+// @Success      200
+// @Failure      400      {object}  responses.ErrorResponse
+// @Failure      401      {object}  responses.ErrorResponse
+// @Failure      403      {object}  responses.ErrorResponse
+// @Router       /v2/admin/audit/events/export [get]
+func (controller *AuditEventController) ExportAuditEvents(c echo.Context) error {
+	filter, err := parseAuditEventFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "jsonl"
+	}
+	res := c.Response()
+	var write func(event *models.AuditEvent) error
+	var flush func() error
+	switch format {
+	case "csv":
+		w := csv.NewWriter(res)
+		write = func(event *models.AuditEvent) error {
+			details, err := json.Marshal(event.Details)
+			if err != nil {
+				return err
+			}
+			return w.Write([]string{
+				strconv.FormatInt(event.ID, 10),
+				event.CreatedAt.Format(time.RFC3339Nano),
+				event.Type,
+				strconv.FormatInt(event.UserID, 10),
+				event.Login,
+				event.Actor,
+				event.IP,
+				string(details),
+			})
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+		res.Header().Set(echo.HeaderContentType, "text/csv")
+		res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit_events.csv"`)
+		res.WriteHeader(http.StatusOK)
+		err = w.Write(auditEventCSVHeader)
+	case "jsonl":
+		enc := json.NewEncoder(res)
+		write = func(event *models.AuditEvent) error {
+			return enc.Encode(event)
+		}
+		flush = func() error {
+			return nil
+		}
+		res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+		res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit_events.jsonl"`)
+		res.WriteHeader(http.StatusOK)
+	default:
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	// the status is already sent, errors can only be logged and end the export early
+	if err == nil {
+		err = controller.svc.ExportAuditEvents(c.Request().Context(), filter, write)
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to export audit events: %v", err)
+	}
+	return nil
+}
+
+func parseAuditEventFilter(c echo.Context) (filter service.AuditEventFilter, err error) {
+	if param := c.QueryParam("user_id"); param != "" {
+		if filter.UserID, err = strconv.ParseInt(param, 10, 64); err != nil {
+			return filter, err
+		}
+	}
+	if param := c.QueryParam("from"); param != "" {
+		if filter.From, err = time.Parse(time.RFC3339, param); err != nil {
+			return filter, err
+		}
+	}
+	if param := c.QueryParam("to"); param != "" {
+		if filter.To, err = time.Parse(time.RFC3339, param); err != nil {
+			return filter, err
+		}
+	}
+	filter.Login = c.QueryParam("login")
+	filter.Type = c.QueryParam("type")
+	return filter, nil
+}
@@ -0,0 +1,251 @@
+package v2controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// BudgetController : Budget controller struct
+type BudgetController struct {
+	svc *service.LndhubService
+}
+
+func NewBudgetController(svc *service.LndhubService) *BudgetController {
+	return &BudgetController{svc: svc}
+}
+
+type CreateBudgetRequestBody struct {
+	Name          string `json:"name" validate:"required"`
+	Amount        int64  `json:"amount" validate:"required,gt=0"`
+	RenewalPeriod string `json:"renewal_period" validate:"required,oneof=daily weekly monthly yearly never"`
+	RenewalType   string `json:"renewal_type" validate:"omitempty,oneof=rolling calendar"`
+}
+
+type Budget struct {
+	ID            int64      `json:"id"`
+	Name          string     `json:"name"`
+	Amount        int64      `json:"amount"`
+	RenewalPeriod string     `json:"renewal_period"`
+	RenewalType   string     `json:"renewal_type"`
+	Spent         int64      `json:"spent"`
+	Remaining     int64      `json:"remaining"`
+	PeriodStart   *time.Time `json:"period_start,omitempty"`
+	RenewsAt      *time.Time `json:"renews_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type CreateBudgetResponseBody struct {
+	Budget
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// CreateBudget godoc
+// @Summary      Create a budget
+// @Description  Creates a spending budget and returns tokens that can only spend within it, e.g. to connect a third-party app. Calendar budgets are reset at the start of every period (UTC, weeks start on monday), rolling budgets count the payments of the last period.
+// @Accept       json
+// @Produce      json
+// @Tags         Budget
+// @Param        CreateBudgetRequestBody  body      CreateBudgetRequestBody  True  "Budget to create"
+// @Success      200                      {object}  CreateBudgetResponseBody
+// @Failure      400                      {object}  responses.ErrorResponse
+// @Failure      403                      {object}  responses.ErrorResponse
+// @Failure      500                      {object}  responses.ErrorResponse
+// @Router       /v2/budgets [post]
+// @Security     OAuth2Password
+func (controller *BudgetController) CreateBudget(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := CreateBudgetRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load budget request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid budget request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	budget := &models.Budget{
+		UserID:        userID,
+		Name:          reqBody.Name,
+		Amount:        reqBody.Amount,
+		RenewalPeriod: reqBody.RenewalPeriod,
+		RenewalType:   reqBody.RenewalType,
+	}
+	err := controller.svc.CreateBudget(c.Request().Context(), budget)
+	if err != nil {
+		c.Logger().Errorf("Failed to create budget user_id:%v error: %v", userID, err)
+		return budgetError(c, err)
+	}
+	accessToken, refreshToken, err := controller.svc.GenerateBudgetTokens(c.Request().Context(), budget)
+	if err != nil {
+		c.Logger().Errorf("Failed to generate budget tokens user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	response, err := controller.toBudgetResponse(c, budget)
+	if err != nil {
+		return budgetError(c, err)
+	}
+	return c.JSON(http.StatusOK, &CreateBudgetResponseBody{
+		Budget:       *response,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	})
+}
+
+// GetBudgets godoc
+// @Summary      Retrieve budgets
+// @Description  Returns the budgets of a user that were not revoked
+// @Accept       json
+// @Produce      json
+// @Tags         Budget
+// @Success      200  {object}  []Budget
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/budgets [get]
+// @Security     OAuth2Password
+func (controller *BudgetController) GetBudgets(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	budgets, err := controller.svc.BudgetsFor(c.Request().Context(), userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to get budgets user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	response := make([]Budget, len(budgets))
+	for i := range budgets {
+		budget, err := controller.toBudgetResponse(c, &budgets[i])
+		if err != nil {
+			return budgetError(c, err)
+		}
+		response[i] = *budget
+	}
+	return c.JSON(http.StatusOK, &response)
+}
+
+// GetBudget godoc
+// @Summary      Retrieve a budget
+// @Description  Returns a budget with the amount that was spent in the current period
+// @Accept       json
+// @Produce      json
+// @Tags         Budget
+// @Param        id   path      int  true  "Budget id"
+// @Success      200  {object}  Budget
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/budgets/{id} [get]
+// @Security     OAuth2Password
+func (controller *BudgetController) GetBudget(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	return controller.respondWithBudget(c, id)
+}
+
+// GetCurrentBudget godoc
+// @Summary      Retrieve the budget of the token
+// @Description  Returns the budget the token of the request is bound to, with the remaining amount of the current period
+// @Accept       json
+// @Produce      json
+// @Tags         Budget
+// @Success      200  {object}  Budget
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/budgets/current [get]
+// @Security     OAuth2Password
+func (controller *BudgetController) GetCurrentBudget(c echo.Context) error {
+	budgetID := controller.svc.GetBudgetID(c)
+	if budgetID == 0 {
+		return budgetError(c, service.ErrBudgetNotFound)
+	}
+	return controller.respondWithBudget(c, budgetID)
+}
+
+// RevokeBudget godoc
+// @Summary      Revoke a budget
+// @Description  Revokes a budget, the tokens that are bound to it can't be used anymore
+// @Accept       json
+// @Produce      json
+// @Tags         Budget
+// @Param        id   path  int  true  "Budget id"
+// @Success      200
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/budgets/{id} [delete]
+// @Security     OAuth2Password
+func (controller *BudgetController) RevokeBudget(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	err = controller.svc.RevokeBudget(c.Request().Context(), userID, id)
+	if err != nil {
+		c.Logger().Errorf("Failed to revoke budget id:%v user_id:%v error: %v", id, userID, err)
+		return budgetError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (controller *BudgetController) respondWithBudget(c echo.Context, id int64) error {
+	userID := c.Get("UserID").(int64)
+	budget, err := controller.svc.FindBudget(c.Request().Context(), userID, id)
+	if err != nil {
+		return budgetError(c, err)
+	}
+	response, err := controller.toBudgetResponse(c, budget)
+	if err != nil {
+		return budgetError(c, err)
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+func (controller *BudgetController) toBudgetResponse(c echo.Context, budget *models.Budget) (*Budget, error) {
+	usage, err := controller.svc.BudgetUsage(c.Request().Context(), budget)
+	if err != nil {
+		c.Logger().Errorf("Failed to get budget usage id:%v error: %v", budget.ID, err)
+		return nil, err
+	}
+	response := &Budget{
+		ID:            budget.ID,
+		Name:          budget.Name,
+		Amount:        budget.Amount,
+		RenewalPeriod: budget.RenewalPeriod,
+		RenewalType:   budget.RenewalType,
+		Spent:         usage.Spent,
+		Remaining:     usage.Remaining,
+		CreatedAt:     budget.CreatedAt,
+	}
+	if !usage.PeriodStart.IsZero() {
+		response.PeriodStart = &usage.PeriodStart
+	}
+	if !usage.RenewsAt.IsZero() {
+		response.RenewsAt = &usage.RenewsAt
+	}
+	return response, nil
+}
+
+func budgetError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, service.ErrBudgetNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidBudget):
+	default:
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(status, responses.ErrorResponse{
+		Error:          true,
+		Code:           8,
+		Message:        err.Error(),
+		HttpStatusCode: status,
+	})
+}
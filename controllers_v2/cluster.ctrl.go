@@ -0,0 +1,150 @@
+package v2controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/labstack/echo/v4"
+)
+
+// ClusterController : Cluster admin controller struct
+type ClusterController struct {
+	svc     *service.LndhubService
+	cluster lnd.ClusterAdmin
+}
+
+func NewClusterController(svc *service.LndhubService, cluster lnd.ClusterAdmin) *ClusterController {
+	return &ClusterController{svc: svc, cluster: cluster}
+}
+
+type ClusterNodeResponseBody struct {
+	Pubkey             string     `json:"pubkey"`
+	Reachable          bool       `json:"reachable"`
+	Healthy            bool       `json:"healthy"`
+	Active             bool       `json:"active"`
+	Draining           bool       `json:"draining"`
+	ActiveChannelRatio float64    `json:"active_channel_ratio"`
+	OutboundLiquidity  int64      `json:"outbound_liquidity"`
+	InboundLiquidity   int64      `json:"inbound_liquidity"`
+	LastCheck          *time.Time `json:"last_check,omitempty"`
+}
+
+// GetNodes godoc
+// @Summary      List cluster nodes
+// @Description  Returns the nodes of the LND cluster as seen by the last liveness check. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Cluster
+// @Success      200  {object}  []ClusterNodeResponseBody
+// @Failure      401  {object}  responses.ErrorResponse
+// @Router       /v2/admin/cluster/nodes [get]
+func (controller *ClusterController) GetNodes(c echo.Context) error {
+	response := []ClusterNodeResponseBody{}
+	for _, status := range controller.cluster.NodeStatuses() {
+		response = append(response, toClusterNodeResponse(status))
+	}
+	return c.JSON(http.StatusOK, &response)
+}
+
+// ActivateNode godoc
+// @Summary      Switch the active node
+// @Description  Makes the node the active node of the LND cluster for as long as it is healthy. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Cluster
+// @Param        pubkey  path      string  true  "Node pubkey"
+// @Success      200     {object}  ClusterNodeResponseBody
+// @Failure      400     {object}  responses.ErrorResponse
+// @Failure      404     {object}  responses.ErrorResponse
+// @Router       /v2/admin/cluster/nodes/{pubkey}/activate [post]
+func (controller *ClusterController) ActivateNode(c echo.Context) error {
+	return controller.updateNode(c, "activate_node", controller.cluster.SwitchActiveNode)
+}
+
+// DrainNode godoc
+// @Summary      Drain a node
+// @Description  Stops sending payments and invoices to the node, e.g. for maintenance. Invoices that were created on the node are still processed. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Cluster
+// @Param        pubkey  path      string  true  "Node pubkey"
+// @Success      200     {object}  ClusterNodeResponseBody
+// @Failure      400     {object}  responses.ErrorResponse
+// @Failure      404     {object}  responses.ErrorResponse
+// @Router       /v2/admin/cluster/nodes/{pubkey}/drain [post]
+func (controller *ClusterController) DrainNode(c echo.Context) error {
+	return controller.updateNode(c, "drain_node", controller.cluster.DrainNode)
+}
+
+// UndrainNode godoc
+// @Summary      Stop draining a node
+// @Description  Sends payments and invoices to the node again. Requires Authorization header with admin token.
+// @Accept       json
+// @Produce      json
+// @Tags         Cluster
+// @Param        pubkey  path      string  true  "Node pubkey"
+// @Success      200     {object}  ClusterNodeResponseBody
+// @Failure      404     {object}  responses.ErrorResponse
+// @Router       /v2/admin/cluster/nodes/{pubkey}/drain [delete]
+func (controller *ClusterController) UndrainNode(c echo.Context) error {
+	return controller.updateNode(c, "undrain_node", controller.cluster.UndrainNode)
+}
+
+func (controller *ClusterController) updateNode(c echo.Context, action string, update func(pubkey string) error) error {
+	pubkey := c.Param("pubkey")
+	before := controller.nodeStatus(pubkey)
+	err := update(pubkey)
+	if err != nil {
+		c.Logger().Errorf("Failed to update cluster node %s: %v", pubkey, err)
+		status := http.StatusBadRequest
+		if errors.Is(err, lnd.ErrNodeNotFound) {
+			status = http.StatusNotFound
+		}
+		return c.JSON(status, responses.ErrorResponse{
+			Error:          true,
+			Code:           8,
+			Message:        err.Error(),
+			HttpStatusCode: status,
+		})
+	}
+	after := controller.nodeStatus(pubkey)
+	if after == nil {
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	err = controller.svc.RecordAdminAction(c.Request().Context(), action, 0, pubkey, before, after)
+	if err != nil {
+		c.Logger().Errorf("Failed to record admin action %s on cluster node %s: %v", action, pubkey, err)
+	}
+	return c.JSON(http.StatusOK, after)
+}
+
+func (controller *ClusterController) nodeStatus(pubkey string) *ClusterNodeResponseBody {
+	for _, status := range controller.cluster.NodeStatuses() {
+		if status.Pubkey == pubkey {
+			response := toClusterNodeResponse(status)
+			return &response
+		}
+	}
+	return nil
+}
+
+func toClusterNodeResponse(status lnd.NodeStatus) ClusterNodeResponseBody {
+	response := ClusterNodeResponseBody{
+		Pubkey:             status.Pubkey,
+		Reachable:          status.Reachable,
+		Healthy:            status.Healthy,
+		Active:             status.Active,
+		Draining:           status.Draining,
+		ActiveChannelRatio: status.ActiveChannelRatio,
+		OutboundLiquidity:  status.OutboundLiquidity(),
+		InboundLiquidity:   status.InboundLiquidity(),
+	}
+	if !status.LastCheck.IsZero() {
+		response.LastCheck = &status.LastCheck
+	}
+	return response
+}
@@ -0,0 +1,151 @@
+package v2controllers
+
+import (
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// HoldInvoiceController : Hold invoice controller struct
+type HoldInvoiceController struct {
+	svc *service.LndhubService
+}
+
+func NewHoldInvoiceController(svc *service.LndhubService) *HoldInvoiceController {
+	return &HoldInvoiceController{svc: svc}
+}
+
+type AddHoldInvoiceRequestBody struct {
+	Amount          int64  `json:"amount" validate:"gte=0"`
+	Description     string `json:"description"`
+	DescriptionHash string `json:"description_hash" validate:"omitempty,hexadecimal,len=64"`
+	PaymentHash     string `json:"payment_hash" validate:"required,hexadecimal,len=64"`
+}
+
+type SettleHoldInvoiceRequestBody struct {
+	Preimage string `json:"preimage" validate:"required,hexadecimal,len=64"`
+}
+
+type HoldInvoiceResponseBody struct {
+	PaymentHash string `json:"payment_hash"`
+	Status      string `json:"status"`
+}
+
+// AddHoldInvoice godoc
+// @Summary      Generate a new hold invoice
+// @Description  Returns a new bolt11 invoice for the given payment hash. Payments are held until the invoice is settled with the preimage or canceled.
+// @Accept       json
+// @Produce      json
+// @Tags         Invoice
+// @Param        invoice  body      AddHoldInvoiceRequestBody  True  "Add Hold Invoice"
+// @Success      200      {object}  AddInvoiceResponseBody
+// @Failure      400      {object}  responses.ErrorResponse
+// @Failure      500      {object}  responses.ErrorResponse
+// @Router       /v2/invoices/hold [post]
+// @Security     OAuth2Password
+func (controller *HoldInvoiceController) AddHoldInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	var body AddHoldInvoiceRequestBody
+
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load add hold invoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid add hold invoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	resp, err := controller.svc.CheckIncomingPaymentAllowed(c, body.Amount, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	if resp != nil {
+		c.Logger().Errorf("Error: %v user_id:%v amount:%v", resp.Message, userID, body.Amount)
+		return c.JSON(resp.HttpStatusCode, resp)
+	}
+
+	c.Logger().Infof("Adding hold invoice: user_id:%v memo:%s value:%v payment_hash:%s", userID, body.Description, body.Amount, body.PaymentHash)
+
+	invoice, errResp := controller.svc.AddHoldInvoice(c.Request().Context(), userID, body.Amount, body.Description, body.DescriptionHash, body.PaymentHash)
+	if errResp != nil {
+		return c.JSON(errResp.HttpStatusCode, errResp)
+	}
+	responseBody := AddInvoiceResponseBody{
+		PaymentHash:    invoice.RHash,
+		PaymentRequest: invoice.PaymentRequest,
+		ExpiresAt:      invoice.ExpiresAt.Time,
+		CreatedAt:      invoice.CreatedAt,
+	}
+
+	return c.JSON(http.StatusOK, &responseBody)
+}
+
+// SettleHoldInvoice godoc
+// @Summary      Settle a hold invoice
+// @Description  Settles a paid hold invoice with the preimage of its payment hash. The amount is credited once LND reports the invoice as settled.
+// @Accept       json
+// @Produce      json
+// @Tags         Invoice
+// @Param        payment_hash  path      string                        true  "Payment hash"
+// @Param        settle        body      SettleHoldInvoiceRequestBody  True  "Settle Hold Invoice"
+// @Success      200           {object}  HoldInvoiceResponseBody
+// @Failure      400           {object}  responses.ErrorResponse
+// @Failure      500           {object}  responses.ErrorResponse
+// @Router       /v2/invoices/hold/{payment_hash}/settle [post]
+// @Security     OAuth2Password
+func (controller *HoldInvoiceController) SettleHoldInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	rHash := c.Param("payment_hash")
+	var body SettleHoldInvoiceRequestBody
+
+	if err := c.Bind(&body); err != nil {
+		c.Logger().Errorf("Failed to load settle hold invoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	if err := c.Validate(&body); err != nil {
+		c.Logger().Errorf("Invalid settle hold invoice request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	invoice, err := controller.svc.SettleHoldInvoice(c.Request().Context(), userID, rHash, body.Preimage)
+	if err != nil {
+		c.Logger().Errorf("Failed to settle hold invoice user_id:%v payment_hash:%s error: %v", userID, rHash, err)
+		return invoiceStateError(c, err)
+	}
+	return c.JSON(http.StatusOK, &HoldInvoiceResponseBody{
+		PaymentHash: invoice.RHash,
+		Status:      invoice.State,
+	})
+}
+
+// CancelHoldInvoice godoc
+// @Summary      Cancel a hold invoice
+// @Description  Cancels a hold invoice, held payments are returned to the sender
+// @Accept       json
+// @Produce      json
+// @Tags         Invoice
+// @Param        payment_hash  path      string  true  "Payment hash"
+// @Success      200           {object}  HoldInvoiceResponseBody
+// @Failure      400           {object}  responses.ErrorResponse
+// @Failure      500           {object}  responses.ErrorResponse
+// @Router       /v2/invoices/hold/{payment_hash}/cancel [post]
+// @Security     OAuth2Password
+func (controller *HoldInvoiceController) CancelHoldInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	rHash := c.Param("payment_hash")
+
+	invoice, err := controller.svc.CancelHoldInvoice(c.Request().Context(), userID, rHash)
+	if err != nil {
+		c.Logger().Errorf("Failed to cancel hold invoice user_id:%v payment_hash:%s error: %v", userID, rHash, err)
+		return invoiceStateError(c, err)
+	}
+	return c.JSON(http.StatusOK, &HoldInvoiceResponseBody{
+		PaymentHash: invoice.RHash,
+		Status:      invoice.State,
+	})
+}
@@ -1,6 +1,7 @@
 package v2controllers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
@@ -21,22 +22,25 @@ func NewInvoiceController(svc *service.LndhubService) *InvoiceController {
 }
 
 type Invoice struct {
-	PaymentHash     string            `json:"payment_hash"`
-	PaymentRequest  string            `json:"payment_request"`
-	Description     string            `json:"description"`
-	DescriptionHash string            `json:"description_hash,omitempty"`
-	PaymentPreimage string            `json:"payment_preimage,omitempty"`
-	Destination     string            `json:"destination"`
-	Amount          int64             `json:"amount"`
-	Fee             int64             `json:"fee"`
-	Status          string            `json:"status"`
-	Type            string            `json:"type"`
-	ErrorMessage    string            `json:"error_message,omitempty"`
-	SettledAt       time.Time         `json:"settled_at"`
-	ExpiresAt       time.Time         `json:"expires_at"`
-	IsPaid          bool              `json:"is_paid"`
-	Keysend         bool              `json:"keysend"`
-	CustomRecords   map[uint64][]byte `json:"custom_records,omitempty"`
+	PaymentHash     string              `json:"payment_hash"`
+	PaymentRequest  string              `json:"payment_request"`
+	Description     string              `json:"description"`
+	DescriptionHash string              `json:"description_hash,omitempty"`
+	PaymentPreimage string              `json:"payment_preimage,omitempty"`
+	Destination     string              `json:"destination"`
+	Amount          int64               `json:"amount"`
+	Fee             int64               `json:"fee"`
+	Status          string              `json:"status"`
+	Type            string              `json:"type"`
+	ErrorMessage    string              `json:"error_message,omitempty"`
+	SettledAt       time.Time           `json:"settled_at"`
+	ExpiresAt       time.Time           `json:"expires_at"`
+	IsPaid          bool                `json:"is_paid"`
+	Keysend         bool                `json:"keysend"`
+	Hold            bool                `json:"hold,omitempty"`
+	CustomRecords   map[uint64][]byte   `json:"custom_records,omitempty"`
+	Metadata        map[string]string   `json:"metadata,omitempty"`
+	Boostagram      *service.Boostagram `json:"boostagram,omitempty"`
 }
 
 // GetOutgoingInvoices godoc
@@ -84,6 +88,8 @@ func (controller *InvoiceController) GetOutgoingInvoices(c echo.Context) error {
 			IsPaid:          invoice.State == common.InvoiceStateSettled,
 			Keysend:         invoice.Keysend,
 			CustomRecords:   invoice.DestinationCustomRecords,
+			Metadata:        invoice.Metadata,
+			Boostagram:      service.DecodeBoostagram(invoice.DestinationCustomRecords),
 		}
 	}
 	return c.JSON(http.StatusOK, &response)
@@ -132,7 +138,10 @@ func (controller *InvoiceController) GetIncomingInvoices(c echo.Context) error {
 			ExpiresAt:       invoice.ExpiresAt.Time,
 			IsPaid:          invoice.State == common.InvoiceStateSettled,
 			Keysend:         invoice.Keysend,
+			Hold:            invoice.Hold,
 			CustomRecords:   invoice.DestinationCustomRecords,
+			Metadata:        invoice.Metadata,
+			Boostagram:      service.DecodeBoostagram(invoice.DestinationCustomRecords),
 		}
 	}
 	return c.JSON(http.StatusOK, &response)
@@ -217,7 +226,7 @@ func (controller *InvoiceController) AddInvoice(c echo.Context) error {
 func (controller *InvoiceController) GetInvoice(c echo.Context) error {
 	userID := c.Get("UserID").(int64)
 	rHash := c.Param("payment_hash")
-	invoice, err := controller.svc.FindInvoiceByPaymentHash(c.Request().Context(), userID, rHash)
+	invoice, err := controller.svc.FindWalletInvoiceByPaymentHash(c.Request().Context(), userID, rHash)
 	// Probably we did not find the invoice
 	if err != nil {
 		c.Logger().Errorf("Invalid checkpayment request user_id:%v payment_hash:%s", userID, rHash)
@@ -239,7 +248,64 @@ func (controller *InvoiceController) GetInvoice(c echo.Context) error {
 		ExpiresAt:       invoice.ExpiresAt.Time,
 		IsPaid:          invoice.State == common.InvoiceStateSettled,
 		Keysend:         invoice.Keysend,
+		Hold:            invoice.Hold,
 		CustomRecords:   invoice.DestinationCustomRecords,
+		Metadata:        invoice.Metadata,
+		Boostagram:      service.DecodeBoostagram(invoice.DestinationCustomRecords),
 	}
 	return c.JSON(http.StatusOK, &responseBody)
 }
+
+// CancelInvoice godoc
+// @Summary      Cancel an invoice
+// @Description  Cancels an open incoming invoice, it can't be paid afterwards
+// @Accept       json
+// @Produce      json
+// @Tags         Invoice
+// @Param        payment_hash  path      string  true  "Payment hash"
+// @Success      200  {object}  Invoice
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/invoices/{payment_hash} [delete]
+// @Security     OAuth2Password
+func (controller *InvoiceController) CancelInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	rHash := c.Param("payment_hash")
+	invoice, err := controller.svc.CancelInvoice(c.Request().Context(), userID, rHash)
+	if err != nil {
+		c.Logger().Errorf("Failed to cancel invoice user_id:%v payment_hash:%s error: %v", userID, rHash, err)
+		return invoiceStateError(c, err)
+	}
+	responseBody := Invoice{
+		PaymentHash:     invoice.RHash,
+		PaymentRequest:  invoice.PaymentRequest,
+		Description:     invoice.Memo,
+		DescriptionHash: invoice.DescriptionHash,
+		Destination:     invoice.DestinationPubkeyHex,
+		Amount:          invoice.Amount,
+		Status:          invoice.State,
+		Type:            invoice.Type,
+		ExpiresAt:       invoice.ExpiresAt.Time,
+		Hold:            invoice.Hold,
+	}
+	return c.JSON(http.StatusOK, &responseBody)
+}
+
+// invoiceStateError responds to requests that tried to move an invoice to a state it can't be in
+func invoiceStateError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrNotHoldInvoice),
+		errors.Is(err, service.ErrHoldInvoiceNotAccepted),
+		errors.Is(err, service.ErrInvoiceNotCancelable),
+		errors.Is(err, service.ErrInvalidPreimage):
+		return c.JSON(http.StatusBadRequest, responses.ErrorResponse{
+			Error:          true,
+			Code:           8,
+			Message:        err.Error(),
+			HttpStatusCode: http.StatusBadRequest,
+		})
+	default:
+		// Probably we did not find the invoice
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+}
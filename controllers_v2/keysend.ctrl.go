@@ -136,9 +136,10 @@ func (controller *KeySendController) MultiKeySend(c echo.Context) error {
 		Keysends: []KeySendResult{},
 	}
 	singleSuccesfulPayment := false
+	ctx := service.DetachedContext(c.Request().Context())
 	for _, keysend := range reqBody.Keysends {
 		keysend := keysend
-		res, err := controller.SingleKeySend(context.Background(), &keysend, userID)
+		res, err := controller.SingleKeySend(ctx, &keysend, userID)
 		if err != nil {
 			controller.svc.Logger.Errorf("Error making keysend split payment %v %s", keysend, err.Message)
 			result.Keysends = append(result.Keysends, KeySendResult{
@@ -0,0 +1,270 @@
+package v2controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// lnurlAuthPollInterval is how often the result of a challenge is checked for event streams
+const lnurlAuthPollInterval = time.Second
+
+// LnurlAuthController : LNURL-auth (LUD-04) controller struct
+type LnurlAuthController struct {
+	svc *service.LndhubService
+}
+
+func NewLnurlAuthController(svc *service.LndhubService) *LnurlAuthController {
+	return &LnurlAuthController{svc: svc}
+}
+
+type LnurlAuthChallengeResponseBody struct {
+	K1        string    `json:"k1"`
+	Secret    string    `json:"secret"`
+	LNURL     string    `json:"lnurl"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LnurlAuthCallbackResponseBody is the LUD-04 response for wallets
+type LnurlAuthCallbackResponseBody struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type LnurlAuthStatusResponseBody struct {
+	// Status is pending until the wallet signed the k1, then ok
+	Status       string `json:"status"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// CreateLnurlAuth godoc
+// @Summary      Start an LNURL-auth login
+// @Description  Creates a k1 challenge. The lnurl is shown as a QR code for the wallet, the secret is kept by the client to collect the tokens from GET /v2/lnurlauth/status once the wallet signed the challenge.
+// @Accept       json
+// @Produce      json
+// @Tags         Account
+// @Success      200  {object}  LnurlAuthChallengeResponseBody
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/lnurlauth [post]
+func (controller *LnurlAuthController) CreateLnurlAuth(c echo.Context) error {
+	return controller.createChallenge(c, service.LnurlAuthActionLogin, 0)
+}
+
+// LinkLnurlAuth godoc
+// @Summary      Link an LNURL-auth wallet
+// @Description  Creates a k1 challenge that links the linking key of the wallet that signs it to the user, so that the wallet can log in
+// @Accept       json
+// @Produce      json
+// @Tags         Account
+// @Success      200  {object}  LnurlAuthChallengeResponseBody
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/lnurlauth/link [post]
+// @Security     OAuth2Password
+func (controller *LnurlAuthController) LinkLnurlAuth(c echo.Context) error {
+	return controller.createChallenge(c, service.LnurlAuthActionLink, c.Get("UserID").(int64))
+}
+
+func (controller *LnurlAuthController) createChallenge(c echo.Context, action string, userID int64) error {
+	callbackUrl := fmt.Sprintf("%s://%s/v2/lnurlauth/callback", c.Scheme(), c.Request().Host)
+	challenge, err := controller.svc.CreateLnurlAuthChallenge(c.Request().Context(), callbackUrl, action, userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to create lnurl-auth challenge: %v", err)
+		return lnurlAuthError(c, err)
+	}
+	return c.JSON(http.StatusOK, &LnurlAuthChallengeResponseBody{
+		K1:        challenge.K1,
+		Secret:    challenge.Secret,
+		LNURL:     challenge.LNURL,
+		ExpiresAt: challenge.ExpiresAt,
+	})
+}
+
+// LnurlAuthCallback godoc
+// @Summary      LNURL-auth callback
+// @Description  Called by the wallet with the signature of the k1 by its linking key (LUD-04)
+// @Produce      json
+// @Tags         Account
+// @Param        tag  query     string  true  "login"
+// @Param        k1   query     string  true  "k1 challenge"
+// @Param        sig  query     string  true  "DER signature of k1, hex encoded"
+// @Param        key  query     string  true  "Linking key, hex encoded"
+// @Success      200  {object}  LnurlAuthCallbackResponseBody
+// @Failure      400  {object}  LnurlAuthCallbackResponseBody
+// @Failure      500  {object}  LnurlAuthCallbackResponseBody
+// @Router       /v2/lnurlauth/callback [get]
+func (controller *LnurlAuthController) LnurlAuthCallback(c echo.Context) error {
+	if c.QueryParam("tag") != "login" {
+		return c.JSON(http.StatusBadRequest, &LnurlAuthCallbackResponseBody{Status: "ERROR", Reason: "invalid tag"})
+	}
+	ctx := service.WithClientIP(c.Request().Context(), c.RealIP())
+	err := controller.svc.CompleteLnurlAuth(ctx, c.QueryParam("k1"), c.QueryParam("sig"), c.QueryParam("key"))
+	if err != nil {
+		c.Logger().Errorf("Failed to complete lnurl-auth: %v", err)
+		status := http.StatusBadRequest
+		reason := err.Error()
+		switch {
+		case errors.Is(err, service.ErrLnurlAuthDisabled),
+			errors.Is(err, service.ErrLnurlAuthChallengeNotFound),
+			errors.Is(err, service.ErrInvalidLnurlAuthSignature),
+			errors.Is(err, service.ErrLnurlAuthKeyNotLinked),
+			errors.Is(err, service.ErrLnurlAuthKeyAlreadyLinked),
+			err.Error() == responses.AccountDeactivatedError.Message:
+		default:
+			status = http.StatusInternalServerError
+			reason = responses.GeneralServerError.Message
+		}
+		return c.JSON(status, &LnurlAuthCallbackResponseBody{Status: "ERROR", Reason: reason})
+	}
+	return c.JSON(http.StatusOK, &LnurlAuthCallbackResponseBody{Status: "OK"})
+}
+
+// LnurlAuthStatus godoc
+// @Summary      LNURL-auth status
+// @Description  Returns pending until the wallet signed the challenge, then the access and refresh token of a login. The tokens are only returned once. With "Accept: text/event-stream" the result is sent as a completed event instead of polling.
+// @Produce      json
+// @Tags         Account
+// @Param        k1      query     string  true  "k1 challenge"
+// @Param        secret  query     string  true  "Secret of the challenge"
+// @Success      200     {object}  LnurlAuthStatusResponseBody
+// @Failure      401     {object}  responses.ErrorResponse
+// @Failure      404     {object}  responses.ErrorResponse
+// @Failure      500     {object}  responses.ErrorResponse
+// @Router       /v2/lnurlauth/status [get]
+func (controller *LnurlAuthController) LnurlAuthStatus(c echo.Context) error {
+	k1 := c.QueryParam("k1")
+	secret := c.QueryParam("secret")
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/event-stream") {
+		return controller.streamLnurlAuthStatus(c, k1, secret)
+	}
+	result, err := controller.svc.LnurlAuthResult(c.Request().Context(), k1, secret)
+	if err != nil {
+		c.Logger().Errorf("Failed to get lnurl-auth result: %v", err)
+		return lnurlAuthError(c, err)
+	}
+	return c.JSON(http.StatusOK, toLnurlAuthStatus(result))
+}
+
+// streamLnurlAuthStatus sends a completed event with the result once the challenge is completed,
+// or an error event if it fails or expires
+func (controller *LnurlAuthController) streamLnurlAuthStatus(c echo.Context, k1, secret string) error {
+	ctx := c.Request().Context()
+	c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	c.Response().WriteHeader(http.StatusOK)
+	ticker := time.NewTicker(lnurlAuthPollInterval)
+	defer ticker.Stop()
+	for {
+		result, err := controller.svc.LnurlAuthResult(ctx, k1, secret)
+		if err != nil {
+			c.Logger().Errorf("Failed to get lnurl-auth result: %v", err)
+			reason := responses.GeneralServerError.Message
+			if errors.Is(err, service.ErrLnurlAuthChallengeNotFound) {
+				reason = err.Error()
+			}
+			return writeEvent(c, "error", &LnurlAuthCallbackResponseBody{Status: "ERROR", Reason: reason})
+		}
+		if result.Completed {
+			return writeEvent(c, "completed", toLnurlAuthStatus(result))
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetLnurlAuthKeys godoc
+// @Summary      Retrieve linked LNURL-auth wallets
+// @Description  Returns the linking keys of the wallets that can log in as the user
+// @Produce      json
+// @Tags         Account
+// @Success      200  {object}  []models.LnurlAuthKey
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/lnurlauth/keys [get]
+// @Security     OAuth2Password
+func (controller *LnurlAuthController) GetLnurlAuthKeys(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	keys, err := controller.svc.LnurlAuthKeys(c.Request().Context(), userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to get lnurl-auth keys user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &keys)
+}
+
+// UnlinkLnurlAuthKey godoc
+// @Summary      Unlink an LNURL-auth wallet
+// @Description  Removes a linking key, the wallet can't log in anymore
+// @Produce      json
+// @Tags         Account
+// @Param        key  path      string  true  "Linking key"
+// @Success      200  {object}  []models.LnurlAuthKey
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/lnurlauth/keys/{key} [delete]
+// @Security     OAuth2Password
+func (controller *LnurlAuthController) UnlinkLnurlAuthKey(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	ctx := c.Request().Context()
+	if err := controller.svc.UnlinkLnurlAuthKey(ctx, userID, c.Param("key")); err != nil {
+		c.Logger().Errorf("Failed to unlink lnurl-auth key user_id:%v error: %v", userID, err)
+		return lnurlAuthError(c, err)
+	}
+	return controller.GetLnurlAuthKeys(c)
+}
+
+func toLnurlAuthStatus(result *service.LnurlAuthResult) *LnurlAuthStatusResponseBody {
+	if !result.Completed {
+		return &LnurlAuthStatusResponseBody{Status: "pending"}
+	}
+	return &LnurlAuthStatusResponseBody{
+		Status:       "ok",
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+	}
+}
+
+func writeEvent(c echo.Context, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Response(), "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	c.Response().Flush()
+	return nil
+}
+
+func lnurlAuthError(c echo.Context, err error) error {
+	status := http.StatusNotFound
+	switch {
+	case errors.Is(err, service.ErrLnurlAuthDisabled),
+		errors.Is(err, service.ErrLnurlAuthChallengeNotFound),
+		errors.Is(err, service.ErrLnurlAuthKeyNotFound):
+	case err.Error() == responses.AccountDeactivatedError.Message:
+		return c.JSON(http.StatusUnauthorized, responses.AccountDeactivatedError)
+	default:
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(status, responses.ErrorResponse{
+		Error:          true,
+		Code:           8,
+		Message:        err.Error(),
+		HttpStatusCode: status,
+	})
+}
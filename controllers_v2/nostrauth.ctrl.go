@@ -0,0 +1,114 @@
+package v2controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/labstack/echo/v4"
+)
+
+// NostrLinkHeader carries the NIP-98 event of the pubkey to link, the Authorization header is the token of the user
+const NostrLinkHeader = "X-Nostr-Authorization"
+
+// NostrAuthController : Nostr (NIP-98) auth controller struct
+type NostrAuthController struct {
+	svc *service.LndhubService
+}
+
+func NewNostrAuthController(svc *service.LndhubService) *NostrAuthController {
+	return &NostrAuthController{svc: svc}
+}
+
+// LinkNostrPubkey godoc
+// @Summary      Link a nostr pubkey
+// @Description  Links the pubkey that signed the NIP-98 event of this request in the X-Nostr-Authorization header, so that it can authenticate requests of the user with "Authorization: Nostr <event>"
+// @Produce      json
+// @Tags         Account
+// @Param        X-Nostr-Authorization  header    string  true  "Nostr <base64 encoded NIP-98 event>"
+// @Success      200                    {object}  []models.NostrPubkey
+// @Failure      400                    {object}  responses.ErrorResponse
+// @Failure      403                    {object}  responses.ErrorResponse
+// @Failure      404                    {object}  responses.ErrorResponse
+// @Failure      413                    {object}  responses.ErrorResponse
+// @Failure      500                    {object}  responses.ErrorResponse
+// @Router       /v2/nostr/pubkeys [post]
+// @Security     OAuth2Password
+func (controller *NostrAuthController) LinkNostrPubkey(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	event, err := controller.svc.NostrAuth().VerifyRequest(c, c.Request().Header.Get(NostrLinkHeader))
+	if err != nil {
+		c.Logger().Errorf("Failed to verify nostr event user_id:%v error: %v", userID, err)
+		return nostrAuthError(c, err)
+	}
+	if err := controller.svc.LinkNostrPubkey(c.Request().Context(), userID, event.Pubkey); err != nil {
+		c.Logger().Errorf("Failed to link nostr pubkey user_id:%v error: %v", userID, err)
+		return nostrAuthError(c, err)
+	}
+	return controller.GetNostrPubkeys(c)
+}
+
+// GetNostrPubkeys godoc
+// @Summary      Retrieve linked nostr pubkeys
+// @Description  Returns the pubkeys that can authenticate requests of the user
+// @Produce      json
+// @Tags         Account
+// @Success      200  {object}  []models.NostrPubkey
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/nostr/pubkeys [get]
+// @Security     OAuth2Password
+func (controller *NostrAuthController) GetNostrPubkeys(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	keys, err := controller.svc.NostrPubkeys(c.Request().Context(), userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to get nostr pubkeys user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &keys)
+}
+
+// UnlinkNostrPubkey godoc
+// @Summary      Unlink a nostr pubkey
+// @Description  Removes a pubkey, its events are not accepted anymore
+// @Produce      json
+// @Tags         Account
+// @Param        pubkey  path      string  true  "Pubkey, hex encoded"
+// @Success      200     {object}  []models.NostrPubkey
+// @Failure      403     {object}  responses.ErrorResponse
+// @Failure      404     {object}  responses.ErrorResponse
+// @Failure      500     {object}  responses.ErrorResponse
+// @Router       /v2/nostr/pubkeys/{pubkey} [delete]
+// @Security     OAuth2Password
+func (controller *NostrAuthController) UnlinkNostrPubkey(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	if err := controller.svc.UnlinkNostrPubkey(c.Request().Context(), userID, c.Param("pubkey")); err != nil {
+		c.Logger().Errorf("Failed to unlink nostr pubkey user_id:%v error: %v", userID, err)
+		return nostrAuthError(c, err)
+	}
+	return controller.GetNostrPubkeys(c)
+}
+
+func nostrAuthError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrNostrAuthDisabled),
+		errors.Is(err, service.ErrNostrPubkeyNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, tokens.ErrInvalidNostrAuth),
+		errors.Is(err, service.ErrNostrPubkeyAlreadyLinked):
+	default:
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(status, responses.ErrorResponse{
+		Error:          true,
+		Code:           8,
+		Message:        err.Error(),
+		HttpStatusCode: status,
+	})
+}
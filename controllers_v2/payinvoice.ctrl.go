@@ -1,10 +1,14 @@
 package v2controllers
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/getAlby/lndhub.go/db/models"
 	"github.com/getAlby/lndhub.go/lib/responses"
 	"github.com/getAlby/lndhub.go/lib/service"
 	"github.com/getAlby/lndhub.go/lnd"
@@ -27,6 +31,19 @@ type PayInvoiceRequestBody struct {
 	Invoice string `json:"invoice" validate:"required"`
 	Amount  int64  `json:"amount" validate:"omitempty,gte=0"`
 }
+type MultiPayInvoiceRequestBody struct {
+	Invoices     []PayInvoiceRequestBody `json:"invoices" validate:"required,min=1"`
+	AllOrNothing bool                    `json:"all_or_nothing"`
+}
+type MultiPayInvoiceResponseBody struct {
+	Payments []PayInvoiceResult `json:"payments"`
+}
+
+type PayInvoiceResult struct {
+	Payment *PayInvoiceResponseBody  `json:"payment,omitempty"`
+	Error   *responses.ErrorResponse `json:"error,omitempty"`
+}
+
 type PayInvoiceResponseBody struct {
 	PaymentRequest  string `json:"payment_request,omitempty"`
 	Amount          int64  `json:"amount,omitempty"`
@@ -63,40 +80,10 @@ func (controller *PayInvoiceController) PayInvoice(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
 	}
 
-	paymentRequest := reqBody.Invoice
-	paymentRequest = strings.ToLower(paymentRequest)
-	decodedPaymentRequest, err := controller.svc.DecodePaymentRequest(c.Request().Context(), paymentRequest)
-	if err != nil {
-		if strings.Contains(err.Error(), "invoice not for current active network") {
-			c.Logger().Errorf("Incorrect network user_id:%v error: %v", userID, err)
-			return c.JSON(http.StatusBadRequest, responses.IncorrectNetworkError)
-		}
-		c.Logger().Errorf("Invalid payment request user_id:%v error: %v", userID, err)
-		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
-	}
-
-	lnPayReq := &lnd.LNPayReq{
-		PayReq:  decodedPaymentRequest,
-		Keysend: false,
-	}
-	if (decodedPaymentRequest.Timestamp + decodedPaymentRequest.Expiry) < time.Now().Unix() {
-		c.Logger().Errorf("Payment request expired")
-		return c.JSON(http.StatusBadRequest, responses.InvoiceExpiredError)
-	}
-
-	if decodedPaymentRequest.NumSatoshis == 0 {
-		amt, err := controller.svc.ParseInt(reqBody.Amount)
-		if err != nil || amt <= 0 {
-			c.Logger().Errorj(
-				log.JSON{
-					"message":        "invalid amount",
-					"error":          err,
-					"lndhub_user_id": userID,
-				},
-			)
-			return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
-		}
-		lnPayReq.PayReq.NumSatoshis = amt
+	paymentRequest := strings.ToLower(reqBody.Invoice)
+	lnPayReq, errResp := controller.decodePaymentRequest(c, paymentRequest, reqBody.Amount, userID)
+	if errResp != nil {
+		return c.JSON(errResp.HttpStatusCode, errResp)
 	}
 	resp, err := controller.svc.CheckOutgoingPaymentAllowed(c, lnPayReq, userID)
 	if err != nil {
@@ -140,3 +127,207 @@ func (controller *PayInvoiceController) PayInvoice(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, responseBody)
 }
+
+// MultiPayInvoice godoc
+// @Summary      Pay multiple invoices
+// @Description  Pay up to BATCH_PAYMENT_MAX_INVOICES bolt11 invoices concurrently. With all_or_nothing the balance for all payments is reserved before any of them is made.
+// @Accept       json
+// @Produce      json
+// @Tags         Payment
+// @Param        MultiPayInvoiceRequestBody  body      MultiPayInvoiceRequestBody  True  "Invoices to pay"
+// @Success      200                         {object}  MultiPayInvoiceResponseBody
+// @Failure      400                         {object}  responses.ErrorResponse
+// @Failure      500                         {object}  responses.ErrorResponse
+// @Router       /v2/payments/bolt11/multi [post]
+// @Security     OAuth2Password
+func (controller *PayInvoiceController) MultiPayInvoice(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := MultiPayInvoiceRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load multi payinvoice request body: user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid multi payinvoice request body user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if len(reqBody.Invoices) > controller.svc.Config.BatchPaymentMaxInvoices {
+		c.Logger().Errorf("Too many invoices in batch user_id:%v count:%v", userID, len(reqBody.Invoices))
+		return c.JSON(http.StatusBadRequest, responses.ErrorResponse{
+			Error:          true,
+			Code:           8,
+			Message:        fmt.Sprintf("a batch can contain at most %d invoices", controller.svc.Config.BatchPaymentMaxInvoices),
+			HttpStatusCode: http.StatusBadRequest,
+		})
+	}
+
+	results := make([]PayInvoiceResult, len(reqBody.Invoices))
+	paymentRequests := make([]string, len(reqBody.Invoices))
+	lnPayReqs := make([]*lnd.LNPayReq, len(reqBody.Invoices))
+	validPayReqs := []*lnd.LNPayReq{}
+	for i, payment := range reqBody.Invoices {
+		payment := payment
+		paymentRequests[i] = strings.ToLower(payment.Invoice)
+		if err := c.Validate(&payment); err != nil {
+			results[i].Error = &responses.BadArgumentsError
+		} else {
+			lnPayReqs[i], results[i].Error = controller.decodePaymentRequest(c, paymentRequests[i], payment.Amount, userID)
+		}
+		if results[i].Error != nil {
+			if reqBody.AllOrNothing {
+				return c.JSON(results[i].Error.HttpStatusCode, results[i].Error)
+			}
+			continue
+		}
+		validPayReqs = append(validPayReqs, lnPayReqs[i])
+	}
+
+	// the limits and the balance are checked for the whole batch
+	resp, err := controller.svc.CheckOutgoingPaymentsAllowed(c, validPayReqs, userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to check batch payment user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	if resp != nil {
+		c.Logger().Errorf("Error: %v user_id:%v invoices:%v", resp.Message, userID, len(validPayReqs))
+		return c.JSON(resp.HttpStatusCode, resp)
+	}
+
+	invoices := make([]*models.Invoice, len(reqBody.Invoices))
+	reservedInvoices := []*models.Invoice{}
+	for i, lnPayReq := range lnPayReqs {
+		if lnPayReq == nil {
+			continue
+		}
+		invoices[i], results[i].Error = controller.svc.AddOutgoingInvoice(c.Request().Context(), userID, paymentRequests[i], lnPayReq)
+		if results[i].Error != nil {
+			if reqBody.AllOrNothing {
+				// none of the payments is made, the invoices that were already added are closed
+				controller.svc.FailOutgoingInvoices(c.Request().Context(), reservedInvoices, results[i].Error.Message)
+				return c.JSON(results[i].Error.HttpStatusCode, results[i].Error)
+			}
+			continue
+		}
+		reservedInvoices = append(reservedInvoices, invoices[i])
+	}
+
+	entries := map[int64]models.TransactionEntry{}
+	if reqBody.AllOrNothing {
+		reserved, err := controller.svc.ReserveOutgoingPayments(c.Request().Context(), userID, reservedInvoices)
+		if err != nil {
+			c.Logger().Errorf("Could not reserve batch payment user_id:%v error: %v", userID, err)
+			return c.JSON(http.StatusBadRequest, responses.NotEnoughBalanceError)
+		}
+		for _, entry := range reserved {
+			entries[entry.InvoiceID] = entry
+		}
+	}
+
+	// Here we use context.Background because the payments should complete
+	// regardless of if the request's context is canceled or not.
+	workers := controller.svc.Config.BatchPaymentWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	wg := sync.WaitGroup{}
+	for i, invoice := range invoices {
+		if invoice == nil {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, invoice *models.Invoice) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			var sendPaymentResponse *service.SendPaymentResponse
+			var err error
+			if entry, ok := entries[invoice.ID]; ok {
+				sendPaymentResponse, err = controller.svc.PayInvoiceWithEntry(context.Background(), invoice, entry)
+			} else {
+				sendPaymentResponse, err = controller.svc.PayInvoice(context.Background(), invoice)
+			}
+			if err != nil {
+				controller.svc.Logger.Errorf("Batch payment failed invoice_id:%v user_id:%v error: %v", invoice.ID, userID, err)
+				results[i].Error = &responses.ErrorResponse{
+					Error:          true,
+					Code:           10,
+					Message:        err.Error(),
+					HttpStatusCode: http.StatusInternalServerError,
+				}
+				return
+			}
+			results[i].Payment = &PayInvoiceResponseBody{
+				PaymentRequest:  paymentRequests[i],
+				Amount:          invoice.Amount,
+				Fee:             invoice.Fee,
+				Description:     invoice.Memo,
+				DescriptionHash: invoice.DescriptionHash,
+				Destination:     invoice.DestinationPubkeyHex,
+				PaymentPreimage: sendPaymentResponse.PaymentPreimageStr,
+				PaymentHash:     sendPaymentResponse.PaymentHashStr,
+			}
+		}(i, invoice)
+	}
+	wg.Wait()
+
+	singleSuccesfulPayment := false
+	for _, result := range results {
+		if result.Error == nil {
+			singleSuccesfulPayment = true
+		}
+	}
+	status := http.StatusOK
+	if !singleSuccesfulPayment {
+		status = http.StatusInternalServerError
+	}
+	return c.JSON(status, &MultiPayInvoiceResponseBody{Payments: results})
+}
+
+// decodePaymentRequest decodes and checks a single bolt11 invoice, amount is used for zero-amount invoices
+func (controller *PayInvoiceController) decodePaymentRequest(c echo.Context, paymentRequest string, amount int64, userID int64) (*lnd.LNPayReq, *responses.ErrorResponse) {
+	lnPayReq, errResp := decodeBolt11(c, controller.svc, paymentRequest, userID)
+	if errResp != nil {
+		return nil, errResp
+	}
+	decodedPaymentRequest := lnPayReq.PayReq
+	if decodedPaymentRequest.NumSatoshis == 0 {
+		if amount <= 0 {
+			c.Logger().Errorj(
+				log.JSON{
+					"message":        "invalid amount",
+					"amount":         amount,
+					"lndhub_user_id": userID,
+				},
+			)
+			return nil, &responses.BadArgumentsError
+		}
+		lnPayReq.PayReq.NumSatoshis = amount
+	}
+	return lnPayReq, nil
+}
+
+// decodeBolt11 decodes a payment request of this network that is not expired yet, the amount is 0 for amountless invoices
+func decodeBolt11(c echo.Context, svc *service.LndhubService, paymentRequest string, userID int64) (*lnd.LNPayReq, *responses.ErrorResponse) {
+	decodedPaymentRequest, err := svc.DecodePaymentRequest(c.Request().Context(), paymentRequest)
+	if err != nil {
+		if strings.Contains(err.Error(), "invoice not for current active network") {
+			c.Logger().Errorf("Incorrect network user_id:%v error: %v", userID, err)
+			return nil, &responses.IncorrectNetworkError
+		}
+		c.Logger().Errorf("Invalid payment request user_id:%v error: %v", userID, err)
+		return nil, &responses.BadArgumentsError
+	}
+
+	lnPayReq := &lnd.LNPayReq{
+		PayReq:  decodedPaymentRequest,
+		Keysend: false,
+	}
+	if (decodedPaymentRequest.Timestamp + decodedPaymentRequest.Expiry) < time.Now().Unix() {
+		c.Logger().Errorf("Payment request expired")
+		return nil, &responses.InvoiceExpiredError
+	}
+	return lnPayReq, nil
+}
@@ -0,0 +1,401 @@
+package v2controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// ScheduledPaymentController : Scheduled payment controller struct
+type ScheduledPaymentController struct {
+	svc *service.LndhubService
+}
+
+func NewScheduledPaymentController(svc *service.LndhubService) *ScheduledPaymentController {
+	return &ScheduledPaymentController{svc: svc}
+}
+
+type CreateScheduledPaymentRequestBody struct {
+	Type          string            `json:"type" validate:"required,oneof=keysend lnaddress"`
+	Destination   string            `json:"destination" validate:"required"`
+	Amount        int64             `json:"amount" validate:"required,gt=0"`
+	Memo          string            `json:"memo" validate:"omitempty"`
+	CustomRecords map[string]string `json:"custom_records" validate:"omitempty"`
+	Schedule      string            `json:"schedule" validate:"required"`
+	Budget        int64             `json:"budget" validate:"gte=0"`
+}
+
+type UpdateScheduledPaymentRequestBody struct {
+	Amount        *int64            `json:"amount,omitempty" validate:"omitempty,gt=0"`
+	Memo          *string           `json:"memo,omitempty"`
+	CustomRecords map[string]string `json:"custom_records,omitempty"`
+	Schedule      *string           `json:"schedule,omitempty"`
+	Budget        *int64            `json:"budget,omitempty" validate:"omitempty,gte=0"`
+}
+
+type ScheduledPayment struct {
+	ID            int64             `json:"id"`
+	Type          string            `json:"type"`
+	Destination   string            `json:"destination"`
+	Amount        int64             `json:"amount"`
+	Memo          string            `json:"memo,omitempty"`
+	CustomRecords map[string]string `json:"custom_records,omitempty"`
+	Schedule      string            `json:"schedule"`
+	Budget        int64             `json:"budget,omitempty"`
+	TotalPaid     int64             `json:"total_paid"`
+	State         string            `json:"state"`
+	NextRunAt     time.Time         `json:"next_run_at"`
+	LastRunAt     time.Time         `json:"last_run_at,omitempty"`
+	LastError     string            `json:"last_error,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+type ScheduledPaymentRun struct {
+	Amount       int64     `json:"amount"`
+	State        string    `json:"state"`
+	ErrorMessage string    `json:"error_message,omitempty"`
+	PaymentHash  string    `json:"payment_hash,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreateScheduledPayment godoc
+// @Summary      Schedule a payment
+// @Description  Schedules a recurring keysend payment or payment to a Lightning Address. The schedule is a cron expression (e.g. "0 9 * * 1"), a descriptor (e.g. "@daily") or an interval (e.g. "@every 12h"). The payment is completed once the budget (total amount) is used up.
+// @Accept       json
+// @Produce      json
+// @Tags         Scheduled Payment
+// @Param        CreateScheduledPaymentRequestBody  body      CreateScheduledPaymentRequestBody  True  "Payment to schedule"
+// @Success      200                                {object}  ScheduledPayment
+// @Failure      400                                {object}  responses.ErrorResponse
+// @Failure      500                                {object}  responses.ErrorResponse
+// @Router       /v2/payments/scheduled [post]
+// @Security     OAuth2Password
+func (controller *ScheduledPaymentController) CreateScheduledPayment(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := CreateScheduledPaymentRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load scheduled payment request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid scheduled payment request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	limits := controller.svc.GetLimits(c)
+	if limits.MaxSendAmount >= 0 && reqBody.Amount > limits.MaxSendAmount {
+		return c.JSON(http.StatusBadRequest, responses.SendExceededError)
+	}
+	customRecords, err := parseCustomRecords(reqBody.CustomRecords)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	payment := &models.ScheduledPayment{
+		UserID:        userID,
+		Type:          reqBody.Type,
+		Destination:   reqBody.Destination,
+		Amount:        reqBody.Amount,
+		Memo:          reqBody.Memo,
+		CustomRecords: customRecords,
+		Schedule:      reqBody.Schedule,
+		Budget:        reqBody.Budget,
+		// the send limits of this token apply to every run of the payment
+		MaxSendAmount: &limits.MaxSendAmount,
+		MaxSendVolume: &limits.MaxSendVolume,
+	}
+	err = controller.svc.CreateScheduledPayment(c.Request().Context(), payment)
+	if err != nil {
+		c.Logger().Errorf("Failed to create scheduled payment user_id:%v error: %v", userID, err)
+		return scheduledPaymentError(c, err)
+	}
+	return c.JSON(http.StatusOK, toScheduledPaymentResponse(payment))
+}
+
+// GetScheduledPayments godoc
+// @Summary      Retrieve scheduled payments
+// @Description  Returns the scheduled payments of a user
+// @Accept       json
+// @Produce      json
+// @Tags         Scheduled Payment
+// @Success      200  {object}  []ScheduledPayment
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/payments/scheduled [get]
+// @Security     OAuth2Password
+func (controller *ScheduledPaymentController) GetScheduledPayments(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	payments, err := controller.svc.ScheduledPaymentsFor(c.Request().Context(), userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to get scheduled payments user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	response := make([]ScheduledPayment, len(payments))
+	for i := range payments {
+		response[i] = toScheduledPaymentResponse(&payments[i])
+	}
+	return c.JSON(http.StatusOK, &response)
+}
+
+// GetScheduledPayment godoc
+// @Summary      Retrieve a scheduled payment
+// @Description  Returns a scheduled payment
+// @Accept       json
+// @Produce      json
+// @Tags         Scheduled Payment
+// @Param        id   path      int  true  "Scheduled payment id"
+// @Success      200  {object}  ScheduledPayment
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/payments/scheduled/{id} [get]
+// @Security     OAuth2Password
+func (controller *ScheduledPaymentController) GetScheduledPayment(c echo.Context) error {
+	payment, err := controller.findScheduledPayment(c)
+	if err != nil {
+		return scheduledPaymentError(c, err)
+	}
+	return c.JSON(http.StatusOK, toScheduledPaymentResponse(payment))
+}
+
+// GetScheduledPaymentRuns godoc
+// @Summary      Retrieve the runs of a scheduled payment
+// @Description  Returns the outcome of every run of a scheduled payment, the latest first
+// @Accept       json
+// @Produce      json
+// @Tags         Scheduled Payment
+// @Param        id   path      int  true  "Scheduled payment id"
+// @Success      200  {object}  []ScheduledPaymentRun
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/payments/scheduled/{id}/runs [get]
+// @Security     OAuth2Password
+func (controller *ScheduledPaymentController) GetScheduledPaymentRuns(c echo.Context) error {
+	payment, err := controller.findScheduledPayment(c)
+	if err != nil {
+		return scheduledPaymentError(c, err)
+	}
+	runs, err := controller.svc.ScheduledPaymentRunsFor(c.Request().Context(), payment.ID)
+	if err != nil {
+		c.Logger().Errorf("Failed to get scheduled payment runs id:%v error: %v", payment.ID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	response := make([]ScheduledPaymentRun, len(runs))
+	for i, run := range runs {
+		response[i] = ScheduledPaymentRun{
+			Amount:       run.Amount,
+			State:        run.State,
+			ErrorMessage: run.ErrorMessage,
+			CreatedAt:    run.CreatedAt,
+		}
+		if run.Invoice != nil && run.Invoice.RHash != "" {
+			response[i].PaymentHash = run.Invoice.RHash
+		}
+	}
+	return c.JSON(http.StatusOK, &response)
+}
+
+// UpdateScheduledPayment godoc
+// @Summary      Update a scheduled payment
+// @Description  Changes the amount, memo, custom records, schedule or budget of a scheduled payment
+// @Accept       json
+// @Produce      json
+// @Tags         Scheduled Payment
+// @Param        id                                 path      int                                true  "Scheduled payment id"
+// @Param        UpdateScheduledPaymentRequestBody  body      UpdateScheduledPaymentRequestBody  True  "Changes"
+// @Success      200                                {object}  ScheduledPayment
+// @Failure      400                                {object}  responses.ErrorResponse
+// @Failure      404                                {object}  responses.ErrorResponse
+// @Failure      500                                {object}  responses.ErrorResponse
+// @Router       /v2/payments/scheduled/{id} [put]
+// @Security     OAuth2Password
+func (controller *ScheduledPaymentController) UpdateScheduledPayment(c echo.Context) error {
+	reqBody := UpdateScheduledPaymentRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load scheduled payment request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid scheduled payment request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	payment, err := controller.findScheduledPayment(c)
+	if err != nil {
+		return scheduledPaymentError(c, err)
+	}
+	if reqBody.Amount != nil {
+		limits := controller.svc.GetLimits(c)
+		if limits.MaxSendAmount >= 0 && *reqBody.Amount > limits.MaxSendAmount {
+			return c.JSON(http.StatusBadRequest, responses.SendExceededError)
+		}
+		payment.Amount = *reqBody.Amount
+	}
+	if reqBody.Memo != nil {
+		payment.Memo = *reqBody.Memo
+	}
+	if reqBody.CustomRecords != nil {
+		payment.CustomRecords, err = parseCustomRecords(reqBody.CustomRecords)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+		}
+	}
+	scheduleChanged := reqBody.Schedule != nil && *reqBody.Schedule != payment.Schedule
+	if reqBody.Schedule != nil {
+		payment.Schedule = *reqBody.Schedule
+	}
+	if reqBody.Budget != nil {
+		payment.Budget = *reqBody.Budget
+	}
+	err = controller.svc.UpdateScheduledPayment(c.Request().Context(), payment, scheduleChanged)
+	if err != nil {
+		c.Logger().Errorf("Failed to update scheduled payment id:%v error: %v", payment.ID, err)
+		return scheduledPaymentError(c, err)
+	}
+	return c.JSON(http.StatusOK, toScheduledPaymentResponse(payment))
+}
+
+// DeleteScheduledPayment godoc
+// @Summary      Delete a scheduled payment
+// @Description  Deletes a scheduled payment, payments that were already made are not affected
+// @Accept       json
+// @Produce      json
+// @Tags         Scheduled Payment
+// @Param        id   path      int  true  "Scheduled payment id"
+// @Success      200  {object}  ScheduledPayment
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/payments/scheduled/{id} [delete]
+// @Security     OAuth2Password
+func (controller *ScheduledPaymentController) DeleteScheduledPayment(c echo.Context) error {
+	payment, err := controller.findScheduledPayment(c)
+	if err != nil {
+		return scheduledPaymentError(c, err)
+	}
+	err = controller.svc.DeleteScheduledPayment(c.Request().Context(), payment.UserID, payment.ID)
+	if err != nil {
+		c.Logger().Errorf("Failed to delete scheduled payment id:%v error: %v", payment.ID, err)
+		return scheduledPaymentError(c, err)
+	}
+	return c.JSON(http.StatusOK, toScheduledPaymentResponse(payment))
+}
+
+// PauseScheduledPayment godoc
+// @Summary      Pause a scheduled payment
+// @Description  No payments are made until the scheduled payment is resumed
+// @Accept       json
+// @Produce      json
+// @Tags         Scheduled Payment
+// @Param        id   path      int  true  "Scheduled payment id"
+// @Success      200  {object}  ScheduledPayment
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/payments/scheduled/{id}/pause [post]
+// @Security     OAuth2Password
+func (controller *ScheduledPaymentController) PauseScheduledPayment(c echo.Context) error {
+	return controller.updateState(c, controller.svc.PauseScheduledPayment)
+}
+
+// ResumeScheduledPayment godoc
+// @Summary      Resume a scheduled payment
+// @Description  Resumes a paused scheduled payment, runs that were missed while it was paused are skipped
+// @Accept       json
+// @Produce      json
+// @Tags         Scheduled Payment
+// @Param        id   path      int  true  "Scheduled payment id"
+// @Success      200  {object}  ScheduledPayment
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/payments/scheduled/{id}/resume [post]
+// @Security     OAuth2Password
+func (controller *ScheduledPaymentController) ResumeScheduledPayment(c echo.Context) error {
+	return controller.updateState(c, controller.svc.ResumeScheduledPayment)
+}
+
+func (controller *ScheduledPaymentController) updateState(c echo.Context, update func(ctx context.Context, userId, id int64) (*models.ScheduledPayment, error)) error {
+	userID := c.Get("UserID").(int64)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return scheduledPaymentError(c, service.ErrScheduledPaymentNotFound)
+	}
+	payment, err := update(c.Request().Context(), userID, id)
+	if err != nil {
+		c.Logger().Errorf("Failed to update scheduled payment id:%v error: %v", id, err)
+		return scheduledPaymentError(c, err)
+	}
+	return c.JSON(http.StatusOK, toScheduledPaymentResponse(payment))
+}
+
+func (controller *ScheduledPaymentController) findScheduledPayment(c echo.Context) (*models.ScheduledPayment, error) {
+	userID := c.Get("UserID").(int64)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, service.ErrScheduledPaymentNotFound
+	}
+	return controller.svc.FindScheduledPayment(c.Request().Context(), userID, id)
+}
+
+func parseCustomRecords(customRecords map[string]string) (map[uint64][]byte, error) {
+	if len(customRecords) == 0 {
+		return nil, nil
+	}
+	result := map[uint64][]byte{}
+	for key, value := range customRecords {
+		intKey, err := strconv.ParseUint(key, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		result[intKey] = []byte(value)
+	}
+	return result, nil
+}
+
+func toScheduledPaymentResponse(payment *models.ScheduledPayment) ScheduledPayment {
+	response := ScheduledPayment{
+		ID:          payment.ID,
+		Type:        payment.Type,
+		Destination: payment.Destination,
+		Amount:      payment.Amount,
+		Memo:        payment.Memo,
+		Schedule:    payment.Schedule,
+		Budget:      payment.Budget,
+		TotalPaid:   payment.TotalPaid,
+		State:       payment.State,
+		NextRunAt:   payment.NextRunAt,
+		LastRunAt:   payment.LastRunAt.Time,
+		LastError:   payment.LastError,
+		CreatedAt:   payment.CreatedAt,
+	}
+	if len(payment.CustomRecords) > 0 {
+		response.CustomRecords = map[string]string{}
+		for key, value := range payment.CustomRecords {
+			response.CustomRecords[strconv.FormatUint(key, 10)] = string(value)
+		}
+	}
+	return response
+}
+
+func scheduledPaymentError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, service.ErrScheduledPaymentNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrScheduledPaymentCompleted),
+		errors.Is(err, service.ErrInvalidSchedule),
+		errors.Is(err, service.ErrInvalidScheduledPayment),
+		errors.Is(err, service.ErrInvalidLightningAddress):
+	default:
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(status, responses.ErrorResponse{
+		Error:          true,
+		Code:           8,
+		Message:        err.Error(),
+		HttpStatusCode: status,
+	})
+}
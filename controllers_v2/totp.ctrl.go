@@ -0,0 +1,287 @@
+package v2controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/controllers"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+// TOTPController : TOTP two-factor authentication controller struct
+type TOTPController struct {
+	svc *service.LndhubService
+}
+
+func NewTOTPController(svc *service.LndhubService) *TOTPController {
+	return &TOTPController{svc: svc}
+}
+
+type TOTPStatusResponseBody struct {
+	Enrolled                bool  `json:"enrolled"`
+	PendingVerification     bool  `json:"pending_verification"`
+	PaymentThreshold        int64 `json:"payment_threshold"`
+	RequireOnPasswordChange bool  `json:"require_on_password_change"`
+}
+
+type EnrolTOTPResponseBody struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+}
+
+type VerifyTOTPRequestBody struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type RecoveryCodesResponseBody struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type UpdateTOTPRequestBody struct {
+	PaymentThreshold        int64 `json:"payment_threshold" validate:"gte=0"`
+	RequireOnPasswordChange bool  `json:"require_on_password_change"`
+}
+
+// GetTOTP godoc
+// @Summary      Retrieve the TOTP status
+// @Description  Returns if the user enrolled TOTP two-factor authentication and when codes are required
+// @Accept       json
+// @Produce      json
+// @Tags         TOTP
+// @Success      200  {object}  TOTPStatusResponseBody
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/totp [get]
+// @Security     OAuth2Password
+func (controller *TOTPController) GetTOTP(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	credential, err := controller.svc.TOTPCredential(c.Request().Context(), userID)
+	if errors.Is(err, service.ErrTOTPNotEnrolled) {
+		return c.JSON(http.StatusOK, &TOTPStatusResponseBody{})
+	}
+	if err != nil {
+		c.Logger().Errorf("Failed to get totp user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &TOTPStatusResponseBody{
+		Enrolled:                credential.Verified(),
+		PendingVerification:     !credential.Verified(),
+		PaymentThreshold:        credential.PaymentThreshold,
+		RequireOnPasswordChange: credential.RequireOnPasswordChange,
+	})
+}
+
+// EnrolTOTP godoc
+// @Summary      Enrol TOTP
+// @Description  Creates a TOTP secret for an authenticator app, which scans the QR code of GET /v2/totp/qr. Codes are required once a code of the secret was verified with POST /v2/totp/verify.
+// @Accept       json
+// @Produce      json
+// @Tags         TOTP
+// @Success      200  {object}  EnrolTOTPResponseBody
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/totp [post]
+// @Security     OAuth2Password
+func (controller *TOTPController) EnrolTOTP(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	enrolment, err := controller.svc.EnrolTOTP(c.Request().Context(), userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to enrol totp user_id:%v error: %v", userID, err)
+		return totpError(c, err)
+	}
+	return c.JSON(http.StatusOK, &EnrolTOTPResponseBody{
+		Secret: enrolment.Secret,
+		URL:    enrolment.URL,
+	})
+}
+
+// TOTPQR godoc
+// @Summary      TOTP QR code
+// @Description  Returns the QR code of the TOTP secret that was not verified yet, for authenticator apps to scan
+// @Produce      png
+// @Tags         TOTP
+// @Success      200
+// @Failure      400  {object}  responses.ErrorResponse
+// @Failure      403  {object}  responses.ErrorResponse
+// @Failure      404  {object}  responses.ErrorResponse
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/totp/qr [get]
+// @Security     OAuth2Password
+func (controller *TOTPController) TOTPQR(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	url, err := controller.svc.TOTPEnrolmentURL(c.Request().Context(), userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to get totp enrolment user_id:%v error: %v", userID, err)
+		return totpError(c, err)
+	}
+	return controllers.QRCode(c, url)
+}
+
+// VerifyTOTP godoc
+// @Summary      Verify TOTP
+// @Description  Completes the enrolment with a code of the authenticator app and returns the recovery codes. They are only shown once and each of them can be used once instead of a code.
+// @Accept       json
+// @Produce      json
+// @Tags         TOTP
+// @Param        VerifyTOTPRequestBody  body      VerifyTOTPRequestBody  True  "Code of the authenticator app"
+// @Success      200                    {object}  RecoveryCodesResponseBody
+// @Failure      400                    {object}  responses.ErrorResponse
+// @Failure      401                    {object}  responses.ErrorResponse
+// @Failure      403                    {object}  responses.ErrorResponse
+// @Failure      404                    {object}  responses.ErrorResponse
+// @Failure      500                    {object}  responses.ErrorResponse
+// @Router       /v2/totp/verify [post]
+// @Security     OAuth2Password
+func (controller *TOTPController) VerifyTOTP(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := VerifyTOTPRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load verify totp request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid verify totp request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	codes, err := controller.svc.VerifyTOTP(c.Request().Context(), userID, reqBody.Code)
+	if err != nil {
+		c.Logger().Errorf("Failed to verify totp user_id:%v error: %v", userID, err)
+		return totpError(c, err)
+	}
+	return c.JSON(http.StatusOK, &RecoveryCodesResponseBody{RecoveryCodes: codes})
+}
+
+// UpdateTOTP godoc
+// @Summary      Update TOTP settings
+// @Description  Changes if codes are also required for payments above payment_threshold (0 never requires one) and for password changes. Requires a code in the X-TOTP-Code header.
+// @Accept       json
+// @Produce      json
+// @Tags         TOTP
+// @Param        X-TOTP-Code            header    string                 True  "TOTP or recovery code"
+// @Param        UpdateTOTPRequestBody  body      UpdateTOTPRequestBody  True  "TOTP settings"
+// @Success      200                    {object}  TOTPStatusResponseBody
+// @Failure      400                    {object}  responses.ErrorResponse
+// @Failure      401                    {object}  responses.ErrorResponse
+// @Failure      403                    {object}  responses.ErrorResponse
+// @Failure      404                    {object}  responses.ErrorResponse
+// @Failure      429                    {object}  responses.ErrorResponse
+// @Failure      500                    {object}  responses.ErrorResponse
+// @Router       /v2/totp [put]
+// @Security     OAuth2Password
+func (controller *TOTPController) UpdateTOTP(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := UpdateTOTPRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load update totp request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid update totp request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := controller.requireCode(c, userID); err != nil {
+		return err
+	}
+	credential, err := controller.svc.UpdateTOTPSettings(c.Request().Context(), userID, reqBody.PaymentThreshold, reqBody.RequireOnPasswordChange)
+	if err != nil {
+		c.Logger().Errorf("Failed to update totp user_id:%v error: %v", userID, err)
+		return totpError(c, err)
+	}
+	return c.JSON(http.StatusOK, &TOTPStatusResponseBody{
+		Enrolled:                true,
+		PaymentThreshold:        credential.PaymentThreshold,
+		RequireOnPasswordChange: credential.RequireOnPasswordChange,
+	})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary      Regenerate recovery codes
+// @Description  Replaces all recovery codes, e.g. when most of them were used. Requires a code in the X-TOTP-Code header.
+// @Accept       json
+// @Produce      json
+// @Tags         TOTP
+// @Param        X-TOTP-Code  header    string  True  "TOTP or recovery code"
+// @Success      200          {object}  RecoveryCodesResponseBody
+// @Failure      401          {object}  responses.ErrorResponse
+// @Failure      403          {object}  responses.ErrorResponse
+// @Failure      404          {object}  responses.ErrorResponse
+// @Failure      429          {object}  responses.ErrorResponse
+// @Failure      500          {object}  responses.ErrorResponse
+// @Router       /v2/totp/recovery-codes [post]
+// @Security     OAuth2Password
+func (controller *TOTPController) RegenerateRecoveryCodes(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	if err := controller.requireCode(c, userID); err != nil {
+		return err
+	}
+	codes, err := controller.svc.RegenerateRecoveryCodes(c.Request().Context(), userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to regenerate recovery codes user_id:%v error: %v", userID, err)
+		return totpError(c, err)
+	}
+	return c.JSON(http.StatusOK, &RecoveryCodesResponseBody{RecoveryCodes: codes})
+}
+
+// DisableTOTP godoc
+// @Summary      Disable TOTP
+// @Description  Removes the TOTP secret and the recovery codes, or cancels an enrolment that was not verified. Requires a code in the X-TOTP-Code header once TOTP was verified.
+// @Accept       json
+// @Produce      json
+// @Tags         TOTP
+// @Param        X-TOTP-Code  header    string  False  "TOTP or recovery code"
+// @Success      200          {object}  TOTPStatusResponseBody
+// @Failure      401          {object}  responses.ErrorResponse
+// @Failure      403          {object}  responses.ErrorResponse
+// @Failure      404          {object}  responses.ErrorResponse
+// @Failure      429          {object}  responses.ErrorResponse
+// @Failure      500          {object}  responses.ErrorResponse
+// @Router       /v2/totp [delete]
+// @Security     OAuth2Password
+func (controller *TOTPController) DisableTOTP(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	if err := controller.requireCode(c, userID); err != nil {
+		return err
+	}
+	if err := controller.svc.DisableTOTP(c.Request().Context(), userID); err != nil {
+		c.Logger().Errorf("Failed to disable totp user_id:%v error: %v", userID, err)
+		return totpError(c, err)
+	}
+	return c.JSON(http.StatusOK, &TOTPStatusResponseBody{})
+}
+
+// requireCode checks the code of the X-TOTP-Code header, a stolen access token alone can't change the second factor
+func (controller *TOTPController) requireCode(c echo.Context, userID int64) error {
+	ctx := service.WithClientIP(c.Request().Context(), c.RealIP())
+	err := controller.svc.RequireTOTP(ctx, userID, c.Request().Header.Get(service.TOTPCodeHeader), service.TOTPActionSettings)
+	if err != nil {
+		c.Logger().Errorf("TOTP code rejected user_id:%v error: %v", userID, err)
+		return totpError(c, err)
+	}
+	return nil
+}
+
+func totpError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, service.ErrTOTPRequired):
+		return c.JSON(http.StatusUnauthorized, responses.TOTPRequiredError)
+	case errors.Is(err, service.ErrInvalidTOTPCode):
+		return c.JSON(http.StatusUnauthorized, responses.InvalidTOTPCodeError)
+	case errors.Is(err, service.ErrLoginLocked):
+		return c.JSON(http.StatusTooManyRequests, responses.LoginLockedError)
+	case errors.Is(err, service.ErrTOTPNotEnrolled):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrTOTPAlreadyEnrolled):
+	default:
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(status, responses.ErrorResponse{
+		Error:          true,
+		Code:           8,
+		Message:        err.Error(),
+		HttpStatusCode: status,
+	})
+}
@@ -0,0 +1,126 @@
+package v2controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/labstack/echo/v4"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// TransferController : Internal transfer controller struct
+type TransferController struct {
+	svc *service.LndhubService
+}
+
+func NewTransferController(svc *service.LndhubService) *TransferController {
+	return &TransferController{svc: svc}
+}
+
+type InternalTransferRequestBody struct {
+	Amount    int64             `json:"amount" validate:"required,gt=0"`
+	Recipient string            `json:"recipient" validate:"required"`
+	Memo      string            `json:"memo" validate:"omitempty"`
+	Metadata  map[string]string `json:"metadata" validate:"omitempty"`
+}
+
+type InternalTransferResponseBody struct {
+	Amount          int64             `json:"amount"`
+	Fee             int64             `json:"fee"`
+	Recipient       string            `json:"recipient"`
+	Description     string            `json:"description,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	PaymentPreimage string            `json:"payment_preimage"`
+	PaymentHash     string            `json:"payment_hash"`
+}
+
+// InternalTransfer godoc
+// @Summary      Transfer to another user
+// @Description  Moves sats to another user of this hub, identified by login or Lightning Address. No lightning payment is made.
+// @Accept       json
+// @Produce      json
+// @Tags         Payment
+// @Param        InternalTransferRequestBody  body      InternalTransferRequestBody  True  "Transfer to make"
+// @Success      200                          {object}  InternalTransferResponseBody
+// @Failure      400                          {object}  responses.ErrorResponse
+// @Failure      500                          {object}  responses.ErrorResponse
+// @Router       /v2/payments/internal [post]
+// @Security     OAuth2Password
+func (controller *TransferController) InternalTransfer(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := InternalTransferRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load internal transfer request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid internal transfer request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+
+	recipient, err := controller.svc.FindTransferRecipient(c.Request().Context(), reqBody.Recipient)
+	if err != nil {
+		c.Logger().Errorf("Failed to find transfer recipient %s: %v", reqBody.Recipient, err)
+		return transferError(c, err)
+	}
+
+	// internal transfers don't need a fee reserve, as there are no routing fees
+	syntheticPayReq := &lnd.LNPayReq{
+		PayReq: &lnrpc.PayReq{
+			Destination: controller.svc.LndClient.GetMainPubkey(),
+			NumSatoshis: reqBody.Amount,
+		},
+	}
+	resp, err := controller.svc.CheckOutgoingPaymentAllowed(c, syntheticPayReq, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	if resp != nil {
+		c.Logger().Errorf("Error: %v user_id:%v amount:%v", resp.Message, userID, reqBody.Amount)
+		return c.JSON(resp.HttpStatusCode, resp)
+	}
+	// the recipient's limits are the configured ones, token limits only apply to the sender
+	resp, err = controller.svc.CheckIncomingLimits(c.Request().Context(), controller.svc.DefaultLimits(), reqBody.Amount, recipient.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	if resp != nil {
+		c.Logger().Errorf("Error: %v recipient_id:%v amount:%v", resp.Message, recipient.ID, reqBody.Amount)
+		return c.JSON(resp.HttpStatusCode, resp)
+	}
+
+	invoice, err := controller.svc.InternalTransfer(c.Request().Context(), userID, recipient, reqBody.Amount, reqBody.Memo, reqBody.Metadata, nil)
+	if err != nil {
+		return transferError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &InternalTransferResponseBody{
+		Amount:          invoice.Amount,
+		Fee:             invoice.Fee,
+		Recipient:       reqBody.Recipient,
+		Description:     invoice.Memo,
+		Metadata:        invoice.Metadata,
+		PaymentPreimage: invoice.Preimage,
+		PaymentHash:     invoice.RHash,
+	})
+}
+
+func transferError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrTransferRecipientNotFound),
+		errors.Is(err, service.ErrTransferToSelf):
+		return c.JSON(http.StatusBadRequest, responses.ErrorResponse{
+			Error:          true,
+			Code:           8,
+			Message:        err.Error(),
+			HttpStatusCode: http.StatusBadRequest,
+		})
+	case errors.Is(err, service.ErrBudgetExceeded):
+		return c.JSON(http.StatusBadRequest, responses.BudgetExceededError)
+	default:
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+}
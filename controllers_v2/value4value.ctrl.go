@@ -0,0 +1,246 @@
+package v2controllers
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+type ValueForValueRequestBody struct {
+	Amount     int64                    `json:"amount" validate:"required,gt=0"`
+	Memo       string                   `json:"memo" validate:"omitempty"`
+	Recipients []service.ValueRecipient `json:"recipients" validate:"required,min=1,dive"`
+	Boostagram *service.Boostagram      `json:"boostagram" validate:"omitempty"`
+}
+
+// ValueForValue godoc
+// @Summary      Make a value for value payment
+// @Description  Splits the amount between the recipients of a podcast:value block and pays every recipient with a keysend payment, or with an internal transfer for Lightning Addresses of this hub. The boostagram is sent along with every payment.
+// @Accept       json
+// @Produce      json
+// @Tags         Payment
+// @Param        ValueForValueRequestBody  body      ValueForValueRequestBody  True  "Value block to pay"
+// @Success      200                       {object}  MultiKeySendResponseBody
+// @Failure      400                       {object}  responses.ErrorResponse
+// @Failure      500                       {object}  responses.ErrorResponse
+// @Router       /v2/payments/value4value [post]
+// @Security     OAuth2Password
+func (controller *KeySendController) ValueForValue(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := ValueForValueRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load value4value request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid value4value request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	amounts, err := service.CalculateValueSplits(reqBody.Amount, reqBody.Recipients)
+	if err != nil {
+		c.Logger().Errorf("Invalid value4value splits user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusBadRequest, valueSplitError(err))
+	}
+	var totalAmount int64
+	for _, amount := range amounts {
+		totalAmount += amount
+	}
+	// every split is checked before the first one is paid, a bad recipient
+	// must not fail the request after other splits were already sent
+	var errResp *responses.ErrorResponse
+	customRecords := make([]map[string]string, len(reqBody.Recipients))
+	transferRecipients := make([]*models.User, len(reqBody.Recipients))
+	for i := range reqBody.Recipients {
+		recipient := &reqBody.Recipients[i]
+		customRecords[i], err = valueCustomRecords(&reqBody, recipient, amounts[i])
+		if err != nil {
+			c.Logger().Errorf("Invalid boostagram user_id:%v error: %v", userID, err)
+			return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+		}
+		transferRecipients[i], errResp = controller.checkValueRecipient(c.Request().Context(), recipient, customRecords[i])
+		if errResp != nil {
+			c.Logger().Errorf("Invalid value4value recipient %v user_id:%v error: %s", recipient.Address, userID, errResp.Message)
+			return c.JSON(errResp.HttpStatusCode, errResp)
+		}
+	}
+	errResp = controller.checkKeysendPaymentAllowed(c, totalAmount, userID)
+	if errResp != nil {
+		c.Logger().Errorf("Failed to make value4value payments: %s", errResp.Message)
+		return c.JSON(errResp.HttpStatusCode, errResp)
+	}
+
+	result := &MultiKeySendResponseBody{
+		Keysends: []KeySendResult{},
+	}
+	singleSuccesfulPayment := false
+	// Here we use context.Background because the payments should complete
+	// regardless of if the request's context is canceled or not.
+	ctx := service.DetachedContext(c.Request().Context())
+	for i, recipient := range reqBody.Recipients {
+		var res *KeySendResponseBody
+		var errResp *responses.ErrorResponse
+		switch {
+		case amounts[i] == 0:
+			errResp = valueSplitError(service.ErrValueSplitTooSmall)
+		case recipient.Type == service.ValueRecipientTypeLnAddress:
+			res, errResp = controller.valueTransfer(ctx, &recipient, transferRecipients[i], amounts[i], reqBody.Memo, customRecords[i], userID)
+		default:
+			res, errResp = controller.SingleKeySend(ctx, &KeySendRequestBody{
+				Amount:        amounts[i],
+				Destination:   recipient.Address,
+				Memo:          reqBody.Memo,
+				CustomRecords: customRecords[i],
+			}, userID)
+		}
+		if errResp != nil {
+			controller.svc.Logger.Errorf("Error making value4value split payment %v %s", recipient, errResp.Message)
+			result.Keysends = append(result.Keysends, KeySendResult{
+				Keysend: &KeySendResponseBody{
+					Amount:        amounts[i],
+					Destination:   recipient.Address,
+					CustomRecords: customRecords[i],
+				},
+				Error: errResp,
+			})
+			continue
+		}
+		result.Keysends = append(result.Keysends, KeySendResult{
+			Keysend: res,
+		})
+		singleSuccesfulPayment = true
+	}
+	status := http.StatusOK
+	if !singleSuccesfulPayment {
+		status = http.StatusInternalServerError
+	}
+	return c.JSON(status, result)
+}
+
+// checkValueRecipient checks the address and custom records of a split before any split is paid.
+// It returns the user of a Lightning Address of this hub.
+func (controller *KeySendController) checkValueRecipient(ctx context.Context, recipient *service.ValueRecipient, customRecords map[string]string) (*models.User, *responses.ErrorResponse) {
+	if _, err := parseValueCustomRecords(customRecords); err != nil {
+		return nil, &responses.BadArgumentsError
+	}
+	if recipient.Type == service.ValueRecipientTypeLnAddress {
+		user, err := controller.svc.FindTransferRecipient(ctx, recipient.Address)
+		if err != nil {
+			if errors.Is(err, service.ErrTransferRecipientNotFound) {
+				return nil, valueSplitError(err)
+			}
+			return nil, &responses.GeneralServerError
+		}
+		return user, nil
+	}
+	if _, err := hex.DecodeString(recipient.Address); err != nil || len(recipient.Address) != common.DestinationPubkeyHexSize {
+		return nil, &responses.InvalidDestinationError
+	}
+	if controller.svc.LndClient.IsIdentityPubkey(recipient.Address) && customRecords[strconv.Itoa(service.TLV_WALLET_ID)] == "" {
+		return nil, &responses.ErrorResponse{
+			Error:          true,
+			Code:           8,
+			Message:        fmt.Sprintf("Internal keysend payments require the custom record %d to be present.", service.TLV_WALLET_ID),
+			HttpStatusCode: http.StatusBadRequest,
+		}
+	}
+	return nil, nil
+}
+
+// parseValueCustomRecords parses the keys of custom records, they have to fit into
+// an int as well because SingleKeySend parses them with strconv.Atoi
+func parseValueCustomRecords(customRecords map[string]string) (map[uint64][]byte, error) {
+	records := map[uint64][]byte{}
+	for key, value := range customRecords {
+		intKey, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if intKey < 0 {
+			return nil, fmt.Errorf("invalid custom record key %s", key)
+		}
+		records[uint64(intKey)] = []byte(value)
+	}
+	return records, nil
+}
+
+// valueTransfer pays a split to a Lightning Address of this hub with an internal transfer
+func (controller *KeySendController) valueTransfer(ctx context.Context, recipient *service.ValueRecipient, user *models.User, amount int64, memo string, customRecords map[string]string, userID int64) (*KeySendResponseBody, *responses.ErrorResponse) {
+	// the recipient's limits are the configured ones, token limits only apply to the sender
+	resp, err := controller.svc.CheckIncomingLimits(ctx, controller.svc.DefaultLimits(), amount, user.ID)
+	if err != nil {
+		return nil, &responses.GeneralServerError
+	}
+	if resp != nil {
+		return nil, resp
+	}
+	records, err := parseValueCustomRecords(customRecords)
+	if err != nil {
+		return nil, &responses.BadArgumentsError
+	}
+	invoice, err := controller.svc.InternalTransfer(ctx, userID, user, amount, memo, nil, records)
+	if err != nil {
+		if errors.Is(err, service.ErrTransferToSelf) {
+			return nil, valueSplitError(err)
+		}
+		if errors.Is(err, service.ErrBudgetExceeded) {
+			return nil, &responses.BudgetExceededError
+		}
+		return nil, &responses.ErrorResponse{
+			Error:          true,
+			Code:           10,
+			Message:        err.Error(),
+			HttpStatusCode: http.StatusInternalServerError,
+		}
+	}
+	return &KeySendResponseBody{
+		Amount:          invoice.Amount,
+		Fee:             invoice.Fee,
+		Description:     invoice.Memo,
+		Destination:     recipient.Address,
+		CustomRecords:   customRecords,
+		PaymentPreimage: invoice.Preimage,
+		PaymentHash:     invoice.RHash,
+	}, nil
+}
+
+// valueCustomRecords returns the custom records of a single split: the recipient's
+// custom key and the boostagram with the recipient's name and amount
+func valueCustomRecords(reqBody *ValueForValueRequestBody, recipient *service.ValueRecipient, amount int64) (map[string]string, error) {
+	customRecords := map[string]string{}
+	if recipient.CustomKey != "" {
+		customRecords[recipient.CustomKey] = recipient.CustomValue
+	}
+	if reqBody.Boostagram != nil {
+		boostagram := *reqBody.Boostagram
+		boostagram.Name = recipient.Name
+		boostagram.ValueMsat = amount * 1000
+		if boostagram.ValueMsatTotal == 0 {
+			boostagram.ValueMsatTotal = reqBody.Amount * 1000
+		}
+		encoded, err := json.Marshal(&boostagram)
+		if err != nil {
+			return nil, err
+		}
+		customRecords[strconv.Itoa(service.TLV_BOOSTAGRAM)] = string(encoded)
+	}
+	return customRecords, nil
+}
+
+func valueSplitError(err error) *responses.ErrorResponse {
+	return &responses.ErrorResponse{
+		Error:          true,
+		Code:           8,
+		Message:        err.Error(),
+		HttpStatusCode: http.StatusBadRequest,
+	}
+}
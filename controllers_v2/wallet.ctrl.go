@@ -0,0 +1,241 @@
+package v2controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/labstack/echo/v4"
+)
+
+const defaultWalletName = "default"
+
+// WalletController : Wallet controller struct
+type WalletController struct {
+	svc *service.LndhubService
+}
+
+func NewWalletController(svc *service.LndhubService) *WalletController {
+	return &WalletController{svc: svc}
+}
+
+type WalletRequestBody struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type WalletTransferRequestBody struct {
+	FromWalletID int64  `json:"from_wallet_id" validate:"gte=0"`
+	ToWalletID   int64  `json:"to_wallet_id" validate:"gte=0"`
+	Amount       int64  `json:"amount" validate:"required,gt=0"`
+	Memo         string `json:"memo" validate:"omitempty"`
+}
+
+type Wallet struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Balance   int64      `json:"balance"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+type WalletTransferResponseBody struct {
+	FromWalletID int64  `json:"from_wallet_id"`
+	ToWalletID   int64  `json:"to_wallet_id"`
+	Amount       int64  `json:"amount"`
+	Description  string `json:"description,omitempty"`
+	PaymentHash  string `json:"payment_hash"`
+}
+
+// CreateWallet godoc
+// @Summary      Create a wallet
+// @Description  Creates an additional wallet with its own balance and invoices. Wallet-scoped endpoints use it with the wallet_id query parameter.
+// @Accept       json
+// @Produce      json
+// @Tags         Wallet
+// @Param        WalletRequestBody  body      WalletRequestBody  True  "Wallet to create"
+// @Success      200                {object}  Wallet
+// @Failure      400                {object}  responses.ErrorResponse
+// @Failure      500                {object}  responses.ErrorResponse
+// @Router       /v2/wallets [post]
+// @Security     OAuth2Password
+func (controller *WalletController) CreateWallet(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := WalletRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load wallet request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid wallet request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	wallet := &models.Wallet{
+		UserID: userID,
+		Name:   reqBody.Name,
+	}
+	err := controller.svc.CreateWallet(c.Request().Context(), wallet)
+	if err != nil {
+		c.Logger().Errorf("Failed to create wallet user_id:%v error: %v", userID, err)
+		return walletError(c, err)
+	}
+	return c.JSON(http.StatusOK, &Wallet{
+		ID:        wallet.ID,
+		Name:      wallet.Name,
+		CreatedAt: &wallet.CreatedAt,
+	})
+}
+
+// GetWallets godoc
+// @Summary      Retrieve wallets
+// @Description  Returns the wallets of a user with their balance, the first one is the default wallet with id 0
+// @Accept       json
+// @Produce      json
+// @Tags         Wallet
+// @Success      200  {object}  []Wallet
+// @Failure      500  {object}  responses.ErrorResponse
+// @Router       /v2/wallets [get]
+// @Security     OAuth2Password
+func (controller *WalletController) GetWallets(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	response, err := walletsWithBalances(c.Request().Context(), controller.svc, userID)
+	if err != nil {
+		c.Logger().Errorf("Failed to get wallets user_id:%v error: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &response)
+}
+
+// walletsWithBalances returns the default wallet and all other wallets of a user with their balances
+func walletsWithBalances(ctx context.Context, svc *service.LndhubService, userID int64) ([]Wallet, error) {
+	wallets, err := svc.WalletsFor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	response := []Wallet{{Name: defaultWalletName}}
+	for i := range wallets {
+		response = append(response, Wallet{
+			ID:        wallets[i].ID,
+			Name:      wallets[i].Name,
+			CreatedAt: &wallets[i].CreatedAt,
+		})
+	}
+	for i := range response {
+		response[i].Balance, err = svc.WalletBalance(ctx, userID, response[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// UpdateWallet godoc
+// @Summary      Rename a wallet
+// @Description  Changes the name of a wallet, the default wallet can't be renamed
+// @Accept       json
+// @Produce      json
+// @Tags         Wallet
+// @Param        id                 path      int                true  "Wallet id"
+// @Param        WalletRequestBody  body      WalletRequestBody  True  "New name"
+// @Success      200                {object}  Wallet
+// @Failure      400                {object}  responses.ErrorResponse
+// @Failure      404                {object}  responses.ErrorResponse
+// @Failure      500                {object}  responses.ErrorResponse
+// @Router       /v2/wallets/{id} [put]
+// @Security     OAuth2Password
+func (controller *WalletController) UpdateWallet(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	reqBody := WalletRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load wallet request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid wallet request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	wallet, err := controller.svc.FindWallet(c.Request().Context(), userID, id)
+	if err != nil {
+		return walletError(c, err)
+	}
+	err = controller.svc.RenameWallet(c.Request().Context(), wallet, reqBody.Name)
+	if err != nil {
+		c.Logger().Errorf("Failed to rename wallet id:%v user_id:%v error: %v", id, userID, err)
+		return walletError(c, err)
+	}
+	balance, err := controller.svc.WalletBalance(c.Request().Context(), userID, wallet.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(http.StatusOK, &Wallet{
+		ID:        wallet.ID,
+		Name:      wallet.Name,
+		Balance:   balance,
+		CreatedAt: &wallet.CreatedAt,
+	})
+}
+
+// WalletTransfer godoc
+// @Summary      Transfer between wallets
+// @Description  Moves sats between two wallets of the user instantly, 0 is the default wallet. No fees are charged.
+// @Accept       json
+// @Produce      json
+// @Tags         Wallet
+// @Param        WalletTransferRequestBody  body      WalletTransferRequestBody  True  "Transfer to make"
+// @Success      200                        {object}  WalletTransferResponseBody
+// @Failure      400                        {object}  responses.ErrorResponse
+// @Failure      404                        {object}  responses.ErrorResponse
+// @Failure      500                        {object}  responses.ErrorResponse
+// @Router       /v2/wallets/transfer [post]
+// @Security     OAuth2Password
+func (controller *WalletController) WalletTransfer(c echo.Context) error {
+	userID := c.Get("UserID").(int64)
+	reqBody := WalletTransferRequestBody{}
+	if err := c.Bind(&reqBody); err != nil {
+		c.Logger().Errorf("Failed to load wallet transfer request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	if err := c.Validate(&reqBody); err != nil {
+		c.Logger().Errorf("Invalid wallet transfer request body: %v", err)
+		return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+	}
+	invoice, err := controller.svc.WalletTransfer(c.Request().Context(), userID, reqBody.FromWalletID, reqBody.ToWalletID, reqBody.Amount, reqBody.Memo)
+	if err != nil {
+		c.Logger().Errorf("Failed to transfer between wallets user_id:%v error: %v", userID, err)
+		return walletError(c, err)
+	}
+	return c.JSON(http.StatusOK, &WalletTransferResponseBody{
+		FromWalletID: reqBody.FromWalletID,
+		ToWalletID:   reqBody.ToWalletID,
+		Amount:       invoice.Amount,
+		Description:  invoice.Memo,
+		PaymentHash:  invoice.RHash,
+	})
+}
+
+func walletError(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, service.ErrWalletNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrNotEnoughBalance):
+		return c.JSON(http.StatusBadRequest, responses.NotEnoughBalanceError)
+	case errors.Is(err, service.ErrInvalidWallet),
+		errors.Is(err, service.ErrTransferToSameWallet):
+	default:
+		return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+	}
+	return c.JSON(status, responses.ErrorResponse{
+		Error:          true,
+		Code:           8,
+		Message:        err.Error(),
+		HttpStatusCode: status,
+	})
+}
@@ -0,0 +1,7 @@
+alter table invoices add column if not exists hold boolean;
+
+--bun:split
+
+-- hold invoices use a payment hash chosen by the user, two requests for the same hash must not both create an invoice.
+-- keysend payments can create several incoming invoices for a hash, so the index only covers hold invoices.
+create unique index if not exists index_invoices_on_hold_r_hash on invoices(r_hash) where type = 'incoming' and hold;
@@ -0,0 +1 @@
+alter table invoices add column if not exists metadata jsonb;
@@ -0,0 +1,49 @@
+create table if not exists scheduled_payments (
+    id serial primary key,
+    user_id bigint not null,
+    type character varying not null,
+    destination character varying not null,
+    amount bigint not null,
+    memo character varying,
+    custom_records jsonb,
+    schedule character varying not null,
+    budget bigint,
+    total_paid bigint not null default 0,
+    state character varying not null default 'active',
+    retries integer not null default 0,
+    max_send_amount bigint,
+    max_send_volume bigint,
+    next_run_at timestamp with time zone not null,
+    last_run_at timestamp with time zone,
+    last_error character varying,
+    created_at timestamp with time zone default current_timestamp not null,
+    updated_at timestamp with time zone,
+    constraint fk_user
+        foreign key(user_id)
+        references users(id)
+        on delete cascade
+);
+
+--bun:split
+
+create index if not exists index_scheduled_payments_on_state_next_run_at on scheduled_payments(state, next_run_at);
+
+--bun:split
+
+create table if not exists scheduled_payment_runs (
+    id serial primary key,
+    scheduled_payment_id bigint not null,
+    invoice_id bigint,
+    amount bigint not null,
+    state character varying not null,
+    error_message character varying,
+    created_at timestamp with time zone default current_timestamp not null,
+    constraint fk_scheduled_payment
+        foreign key(scheduled_payment_id)
+        references scheduled_payments(id)
+        on delete cascade
+);
+
+--bun:split
+
+create index if not exists index_scheduled_payment_runs_on_scheduled_payment_id on scheduled_payment_runs(scheduled_payment_id);
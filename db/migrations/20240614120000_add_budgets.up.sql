@@ -0,0 +1,27 @@
+create table if not exists budgets (
+    id serial primary key,
+    user_id bigint not null,
+    name character varying not null,
+    amount bigint not null,
+    renewal_period character varying not null,
+    renewal_type character varying not null,
+    revoked_at timestamp with time zone,
+    created_at timestamp with time zone default current_timestamp not null,
+    updated_at timestamp with time zone,
+    constraint fk_user
+        foreign key(user_id)
+        references users(id)
+        on delete cascade
+);
+
+--bun:split
+
+create index if not exists index_budgets_on_user_id on budgets(user_id);
+
+--bun:split
+
+alter table invoices add column if not exists budget_id bigint;
+
+--bun:split
+
+create index if not exists index_invoices_on_budget_id on invoices(budget_id) where budget_id is not null;
@@ -0,0 +1,31 @@
+create table if not exists wallets (
+    id serial primary key,
+    user_id bigint not null,
+    name character varying not null,
+    created_at timestamp with time zone default current_timestamp not null,
+    updated_at timestamp with time zone,
+    constraint fk_user
+        foreign key(user_id)
+        references users(id)
+        on delete cascade
+);
+
+--bun:split
+
+create index if not exists index_wallets_on_user_id on wallets(user_id);
+
+--bun:split
+
+alter table accounts add column if not exists wallet_id bigint;
+
+--bun:split
+
+create index if not exists index_accounts_on_wallet_id on accounts(wallet_id) where wallet_id is not null;
+
+--bun:split
+
+alter table invoices add column if not exists wallet_id bigint;
+
+--bun:split
+
+alter table scheduled_payments add column if not exists wallet_id bigint;
@@ -0,0 +1,25 @@
+create table if not exists ledger_adjustments (
+    id serial primary key,
+    user_id bigint not null,
+    wallet_id bigint,
+    type character varying not null,
+    amount bigint not null,
+    reason character varying not null,
+    reference character varying not null,
+    invoice_id bigint not null,
+    transaction_entry_id bigint not null,
+    refunded_invoice_id bigint,
+    created_at timestamp with time zone default current_timestamp not null
+);
+
+--bun:split
+
+create index if not exists index_ledger_adjustments_on_user_id on ledger_adjustments(user_id);
+
+--bun:split
+
+create unique index if not exists index_ledger_adjustments_on_refunded_invoice_id on ledger_adjustments(refunded_invoice_id) where refunded_invoice_id is not null;
+
+--bun:split
+
+create unique index if not exists index_accounts_on_operator on accounts(user_id, coalesce(wallet_id, 0)) where type = 'operator';
@@ -0,0 +1,32 @@
+create table if not exists admin_principals (
+    id serial primary key,
+    name character varying not null unique,
+    role character varying not null,
+    token_hash character varying not null unique,
+    revoked_at timestamp with time zone,
+    created_at timestamp with time zone default current_timestamp not null,
+    updated_at timestamp with time zone
+);
+
+--bun:split
+
+create table if not exists admin_audit_logs (
+    id serial primary key,
+    principal_id bigint,
+    actor character varying not null,
+    role character varying not null,
+    action character varying not null,
+    target_user_id bigint,
+    target character varying,
+    before jsonb,
+    after jsonb,
+    created_at timestamp with time zone default current_timestamp not null
+);
+
+--bun:split
+
+create index if not exists index_admin_audit_logs_on_target_user_id on admin_audit_logs(target_user_id) where target_user_id is not null;
+
+--bun:split
+
+create index if not exists index_admin_audit_logs_on_principal_id on admin_audit_logs(principal_id) where principal_id is not null;
@@ -0,0 +1,22 @@
+create table if not exists audit_events (
+    id bigserial primary key,
+    type character varying not null,
+    user_id bigint,
+    login character varying,
+    actor character varying,
+    ip character varying,
+    details jsonb,
+    created_at timestamp with time zone default current_timestamp not null
+);
+
+--bun:split
+
+create index if not exists index_audit_events_on_user_id on audit_events(user_id, created_at) where user_id is not null;
+
+--bun:split
+
+create index if not exists index_audit_events_on_login on audit_events(login, type, created_at) where login is not null;
+
+--bun:split
+
+create index if not exists index_audit_events_on_type on audit_events(type, created_at);
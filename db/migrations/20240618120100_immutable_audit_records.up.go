@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+
+		if db.Dialect().Name().String() != "pg" {
+			fmt.Printf("\033[1;31m%s\033[0m", "You are not using PostgreSQL. DB level checks can not be enabled!\n")
+			return nil
+		}
+		sql := `
+				-- audit records are append-only, they can't be changed or removed
+				CREATE OR REPLACE FUNCTION prevent_audit_record_changes()
+					RETURNS TRIGGER AS $$
+				BEGIN
+					RAISE EXCEPTION 'audit records are immutable [table:%] [id:%]', TG_TABLE_NAME, OLD.id;
+				END;
+				$$ LANGUAGE plpgsql;
+
+				DROP TRIGGER IF EXISTS prevent_audit_record_changes ON audit_events;
+
+				CREATE TRIGGER prevent_audit_record_changes
+				BEFORE UPDATE OR DELETE ON audit_events
+				FOR EACH ROW EXECUTE PROCEDURE prevent_audit_record_changes();
+
+				DROP TRIGGER IF EXISTS prevent_audit_record_changes ON admin_audit_logs;
+
+				CREATE TRIGGER prevent_audit_record_changes
+				BEFORE UPDATE OR DELETE ON admin_audit_logs
+				FOR EACH ROW EXECUTE PROCEDURE prevent_audit_record_changes();
+		`
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+		return nil
+	}, nil)
+}
@@ -0,0 +1,10 @@
+create table if not exists login_failures (
+    key character varying primary key,
+    failures integer not null default 0,
+    last_failure_at timestamp with time zone not null,
+    locked_until timestamp with time zone
+);
+
+--bun:split
+
+create index if not exists index_login_failures_on_last_failure_at on login_failures(last_failure_at);
@@ -0,0 +1,9 @@
+create table if not exists rate_limits (
+    key character varying primary key,
+    tat bigint not null,
+    allowed boolean not null default true
+);
+
+--bun:split
+
+create index if not exists index_rate_limits_on_tat on rate_limits(tat);
@@ -0,0 +1,32 @@
+create table if not exists totp_credentials (
+    user_id bigint primary key,
+    secret character varying not null,
+    verified_at timestamp with time zone,
+    last_used_step bigint not null default 0,
+    payment_threshold bigint not null default 0,
+    require_on_password_change boolean not null default false,
+    created_at timestamp with time zone default current_timestamp not null,
+    updated_at timestamp with time zone,
+    constraint fk_user
+        foreign key(user_id)
+        references users(id)
+        on delete cascade
+);
+
+--bun:split
+
+create table if not exists totp_recovery_codes (
+    id bigserial primary key,
+    user_id bigint not null,
+    code_hash character varying not null,
+    used_at timestamp with time zone,
+    created_at timestamp with time zone default current_timestamp not null,
+    constraint fk_user
+        foreign key(user_id)
+        references users(id)
+        on delete cascade
+);
+
+--bun:split
+
+create unique index if not exists index_totp_recovery_codes_on_user_id_and_code_hash on totp_recovery_codes(user_id, code_hash);
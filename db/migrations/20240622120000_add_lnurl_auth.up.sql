@@ -0,0 +1,30 @@
+create table if not exists lnurl_auth_keys (
+    linking_key character varying primary key,
+    user_id bigint not null,
+    created_at timestamp with time zone default current_timestamp not null,
+    constraint fk_user
+        foreign key(user_id)
+        references users(id)
+        on delete cascade
+);
+
+--bun:split
+
+create index if not exists index_lnurl_auth_keys_on_user_id on lnurl_auth_keys(user_id);
+
+--bun:split
+
+create table if not exists lnurl_auth_challenges (
+    k1 character varying primary key,
+    secret_hash character varying not null,
+    action character varying not null,
+    user_id bigint,
+    linking_key character varying,
+    completed_at timestamp with time zone,
+    expires_at timestamp with time zone not null,
+    created_at timestamp with time zone default current_timestamp not null
+);
+
+--bun:split
+
+create index if not exists index_lnurl_auth_challenges_on_expires_at on lnurl_auth_challenges(expires_at);
@@ -0,0 +1,24 @@
+create table if not exists nostr_pubkeys (
+    pubkey character varying primary key,
+    user_id bigint not null,
+    created_at timestamp with time zone default current_timestamp not null,
+    constraint fk_user
+        foreign key(user_id)
+        references users(id)
+        on delete cascade
+);
+
+--bun:split
+
+create index if not exists index_nostr_pubkeys_on_user_id on nostr_pubkeys(user_id);
+
+--bun:split
+
+create table if not exists nostr_auth_events (
+    id character varying primary key,
+    expires_at timestamp with time zone not null
+);
+
+--bun:split
+
+create index if not exists index_nostr_auth_events_on_expires_at on nostr_auth_events(expires_at);
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// AdminPrincipal : admin with its own token and a role that defines the admin endpoints it can use
+type AdminPrincipal struct {
+	ID        int64        `json:"id" bun:",pk,autoincrement"`
+	Name      string       `json:"name" bun:",unique,notnull"`
+	Role      string       `json:"role" bun:",notnull"`
+	TokenHash string       `json:"-" bun:",unique,notnull"`
+	RevokedAt bun.NullTime `json:"revoked_at"`
+	CreatedAt time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt bun.NullTime `json:"updated_at"`
+}
+
+func (p *AdminPrincipal) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		p.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*AdminPrincipal)(nil)
+
+// AdminAuditLog : action of an admin with the state of the target before and after it
+type AdminAuditLog struct {
+	ID           int64       `json:"id" bun:",pk,autoincrement"`
+	PrincipalID  int64       `json:"principal_id,omitempty" bun:",nullzero"`
+	Actor        string      `json:"actor" bun:",notnull"`
+	Role         string      `json:"role" bun:",notnull"`
+	Action       string      `json:"action" bun:",notnull"`
+	TargetUserID int64       `json:"target_user_id,omitempty" bun:",nullzero"`
+	Target       string      `json:"target,omitempty" bun:",nullzero"`
+	Before       interface{} `json:"before,omitempty" bun:"type:jsonb"`
+	After        interface{} `json:"after,omitempty" bun:"type:jsonb"`
+	CreatedAt    time.Time   `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	AuditEventLoginSucceeded    = "login_succeeded"
+	AuditEventLoginFailed       = "login_failed"
+	AuditEventPasswordChanged   = "password_changed"
+	AuditEventLoginChanged      = "login_changed"
+	AuditEventUserDeactivated   = "user_deactivated"
+	AuditEventUserReactivated   = "user_reactivated"
+	AuditEventUserDeleted       = "user_deleted"
+	AuditEventLimitExceeded     = "limit_exceeded"
+	AuditEventPaymentsLocked    = "payments_locked"
+	AuditEventLoginLocked       = "login_locked"
+	AuditEventLoginUnlocked     = "login_unlocked"
+	AuditEventAdminAction       = "admin_action"
+	AuditEventTOTPEnabled       = "totp_enabled"
+	AuditEventTOTPDisabled      = "totp_disabled"
+	AuditEventTOTPFailed        = "totp_failed"
+	AuditEventRecoveryCodeUsed  = "recovery_code_used"
+	AuditEventLnurlAuthLinked   = "lnurl_auth_linked"
+	AuditEventLnurlAuthUnlinked = "lnurl_auth_unlinked"
+	AuditEventNostrLinked       = "nostr_pubkey_linked"
+	AuditEventNostrUnlinked     = "nostr_pubkey_unlinked"
+)
+
+// AuditEvent : security relevant event, rows are never updated or deleted
+type AuditEvent struct {
+	ID        int64                  `json:"id" bun:",pk,autoincrement"`
+	Type      string                 `json:"type" bun:",notnull"`
+	UserID    int64                  `json:"user_id,omitempty" bun:",nullzero"`
+	Login     string                 `json:"login,omitempty" bun:",nullzero"`
+	Actor     string                 `json:"actor,omitempty" bun:",nullzero"`
+	IP        string                 `json:"ip,omitempty" bun:"ip,nullzero"`
+	Details   map[string]interface{} `json:"details,omitempty" bun:"type:jsonb"`
+	CreatedAt time.Time              `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
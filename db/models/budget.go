@@ -0,0 +1,31 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Budget : spending budget of a credential that was shared with a third party
+type Budget struct {
+	ID            int64        `json:"id" bun:",pk,autoincrement"`
+	UserID        int64        `json:"user_id" bun:",notnull"`
+	Name          string       `json:"name" bun:",notnull"`
+	Amount        int64        `json:"amount" bun:",notnull"`
+	RenewalPeriod string       `json:"renewal_period" bun:",notnull"`
+	RenewalType   string       `json:"renewal_type" bun:",notnull"`
+	RevokedAt     bun.NullTime `json:"revoked_at"`
+	CreatedAt     time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt     bun.NullTime `json:"updated_at"`
+}
+
+func (b *Budget) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		b.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*Budget)(nil)
@@ -22,13 +22,18 @@ type Invoice struct {
 	PaymentRequest           string            `json:"payment_request" bun:",nullzero"`
 	DestinationPubkeyHex     string            `json:"destination_pubkey_hex" bun:",notnull"`
 	DestinationCustomRecords map[uint64][]byte `json:"custom_records,omitempty"`
+	Metadata                 map[string]string `json:"metadata,omitempty"`
 	RHash                    string            `json:"r_hash"`
 	Preimage                 string            `json:"preimage" bun:",nullzero"`
 	Internal                 bool              `json:"-" bun:",nullzero"`
 	Keysend                  bool              `json:"keysend" bun:",nullzero"`
+	Hold                     bool              `json:"hold" bun:",nullzero"`
 	State                    string            `json:"state" bun:",default:'initialized'"`
 	ErrorMessage             string            `json:"error_message,omitempty" bun:",nullzero"`
 	AddIndex                 uint64            `json:"-" bun:",nullzero"`
+	NodePubkey               string            `json:"-" bun:",nullzero"`
+	BudgetID                 int64             `json:"-" bun:",nullzero"`
+	WalletID                 int64             `json:"wallet_id,omitempty" bun:",nullzero"`
 	CreatedAt                time.Time         `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
 	ExpiresAt                bun.NullTime      `json:"expires_at" bun:",nullzero"`
 	UpdatedAt                bun.NullTime      `json:"updated_at"`
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	LedgerAdjustmentTypeCredit = "credit"
+	LedgerAdjustmentTypeDebit  = "debit"
+	LedgerAdjustmentTypeRefund = "refund"
+)
+
+// LedgerAdjustment : audit trail of a manual balance change made by an admin, rows are never updated or deleted
+type LedgerAdjustment struct {
+	ID                 int64     `json:"id" bun:",pk,autoincrement"`
+	UserID             int64     `json:"user_id" bun:",notnull"`
+	WalletID           int64     `json:"wallet_id" bun:",nullzero"`
+	Type               string    `json:"type" bun:",notnull"`
+	Amount             int64     `json:"amount" bun:",notnull"`
+	Reason             string    `json:"reason" bun:",notnull"`
+	Reference          string    `json:"reference" bun:",notnull"`
+	InvoiceID          int64     `json:"invoice_id" bun:",notnull"`
+	TransactionEntryID int64     `json:"transaction_entry_id" bun:",notnull"`
+	RefundedInvoiceID  int64     `json:"refunded_invoice_id,omitempty" bun:",nullzero"`
+	CreatedAt          time.Time `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// LnurlAuthKey : LNURL-auth linking key of a wallet that can log in as the user
+type LnurlAuthKey struct {
+	LinkingKey string    `json:"linking_key" bun:",pk"`
+	UserID     int64     `json:"user_id" bun:",notnull"`
+	CreatedAt  time.Time `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+// LnurlAuthChallenge : k1 of an LNURL-auth login or link, the browser that created it collects the result with the secret
+type LnurlAuthChallenge struct {
+	K1         string `bun:",pk"`
+	SecretHash string `bun:",notnull"`
+	Action     string `bun:",notnull"`
+	// UserID is the user to link the key to, or the user that logged in once the challenge is completed
+	UserID      int64  `bun:",nullzero"`
+	LinkingKey  string `bun:",nullzero"`
+	CompletedAt bun.NullTime
+	ExpiresAt   time.Time `bun:",notnull"`
+	CreatedAt   time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"github.com/uptrace/bun"
+)
+
+// LoginFailure : failed login attempts of a login or a client IP
+type LoginFailure struct {
+	Key           string       `bun:",pk"`
+	Failures      int          `bun:",notnull"`
+	LastFailureAt bun.NullTime `bun:",notnull"`
+	LockedUntil   bun.NullTime
+}
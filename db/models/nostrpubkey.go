@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// NostrPubkey : nostr pubkey that can authenticate requests of the user with NIP-98 events
+type NostrPubkey struct {
+	Pubkey    string    `json:"pubkey" bun:",pk"`
+	UserID    int64     `json:"user_id" bun:",notnull"`
+	CreatedAt time.Time `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+// NostrAuthEvent : id of a NIP-98 event that was used, it can't authenticate another request until it expires
+type NostrAuthEvent struct {
+	ID        string    `bun:",pk"`
+	ExpiresAt time.Time `bun:",notnull"`
+}
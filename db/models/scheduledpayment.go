@@ -0,0 +1,54 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// ScheduledPayment : Scheduled Payment Model
+type ScheduledPayment struct {
+	ID            int64             `json:"id" bun:",pk,autoincrement"`
+	UserID        int64             `json:"user_id" bun:",notnull"`
+	WalletID      int64             `json:"wallet_id,omitempty" bun:",nullzero"`
+	Type          string            `json:"type" bun:",notnull"`
+	Destination   string            `json:"destination" bun:",notnull"`
+	Amount        int64             `json:"amount" bun:",notnull"`
+	Memo          string            `json:"memo" bun:",nullzero"`
+	CustomRecords map[uint64][]byte `json:"custom_records,omitempty"`
+	Schedule      string            `json:"schedule" bun:",notnull"`
+	Budget        int64             `json:"budget" bun:",nullzero"`
+	TotalPaid     int64             `json:"total_paid" bun:",notnull"`
+	State         string            `json:"state" bun:",notnull,default:'active'"`
+	Retries       int               `json:"retries" bun:",notnull"`
+	MaxSendAmount *int64            `json:"-"`
+	MaxSendVolume *int64            `json:"-"`
+	NextRunAt     time.Time         `json:"next_run_at" bun:",notnull"`
+	LastRunAt     bun.NullTime      `json:"last_run_at"`
+	LastError     string            `json:"last_error,omitempty" bun:",nullzero"`
+	CreatedAt     time.Time         `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt     bun.NullTime      `json:"updated_at"`
+}
+
+func (s *ScheduledPayment) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		s.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*ScheduledPayment)(nil)
+
+// ScheduledPaymentRun : outcome of a single execution of a scheduled payment
+type ScheduledPaymentRun struct {
+	ID                 int64     `json:"id" bun:",pk,autoincrement"`
+	ScheduledPaymentID int64     `json:"scheduled_payment_id" bun:",notnull"`
+	InvoiceID          int64     `json:"invoice_id,omitempty" bun:",nullzero"`
+	Invoice            *Invoice  `json:"-" bun:"rel:belongs-to,join:invoice_id=id"`
+	Amount             int64     `json:"amount" bun:",notnull"`
+	State              string    `json:"state" bun:",notnull"`
+	ErrorMessage       string    `json:"error_message,omitempty" bun:",nullzero"`
+	CreatedAt          time.Time `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+}
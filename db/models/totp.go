@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// TOTPCredential : TOTP secret of a user for two-factor authentication, it is only used once it was verified
+type TOTPCredential struct {
+	bun.BaseModel `bun:"table:totp_credentials"`
+
+	UserID     int64  `bun:",pk"`
+	Secret     string `bun:",notnull"`
+	VerifiedAt bun.NullTime
+	// LastUsedStep is the time step of the last accepted code, codes can't be used twice
+	LastUsedStep int64 `bun:",notnull"`
+	// PaymentThreshold requires a code for payments above this amount, 0 never requires one
+	PaymentThreshold        int64     `bun:",notnull"`
+	RequireOnPasswordChange bool      `bun:",notnull"`
+	CreatedAt               time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt               bun.NullTime
+}
+
+// Verified returns true if the user proved to have the secret, only then codes are required
+func (t *TOTPCredential) Verified() bool {
+	return !t.VerifiedAt.IsZero()
+}
+
+func (t *TOTPCredential) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		t.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*TOTPCredential)(nil)
+
+// TOTPRecoveryCode : one-time code that replaces a TOTP code, e.g. if the device was lost. Only the hash is stored.
+type TOTPRecoveryCode struct {
+	bun.BaseModel `bun:"table:totp_recovery_codes"`
+
+	ID        int64  `bun:",pk,autoincrement"`
+	UserID    int64  `bun:",notnull"`
+	CodeHash  string `bun:",notnull"`
+	UsedAt    bun.NullTime
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
@@ -13,6 +13,9 @@ const (
 	EntryTypeServiceFeeReversal = "service_fee_reversal"
 	EntryTypeFeeReserveReversal = "fee_reserve_reversal"
 	EntryTypeOutgoingReversal   = "outgoing_reversal"
+	EntryTypeAdminCredit        = "admin_credit"
+	EntryTypeAdminDebit         = "admin_debit"
+	EntryTypeRefund             = "refund"
 )
 
 // TransactionEntry : Transaction Entries Model
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Wallet : additional wallet of a user with its own accounts and invoices
+type Wallet struct {
+	ID        int64        `json:"id" bun:",pk,autoincrement"`
+	UserID    int64        `json:"user_id" bun:",notnull"`
+	Name      string       `json:"name" bun:",notnull"`
+	CreatedAt time.Time    `json:"created_at" bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt bun.NullTime `json:"updated_at"`
+}
+
+func (w *Wallet) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.UpdateQuery:
+		w.UpdatedAt = bun.NullTime{Time: time.Now()}
+	}
+	return nil
+}
+
+var _ bun.BeforeAppendModelHook = (*Wallet)(nil)
@@ -0,0 +1,443 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/labstack/gommon/log"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/ziflex/lecho/v3"
+)
+
+// bufPool is a classic buffer pool pattern that allows more clever reuse of heap memory.
+// Instead of allocating new memory everytime we need to encode the invoices we
+// reuse buffers from this buffer pool. If we consume events sequentially there will
+// only be one buffer in this pool at all times, but when scaling to multiple go
+// routines this memory pool will scale with it.
+var bufPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+const (
+	incomingInvoicesRoutingKey = "invoice.incoming.settled"
+	outgoingPaymentsRoutingKey = "payment.outgoing.*"
+)
+
+type ClientConfig struct {
+	LndInvoiceConsumerName string
+	LndPaymentConsumerName string
+	LndInvoiceTopic        string
+	LndPaymentTopic        string
+	LndHubInvoiceTopic     string
+	LndHubClusterTopic     string
+	LndHubScheduledTopic   string
+	RetryTopic             string
+	DeadLetterTopic        string
+	DeadLetterConsumerName string
+	RetryPolicy            RetryPolicy
+}
+
+type DefaultClient struct {
+	broker Broker
+	logger *lecho.Logger
+
+	deadLetterHook DeadLetterHook
+
+	config ClientConfig
+}
+
+type ClientOption = func(client *DefaultClient)
+
+func WithLndInvoiceTopic(topic string) ClientOption {
+	return func(client *DefaultClient) {
+		client.config.LndInvoiceTopic = topic
+	}
+}
+
+func WithLndHubInvoiceTopic(topic string) ClientOption {
+	return func(client *DefaultClient) {
+		client.config.LndHubInvoiceTopic = topic
+	}
+}
+
+func WithLndHubClusterTopic(topic string) ClientOption {
+	return func(client *DefaultClient) {
+		client.config.LndHubClusterTopic = topic
+	}
+}
+
+func WithLndHubScheduledPaymentTopic(topic string) ClientOption {
+	return func(client *DefaultClient) {
+		client.config.LndHubScheduledTopic = topic
+	}
+}
+
+func WithLndInvoiceConsumerName(name string) ClientOption {
+	return func(client *DefaultClient) {
+		client.config.LndInvoiceConsumerName = name
+	}
+}
+
+func WithLndPaymentConsumerName(name string) ClientOption {
+	return func(client *DefaultClient) {
+		client.config.LndPaymentConsumerName = name
+	}
+}
+
+func WithLndPaymentTopic(topic string) ClientOption {
+	return func(client *DefaultClient) {
+		client.config.LndPaymentTopic = topic
+	}
+}
+
+func WithRetryTopic(topic string) ClientOption {
+	return func(client *DefaultClient) {
+		client.config.RetryTopic = topic
+	}
+}
+
+func WithDeadLetterTopic(topic string) ClientOption {
+	return func(client *DefaultClient) {
+		client.config.DeadLetterTopic = topic
+	}
+}
+
+func WithDeadLetterConsumerName(name string) ClientOption {
+	return func(client *DefaultClient) {
+		client.config.DeadLetterConsumerName = name
+	}
+}
+
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(client *DefaultClient) {
+		client.config.RetryPolicy = policy
+	}
+}
+
+func WithDeadLetterHook(hook DeadLetterHook) ClientOption {
+	return func(client *DefaultClient) {
+		client.deadLetterHook = hook
+	}
+}
+
+func WithLogger(logger *lecho.Logger) ClientOption {
+	return func(client *DefaultClient) {
+		client.logger = logger
+	}
+}
+
+// NewClient creates a client that consumes and publishes lndhub events through the given broker
+func NewClient(broker Broker, options ...ClientOption) (Client, error) {
+	client := &DefaultClient{
+		broker: broker,
+
+		logger: lecho.New(
+			os.Stdout,
+			lecho.WithLevel(log.DEBUG),
+			lecho.WithTimestamp(),
+		),
+
+		config: ClientConfig{
+			LndInvoiceConsumerName: "lnd_invoice_consumer",
+			LndPaymentConsumerName: "lnd_payment_consumer",
+			LndInvoiceTopic:        "lnd_invoice",
+			LndPaymentTopic:        "lnd_payment",
+			LndHubInvoiceTopic:     "lndhub_invoice",
+			LndHubClusterTopic:     "lndhub_cluster",
+			LndHubScheduledTopic:   "lndhub_scheduled_payment",
+			RetryTopic:             "lndhub_retry",
+			DeadLetterTopic:        "lndhub_dead_letter",
+			DeadLetterConsumerName: "lndhub_dead_letter",
+		},
+	}
+
+	for _, opt := range options {
+		opt(client)
+	}
+
+	return client, nil
+}
+
+func (client *DefaultClient) Close() error { return client.broker.Close() }
+
+func (client *DefaultClient) FinalizeInitializedPayments(ctx context.Context, svc LndHubService) error {
+	getInvoicesTable := func(ctx context.Context) (map[string]models.Invoice, error) {
+		invoicesByHash := map[string]models.Invoice{}
+		pendingInvoices, err := svc.GetAllPendingPayments(ctx)
+
+		if err != nil {
+			return invoicesByHash, err
+		}
+
+		for _, invoice := range pendingInvoices {
+			invoicesByHash[invoice.RHash] = invoice
+		}
+		return invoicesByHash, nil
+	}
+
+	pendingInvoices, err := getInvoicesTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	client.logger.Infoj(log.JSON{
+		"subroutine":           "payment finalizer",
+		"num_pending_payments": len(pendingInvoices),
+		"message":              "starting payment finalizer loop",
+	})
+	return client.consume(ctx, "payment finalizer",
+		client.config.LndPaymentTopic,
+		outgoingPaymentsRoutingKey,
+		client.config.LndPaymentConsumerName,
+		func(ctx context.Context, body []byte) error {
+			payment := lnrpc.Payment{}
+
+			err := json.Unmarshal(body, &payment)
+			if err != nil {
+				captureErr(client.logger, err, log.JSON{
+					"subroutine": "payment finalizer",
+					"message":    "error unmarshalling payment json",
+				})
+				return permanentError{err}
+			}
+
+			// Check if paymentHash corresponds to one of the pending invoices
+			invoice, ok := pendingInvoices[payment.PaymentHash]
+			if !ok {
+				return nil
+			}
+			t, err := svc.GetTransactionEntryByInvoiceId(ctx, invoice.ID)
+			if err != nil {
+				captureErr(client.logger, err, log.JSON{
+					"subroutine":   "payment finalizer",
+					"payment_hash": invoice.RHash,
+					"message":      "error fetching transaction entry by id",
+				})
+				return err
+			}
+			client.logger.Infoj(log.JSON{
+				"subroutine":   "payment finalizer",
+				"payment_hash": invoice.RHash,
+				"message":      "updating payment",
+			})
+
+			switch payment.Status {
+			case lnrpc.Payment_SUCCEEDED:
+				invoice.SetFee(t, payment.FeeSat)
+				invoice.Preimage = payment.PaymentPreimage
+				invoice.RHash = payment.PaymentHash
+
+				if err = svc.HandleSuccessfulPayment(ctx, &invoice, t); err != nil {
+					captureErr(client.logger, err, log.JSON{
+						"subroutine":   "payment finalizer",
+						"payment_hash": invoice.RHash,
+						"message":      "error handling succesful payment",
+					})
+					return err
+				}
+
+				client.logger.Infoj(log.JSON{
+					"subroutine":   "payment finalizer",
+					"message":      "updated succesful payment",
+					"payment_hash": payment.PaymentHash,
+				})
+				delete(pendingInvoices, payment.PaymentHash)
+
+			case lnrpc.Payment_FAILED:
+				if err = svc.HandleFailedPayment(ctx, &invoice, t, fmt.Errorf(payment.FailureReason.String())); err != nil {
+					captureErr(client.logger, err, log.JSON{
+						"subroutine":   "payment finalizer",
+						"message":      "error handling failed payment",
+						"payment_hash": invoice.RHash,
+					})
+					return err
+				}
+				client.logger.Infoj(log.JSON{
+					"subroutine":   "payment finalizer",
+					"message":      "updated failed payment",
+					"payment_hash": payment.PaymentHash,
+				})
+				delete(pendingInvoices, payment.PaymentHash)
+			}
+			return nil
+		},
+	)
+}
+
+func (client *DefaultClient) SubscribeToLndInvoices(ctx context.Context, handler IncomingInvoiceHandler) error {
+	client.logger.Infoj(log.JSON{
+		"subroutine": "invoice consumer",
+		"message":    "starting loop",
+	})
+	return client.consume(ctx, "invoice consumer",
+		client.config.LndInvoiceTopic,
+		incomingInvoicesRoutingKey,
+		client.config.LndInvoiceConsumerName,
+		func(ctx context.Context, body []byte) error {
+			var invoice lnrpc.Invoice
+
+			err := json.Unmarshal(body, &invoice)
+			if err != nil {
+				captureErr(client.logger, err, log.JSON{
+					"subroutine": "invoice consumer",
+					"message":    "error unmarshalling invoice json",
+				})
+
+				// If we can't even Unmarshall the message we are dealing with
+				// badly formatted events. Retrying won't help, so it goes
+				// straight to the dead letter topic.
+				return permanentError{err}
+			}
+			client.logger.Infoj(log.JSON{
+				"subroutine":   "invoice consumer",
+				"message":      "adding invoice",
+				"payment_hash": hex.EncodeToString(invoice.RHash),
+			})
+
+			err = handler(ctx, &invoice)
+			if err != nil {
+				captureErr(client.logger, err, log.JSON{
+					"subroutine":   "invoice consumer",
+					"message":      "error handling invoice",
+					"payment_hash": hex.EncodeToString(invoice.RHash),
+				})
+				return err
+			}
+			return nil
+		},
+	)
+}
+
+func (client *DefaultClient) StartPublishInvoices(ctx context.Context, invoicesSubscribeFunc SubscribeToInvoicesFunc, payloadFunc EncodeOutgoingInvoiceFunc) error {
+	err := client.broker.Declare(ctx, client.config.LndHubInvoiceTopic)
+	if err != nil {
+		return err
+	}
+
+	client.logger.Infoj(log.JSON{
+		"subroutine": "invoice publisher",
+		"message":    "starting publisher",
+	})
+
+	in, out, err := invoicesSubscribeFunc()
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Canceled
+		case incomingInvoice := <-in:
+			err = client.PublishInvoice(ctx, incomingInvoice, payloadFunc)
+
+			if err != nil {
+				captureErr(client.logger, err, log.JSON{
+					"subroutine":   "invoice publisher",
+					"message":      "error publishing invoice",
+					"payment_hash": incomingInvoice.RHash,
+				})
+			}
+		case outgoing := <-out:
+			err = client.PublishInvoice(ctx, outgoing, payloadFunc)
+
+			if err != nil {
+				captureErr(client.logger, err, log.JSON{
+					"subroutine":   "invoice publisher",
+					"message":      "error publishing invoice",
+					"payment_hash": outgoing.RHash,
+				})
+			}
+		}
+	}
+}
+
+func (client *DefaultClient) PublishInvoice(ctx context.Context, invoice models.Invoice, payloadFunc EncodeOutgoingInvoiceFunc) error {
+	payload := bufPool.Get().(*bytes.Buffer)
+	defer func() {
+		payload.Reset()
+		bufPool.Put(payload)
+	}()
+	err := payloadFunc(ctx, payload, invoice)
+	if err != nil {
+		return err
+	}
+
+	key := InvoiceRoutingKey(invoice)
+
+	err = client.broker.Publish(ctx, client.config.LndHubInvoiceTopic, key, payload.Bytes())
+	if err != nil {
+		return err
+	}
+
+	client.logger.Infoj(log.JSON{
+		"subroutine":   "invoice publisher",
+		"message":      "succesfully published invoice",
+		"payment_hash": invoice.RHash,
+		"routing_key":  key,
+	})
+
+	return nil
+}
+
+func (client *DefaultClient) PublishClusterEvent(ctx context.Context, routingKey string, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	err = client.broker.Declare(ctx, client.config.LndHubClusterTopic)
+	if err != nil {
+		return err
+	}
+	err = client.broker.Publish(ctx, client.config.LndHubClusterTopic, routingKey, payload)
+	if err != nil {
+		return err
+	}
+
+	client.logger.Infoj(log.JSON{
+		"subroutine":  "cluster event publisher",
+		"message":     "succesfully published cluster event",
+		"routing_key": routingKey,
+	})
+	return nil
+}
+
+func (client *DefaultClient) PublishScheduledPaymentEvent(ctx context.Context, routingKey string, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	err = client.broker.Declare(ctx, client.config.LndHubScheduledTopic)
+	if err != nil {
+		return err
+	}
+	err = client.broker.Publish(ctx, client.config.LndHubScheduledTopic, routingKey, payload)
+	if err != nil {
+		return err
+	}
+
+	client.logger.Infoj(log.JSON{
+		"subroutine":  "scheduled payment event publisher",
+		"message":     "succesfully published scheduled payment event",
+		"routing_key": routingKey,
+	})
+	return nil
+}
+
+// InvoiceRoutingKey returns the key lndhub invoice updates are published with, e.g. invoice.incoming.settled
+func InvoiceRoutingKey(invoice models.Invoice) string {
+	return fmt.Sprintf("invoice.%s.%s", invoice.Type, invoice.State)
+}
+
+func captureErr(logger *lecho.Logger, err error, j log.JSON) {
+	j["error"] = err
+	logger.Errorj(j)
+	sentry.CaptureException(err)
+}
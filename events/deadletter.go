@@ -0,0 +1,379 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	requeueRoutingKeySuffix = "requeue"
+
+	// how often deliveries held back for a retry are marked as in progress
+	inProgressInterval = 10 * time.Second
+)
+
+var deadLetteredDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "lndhub_dead_lettered_deliveries_total",
+	Help: "Number of broker deliveries that could not be processed and were moved to the dead letter topic",
+}, []string{"consumer"})
+
+// RetryPolicy decides what happens with deliveries that could not be processed.
+// A failed delivery is retried once after every delay. If the last retry fails as
+// well, or the message can never be processed, it is moved to the dead letter topic.
+type RetryPolicy struct {
+	Delays []time.Duration
+}
+
+// DeadLetterHook is called for every delivery that is moved to the dead letter topic,
+// e.g. to send an alert.
+type DeadLetterHook = func(ctx context.Context, failed FailedDelivery)
+
+// FailedDelivery wraps a message that could not be processed. It is what gets
+// published to the retry and dead letter topics.
+type FailedDelivery struct {
+	ID string `json:"id"`
+	// Topic and RoutingKey the message was originally published with
+	Topic      string `json:"topic"`
+	RoutingKey string `json:"routing_key"`
+	// Consumer that failed to process the message
+	Consumer string `json:"consumer"`
+	// Attempts is the number of times processing the message failed
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Body     []byte    `json:"body"`
+	FailedAt time.Time `json:"failed_at"`
+	RetryAt  time.Time `json:"retry_at,omitempty"`
+}
+
+// permanentError marks errors that won't go away by retrying, e.g. badly formatted messages
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error { return e.error }
+
+type retry struct {
+	delivery Delivery
+	failed   FailedDelivery
+}
+
+type processFunc = func(ctx context.Context, body []byte) error
+
+// DeadLetterAction is returned by the callback of WalkDeadLetters
+type DeadLetterAction int
+
+const (
+	// DeadLetterKeep leaves the delivery in the dead letter topic
+	DeadLetterKeep DeadLetterAction = iota
+	// DeadLetterRequeue hands the delivery back to the consumer that failed to process it
+	DeadLetterRequeue
+)
+
+func retryRoutingKey(consumerName string, attempt int) string {
+	return fmt.Sprintf("%s.%d", consumerName, attempt)
+}
+
+func newDeliveryID() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		// the id is only used to recognize deliveries, a timestamp will do
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// consume processes all deliveries of topic matching routingKey one by one. Deliveries
+// that fail are retried according to the retry policy and dead lettered afterwards.
+func (client *DefaultClient) consume(ctx context.Context, subroutine, topic, routingKey, consumerName string, process processFunc) error {
+	err := client.broker.Bind(ctx, client.config.DeadLetterTopic, "#", client.config.DeadLetterConsumerName)
+	if err != nil {
+		return err
+	}
+
+	deliveryChan, err := client.broker.Listen(ctx, topic, routingKey, consumerName)
+	if err != nil {
+		return err
+	}
+
+	// Retries and requeued dead letters come in through the retry topic. Every
+	// retry attempt has its own consumer, so all messages in it have the same
+	// delay and can be handled in order.
+	retries := make(chan retry)
+	retryListeners := map[string]string{
+		consumerName + "." + requeueRoutingKeySuffix: consumerName + "_" + requeueRoutingKeySuffix,
+	}
+	for i := range client.config.RetryPolicy.Delays {
+		retryListeners[retryRoutingKey(consumerName, i+1)] = fmt.Sprintf("%s_retry_%d", consumerName, i+1)
+	}
+	for key, name := range retryListeners {
+		retryChan, err := client.broker.Listen(ctx, client.config.RetryTopic, key, name)
+		if err != nil {
+			return err
+		}
+		go client.delayRetries(ctx, subroutine, retryChan, retries)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Canceled
+
+		case delivery, ok := <-deliveryChan:
+			if !ok {
+				return fmt.Errorf("Disconnected from message broker")
+			}
+			client.handle(ctx, subroutine, delivery, FailedDelivery{
+				ID:         newDeliveryID(),
+				Topic:      topic,
+				RoutingKey: delivery.RoutingKey(),
+				Consumer:   consumerName,
+				Body:       delivery.Body(),
+			}, process)
+
+		case r := <-retries:
+			client.handle(ctx, subroutine, r.delivery, r.failed, process)
+		}
+	}
+}
+
+// delayRetries holds on to retried deliveries until they are due
+func (client *DefaultClient) delayRetries(ctx context.Context, subroutine string, deliveryChan <-chan Delivery, retries chan<- retry) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case delivery, ok := <-deliveryChan:
+			if !ok {
+				return
+			}
+			failed := FailedDelivery{}
+			err := json.Unmarshal(delivery.Body(), &failed)
+			if err != nil {
+				captureErr(client.logger, err, log.JSON{
+					"subroutine": subroutine,
+					"message":    "error unmarshalling retried delivery",
+				})
+				err = delivery.Nack(false)
+				if err != nil {
+					captureErr(client.logger, err, log.JSON{
+						"subroutine": subroutine,
+						"message":    "error nacking retried delivery",
+					})
+				}
+				continue
+			}
+
+			// not acked, the broker will redeliver the message when we are back
+			if !client.waitUntil(ctx, delivery, failed.RetryAt) {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case retries <- retry{delivery: delivery, failed: failed}:
+			}
+		}
+	}
+}
+
+// waitUntil blocks until t or until ctx is done, in which case it returns false.
+// Brokers that redeliver messages that are not acked in time are told we are still working on it.
+func (client *DefaultClient) waitUntil(ctx context.Context, delivery Delivery, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	ticker := time.NewTicker(inProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-ticker.C:
+			if d, ok := delivery.(InProgressDelivery); ok {
+				err := d.InProgress()
+				if err != nil {
+					client.logger.Errorf("Error extending ack deadline: %v", err)
+				}
+			}
+		}
+	}
+}
+
+func (client *DefaultClient) handle(ctx context.Context, subroutine string, delivery Delivery, failed FailedDelivery, process processFunc) {
+	err := process(ctx, failed.Body)
+	if err != nil {
+		err = client.fail(ctx, failed, err)
+		if err != nil {
+			captureErr(client.logger, err, log.JSON{
+				"subroutine":  subroutine,
+				"message":     "error publishing failed delivery",
+				"delivery_id": failed.ID,
+			})
+
+			// Keep the message, the broker will redeliver it up to its delivery limit
+			err = delivery.Nack(true)
+			if err != nil {
+				captureErr(client.logger, err, log.JSON{
+					"subroutine":  subroutine,
+					"message":     "error nacking delivery",
+					"delivery_id": failed.ID,
+				})
+			}
+			return
+		}
+	}
+
+	err = delivery.Ack()
+	if err != nil {
+		captureErr(client.logger, err, log.JSON{
+			"subroutine":  subroutine,
+			"message":     "error acking delivery",
+			"delivery_id": failed.ID,
+		})
+	}
+}
+
+// fail schedules a retry for a delivery that could not be processed, or moves it to the
+// dead letter topic if it can't be retried anymore
+func (client *DefaultClient) fail(ctx context.Context, failed FailedDelivery, cause error) error {
+	failed.Attempts++
+	failed.Error = cause.Error()
+	failed.FailedAt = time.Now()
+	failed.RetryAt = time.Time{}
+
+	var permanent permanentError
+	delays := client.config.RetryPolicy.Delays
+	if errors.As(cause, &permanent) || failed.Attempts > len(delays) {
+		return client.deadLetter(ctx, failed)
+	}
+
+	failed.RetryAt = failed.FailedAt.Add(delays[failed.Attempts-1])
+	payload, err := json.Marshal(failed)
+	if err != nil {
+		return err
+	}
+	client.logger.Infoj(log.JSON{
+		"message":     "scheduling retry",
+		"delivery_id": failed.ID,
+		"consumer":    failed.Consumer,
+		"attempts":    failed.Attempts,
+		"retry_at":    failed.RetryAt,
+	})
+	return client.broker.Publish(ctx, client.config.RetryTopic, retryRoutingKey(failed.Consumer, failed.Attempts), payload)
+}
+
+func (client *DefaultClient) deadLetter(ctx context.Context, failed FailedDelivery) error {
+	payload, err := json.Marshal(failed)
+	if err != nil {
+		return err
+	}
+	err = client.broker.Publish(ctx, client.config.DeadLetterTopic, failed.Consumer, payload)
+	if err != nil {
+		return err
+	}
+
+	client.logger.Errorj(log.JSON{
+		"message":     "moved delivery to dead letter topic",
+		"delivery_id": failed.ID,
+		"consumer":    failed.Consumer,
+		"attempts":    failed.Attempts,
+		"error":       failed.Error,
+	})
+	deadLetteredDeliveries.WithLabelValues(failed.Consumer).Inc()
+	if client.deadLetterHook != nil {
+		client.deadLetterHook(ctx, failed)
+	}
+	return nil
+}
+
+// WalkDeadLetters calls fn once for every delivery in the dead letter topic and requeues
+// or keeps it depending on the returned action. Kept deliveries are moved to the back of
+// the dead letter topic, so the walk stops when it sees the first one again or when no
+// delivery came in for idleTimeout.
+func (client *DefaultClient) WalkDeadLetters(ctx context.Context, idleTimeout time.Duration, fn func(FailedDelivery) DeadLetterAction) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	deliveryChan, err := client.broker.Listen(ctx, client.config.DeadLetterTopic, "#", client.config.DeadLetterConsumerName)
+	if err != nil {
+		return err
+	}
+
+	firstKept := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.After(idleTimeout):
+			return nil
+
+		case delivery, ok := <-deliveryChan:
+			if !ok {
+				return fmt.Errorf("Disconnected from message broker")
+			}
+
+			failed := FailedDelivery{}
+			err := json.Unmarshal(delivery.Body(), &failed)
+			if err != nil {
+				// can't be requeued anyway, drop it
+				client.logger.Errorf("Dropping badly formatted dead letter: %v", err)
+				err = delivery.Nack(false)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if failed.ID == firstKept {
+				// we went through the whole topic, keep this one where it is
+				err = client.broker.Publish(ctx, client.config.DeadLetterTopic, delivery.RoutingKey(), delivery.Body())
+				if err != nil {
+					return err
+				}
+				return delivery.Ack()
+			}
+
+			switch fn(failed) {
+			case DeadLetterRequeue:
+				err = client.Requeue(ctx, failed)
+			default:
+				if firstKept == "" {
+					firstKept = failed.ID
+				}
+				err = client.broker.Publish(ctx, client.config.DeadLetterTopic, delivery.RoutingKey(), delivery.Body())
+			}
+			if err != nil {
+				return err
+			}
+			err = delivery.Ack()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Requeue hands a dead lettered delivery back to the consumer that failed to process it.
+// It will be processed right away and retried according to the retry policy again.
+func (client *DefaultClient) Requeue(ctx context.Context, failed FailedDelivery) error {
+	failed.Attempts = 0
+	failed.RetryAt = time.Time{}
+	payload, err := json.Marshal(failed)
+	if err != nil {
+		return err
+	}
+	return client.broker.Publish(ctx, client.config.RetryTopic, failed.Consumer+"."+requeueRoutingKeySuffix, payload)
+}
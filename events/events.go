@@ -0,0 +1,80 @@
+package events
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+type (
+	IncomingInvoiceHandler    = func(ctx context.Context, invoice *lnrpc.Invoice) error
+	SubscribeToInvoicesFunc   = func() (in chan models.Invoice, out chan models.Invoice, err error)
+	EncodeOutgoingInvoiceFunc = func(ctx context.Context, w io.Writer, invoice models.Invoice) error
+)
+
+// Client is the broker-agnostic interface lndhub uses to consume LND invoice and
+// payment updates and to publish its own invoice updates.
+type Client interface {
+	SubscribeToLndInvoices(context.Context, IncomingInvoiceHandler) error
+	StartPublishInvoices(context.Context, SubscribeToInvoicesFunc, EncodeOutgoingInvoiceFunc) error
+	FinalizeInitializedPayments(context.Context, LndHubService) error
+	// PublishInvoice publishes a single invoice to the lndhub invoice topic
+	PublishInvoice(context.Context, models.Invoice, EncodeOutgoingInvoiceFunc) error
+	// PublishClusterEvent publishes an event of the LND cluster, e.g. a switch of the active node, to the lndhub cluster topic
+	PublishClusterEvent(ctx context.Context, routingKey string, event interface{}) error
+	// PublishScheduledPaymentEvent publishes the outcome of a scheduled payment to the lndhub scheduled payment topic
+	PublishScheduledPaymentEvent(ctx context.Context, routingKey string, event interface{}) error
+	// WalkDeadLetters goes through the dead lettered deliveries, see DefaultClient.WalkDeadLetters
+	WalkDeadLetters(ctx context.Context, idleTimeout time.Duration, fn func(FailedDelivery) DeadLetterAction) error
+	// Requeue hands a dead lettered delivery back to the consumer that failed to process it
+	Requeue(context.Context, FailedDelivery) error
+	// Close will close all connections to the message broker
+	Close() error
+}
+
+type LndHubService interface {
+	HandleFailedPayment(context.Context, *models.Invoice, models.TransactionEntry, error) error
+	HandleSuccessfulPayment(context.Context, *models.Invoice, models.TransactionEntry) error
+	GetAllPendingPayments(context.Context) ([]models.Invoice, error)
+	GetTransactionEntryByInvoiceId(context.Context, int64) (models.TransactionEntry, error)
+}
+
+// Delivery is a single message received from a broker. Every delivery has to be
+// either acked or nacked exactly once.
+type Delivery interface {
+	Body() []byte
+	// RoutingKey is the routing key the message was published with
+	RoutingKey() string
+	Ack() error
+	// Nack rejects the message. If requeue is true the broker will redeliver it,
+	// otherwise it is dropped.
+	Nack(requeue bool) error
+}
+
+// InProgressDelivery is implemented by deliveries of brokers that redeliver messages
+// that are not acked within a deadline. InProgress resets that deadline.
+type InProgressDelivery interface {
+	Delivery
+	InProgress() error
+}
+
+// Broker is implemented by every message broker backend (RabbitMQ, NATS JetStream, Kafka).
+//
+// Topics and routing keys follow the AMQP topic exchange conventions: keys are
+// dot separated, "*" matches exactly one word and "#" matches zero or more words.
+// Backends translate these into their own addressing scheme.
+type Broker interface {
+	// Declare makes sure the topic exists so messages can be published to it
+	Declare(ctx context.Context, topic string) error
+	// Bind makes sure the durable consumer named consumerName exists, so messages
+	// published to topic matching routingKey are retained until somebody listens.
+	Bind(ctx context.Context, topic, routingKey, consumerName string) error
+	// Listen starts a durable consumer named consumerName on all messages in topic matching routingKey.
+	// The returned channel is closed when the connection to the broker is lost for good.
+	Listen(ctx context.Context, topic, routingKey, consumerName string) (<-chan Delivery, error)
+	Publish(ctx context.Context, topic, routingKey string, body []byte) error
+	Close() error
+}
@@ -0,0 +1,36 @@
+package events
+
+import "strings"
+
+// MatchRoutingKey reports whether key matches pattern using AMQP topic exchange semantics:
+// "*" matches exactly one word and "#" matches zero or more words.
+// Brokers that can't filter by routing key server side use this to filter deliveries.
+func MatchRoutingKey(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			// try to let "#" swallow 0..n words
+			for i := 0; i <= len(key); i++ {
+				if matchWords(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(key) == 0 {
+				return false
+			}
+		default:
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		key = key[1:]
+	}
+	return len(key) == 0
+}
@@ -0,0 +1,24 @@
+package events_test
+
+import (
+	"testing"
+
+	"github.com/getAlby/lndhub.go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchRoutingKey(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, events.MatchRoutingKey("invoice.incoming.settled", "invoice.incoming.settled"))
+	assert.False(t, events.MatchRoutingKey("invoice.incoming.settled", "invoice.incoming.open"))
+
+	assert.True(t, events.MatchRoutingKey("payment.outgoing.*", "payment.outgoing.succeeded"))
+	assert.False(t, events.MatchRoutingKey("payment.outgoing.*", "payment.outgoing"))
+	assert.False(t, events.MatchRoutingKey("payment.outgoing.*", "payment.outgoing.a.b"))
+
+	assert.True(t, events.MatchRoutingKey("invoice.#", "invoice"))
+	assert.True(t, events.MatchRoutingKey("invoice.#", "invoice.incoming.settled"))
+	assert.True(t, events.MatchRoutingKey("#.settled", "invoice.outgoing.settled"))
+	assert.False(t, events.MatchRoutingKey("#.settled", "invoice.outgoing.error"))
+}
@@ -0,0 +1,183 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/security"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type AccountTestSuite struct {
+	TestSuite
+	service                  *service.LndhubService
+	mockLND                  *MockLND
+	externalLND              *MockLND
+	userLogins               []ExpectedCreateUserResponseBody
+	userTokens               []string
+	invoiceUpdateSubCancelFn context.CancelFunc
+}
+
+func (suite *AccountTestSuite) SetupSuite() {
+	mockLND := newDefaultMockLND()
+	suite.mockLND = mockLND
+	externalLND, err := NewMockLND("1234567890abcdefabcd", 0, make(chan (*lnrpc.Invoice)))
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	suite.externalLND = externalLND
+	svc, err := LndHubTestServiceInit(mockLND)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	users, userTokens, err := createUsers(svc, 4)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	// Subscribe to LND invoice updates in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.invoiceUpdateSubCancelFn = cancel
+	go svc.InvoiceUpdateSubscription(ctx)
+	suite.service = svc
+	suite.userLogins = users
+	suite.userTokens = userTokens
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	accountCtrl := v2controllers.NewAccountController(svc)
+	secured := e.Group("", tokens.Middleware([]byte(svc.Config.JWTSecret)), svc.ValidateUserMiddleware())
+	secured.PUT("/v2/account/password", accountCtrl.ChangePassword)
+	secured.PUT("/v2/account/login", accountCtrl.ChangeLogin)
+	secured.POST("/v2/account/delete", accountCtrl.DeleteAccount)
+}
+
+func (suite *AccountTestSuite) TearDownSuite() {
+	suite.invoiceUpdateSubCancelFn()
+}
+
+func (suite *AccountTestSuite) TestChangePassword() {
+	user := suite.userLogins[0]
+	token := suite.userTokens[0]
+	suite.service.Config.MinPasswordEntropy = 50
+	defer func() { suite.service.Config.MinPasswordEntropy = 0 }()
+	newPassword := "correct horse battery staple 42"
+
+	rec := suite.request(http.MethodPut, "/v2/account/password", token, &v2controllers.ChangePasswordRequestBody{CurrentPassword: "wrong", NewPassword: newPassword})
+	suite.assertError(rec, http.StatusBadRequest, service.ErrWrongPassword.Error())
+	rec = suite.request(http.MethodPut, "/v2/account/password", token, &v2controllers.ChangePasswordRequestBody{CurrentPassword: user.Password, NewPassword: "123"})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	assert.Contains(suite.T(), rec.Body.String(), security.ErrWeakPassword.Error())
+
+	rec = suite.request(http.MethodPut, "/v2/account/password", token, &v2controllers.ChangePasswordRequestBody{CurrentPassword: user.Password, NewPassword: newPassword})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	_, _, err := suite.service.GenerateToken(context.Background(), user.Login, user.Password, "")
+	assert.Error(suite.T(), err)
+	_, _, err = suite.service.GenerateToken(context.Background(), user.Login, newPassword, "")
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *AccountTestSuite) TestChangeLogin() {
+	user := suite.userLogins[1]
+	token := suite.userTokens[1]
+
+	rec := suite.request(http.MethodPut, "/v2/account/login", token, &v2controllers.ChangeLoginRequestBody{Password: user.Password, Login: suite.userLogins[2].Login})
+	suite.assertError(rec, http.StatusBadRequest, service.ErrLoginTaken.Error())
+	rec = suite.request(http.MethodPut, "/v2/account/login", token, &v2controllers.ChangeLoginRequestBody{Password: "wrong", Login: "new-login"})
+	suite.assertError(rec, http.StatusBadRequest, service.ErrWrongPassword.Error())
+
+	rec = suite.request(http.MethodPut, "/v2/account/login", token, &v2controllers.ChangeLoginRequestBody{Password: user.Password, Login: "new-login"})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	account := &v2controllers.AccountResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(account))
+	assert.Equal(suite.T(), "new-login", account.Login)
+	_, _, err := suite.service.GenerateToken(context.Background(), "new-login", user.Password, "")
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *AccountTestSuite) TestDeleteAccount() {
+	user := suite.userLogins[2]
+	token := suite.userTokens[2]
+	userId := getUserIdFromToken(token)
+	suite.service.Config.FeeReserve = true
+	defer func() { suite.service.Config.FeeReserve = false }()
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, userId, 1000))
+
+	rec := suite.request(http.MethodPost, "/v2/account/delete", token, &v2controllers.DeleteAccountRequestBody{Password: user.Password})
+	suite.assertError(rec, http.StatusBadRequest, service.ErrBalanceNotZero.Error())
+	rec = suite.request(http.MethodPost, "/v2/account/delete", token, &v2controllers.DeleteAccountRequestBody{Password: user.Password, Invoice: suite.externalInvoice(100)})
+	suite.assertError(rec, http.StatusBadRequest, service.ErrInvalidSweep.Error())
+	rec = suite.request(http.MethodPost, "/v2/account/delete", token, &v2controllers.DeleteAccountRequestBody{Password: "wrong", Invoice: suite.externalInvoice(0)})
+	suite.assertError(rec, http.StatusBadRequest, service.ErrWrongPassword.Error())
+
+	// the amountless invoice gets the balance without the fee reserve, which is left after the payment
+	rec = suite.request(http.MethodPost, "/v2/account/delete", token, &v2controllers.DeleteAccountRequestBody{Password: user.Password, Invoice: suite.externalInvoice(0)})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	deleted := &v2controllers.DeleteAccountResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(deleted))
+	assert.True(suite.T(), deleted.Deleted)
+	assert.NotNil(suite.T(), deleted.Payment)
+	assert.Equal(suite.T(), suite.service.SweepAmount(1000, deleted.Payment.Destination), deleted.Payment.Amount)
+
+	// the token of a deleted user is not accepted anymore
+	rec = suite.request(http.MethodPost, "/v2/account/delete", token, &v2controllers.DeleteAccountRequestBody{Password: user.Password})
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+
+	// accounts without balance are deleted right away
+	rec = suite.request(http.MethodPost, "/v2/account/delete", suite.userTokens[3], &v2controllers.DeleteAccountRequestBody{Password: suite.userLogins[3].Password})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	deletedUser, err := suite.service.FindUser(context.Background(), getUserIdFromToken(suite.userTokens[3]))
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), deletedUser.Deleted)
+}
+
+// externalInvoice returns an invoice of another node, 0 is an amountless invoice
+func (suite *AccountTestSuite) externalInvoice(amount int64) string {
+	invoice, err := suite.externalLND.AddInvoice(context.Background(), &lnrpc.Invoice{
+		Memo:  "integration tests: account sweep",
+		Value: amount,
+		// the mock encodes the expiry as a duration
+		Expiry: int64(time.Hour),
+	})
+	assert.NoError(suite.T(), err)
+	return invoice.PaymentRequest
+}
+
+func (suite *AccountTestSuite) assertError(rec *httptest.ResponseRecorder, status int, message string) {
+	assert.Equal(suite.T(), status, rec.Code)
+	errorResponse := &responses.ErrorResponse{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(errorResponse))
+	assert.Equal(suite.T(), message, errorResponse.Message)
+}
+
+func (suite *AccountTestSuite) request(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAccountSuite(t *testing.T) {
+	suite.Run(t, new(AccountTestSuite))
+}
@@ -0,0 +1,141 @@
+package integration_tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+const testAdminToken = "admin_token"
+
+type AdminPrincipalTestSuite struct {
+	TestSuite
+	service    *service.LndhubService
+	userTokens []string
+}
+
+func (suite *AdminPrincipalTestSuite) SetupSuite() {
+	svc, err := LndHubTestServiceInit(newDefaultMockLND())
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	svc.Config.AdminToken = testAdminToken
+	_, userTokens, err := createUsers(svc, 1)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	suite.service = svc
+	suite.userTokens = userTokens
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	adminCtrl := v2controllers.NewAdminController(svc)
+	adjustmentCtrl := v2controllers.NewAdjustmentController(svc)
+	principalCtrl := v2controllers.NewAdminPrincipalController(svc)
+	e.GET("/v2/admin/users/:id", adminCtrl.GetUser, svc.AdminMiddleware(service.PermissionRead))
+	e.POST("/v2/admin/adjustments", adjustmentCtrl.AdjustBalance, svc.AdminMiddleware(service.PermissionAdjustBalance))
+	manageAdminsMw := svc.AdminMiddleware(service.PermissionManageAdmins)
+	e.POST("/v2/admin/principals", principalCtrl.CreateAdminPrincipal, manageAdminsMw)
+	e.DELETE("/v2/admin/principals/:id", principalCtrl.RevokeAdminPrincipal, manageAdminsMw)
+	e.GET("/v2/admin/audit", principalCtrl.GetAdminAuditLog, manageAdminsMw)
+}
+
+func (suite *AdminPrincipalTestSuite) TearDownTest() {
+	clearTable(suite.service, "admin_principals")
+	clearTable(suite.service, "transaction_entries")
+	clearTable(suite.service, "invoices")
+}
+
+func (suite *AdminPrincipalTestSuite) TestRoles() {
+	userId := getUserIdFromToken(suite.userTokens[0])
+	support := suite.createPrincipal("support", service.AdminRoleSupportReadOnly)
+	operator := suite.createPrincipal("operator", service.AdminRoleOperator)
+
+	rec := suite.adminReq(http.MethodGet, fmt.Sprintf("/v2/admin/users/%d", userId), support.Token, nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	adjustment := &v2controllers.AdjustmentRequestBody{
+		UserID:    userId,
+		Type:      models.LedgerAdjustmentTypeCredit,
+		Amount:    100,
+		Reason:    "goodwill",
+		Reference: "ticket-1",
+	}
+	rec = suite.adminReq(http.MethodPost, "/v2/admin/adjustments", support.Token, adjustment)
+	assert.Equal(suite.T(), http.StatusForbidden, rec.Code)
+	rec = suite.adminReq(http.MethodPost, "/v2/admin/adjustments", operator.Token, adjustment)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	// only superadmins can manage admins
+	rec = suite.adminReq(http.MethodPost, "/v2/admin/principals", operator.Token, &v2controllers.CreateAdminPrincipalRequestBody{
+		Name: "another",
+		Role: service.AdminRoleSuperadmin,
+	})
+	assert.Equal(suite.T(), http.StatusForbidden, rec.Code)
+
+	rec = suite.adminReq(http.MethodGet, fmt.Sprintf("/v2/admin/audit?user_id=%d", userId), testAdminToken, nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	auditLog := &v2controllers.AdminAuditLogResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(auditLog))
+	assert.Equal(suite.T(), 1, auditLog.Total)
+	entry := auditLog.Entries[0]
+	assert.Equal(suite.T(), "operator", entry.Actor)
+	assert.Equal(suite.T(), operator.ID, entry.PrincipalID)
+	assert.Equal(suite.T(), "credit_user", entry.Action)
+	assert.Equal(suite.T(), float64(0), entry.Before.(map[string]interface{})["balance"])
+	assert.Equal(suite.T(), float64(100), entry.After.(map[string]interface{})["balance"])
+
+	// revoked admins can't authenticate anymore
+	rec = suite.adminReq(http.MethodDelete, fmt.Sprintf("/v2/admin/principals/%d", support.ID), testAdminToken, nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec = suite.adminReq(http.MethodGet, fmt.Sprintf("/v2/admin/users/%d", userId), support.Token, nil)
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	rec = suite.adminReq(http.MethodGet, fmt.Sprintf("/v2/admin/users/%d", userId), "", nil)
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+}
+
+func (suite *AdminPrincipalTestSuite) createPrincipal(name, role string) *v2controllers.CreateAdminPrincipalResponseBody {
+	rec := suite.adminReq(http.MethodPost, "/v2/admin/principals", testAdminToken, &v2controllers.CreateAdminPrincipalRequestBody{
+		Name: name,
+		Role: role,
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	principal := &v2controllers.CreateAdminPrincipalResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(principal))
+	assert.NotEmpty(suite.T(), principal.Token)
+	return principal
+}
+
+func (suite *AdminPrincipalTestSuite) adminReq(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	if body != nil {
+		assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminPrincipalSuite(t *testing.T) {
+	suite.Run(t, new(AdminPrincipalTestSuite))
+}
@@ -0,0 +1,163 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type AdminTestSuite struct {
+	TestSuite
+	service                  *service.LndhubService
+	mockLND                  *MockLND
+	userLogins               []ExpectedCreateUserResponseBody
+	userTokens               []string
+	invoiceUpdateSubCancelFn context.CancelFunc
+}
+
+func (suite *AdminTestSuite) SetupSuite() {
+	mockLND := newDefaultMockLND()
+	suite.mockLND = mockLND
+	svc, err := LndHubTestServiceInit(mockLND)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	users, userTokens, err := createUsers(svc, 3)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	// Subscribe to LND invoice updates in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.invoiceUpdateSubCancelFn = cancel
+	go svc.InvoiceUpdateSubscription(ctx)
+	suite.service = svc
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	suite.userLogins = users
+	suite.userTokens = userTokens
+	adminCtrl := v2controllers.NewAdminController(svc)
+	suite.echo.GET("/v2/admin/users", adminCtrl.SearchUsers)
+	suite.echo.GET("/v2/admin/users/:id", adminCtrl.GetUser)
+	suite.echo.GET("/v2/admin/users/:id/invoices", adminCtrl.GetUserInvoices)
+	suite.echo.GET("/v2/admin/users/:id/transactions", adminCtrl.GetUserTransactionEntries)
+	suite.echo.POST("/v2/admin/users/deactivate", adminCtrl.DeactivateUsers)
+	suite.echo.POST("/v2/admin/users/reactivate", adminCtrl.ReactivateUsers)
+}
+
+func (suite *AdminTestSuite) TearDownSuite() {
+	suite.invoiceUpdateSubCancelFn()
+}
+
+func (suite *AdminTestSuite) TearDownTest() {
+	clearTable(suite.service, "transaction_entries")
+	clearTable(suite.service, "invoices")
+}
+
+func (suite *AdminTestSuite) TestSearchAndInspectUser() {
+	aliceId := getUserIdFromToken(suite.userTokens[0])
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 500))
+
+	rec := suite.adminReq(http.MethodGet, "/v2/admin/users?q="+suite.userLogins[0].Login, nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	users := &v2controllers.AdminUsersResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(users))
+	assert.Equal(suite.T(), 1, users.Total)
+	assert.Equal(suite.T(), aliceId, users.Users[0].ID)
+
+	rec = suite.adminReq(http.MethodGet, "/v2/admin/users?limit=1", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	users = &v2controllers.AdminUsersResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(users))
+	assert.Len(suite.T(), users.Users, 1)
+	assert.GreaterOrEqual(suite.T(), users.Total, 3)
+
+	rec = suite.adminReq(http.MethodGet, fmt.Sprintf("/v2/admin/users/%d", aliceId), nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	user := &v2controllers.AdminUserResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(user))
+	assert.Equal(suite.T(), int64(1500), user.Balance)
+	assert.Equal(suite.T(), int64(1500), user.Volumes.Received)
+	assert.Len(suite.T(), user.Wallets, 1)
+	assert.Equal(suite.T(), int64(0), user.Wallets[0].ID)
+	assert.Equal(suite.T(), int64(1500), user.Wallets[0].Balance)
+
+	rec = suite.adminReq(http.MethodGet, fmt.Sprintf("/v2/admin/users/%d/invoices?type=incoming&limit=1&offset=1", aliceId), nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	invoices := &v2controllers.AdminInvoicesResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(invoices))
+	assert.Equal(suite.T(), 2, invoices.Total)
+	assert.Len(suite.T(), invoices.Invoices, 1)
+	assert.Equal(suite.T(), int64(1000), invoices.Invoices[0].Amount)
+
+	rec = suite.adminReq(http.MethodGet, fmt.Sprintf("/v2/admin/users/%d/transactions", aliceId), nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	entries := &v2controllers.AdminTransactionEntriesResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(entries))
+	assert.Equal(suite.T(), 2, entries.Total)
+
+	rec = suite.adminReq(http.MethodGet, "/v2/admin/users/999999999", nil)
+	assert.Equal(suite.T(), http.StatusNotFound, rec.Code)
+	rec = suite.adminReq(http.MethodGet, "/v2/admin/users?limit=-1", nil)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+}
+
+func (suite *AdminTestSuite) TestBulkDeactivate() {
+	ids := []int64{getUserIdFromToken(suite.userTokens[1]), getUserIdFromToken(suite.userTokens[2])}
+	rec := suite.adminReq(http.MethodPost, "/v2/admin/users/deactivate", &v2controllers.BulkUsersRequestBody{IDs: ids})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	users := []v2controllers.AdminUser{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&users))
+	assert.Len(suite.T(), users, 2)
+	for _, user := range users {
+		assert.True(suite.T(), user.Deactivated)
+	}
+	_, _, err := suite.service.GenerateToken(context.Background(), suite.userLogins[1].Login, suite.userLogins[1].Password, "")
+	assert.Error(suite.T(), err)
+
+	rec = suite.adminReq(http.MethodPost, "/v2/admin/users/reactivate", &v2controllers.BulkUsersRequestBody{IDs: ids})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	users = []v2controllers.AdminUser{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&users))
+	for _, user := range users {
+		assert.False(suite.T(), user.Deactivated)
+	}
+	_, _, err = suite.service.GenerateToken(context.Background(), suite.userLogins[1].Login, suite.userLogins[1].Password, "")
+	assert.NoError(suite.T(), err)
+
+	rec = suite.adminReq(http.MethodPost, "/v2/admin/users/deactivate", &v2controllers.BulkUsersRequestBody{})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+}
+
+func (suite *AdminTestSuite) adminReq(method, path string, body interface{}) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	if body != nil {
+		assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminSuite(t *testing.T) {
+	suite.Run(t, new(AdminTestSuite))
+}
@@ -0,0 +1,157 @@
+package integration_tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type AuditEventTestSuite struct {
+	TestSuite
+	service    *service.LndhubService
+	userLogins []ExpectedCreateUserResponseBody
+	userTokens []string
+}
+
+func (suite *AuditEventTestSuite) SetupSuite() {
+	svc, err := LndHubTestServiceInit(newDefaultMockLND())
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	svc.Config.LockoutLimitBreaches = 2
+	svc.Config.LockoutPeriod = 900
+	users, userTokens, err := createUsers(svc, 3)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	suite.service = svc
+	suite.userLogins = users
+	suite.userTokens = userTokens
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	auditEventCtrl := v2controllers.NewAuditEventController(svc)
+	e.GET("/v2/admin/audit/events", auditEventCtrl.GetAuditEvents)
+	e.GET("/v2/admin/audit/events/export", auditEventCtrl.ExportAuditEvents)
+}
+
+func (suite *AuditEventTestSuite) TestLoginEvents() {
+	ctx := service.WithClientIP(context.Background(), "10.0.0.1")
+	user := suite.userLogins[0]
+	_, _, err := suite.service.GenerateToken(ctx, user.Login, "wrong password", "")
+	assert.EqualError(suite.T(), err, "bad auth")
+	_, _, err = suite.service.GenerateToken(ctx, "unknown login", "wrong password", "")
+	assert.EqualError(suite.T(), err, "bad auth")
+	_, _, err = suite.service.GenerateToken(ctx, user.Login, user.Password, "")
+	assert.NoError(suite.T(), err)
+
+	events := suite.auditEvents(fmt.Sprintf("/v2/admin/audit/events?login=%s", user.Login))
+	// the login of createUsers, the failure and the login
+	assert.Equal(suite.T(), 3, events.Total)
+	assert.Equal(suite.T(), models.AuditEventLoginSucceeded, events.Events[0].Type)
+	assert.Equal(suite.T(), models.AuditEventLoginFailed, events.Events[1].Type)
+	assert.Equal(suite.T(), "wrong password", events.Events[1].Details["reason"])
+	assert.Equal(suite.T(), "10.0.0.1", events.Events[1].IP)
+
+	events = suite.auditEvents("/v2/admin/audit/events?login=unknown%20login&type=login_failed")
+	assert.GreaterOrEqual(suite.T(), events.Total, 1)
+	assert.Equal(suite.T(), int64(0), events.Events[0].UserID)
+}
+
+func (suite *AuditEventTestSuite) TestUserChangesAndLimitBreaches() {
+	ctx := context.Background()
+	userId := getUserIdFromToken(suite.userTokens[2])
+	password := "a new password that is long enough"
+	deactivated := true
+	_, err := suite.service.UpdateUser(ctx, userId, nil, &password, &deactivated, nil)
+	assert.NoError(suite.T(), err)
+	deactivated = false
+	_, err = suite.service.UpdateUser(ctx, userId, nil, nil, &deactivated, nil)
+	assert.NoError(suite.T(), err)
+
+	suite.service.Config.MaxSendAmount = 100
+	defer func() { suite.service.Config.MaxSendAmount = -1 }()
+	payReq := &lnd.LNPayReq{PayReq: &lnrpc.PayReq{NumSatoshis: 1000}}
+	for _, expected := range []responses.ErrorResponse{responses.SendExceededError, responses.SendExceededError, responses.PaymentsLockedError} {
+		c := suite.echo.NewContext(httptest.NewRequest(http.MethodPost, "/v2/payments/bolt11", nil), httptest.NewRecorder())
+		result, err := suite.service.CheckOutgoingPaymentAllowed(c, payReq, userId)
+		assert.NoError(suite.T(), err)
+		assert.Equal(suite.T(), expected.Message, result.Message)
+	}
+
+	events := suite.auditEvents(fmt.Sprintf("/v2/admin/audit/events?user_id=%d", userId))
+	types := []string{}
+	for _, event := range events.Events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(suite.T(), []string{
+		models.AuditEventPaymentsLocked,
+		models.AuditEventLimitExceeded,
+		models.AuditEventLimitExceeded,
+		models.AuditEventUserReactivated,
+		models.AuditEventUserDeactivated,
+		models.AuditEventPasswordChanged,
+		models.AuditEventLoginSucceeded,
+	}, types)
+
+	// exports are oldest first
+	rec := httptest.NewRecorder()
+	suite.echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v2/admin/audit/events/export?format=csv&user_id=%d", userId), nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), rows, 8)
+	assert.Equal(suite.T(), "type", rows[0][2])
+	assert.Equal(suite.T(), models.AuditEventLoginSucceeded, rows[1][2])
+	assert.Equal(suite.T(), models.AuditEventPasswordChanged, rows[2][2])
+
+	rec = httptest.NewRecorder()
+	suite.echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v2/admin/audit/events/export?user_id=%d&type=%s", userId, models.AuditEventLimitExceeded), nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	scanner := bufio.NewScanner(rec.Body)
+	lines := 0
+	for scanner.Scan() {
+		event := &models.AuditEvent{}
+		assert.NoError(suite.T(), json.Unmarshal(scanner.Bytes(), event))
+		assert.Equal(suite.T(), responses.SendExceededError.Message, event.Details["error"])
+		lines++
+	}
+	assert.Equal(suite.T(), 2, lines)
+
+	rec = httptest.NewRecorder()
+	suite.echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/admin/audit/events/export?format=xml", nil))
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+}
+
+func (suite *AuditEventTestSuite) auditEvents(path string) *v2controllers.AuditEventsResponseBody {
+	rec := httptest.NewRecorder()
+	suite.echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	events := &v2controllers.AuditEventsResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(events))
+	return events
+}
+
+func TestAuditEventSuite(t *testing.T) {
+	suite.Run(t, new(AuditEventTestSuite))
+}
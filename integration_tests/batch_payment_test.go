@@ -0,0 +1,224 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/common"
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type BatchPaymentTestSuite struct {
+	TestSuite
+	service                  *service.LndhubService
+	mockLND                  *MockLND
+	aliceToken               string
+	bobToken                 string
+	invoiceUpdateSubCancelFn context.CancelFunc
+}
+
+func (suite *BatchPaymentTestSuite) SetupSuite() {
+	mockLND := newDefaultMockLND()
+	suite.mockLND = mockLND
+	svc, err := LndHubTestServiceInit(mockLND)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	svc.Config.BatchPaymentMaxInvoices = 3
+	svc.Config.BatchPaymentWorkers = 2
+	_, userTokens, err := createUsers(svc, 2)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	// Subscribe to LND invoice updates in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.invoiceUpdateSubCancelFn = cancel
+	go svc.InvoiceUpdateSubscription(ctx)
+	suite.service = svc
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	suite.aliceToken = userTokens[0]
+	suite.bobToken = userTokens[1]
+	suite.echo.POST("/v2/payments/bolt11/multi", v2controllers.NewPayInvoiceController(svc).MultiPayInvoice, tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+}
+
+func (suite *BatchPaymentTestSuite) TearDownSuite() {
+	suite.invoiceUpdateSubCancelFn()
+}
+
+func (suite *BatchPaymentTestSuite) TearDownTest() {
+	clearTable(suite.service, "transaction_entries")
+	clearTable(suite.service, "invoices")
+}
+
+func (suite *BatchPaymentTestSuite) TestBatchPayment() {
+	ctx := context.Background()
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	bobId := getUserIdFromToken(suite.bobToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+
+	body := &v2controllers.MultiPayInvoiceRequestBody{
+		Invoices: []v2controllers.PayInvoiceRequestBody{
+			{Invoice: suite.bobInvoice(100)},
+			{Invoice: suite.bobInvoice(200)},
+			{Invoice: "not an invoice"},
+		},
+	}
+	rec := suite.batchReq(body, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	response := &v2controllers.MultiPayInvoiceResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(response))
+	assert.Len(suite.T(), response.Payments, 3)
+	assert.Nil(suite.T(), response.Payments[0].Error)
+	assert.Equal(suite.T(), int64(100), response.Payments[0].Payment.Amount)
+	assert.NotEmpty(suite.T(), response.Payments[0].Payment.PaymentPreimage)
+	assert.Nil(suite.T(), response.Payments[1].Error)
+	assert.Equal(suite.T(), int64(200), response.Payments[1].Payment.Amount)
+	assert.NotNil(suite.T(), response.Payments[2].Error)
+
+	aliceBalance, err := suite.service.CurrentUserBalance(ctx, aliceId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(700), aliceBalance)
+	bobBalance, err := suite.service.CurrentUserBalance(ctx, bobId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(300), bobBalance)
+}
+
+func (suite *BatchPaymentTestSuite) TestBatchPaymentAllOrNothing() {
+	ctx := context.Background()
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+
+	// a single invalid invoice rejects the whole batch
+	rec := suite.batchReq(&v2controllers.MultiPayInvoiceRequestBody{
+		Invoices: []v2controllers.PayInvoiceRequestBody{
+			{Invoice: suite.bobInvoice(100)},
+			{Invoice: "not an invoice"},
+		},
+		AllOrNothing: true,
+	}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	outgoing, err := suite.service.InvoicesFor(ctx, aliceId, common.InvoiceTypeOutgoing)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), outgoing)
+
+	rec = suite.batchReq(&v2controllers.MultiPayInvoiceRequestBody{
+		Invoices: []v2controllers.PayInvoiceRequestBody{
+			{Invoice: suite.bobInvoice(100)},
+			{Invoice: suite.bobInvoice(200)},
+		},
+		AllOrNothing: true,
+	}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	aliceBalance, err := suite.service.CurrentUserBalance(ctx, aliceId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(700), aliceBalance)
+}
+
+func (suite *BatchPaymentTestSuite) TestBatchPaymentPartialFailure() {
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+
+	rec := suite.batchReq(&v2controllers.MultiPayInvoiceRequestBody{
+		Invoices: []v2controllers.PayInvoiceRequestBody{
+			{Invoice: suite.bobInvoice(100)},
+			{Invoice: "not an invoice"},
+		},
+	}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	response := &v2controllers.MultiPayInvoiceResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(response))
+	assert.Nil(suite.T(), response.Payments[0].Error)
+	assert.Equal(suite.T(), int64(100), response.Payments[0].Payment.Amount)
+	// failed payments only have an error
+	assert.NotNil(suite.T(), response.Payments[1].Error)
+	assert.Nil(suite.T(), response.Payments[1].Payment)
+}
+
+func (suite *BatchPaymentTestSuite) TestBatchPaymentAmountlessInvoice() {
+	ctx := context.Background()
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+
+	// zero-amount invoices are paid with the amount of the request, without it they fail
+	rec := suite.batchReq(&v2controllers.MultiPayInvoiceRequestBody{
+		Invoices: []v2controllers.PayInvoiceRequestBody{
+			{Invoice: suite.bobInvoice(0), Amount: 150},
+			{Invoice: suite.bobInvoice(0)},
+		},
+	}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	response := &v2controllers.MultiPayInvoiceResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(response))
+	assert.Nil(suite.T(), response.Payments[0].Error)
+	assert.Equal(suite.T(), int64(150), response.Payments[0].Payment.Amount)
+	assert.NotNil(suite.T(), response.Payments[1].Error)
+	assert.Equal(suite.T(), responses.BadArgumentsError.Message, response.Payments[1].Error.Message)
+
+	aliceBalance, err := suite.service.CurrentUserBalance(ctx, aliceId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(850), aliceBalance)
+}
+
+func (suite *BatchPaymentTestSuite) TestBatchPaymentLimits() {
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 250))
+
+	// the balance has to cover all invoices
+	rec := suite.batchReq(&v2controllers.MultiPayInvoiceRequestBody{
+		Invoices: []v2controllers.PayInvoiceRequestBody{
+			{Invoice: suite.bobInvoice(100)},
+			{Invoice: suite.bobInvoice(200)},
+		},
+	}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	resp := &responses.ErrorResponse{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(resp))
+	assert.Equal(suite.T(), responses.NotEnoughBalanceError.Message, resp.Message)
+
+	// at most BATCH_PAYMENT_MAX_INVOICES invoices
+	invoices := []v2controllers.PayInvoiceRequestBody{}
+	for i := 0; i < 4; i++ {
+		invoices = append(invoices, v2controllers.PayInvoiceRequestBody{Invoice: suite.bobInvoice(1)})
+	}
+	rec = suite.batchReq(&v2controllers.MultiPayInvoiceRequestBody{Invoices: invoices}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+}
+
+func (suite *BatchPaymentTestSuite) bobInvoice(amount int64) string {
+	invoice, errResp := suite.service.AddIncomingInvoice(context.Background(), getUserIdFromToken(suite.bobToken), amount, "payout", "")
+	assert.Nil(suite.T(), errResp)
+	return invoice.PaymentRequest
+}
+
+func (suite *BatchPaymentTestSuite) batchReq(body *v2controllers.MultiPayInvoiceRequestBody, token string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	req := httptest.NewRequest(http.MethodPost, "/v2/payments/bolt11/multi", &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBatchPaymentSuite(t *testing.T) {
+	suite.Run(t, new(BatchPaymentTestSuite))
+}
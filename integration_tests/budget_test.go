@@ -0,0 +1,184 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type BudgetTestSuite struct {
+	TestSuite
+	service                  *service.LndhubService
+	mockLND                  *MockLND
+	aliceToken               string
+	bobLogin                 ExpectedCreateUserResponseBody
+	invoiceUpdateSubCancelFn context.CancelFunc
+}
+
+func (suite *BudgetTestSuite) SetupSuite() {
+	mockLND := newDefaultMockLND()
+	suite.mockLND = mockLND
+	svc, err := LndHubTestServiceInit(mockLND)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	users, userTokens, err := createUsers(svc, 2)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	// Subscribe to LND invoice updates in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.invoiceUpdateSubCancelFn = cancel
+	go svc.InvoiceUpdateSubscription(ctx)
+	suite.service = svc
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	suite.aliceToken = userTokens[0]
+	suite.bobLogin = users[1]
+	secured := suite.echo.Group("", tokens.Middleware([]byte(suite.service.Config.JWTSecret)), svc.ValidateUserMiddleware())
+	fullAccessMw := svc.RequireFullAccessMiddleware()
+	budgetCtrl := v2controllers.NewBudgetController(svc)
+	secured.POST("/v2/budgets", budgetCtrl.CreateBudget, fullAccessMw)
+	secured.GET("/v2/budgets/current", budgetCtrl.GetCurrentBudget)
+	secured.DELETE("/v2/budgets/:id", budgetCtrl.RevokeBudget, fullAccessMw)
+	secured.POST("/v2/payments/internal", v2controllers.NewTransferController(svc).InternalTransfer)
+	secured.GET("/v2/balance", v2controllers.NewBalanceController(svc).Balance)
+}
+
+func (suite *BudgetTestSuite) TearDownSuite() {
+	suite.invoiceUpdateSubCancelFn()
+}
+
+func (suite *BudgetTestSuite) TearDownTest() {
+	clearTable(suite.service, "budgets")
+	clearTable(suite.service, "transaction_entries")
+	clearTable(suite.service, "invoices")
+}
+
+func (suite *BudgetTestSuite) TestBudget() {
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+
+	rec := suite.budgetReq(http.MethodPost, "/v2/budgets", suite.aliceToken, &v2controllers.CreateBudgetRequestBody{
+		Name:          "podcast app",
+		Amount:        200,
+		RenewalPeriod: service.BudgetRenewalWeekly,
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	created := &v2controllers.CreateBudgetResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(created))
+	assert.Equal(suite.T(), service.BudgetRenewalTypeCalendar, created.RenewalType)
+	assert.Equal(suite.T(), int64(200), created.Remaining)
+	assert.NotNil(suite.T(), created.RenewsAt)
+	budgetToken := created.AccessToken
+
+	rec = suite.budgetReq(http.MethodPost, "/v2/payments/internal", budgetToken, &v2controllers.InternalTransferRequestBody{
+		Recipient: suite.bobLogin.Login,
+		Amount:    150,
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	// the remaining budget is too low
+	rec = suite.budgetReq(http.MethodPost, "/v2/payments/internal", budgetToken, &v2controllers.InternalTransferRequestBody{
+		Recipient: suite.bobLogin.Login,
+		Amount:    100,
+	})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	errorResponse := &responses.ErrorResponse{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(errorResponse))
+	assert.Equal(suite.T(), responses.BudgetExceededError.Message, errorResponse.Message)
+
+	// the budget is enforced when the payment is made as well
+	bob, err := suite.service.FindTransferRecipient(context.Background(), suite.bobLogin.Login)
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.InternalTransfer(service.WithBudget(context.Background(), created.ID), aliceId, bob, 100, "", nil, nil)
+	assert.ErrorIs(suite.T(), err, service.ErrBudgetExceeded)
+
+	// the user's own token is not bound to the budget
+	rec = suite.budgetReq(http.MethodPost, "/v2/payments/internal", suite.aliceToken, &v2controllers.InternalTransferRequestBody{
+		Recipient: suite.bobLogin.Login,
+		Amount:    100,
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	rec = suite.budgetReq(http.MethodGet, "/v2/budgets/current", budgetToken, nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	current := &v2controllers.Budget{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(current))
+	assert.Equal(suite.T(), int64(150), current.Spent)
+	assert.Equal(suite.T(), int64(50), current.Remaining)
+
+	rec = suite.budgetReq(http.MethodGet, "/v2/budgets/current", suite.aliceToken, nil)
+	assert.Equal(suite.T(), http.StatusNotFound, rec.Code)
+}
+
+func (suite *BudgetTestSuite) TestBudgetTokenRestrictions() {
+	rec := suite.budgetReq(http.MethodPost, "/v2/budgets", suite.aliceToken, &v2controllers.CreateBudgetRequestBody{
+		Name:          "shop",
+		Amount:        1000,
+		RenewalPeriod: service.BudgetRenewalDaily,
+		RenewalType:   service.BudgetRenewalTypeRolling,
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	created := &v2controllers.CreateBudgetResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(created))
+
+	// budget tokens can't create budgets for themselves
+	rec = suite.budgetReq(http.MethodPost, "/v2/budgets", created.AccessToken, &v2controllers.CreateBudgetRequestBody{
+		Name:          "more",
+		Amount:        100000,
+		RenewalPeriod: service.BudgetRenewalNever,
+	})
+	assert.Equal(suite.T(), http.StatusForbidden, rec.Code)
+
+	// refreshed tokens are still bound to the budget
+	_, refreshedToken, err := suite.service.GenerateToken(context.Background(), "", "", created.RefreshToken)
+	assert.NoError(suite.T(), err)
+	budgetId, err := tokens.GetBudgetIdFromToken([]byte(suite.service.Config.JWTSecret), refreshedToken)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), created.ID, budgetId)
+
+	rec = suite.budgetReq(http.MethodGet, "/v2/balance", created.AccessToken, nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec = suite.budgetReq(http.MethodDelete, fmt.Sprintf("/v2/budgets/%d", created.ID), suite.aliceToken, nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec = suite.budgetReq(http.MethodGet, "/v2/balance", created.AccessToken, nil)
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	_, _, err = suite.service.GenerateToken(context.Background(), "", "", created.RefreshToken)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *BudgetTestSuite) budgetReq(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	if body != nil {
+		assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBudgetSuite(t *testing.T) {
+	suite.Run(t, new(BudgetTestSuite))
+}
@@ -0,0 +1,171 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type HoldInvoiceTestSuite struct {
+	TestSuite
+	service                  *service.LndhubService
+	mockLND                  *MockLND
+	userLogin                ExpectedCreateUserResponseBody
+	userToken                string
+	invoiceUpdateSubCancelFn context.CancelFunc
+}
+
+func (suite *HoldInvoiceTestSuite) SetupSuite() {
+	mockLND := newDefaultMockLND()
+	suite.mockLND = mockLND
+	svc, err := LndHubTestServiceInit(mockLND)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	users, userTokens, err := createUsers(svc, 1)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	// Subscribe to LND invoice updates in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.invoiceUpdateSubCancelFn = cancel
+	go svc.InvoiceUpdateSubscription(ctx)
+	suite.service = svc
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	assert.Equal(suite.T(), 1, len(users))
+	assert.Equal(suite.T(), 1, len(userTokens))
+	suite.userLogin = users[0]
+	suite.userToken = userTokens[0]
+	controller := v2controllers.NewHoldInvoiceController(svc)
+	secured := suite.echo.Group("", tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+	secured.POST("/v2/invoices/hold", controller.AddHoldInvoice)
+	secured.POST("/v2/invoices/hold/:payment_hash/settle", controller.SettleHoldInvoice)
+	secured.POST("/v2/invoices/hold/:payment_hash/cancel", controller.CancelHoldInvoice)
+}
+
+func (suite *HoldInvoiceTestSuite) TearDownSuite() {
+	suite.invoiceUpdateSubCancelFn()
+}
+
+func (suite *HoldInvoiceTestSuite) TearDownTest() {
+	clearTable(suite.service, "invoices")
+}
+
+func (suite *HoldInvoiceTestSuite) TestSettleHoldInvoice() {
+	preimage, paymentHash := newPreimage()
+	invoice := suite.createHoldInvoiceReq(100, paymentHash)
+	assert.Equal(suite.T(), paymentHash, invoice.PaymentHash)
+	assert.NotEmpty(suite.T(), invoice.PaymentRequest)
+
+	// can't be settled before it was paid
+	rec := suite.holdInvoiceAction(paymentHash, "settle", &v2controllers.SettleHoldInvoiceRequestBody{Preimage: preimage})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	assert.NoError(suite.T(), suite.mockLND.mockAcceptHoldInvoice(paymentHash))
+	time.Sleep(100 * time.Millisecond)
+	userId := getUserIdFromToken(suite.userToken)
+	dbInvoice, err := suite.service.FindInvoiceByPaymentHash(context.Background(), userId, paymentHash)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), common.InvoiceStateAccepted, dbInvoice.State)
+	balance, err := suite.service.CurrentUserBalance(context.Background(), userId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(0), balance)
+
+	// wrong preimage
+	wrongPreimage, _ := newPreimage()
+	rec = suite.holdInvoiceAction(paymentHash, "settle", &v2controllers.SettleHoldInvoiceRequestBody{Preimage: wrongPreimage})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	rec = suite.holdInvoiceAction(paymentHash, "settle", &v2controllers.SettleHoldInvoiceRequestBody{Preimage: preimage})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	time.Sleep(100 * time.Millisecond)
+	dbInvoice, err = suite.service.FindInvoiceByPaymentHash(context.Background(), userId, paymentHash)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), common.InvoiceStateSettled, dbInvoice.State)
+	balance, err = suite.service.CurrentUserBalance(context.Background(), userId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(100), balance)
+}
+
+func (suite *HoldInvoiceTestSuite) TestCancelHoldInvoice() {
+	_, paymentHash := newPreimage()
+	suite.createHoldInvoiceReq(100, paymentHash)
+	assert.NoError(suite.T(), suite.mockLND.mockAcceptHoldInvoice(paymentHash))
+	time.Sleep(100 * time.Millisecond)
+
+	rec := suite.holdInvoiceAction(paymentHash, "cancel", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	response := &v2controllers.HoldInvoiceResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(response))
+	assert.Equal(suite.T(), common.InvoiceStateCanceled, response.Status)
+
+	// a canceled invoice can't be canceled again
+	rec = suite.holdInvoiceAction(paymentHash, "cancel", nil)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+}
+
+func (suite *HoldInvoiceTestSuite) createHoldInvoiceReq(amount int64, paymentHash string) *v2controllers.AddInvoiceResponseBody {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(&v2controllers.AddHoldInvoiceRequestBody{
+		Amount:      amount,
+		Description: "integration test HoldInvoiceTestSuite",
+		PaymentHash: paymentHash,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/v2/invoices/hold", &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.userToken))
+	suite.echo.ServeHTTP(rec, req)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	invoice := &v2controllers.AddInvoiceResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(invoice))
+	return invoice
+}
+
+func (suite *HoldInvoiceTestSuite) holdInvoiceAction(paymentHash, action string, body interface{}) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	if body != nil {
+		assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v2/invoices/hold/%s/%s", paymentHash, action), &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.userToken))
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func newPreimage() (preimage, paymentHash string) {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	hash := sha256.Sum256(bytes)
+	return hex.EncodeToString(bytes), hex.EncodeToString(hash[:])
+}
+
+func TestHoldInvoiceSuite(t *testing.T) {
+	suite.Run(t, new(HoldInvoiceTestSuite))
+}
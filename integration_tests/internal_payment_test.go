@@ -464,6 +464,33 @@ func (suite *PaymentTestSuite) TestInternalPaymentFail() {
 	// assert that balance was reduced only once
 	assert.Equal(suite.T(), int64(aliceFundingSats)-int64(bobSatRequested+fee), int64(aliceBalance))
 }
+func (suite *PaymentTestSuite) TestInternalPaymentCanceledInvoice() {
+	aliceFundingSats := 1000
+	invoiceResponse := suite.createAddInvoiceReq(aliceFundingSats, "integration test internal payment alice", suite.aliceToken)
+	err := suite.mlnd.mockPaidInvoice(invoiceResponse, 0, false, nil)
+	assert.NoError(suite.T(), err)
+
+	//wait a bit for the callback event to hit
+	time.Sleep(100 * time.Millisecond)
+
+	//create invoice for bob and cancel it
+	bobInvoice := suite.createAddInvoiceReq(500, "integration test internal payment bob", suite.bobToken)
+	bobId := getUserIdFromToken(suite.bobToken)
+	canceled, err := suite.service.CancelInvoice(context.Background(), bobId, bobInvoice.RHash)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), common.InvoiceStateCanceled, canceled.State)
+
+	//paying the canceled invoice fails
+	errResponse := suite.createPayInvoiceReqError(bobInvoice.PayReq, suite.aliceToken)
+	assert.Contains(suite.T(), errResponse.Message, service.ErrInvoiceCanceled.Error())
+	aliceBalance, err := suite.service.CurrentUserBalance(context.Background(), getUserIdFromToken(suite.aliceToken))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(aliceFundingSats), aliceBalance)
+	bobBalance, err := suite.service.CurrentUserBalance(context.Background(), bobId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(0), bobBalance)
+}
+
 func (suite *PaymentTestSuite) TestInternalPaymentKeysend() {
 	aliceFundingSats := 1000
 	bobAmt := 100
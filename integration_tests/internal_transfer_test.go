@@ -0,0 +1,165 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/common"
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type InternalTransferTestSuite struct {
+	TestSuite
+	service                  *service.LndhubService
+	mockLND                  *MockLND
+	aliceLogin               ExpectedCreateUserResponseBody
+	aliceToken               string
+	bobLogin                 ExpectedCreateUserResponseBody
+	bobToken                 string
+	invoiceUpdateSubCancelFn context.CancelFunc
+}
+
+func (suite *InternalTransferTestSuite) SetupSuite() {
+	mockLND := newDefaultMockLND()
+	suite.mockLND = mockLND
+	svc, err := LndHubTestServiceInit(mockLND)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	users, userTokens, err := createUsers(svc, 2)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	// Subscribe to LND invoice updates in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.invoiceUpdateSubCancelFn = cancel
+	go svc.InvoiceUpdateSubscription(ctx)
+	suite.service = svc
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	suite.aliceLogin = users[0]
+	suite.aliceToken = userTokens[0]
+	suite.bobLogin = users[1]
+	suite.bobToken = userTokens[1]
+	suite.echo.POST("/v2/payments/internal", v2controllers.NewTransferController(svc).InternalTransfer, tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+}
+
+func (suite *InternalTransferTestSuite) TearDownSuite() {
+	suite.invoiceUpdateSubCancelFn()
+}
+
+func (suite *InternalTransferTestSuite) TearDownTest() {
+	clearTable(suite.service, "transaction_entries")
+	clearTable(suite.service, "invoices")
+	suite.service.Config.LightningAddressDomain = ""
+	suite.service.Config.MaxAccountBalance = -1
+}
+
+func (suite *InternalTransferTestSuite) TestInternalTransfer() {
+	ctx := context.Background()
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	bobId := getUserIdFromToken(suite.bobToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+
+	rec := suite.transferReq(&v2controllers.InternalTransferRequestBody{
+		Amount:    300,
+		Recipient: suite.bobLogin.Login,
+		Memo:      "lunch",
+		Metadata:  map[string]string{"order": "42"},
+	}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	response := &v2controllers.InternalTransferResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(response))
+	assert.Equal(suite.T(), int64(300), response.Amount)
+	assert.NotEmpty(suite.T(), response.PaymentPreimage)
+
+	aliceBalance, err := suite.service.CurrentUserBalance(ctx, aliceId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(700), aliceBalance)
+	bobBalance, err := suite.service.CurrentUserBalance(ctx, bobId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(300), bobBalance)
+
+	// both users have the transfer in their history
+	outgoing, err := suite.service.FindInvoiceByPaymentHash(ctx, aliceId, response.PaymentHash)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), common.InvoiceTypeOutgoing, outgoing.Type)
+	assert.Equal(suite.T(), common.InvoiceStateSettled, outgoing.State)
+	incoming, err := suite.service.FindInvoiceByPaymentHash(ctx, bobId, response.PaymentHash)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), common.InvoiceTypeIncoming, incoming.Type)
+	assert.Equal(suite.T(), common.InvoiceStateSettled, incoming.State)
+	assert.Equal(suite.T(), "lunch", incoming.Memo)
+	assert.Equal(suite.T(), "42", incoming.Metadata["order"])
+}
+
+func (suite *InternalTransferTestSuite) TestInternalTransferToLightningAddress() {
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 100))
+	address := fmt.Sprintf("%s@example.com", suite.bobLogin.Login)
+
+	// lightning addresses are only accepted for the configured domain
+	rec := suite.transferReq(&v2controllers.InternalTransferRequestBody{Amount: 10, Recipient: address}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	suite.service.Config.LightningAddressDomain = "example.com"
+	rec = suite.transferReq(&v2controllers.InternalTransferRequestBody{Amount: 10, Recipient: address}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+}
+
+func (suite *InternalTransferTestSuite) TestInternalTransferFailures() {
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 100))
+
+	rec := suite.transferReq(&v2controllers.InternalTransferRequestBody{Amount: 10, Recipient: suite.aliceLogin.Login}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	rec = suite.transferReq(&v2controllers.InternalTransferRequestBody{Amount: 10, Recipient: "unknown"}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	// not enough balance
+	rec = suite.transferReq(&v2controllers.InternalTransferRequestBody{Amount: 1000, Recipient: suite.bobLogin.Login}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	resp := &responses.ErrorResponse{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(resp))
+	assert.Equal(suite.T(), responses.NotEnoughBalanceError.Message, resp.Message)
+
+	// the recipient's balance limit is respected
+	suite.service.Config.MaxAccountBalance = 50
+	rec = suite.transferReq(&v2controllers.InternalTransferRequestBody{Amount: 60, Recipient: suite.bobLogin.Login}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	resp = &responses.ErrorResponse{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(resp))
+	assert.Equal(suite.T(), responses.BalanceExceededError.Message, resp.Message)
+}
+
+func (suite *InternalTransferTestSuite) transferReq(body *v2controllers.InternalTransferRequestBody, token string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	req := httptest.NewRequest(http.MethodPost, "/v2/payments/internal", &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestInternalTransferSuite(t *testing.T) {
+	suite.Run(t, new(InternalTransferTestSuite))
+}
@@ -52,6 +52,7 @@ func (suite *InvoiceTestSuite) SetupSuite() {
 	suite.aliceToken = userTokens[0]
 	suite.echo.POST("/invoice/:user_login", controllers.NewInvoiceController(svc).Invoice)
 	suite.echo.POST("/v2/invoices", v2controllers.NewInvoiceController(svc).AddInvoice, tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+	suite.echo.DELETE("/v2/invoices/:payment_hash", v2controllers.NewInvoiceController(svc).CancelInvoice, tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
 }
 
 func (suite *InvoiceTestSuite) TearDownTest() {
@@ -123,6 +124,32 @@ func (suite *InvoiceTestSuite) TestPreimageEntropy() {
 	assert.Equal(suite.T(), 256, len(preimageChars))
 }
 
+func (suite *InvoiceTestSuite) TestCancelInvoice() {
+	user, _ := suite.service.FindUserByLogin(context.Background(), suite.aliceLogin.Login)
+	inv, errResp := suite.service.AddIncomingInvoice(context.Background(), user.ID, 10, "test cancel", "")
+	assert.Nil(suite.T(), errResp)
+
+	cancelReq := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, "/v2/invoices/"+inv.RHash, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.aliceToken))
+		suite.echo.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := cancelReq()
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	invoice := &v2controllers.Invoice{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(invoice))
+	assert.Equal(suite.T(), common.InvoiceStateCanceled, invoice.Status)
+
+	//a canceled invoice can't be canceled again
+	rec = cancelReq()
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	resp := &responses.ErrorResponse{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(resp))
+	assert.Equal(suite.T(), service.ErrInvoiceNotCancelable.Error(), resp.Message)
+}
+
 func TestInvoiceSuite(t *testing.T) {
 	suite.Run(t, new(InvoiceTestSuite))
 }
@@ -0,0 +1,195 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type LedgerAdjustmentTestSuite struct {
+	TestSuite
+	service                  *service.LndhubService
+	mockLND                  *MockLND
+	aliceToken               string
+	bobLogin                 ExpectedCreateUserResponseBody
+	invoiceUpdateSubCancelFn context.CancelFunc
+}
+
+func (suite *LedgerAdjustmentTestSuite) SetupSuite() {
+	mockLND := newDefaultMockLND()
+	suite.mockLND = mockLND
+	svc, err := LndHubTestServiceInit(mockLND)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	users, userTokens, err := createUsers(svc, 2)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	// Subscribe to LND invoice updates in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.invoiceUpdateSubCancelFn = cancel
+	go svc.InvoiceUpdateSubscription(ctx)
+	suite.service = svc
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	suite.aliceToken = userTokens[0]
+	suite.bobLogin = users[1]
+	adjustmentCtrl := v2controllers.NewAdjustmentController(svc)
+	suite.echo.GET("/v2/admin/adjustments", adjustmentCtrl.GetAdjustments)
+	suite.echo.POST("/v2/admin/adjustments", adjustmentCtrl.AdjustBalance)
+	suite.echo.POST("/v2/admin/refunds", adjustmentCtrl.RefundInvoice)
+}
+
+func (suite *LedgerAdjustmentTestSuite) TearDownSuite() {
+	suite.invoiceUpdateSubCancelFn()
+}
+
+func (suite *LedgerAdjustmentTestSuite) TearDownTest() {
+	clearTable(suite.service, "transaction_entries")
+	clearTable(suite.service, "invoices")
+}
+
+func (suite *LedgerAdjustmentTestSuite) TestAdjustments() {
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+
+	rec := suite.adminReq(http.MethodPost, "/v2/admin/adjustments", &v2controllers.AdjustmentRequestBody{
+		UserID:    aliceId,
+		Type:      models.LedgerAdjustmentTypeCredit,
+		Amount:    500,
+		Reason:    "goodwill",
+		Reference: "ticket-1",
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	credit := &models.LedgerAdjustment{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(credit))
+	assert.NotZero(suite.T(), credit.InvoiceID)
+	assert.NotZero(suite.T(), credit.TransactionEntryID)
+
+	rec = suite.adminReq(http.MethodPost, "/v2/admin/adjustments", &v2controllers.AdjustmentRequestBody{
+		UserID:    aliceId,
+		Type:      models.LedgerAdjustmentTypeDebit,
+		Amount:    200,
+		Reason:    "duplicate credit",
+		Reference: "ticket-2",
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	suite.assertBalance(aliceId, 1300)
+
+	// a debit can't take more than the balance
+	rec = suite.adminReq(http.MethodPost, "/v2/admin/adjustments", &v2controllers.AdjustmentRequestBody{
+		UserID:    aliceId,
+		Type:      models.LedgerAdjustmentTypeDebit,
+		Amount:    1301,
+		Reason:    "too much",
+		Reference: "ticket-3",
+	})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	_, err := suite.service.AdjustBalance(context.Background(), service.LedgerAdjustment{
+		UserID:    aliceId,
+		Type:      models.LedgerAdjustmentTypeDebit,
+		Amount:    1301,
+		Reason:    "too much",
+		Reference: "ticket-3",
+	})
+	assert.Error(suite.T(), err)
+	suite.assertBalance(aliceId, 1300)
+
+	// the reason and the reference are mandatory
+	rec = suite.adminReq(http.MethodPost, "/v2/admin/adjustments", &v2controllers.AdjustmentRequestBody{
+		UserID: aliceId,
+		Type:   models.LedgerAdjustmentTypeCredit,
+		Amount: 100,
+	})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+}
+
+func (suite *LedgerAdjustmentTestSuite) TestRefund() {
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+	bob, err := suite.service.FindTransferRecipient(context.Background(), suite.bobLogin.Login)
+	assert.NoError(suite.T(), err)
+	outgoing, err := suite.service.InternalTransfer(context.Background(), aliceId, bob, 300, "", nil, nil)
+	assert.NoError(suite.T(), err)
+	suite.assertBalance(aliceId, 1000-300-outgoing.Fee)
+
+	rec := suite.adminReq(http.MethodPost, "/v2/admin/refunds", &v2controllers.RefundRequestBody{
+		UserID:      aliceId,
+		PaymentHash: outgoing.RHash,
+		Reason:      "payment not delivered",
+		Reference:   "ticket-4",
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	refund := &models.LedgerAdjustment{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(refund))
+	assert.Equal(suite.T(), 300+outgoing.Fee, refund.Amount)
+	assert.Equal(suite.T(), outgoing.ID, refund.RefundedInvoiceID)
+	suite.assertBalance(aliceId, 1000)
+
+	// an invoice can only be refunded once
+	rec = suite.adminReq(http.MethodPost, "/v2/admin/refunds", &v2controllers.RefundRequestBody{
+		UserID:      aliceId,
+		PaymentHash: outgoing.RHash,
+		Reason:      "payment not delivered",
+		Reference:   "ticket-4",
+	})
+	assert.Equal(suite.T(), http.StatusConflict, rec.Code)
+
+	// incoming invoices can't be refunded
+	rec = suite.adminReq(http.MethodPost, "/v2/admin/refunds", &v2controllers.RefundRequestBody{
+		UserID:      bob.ID,
+		PaymentHash: outgoing.RHash,
+		Reason:      "payment not delivered",
+		Reference:   "ticket-5",
+	})
+	assert.Equal(suite.T(), http.StatusNotFound, rec.Code)
+
+	rec = suite.adminReq(http.MethodGet, fmt.Sprintf("/v2/admin/adjustments?user_id=%d", aliceId), nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	adjustments := []models.LedgerAdjustment{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&adjustments))
+	assert.NotEmpty(suite.T(), adjustments)
+	assert.Equal(suite.T(), models.LedgerAdjustmentTypeRefund, adjustments[0].Type)
+	assert.Equal(suite.T(), "ticket-4", adjustments[0].Reference)
+}
+
+func (suite *LedgerAdjustmentTestSuite) assertBalance(userId, expected int64) {
+	balance, err := suite.service.CurrentUserBalance(context.Background(), userId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), expected, balance)
+}
+
+func (suite *LedgerAdjustmentTestSuite) adminReq(method, path string, body interface{}) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	if body != nil {
+		assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLedgerAdjustmentSuite(t *testing.T) {
+	suite.Run(t, new(LedgerAdjustmentTestSuite))
+}
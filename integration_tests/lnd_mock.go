@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"log"
 	"math/big"
 	"time"
@@ -15,10 +16,12 @@ import (
 	"github.com/getAlby/lndhub.go/lnd"
 	"github.com/labstack/gommon/random"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/zpay32"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
 )
 
 type MockLND struct {
@@ -28,6 +31,7 @@ type MockLND struct {
 	pubKey          *btcec.PublicKey
 	addIndexCounter uint64
 	GetInfoError    error
+	holdInvoices    map[string]*lnrpc.Invoice
 }
 
 func NewMockLND(privkey string, fee int64, invoiceChan chan (*lnrpc.Invoice)) (*MockLND, error) {
@@ -89,8 +93,21 @@ func (mlnd *MockLND) SendPaymentSync(ctx context.Context, req *lnrpc.SendRequest
 func (mlnd *MockLND) AddInvoice(ctx context.Context, req *lnrpc.Invoice, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
 	pHash := sha256.New()
 	pHash.Write(req.RPreimage)
-	pHash.Sum(nil)
-	msat := lnwire.MilliSatoshi(1000 * req.Value)
+	rHash := pHash.Sum(nil)
+	pr, err := mlnd.encodeInvoice(rHash, req.Value, req.Expiry, req.Memo, req.DescriptionHash, req.PaymentAddr)
+	if err != nil {
+		return nil, err
+	}
+	mlnd.addIndexCounter += 1
+	return &lnrpc.AddInvoiceResponse{
+		RHash:          rHash,
+		PaymentRequest: pr,
+		AddIndex:       mlnd.addIndexCounter,
+	}, nil
+}
+
+func (mlnd *MockLND) encodeInvoice(rHash []byte, value, expiry int64, memo string, descriptionHash, paymentAddr []byte) (string, error) {
+	msat := lnwire.MilliSatoshi(1000 * value)
 	invoice := &zpay32.Invoice{
 		Net:         &chaincfg.RegressionNetParams,
 		MilliSat:    &msat,
@@ -102,30 +119,87 @@ func (mlnd *MockLND) AddInvoice(ctx context.Context, req *lnrpc.Invoice, options
 		},
 		FallbackAddr: nil,
 	}
-	zpay32.Expiry(time.Duration(req.Expiry))(invoice)
-	copy(invoice.PaymentHash[:], pHash.Sum(nil))
-	copy(invoice.PaymentAddr[:], req.PaymentAddr)
-	if len(req.DescriptionHash) != 0 {
+	zpay32.Expiry(time.Duration(expiry))(invoice)
+	copy(invoice.PaymentHash[:], rHash)
+	copy(invoice.PaymentAddr[:], paymentAddr)
+	if len(descriptionHash) != 0 {
 		invoice.DescriptionHash = &[32]byte{}
-		copy(req.DescriptionHash, invoice.DescriptionHash[:])
+		copy(descriptionHash, invoice.DescriptionHash[:])
 	}
-	if req.Memo != "" {
-		invoice.Description = &req.Memo
+	if memo != "" {
+		invoice.Description = &memo
 	}
-	pr, err := invoice.Encode(zpay32.MessageSigner{
+	return invoice.Encode(zpay32.MessageSigner{
 		SignCompact: mlnd.signMsg,
 	})
+}
+
+func (mlnd *MockLND) AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error) {
+	pr, err := mlnd.encodeInvoice(req.Hash, req.Value, req.Expiry, req.Memo, req.DescriptionHash, nil)
 	if err != nil {
 		return nil, err
 	}
 	mlnd.addIndexCounter += 1
-	return &lnrpc.AddInvoiceResponse{
-		RHash:          invoice.PaymentHash[:],
+	if mlnd.holdInvoices == nil {
+		mlnd.holdInvoices = map[string]*lnrpc.Invoice{}
+	}
+	mlnd.holdInvoices[hex.EncodeToString(req.Hash)] = &lnrpc.Invoice{
+		Memo:           req.Memo,
+		RHash:          req.Hash,
+		Value:          req.Value,
+		ValueMsat:      1000 * req.Value,
+		CreationDate:   time.Now().Unix(),
+		PaymentRequest: pr,
+		AddIndex:       mlnd.addIndexCounter,
+		State:          lnrpc.Invoice_OPEN,
+	}
+	return &invoicesrpc.AddHoldInvoiceResp{
 		PaymentRequest: pr,
 		AddIndex:       mlnd.addIndexCounter,
 	}, nil
 }
 
+// mockAcceptHoldInvoice simulates an incoming payment that is held until the invoice is settled or canceled
+func (mlnd *MockLND) mockAcceptHoldInvoice(rHash string) error {
+	invoice, ok := mlnd.holdInvoices[rHash]
+	if !ok {
+		return fmt.Errorf("hold invoice %s not found", rHash)
+	}
+	invoice.State = lnrpc.Invoice_ACCEPTED
+	invoice.AmtPaidSat = invoice.Value
+	invoice.AmtPaidMsat = invoice.ValueMsat
+	mlnd.Sub.invoiceChan <- proto.Clone(invoice).(*lnrpc.Invoice)
+	return nil
+}
+
+func (mlnd *MockLND) SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error) {
+	rHash := sha256.Sum256(req.Preimage)
+	invoice, ok := mlnd.holdInvoices[hex.EncodeToString(rHash[:])]
+	if !ok || invoice.State != lnrpc.Invoice_ACCEPTED {
+		return nil, fmt.Errorf("unable to locate accepted invoice")
+	}
+	invoice.State = lnrpc.Invoice_SETTLED
+	invoice.Settled = true
+	invoice.SettleDate = time.Now().Unix()
+	invoice.RPreimage = req.Preimage
+	update := proto.Clone(invoice).(*lnrpc.Invoice)
+	go func() { mlnd.Sub.invoiceChan <- update }()
+	return &invoicesrpc.SettleInvoiceResp{}, nil
+}
+
+func (mlnd *MockLND) CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error) {
+	invoice, ok := mlnd.holdInvoices[hex.EncodeToString(req.PaymentHash)]
+	if ok {
+		if invoice.State == lnrpc.Invoice_SETTLED {
+			return nil, fmt.Errorf("invoice already settled")
+		}
+		invoice.State = lnrpc.Invoice_CANCELED
+		update := proto.Clone(invoice).(*lnrpc.Invoice)
+		go func() { mlnd.Sub.invoiceChan <- update }()
+	}
+	return &invoicesrpc.CancelInvoiceResp{}, nil
+}
+
 func (mlnd *MockLND) mockPaidInvoice(added *ExpectedAddInvoiceResponseBody, amtPaid int64, keysend bool, htlc *lnrpc.InvoiceHTLC) error {
 	var incoming *lnrpc.Invoice
 	if !keysend {
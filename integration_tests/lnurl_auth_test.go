@@ -0,0 +1,197 @@
+package integration_tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type LnurlAuthTestSuite struct {
+	TestSuite
+	service    *service.LndhubService
+	userTokens []string
+}
+
+func (suite *LnurlAuthTestSuite) SetupSuite() {
+	svc, err := LndHubTestServiceInit(newDefaultMockLND())
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	svc.Config.LnurlAuthEnabled = true
+	svc.Config.AllowAccountCreation = true
+	_, userTokens, err := createUsers(svc, 1)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	suite.service = svc
+	suite.userTokens = userTokens
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	lnurlAuthCtrl := v2controllers.NewLnurlAuthController(svc)
+	e.POST("/v2/lnurlauth", lnurlAuthCtrl.CreateLnurlAuth)
+	e.GET("/v2/lnurlauth/callback", lnurlAuthCtrl.LnurlAuthCallback)
+	e.GET("/v2/lnurlauth/status", lnurlAuthCtrl.LnurlAuthStatus)
+	secured := e.Group("", tokens.Middleware([]byte(svc.Config.JWTSecret)), svc.ValidateUserMiddleware())
+	secured.POST("/v2/lnurlauth/link", lnurlAuthCtrl.LinkLnurlAuth)
+	secured.GET("/v2/lnurlauth/keys", lnurlAuthCtrl.GetLnurlAuthKeys)
+	secured.DELETE("/v2/lnurlauth/keys/:key", lnurlAuthCtrl.UnlinkLnurlAuthKey)
+}
+
+func (suite *LnurlAuthTestSuite) TestLoginCreatesAccount() {
+	wallet, err := btcec.NewPrivateKey()
+	assert.NoError(suite.T(), err)
+
+	challenge := suite.challenge("/v2/lnurlauth", "")
+	status := suite.status(challenge, http.StatusOK)
+	assert.Equal(suite.T(), "pending", status.Status)
+
+	// a signature of another key is rejected
+	other, err := btcec.NewPrivateKey()
+	assert.NoError(suite.T(), err)
+	rec := suite.callback(challenge.K1, wallet, other)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	rec = suite.callback(challenge.K1, wallet, wallet)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	callback := &v2controllers.LnurlAuthCallbackResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(callback))
+	assert.Equal(suite.T(), "OK", callback.Status)
+	// every k1 can only be used once
+	rec = suite.callback(challenge.K1, wallet, wallet)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	suite.status(&v2controllers.LnurlAuthChallengeResponseBody{K1: challenge.K1, Secret: "wrong"}, http.StatusNotFound)
+	status = suite.status(challenge, http.StatusOK)
+	assert.Equal(suite.T(), "ok", status.Status)
+	assert.NotEmpty(suite.T(), status.AccessToken)
+	assert.NotEmpty(suite.T(), status.RefreshToken)
+	userId := getUserIdFromToken(status.AccessToken)
+	// the tokens are only returned once
+	suite.status(challenge, http.StatusNotFound)
+
+	// the next login with the same wallet is the same user
+	challenge = suite.challenge("/v2/lnurlauth", "")
+	rec = suite.callback(challenge.K1, wallet, wallet)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	status = suite.status(challenge, http.StatusOK)
+	assert.Equal(suite.T(), userId, getUserIdFromToken(status.AccessToken))
+
+	// without account creation unknown wallets can't log in
+	suite.service.Config.AllowAccountCreation = false
+	defer func() { suite.service.Config.AllowAccountCreation = true }()
+	unknown, err := btcec.NewPrivateKey()
+	assert.NoError(suite.T(), err)
+	challenge = suite.challenge("/v2/lnurlauth", "")
+	rec = suite.callback(challenge.K1, unknown, unknown)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(callback))
+	assert.Equal(suite.T(), service.ErrLnurlAuthKeyNotLinked.Error(), callback.Reason)
+}
+
+func (suite *LnurlAuthTestSuite) TestLinkAndUnlink() {
+	token := suite.userTokens[0]
+	wallet, err := btcec.NewPrivateKey()
+	assert.NoError(suite.T(), err)
+	key := hex.EncodeToString(wallet.PubKey().SerializeCompressed())
+
+	challenge := suite.challenge("/v2/lnurlauth/link", token)
+	rec := suite.callback(challenge.K1, wallet, wallet)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	status := suite.status(challenge, http.StatusOK)
+	assert.Equal(suite.T(), "ok", status.Status)
+	assert.Empty(suite.T(), status.AccessToken)
+
+	keys := []models.LnurlAuthKey{}
+	rec = suite.request(http.MethodGet, "/v2/lnurlauth/keys", token)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&keys))
+	assert.Len(suite.T(), keys, 1)
+	assert.Equal(suite.T(), key, keys[0].LinkingKey)
+
+	// the wallet logs in as the user now
+	challenge = suite.challenge("/v2/lnurlauth", "")
+	rec = suite.callback(challenge.K1, wallet, wallet)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	status = suite.status(challenge, http.StatusOK)
+	assert.Equal(suite.T(), getUserIdFromToken(token), getUserIdFromToken(status.AccessToken))
+
+	// a key can only be linked to one user
+	challenge = suite.challenge("/v2/lnurlauth/link", token)
+	rec = suite.callback(challenge.K1, wallet, wallet)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	rec = suite.request(http.MethodDelete, "/v2/lnurlauth/keys/"+key, token)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec = suite.request(http.MethodDelete, "/v2/lnurlauth/keys/"+key, token)
+	assert.Equal(suite.T(), http.StatusNotFound, rec.Code)
+}
+
+func (suite *LnurlAuthTestSuite) challenge(path, token string) *v2controllers.LnurlAuthChallengeResponseBody {
+	rec := suite.request(http.MethodPost, path, token)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	challenge := &v2controllers.LnurlAuthChallengeResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(challenge))
+	assert.Len(suite.T(), challenge.K1, 64)
+	return challenge
+}
+
+// callback signs k1 with signer and calls the callback with the linking key of wallet
+func (suite *LnurlAuthTestSuite) callback(k1 string, wallet, signer *btcec.PrivateKey) *httptest.ResponseRecorder {
+	k1Bytes, err := hex.DecodeString(k1)
+	assert.NoError(suite.T(), err)
+	query := url.Values{}
+	query.Set("tag", "login")
+	query.Set("k1", k1)
+	query.Set("sig", hex.EncodeToString(ecdsa.Sign(signer, k1Bytes).Serialize()))
+	query.Set("key", hex.EncodeToString(wallet.PubKey().SerializeCompressed()))
+	rec := httptest.NewRecorder()
+	suite.echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/lnurlauth/callback?"+query.Encode(), nil))
+	return rec
+}
+
+func (suite *LnurlAuthTestSuite) status(challenge *v2controllers.LnurlAuthChallengeResponseBody, expectedCode int) *v2controllers.LnurlAuthStatusResponseBody {
+	rec := httptest.NewRecorder()
+	suite.echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v2/lnurlauth/status?k1=%s&secret=%s", challenge.K1, challenge.Secret), nil))
+	assert.Equal(suite.T(), expectedCode, rec.Code)
+	status := &v2controllers.LnurlAuthStatusResponseBody{}
+	if rec.Code == http.StatusOK {
+		assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(status))
+	}
+	return status
+}
+
+func (suite *LnurlAuthTestSuite) request(method, path, token string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, &bytes.Buffer{})
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLnurlAuthSuite(t *testing.T) {
+	suite.Run(t, new(LnurlAuthTestSuite))
+}
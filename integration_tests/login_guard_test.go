@@ -0,0 +1,148 @@
+package integration_tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/controllers"
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type LoginGuardTestSuite struct {
+	TestSuite
+	service    *service.LndhubService
+	userLogins []ExpectedCreateUserResponseBody
+}
+
+func (suite *LoginGuardTestSuite) SetupSuite() {
+	svc, err := LndHubTestServiceInit(newDefaultMockLND())
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	svc.Config.AuthLockoutStore = "postgres"
+	svc.Config.AuthMaxFailedLogins = 3
+	svc.Config.AuthMaxFailedLoginsPerIP = 5
+	svc.Config.AuthLockoutDuration = 60
+	svc.Config.AuthMaxLockoutDuration = 3600
+	svc.Config.AuthFailedLoginsReset = 86400
+	svc.Config.AuthCaptchaAfter = 2
+	svc.LoginGuard, err = service.InitLoginGuard(svc.Config, svc.DB)
+	if err != nil {
+		log.Fatalf("Error initializing login guard: %v", err)
+	}
+	users, _, err := createUsers(svc, 3)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	suite.service = svc
+	suite.userLogins = users
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	e.IPExtractor = echo.ExtractIPDirect()
+	suite.echo = e
+	e.POST("/auth", controllers.NewAuthController(svc).Auth)
+	e.POST("/v2/admin/logins/unlock", v2controllers.NewAdminController(svc).UnlockLogin)
+}
+
+func (suite *LoginGuardTestSuite) TearDownTest() {
+	clearTable(suite.service, "login_failures")
+}
+
+func (suite *LoginGuardTestSuite) TestLoginLockout() {
+	user := suite.userLogins[0]
+	rec, body := suite.auth(user.Login, "wrong password", "10.0.1.1")
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	assert.False(suite.T(), body.CaptchaRequired)
+	rec, body = suite.auth(user.Login, "wrong password", "10.0.1.2")
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	assert.True(suite.T(), body.CaptchaRequired)
+	rec, body = suite.auth(user.Login, "wrong password", "10.0.1.3")
+	assert.Equal(suite.T(), http.StatusTooManyRequests, rec.Code)
+	assert.Equal(suite.T(), responses.LoginLockedError.Message, body.Message)
+	assert.Equal(suite.T(), "60", rec.Header().Get("Retry-After"))
+
+	// the login is locked from every IP, even with the right password
+	rec, _ = suite.auth(user.Login, user.Password, "10.0.1.4")
+	assert.Equal(suite.T(), http.StatusTooManyRequests, rec.Code)
+
+	rec = suite.unlock(&v2controllers.UnlockLoginRequestBody{Login: user.Login})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec, _ = suite.auth(user.Login, user.Password, "10.0.1.4")
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+}
+
+func (suite *LoginGuardTestSuite) TestIPLockout() {
+	// an IP guessing the passwords of many logins is locked for all of them
+	for i := 0; i < 5; i++ {
+		login := suite.userLogins[1+i%2].Login
+		rec, _ := suite.auth(login, "wrong password", "10.0.2.1")
+		if i < 4 {
+			assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+		} else {
+			assert.Equal(suite.T(), http.StatusTooManyRequests, rec.Code)
+		}
+	}
+	rec, _ := suite.auth(suite.userLogins[0].Login, suite.userLogins[0].Password, "10.0.2.1")
+	assert.Equal(suite.T(), http.StatusTooManyRequests, rec.Code)
+	// every request sends the same spoofed headers, they must not put other IPs into the lockout
+	rec, _ = suite.auth(suite.userLogins[0].Login, suite.userLogins[0].Password, "10.0.2.2")
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	rec = suite.unlock(&v2controllers.UnlockLoginRequestBody{IP: "10.0.2.1"})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec, _ = suite.auth(suite.userLogins[0].Login, suite.userLogins[0].Password, "10.0.2.1")
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	rec = suite.unlock(&v2controllers.UnlockLoginRequestBody{})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+}
+
+// spoofedIP is sent in the client IP headers of every request, the IP of the connection has to be used
+const spoofedIP = "203.0.113.1"
+
+func (suite *LoginGuardTestSuite) auth(login, password, ip string) (*httptest.ResponseRecorder, *controllers.AuthErrorResponseBody) {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(&ExpectedAuthRequestBody{
+		Login:    login,
+		Password: password,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/auth", &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.RemoteAddr = ip + ":1234"
+	req.Header.Set(echo.HeaderXForwardedFor, spoofedIP)
+	req.Header.Set(echo.HeaderXRealIP, spoofedIP)
+	suite.echo.ServeHTTP(rec, req)
+	body := &controllers.AuthErrorResponseBody{}
+	if rec.Code != http.StatusOK {
+		assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(body))
+	}
+	return rec, body
+}
+
+func (suite *LoginGuardTestSuite) unlock(body *v2controllers.UnlockLoginRequestBody) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	req := httptest.NewRequest(http.MethodPost, "/v2/admin/logins/unlock", &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLoginGuardSuite(t *testing.T) {
+	suite.Run(t, new(LoginGuardTestSuite))
+}
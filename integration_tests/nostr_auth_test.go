@@ -0,0 +1,161 @@
+package integration_tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type NostrAuthTestSuite struct {
+	TestSuite
+	service    *service.LndhubService
+	userTokens []string
+}
+
+func (suite *NostrAuthTestSuite) SetupSuite() {
+	svc, err := LndHubTestServiceInit(newDefaultMockLND())
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	svc.Config.NostrAuthEnabled = true
+	svc.Config.PublicUrl = "https://lndhub.example.com"
+	_, userTokens, err := createUsers(svc, 2)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	suite.service = svc
+	suite.userTokens = userTokens
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	authMiddleware := svc.NostrAuth().Middleware(svc.NostrAuthUser, tokens.Middleware([]byte(svc.Config.JWTSecret)))
+	secured := e.Group("", authMiddleware, svc.ValidateUserMiddleware())
+	secured.GET("/v2/balance", v2controllers.NewBalanceController(svc).Balance)
+	nostrAuthCtrl := v2controllers.NewNostrAuthController(svc)
+	secured.POST("/v2/nostr/pubkeys", nostrAuthCtrl.LinkNostrPubkey)
+	secured.GET("/v2/nostr/pubkeys", nostrAuthCtrl.GetNostrPubkeys)
+	secured.DELETE("/v2/nostr/pubkeys/:pubkey", nostrAuthCtrl.UnlinkNostrPubkey)
+}
+
+func (suite *NostrAuthTestSuite) TestNostrAuth() {
+	token := suite.userTokens[0]
+	key, err := btcec.NewPrivateKey()
+	assert.NoError(suite.T(), err)
+	pubkey := hex.EncodeToString(schnorr.SerializePubKey(key.PubKey()))
+
+	// keys that are not linked can't authenticate
+	rec := suite.nostrRequest(http.MethodGet, "/v2/balance", key, "")
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+
+	// the event must be signed for the link request
+	rec = suite.link(token, suite.nostrAuthHeader(key, http.MethodPost, suite.url("/v2/balance")))
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	rec = suite.link(token, suite.nostrAuthHeader(key, http.MethodPost, suite.url("/v2/nostr/pubkeys")))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	keys := []models.NostrPubkey{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&keys))
+	assert.Len(suite.T(), keys, 1)
+	assert.Equal(suite.T(), pubkey, keys[0].Pubkey)
+	// a key can only be linked to one user
+	rec = suite.link(suite.userTokens[1], suite.nostrAuthHeader(key, http.MethodPost, suite.url("/v2/nostr/pubkeys")))
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	rec = suite.nostrRequest(http.MethodGet, "/v2/balance", key, "")
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	// an event can't be used twice
+	header := suite.nostrAuthHeader(key, http.MethodGet, suite.url("/v2/balance"))
+	for _, status := range []int{http.StatusOK, http.StatusUnauthorized} {
+		rec = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/v2/balance", &bytes.Buffer{})
+		req.Header.Set(echo.HeaderAuthorization, header)
+		suite.echo.ServeHTTP(rec, req)
+		assert.Equal(suite.T(), status, rec.Code)
+	}
+	// events of other requests are rejected
+	rec = suite.nostrRequest(http.MethodGet, "/v2/balance", key, "/v2/nostr/pubkeys")
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	// events signed for other services are rejected, whatever the Host header of the request is
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "https://evil.example/v2/balance", &bytes.Buffer{})
+	req.Header.Set(echo.HeaderAuthorization, suite.nostrAuthHeader(key, http.MethodGet, "https://evil.example/v2/balance"))
+	suite.echo.ServeHTTP(rec, req)
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+
+	rec = suite.nostrRequest(http.MethodDelete, "/v2/nostr/pubkeys/"+pubkey, key, "")
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec = suite.nostrRequest(http.MethodGet, "/v2/balance", key, "")
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+}
+
+// nostrAuthHeader returns a NIP-98 Authorization header of a request without body to the url
+func (suite *NostrAuthTestSuite) nostrAuthHeader(key *btcec.PrivateKey, method, url string) string {
+	event := &tokens.NostrEvent{
+		Pubkey:    hex.EncodeToString(schnorr.SerializePubKey(key.PubKey())),
+		CreatedAt: time.Now().Unix(),
+		Kind:      tokens.NostrAuthKind,
+		Tags:      [][]string{{"u", url}, {"method", method}},
+		// events can only be used once, the content makes the events of identical requests within a second different
+		Content: strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+	id, err := event.Hash()
+	assert.NoError(suite.T(), err)
+	event.ID = hex.EncodeToString(id)
+	sig, err := schnorr.Sign(key, id)
+	assert.NoError(suite.T(), err)
+	event.Sig = hex.EncodeToString(sig.Serialize())
+	raw, err := json.Marshal(event)
+	assert.NoError(suite.T(), err)
+	return tokens.NostrAuthScheme + " " + base64.StdEncoding.EncodeToString(raw)
+}
+
+// nostrRequest sends a request authenticated with an event for signedPath, or for the path itself if it is empty
+func (suite *NostrAuthTestSuite) nostrRequest(method, path string, key *btcec.PrivateKey, signedPath string) *httptest.ResponseRecorder {
+	if signedPath == "" {
+		signedPath = path
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, &bytes.Buffer{})
+	req.Header.Set(echo.HeaderAuthorization, suite.nostrAuthHeader(key, method, suite.url(signedPath)))
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func (suite *NostrAuthTestSuite) url(path string) string {
+	return suite.service.Config.PublicUrl + path
+}
+
+func (suite *NostrAuthTestSuite) link(token, nostrAuthHeader string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v2/nostr/pubkeys", &bytes.Buffer{})
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	req.Header.Set(v2controllers.NostrLinkHeader, nostrAuthHeader)
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNostrAuthSuite(t *testing.T) {
+	suite.Run(t, new(NostrAuthTestSuite))
+}
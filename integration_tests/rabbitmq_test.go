@@ -68,7 +68,7 @@ func (suite *RabbitMQTestSuite) SetupSuite() {
 	suite.echo.POST("/addinvoice", controllers.NewAddInvoiceController(suite.svc).AddInvoice)
 	suite.echo.POST("/payinvoice", controllers.NewPayInvoiceController(suite.svc).PayInvoice)
 	go func() {
-		err = svc.RabbitMQClient.StartPublishInvoices(ctx, svc.SubscribeIncomingOutgoingInvoices, svc.EncodeInvoiceWithUserLogin)
+		err = svc.EventsClient.StartPublishInvoices(ctx, svc.SubscribeIncomingOutgoingInvoices, svc.EncodeInvoiceWithUserLogin)
 		assert.NoError(suite.T(), err)
 	}()
 }
@@ -113,7 +113,7 @@ func (suite *RabbitMQTestSuite) TestConsumeAndPublishInvoice() {
 	assert.NoError(suite.T(), err)
 
 	go func() {
-		err = suite.svc.RabbitMQClient.SubscribeToLndInvoices(context.Background(), suite.svc.ProcessInvoiceUpdate)
+		err = suite.svc.EventsClient.SubscribeToLndInvoices(context.Background(), suite.svc.ProcessInvoiceUpdate)
 		assert.NoError(suite.T(), err)
 	}()
 	time.Sleep(100 * time.Millisecond)
@@ -0,0 +1,93 @@
+package integration_tests
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getAlby/lndhub.go/controllers"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/ratelimit"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/getAlby/lndhub.go/lib/transport"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimitTestSuite struct {
+	TestSuite
+	service    *service.LndhubService
+	userTokens []string
+}
+
+func (suite *RateLimitTestSuite) SetupSuite() {
+	svc, err := LndHubTestServiceInit(newDefaultMockLND())
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	svc.Config.RateLimitStore = "postgres"
+	svc.RateLimiter, err = service.InitRateLimiter(svc.Config, svc.DB)
+	if err != nil {
+		log.Fatalf("Error initializing rate limiter: %v", err)
+	}
+	_, userTokens, err := createUsers(svc, 2)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	suite.service = svc
+	suite.userTokens = userTokens
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	apiRateLimitMiddleware := transport.CreateRateLimitMiddleware(svc.RateLimiter, ratelimit.ClassAPI, ratelimit.Limit{Rate: 0.01, Burst: 2})
+	paymentsRateLimitMiddleware := transport.CreateRateLimitMiddleware(svc.RateLimiter, ratelimit.ClassPayments, ratelimit.Limit{Rate: 0.01, Burst: 1})
+	secured := e.Group("", tokens.Middleware([]byte(svc.Config.JWTSecret)), svc.ValidateUserMiddleware())
+	secured.GET("/balance", controllers.NewBalanceController(svc).Balance, apiRateLimitMiddleware)
+	secured.GET("/payments/balance", controllers.NewBalanceController(svc).Balance, paymentsRateLimitMiddleware)
+}
+
+func (suite *RateLimitTestSuite) TearDownTest() {
+	clearTable(suite.service, "rate_limits")
+}
+
+func (suite *RateLimitTestSuite) TestRateLimitPerUserAndClass() {
+	rec := suite.request("/balance", suite.userTokens[0])
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Equal(suite.T(), "2", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(suite.T(), "1", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(suite.T(), "100", rec.Header().Get("RateLimit-Reset"))
+	rec = suite.request("/balance", suite.userTokens[0])
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Equal(suite.T(), "0", rec.Header().Get("RateLimit-Remaining"))
+	rec = suite.request("/balance", suite.userTokens[0])
+	assert.Equal(suite.T(), http.StatusTooManyRequests, rec.Code)
+	assert.Equal(suite.T(), "100", rec.Header().Get("Retry-After"))
+
+	// the limit is per user, not per IP, and every endpoint class has its own bucket
+	rec = suite.request("/balance", suite.userTokens[1])
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec = suite.request("/payments/balance", suite.userTokens[0])
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec = suite.request("/payments/balance", suite.userTokens[0])
+	assert.Equal(suite.T(), http.StatusTooManyRequests, rec.Code)
+}
+
+func (suite *RateLimitTestSuite) request(path, token string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitTestSuite))
+}
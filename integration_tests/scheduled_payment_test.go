@@ -0,0 +1,261 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ScheduledPaymentTestSuite struct {
+	TestSuite
+	service                  *service.LndhubService
+	mockLND                  *MockLND
+	aliceToken               string
+	bobLogin                 ExpectedCreateUserResponseBody
+	bobToken                 string
+	invoiceUpdateSubCancelFn context.CancelFunc
+}
+
+func (suite *ScheduledPaymentTestSuite) SetupSuite() {
+	mockLND := newDefaultMockLND()
+	suite.mockLND = mockLND
+	svc, err := LndHubTestServiceInit(mockLND)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	svc.Config.LightningAddressDomain = "example.com"
+	svc.Config.ScheduledPaymentMaxRetries = 3
+	svc.Config.ScheduledPaymentRetryDelay = 60
+	users, userTokens, err := createUsers(svc, 2)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	// Subscribe to LND invoice updates in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.invoiceUpdateSubCancelFn = cancel
+	go svc.InvoiceUpdateSubscription(ctx)
+	suite.service = svc
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	suite.aliceToken = userTokens[0]
+	suite.bobLogin = users[1]
+	suite.bobToken = userTokens[1]
+	ctrl := v2controllers.NewScheduledPaymentController(svc)
+	suite.echo.Use(tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+	suite.echo.POST("/v2/payments/scheduled", ctrl.CreateScheduledPayment)
+	suite.echo.GET("/v2/payments/scheduled/:id/runs", ctrl.GetScheduledPaymentRuns)
+	suite.echo.POST("/v2/payments/scheduled/:id/pause", ctrl.PauseScheduledPayment)
+	suite.echo.POST("/v2/payments/scheduled/:id/resume", ctrl.ResumeScheduledPayment)
+}
+
+func (suite *ScheduledPaymentTestSuite) TearDownSuite() {
+	suite.invoiceUpdateSubCancelFn()
+}
+
+func (suite *ScheduledPaymentTestSuite) TearDownTest() {
+	clearTable(suite.service, "scheduled_payment_runs")
+	clearTable(suite.service, "scheduled_payments")
+	clearTable(suite.service, "transaction_entries")
+	clearTable(suite.service, "invoices")
+}
+
+func (suite *ScheduledPaymentTestSuite) TestScheduledPaymentToLightningAddress() {
+	ctx := context.Background()
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	bobId := getUserIdFromToken(suite.bobToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+
+	rec := suite.scheduledPaymentReq(http.MethodPost, "/v2/payments/scheduled", &v2controllers.CreateScheduledPaymentRequestBody{
+		Type:        service.ScheduledPaymentTypeLnAddress,
+		Destination: fmt.Sprintf("%s@example.com", suite.bobLogin.Login),
+		Amount:      100,
+		Memo:        "weekly payout",
+		Schedule:    "@weekly",
+		Budget:      150,
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	created := &v2controllers.ScheduledPayment{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(created))
+	assert.Equal(suite.T(), service.ScheduledPaymentStateActive, created.State)
+	assert.True(suite.T(), created.NextRunAt.After(time.Now()))
+
+	// nothing is due yet
+	count, err := suite.service.RunDueScheduledPayments(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, count)
+
+	suite.makeDue(created.ID)
+	count, err = suite.service.RunDueScheduledPayments(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, count)
+	aliceBalance, err := suite.service.CurrentUserBalance(ctx, aliceId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(900), aliceBalance)
+	bobBalance, err := suite.service.CurrentUserBalance(ctx, bobId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(100), bobBalance)
+
+	rec = suite.scheduledPaymentReq(http.MethodGet, fmt.Sprintf("/v2/payments/scheduled/%d/runs", created.ID), nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	runs := []v2controllers.ScheduledPaymentRun{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&runs))
+	assert.Len(suite.T(), runs, 1)
+	assert.Equal(suite.T(), service.ScheduledPaymentRunStateSettled, runs[0].State)
+	assert.NotEmpty(suite.T(), runs[0].PaymentHash)
+
+	// another payment would exceed the budget
+	suite.makeDue(created.ID)
+	_, err = suite.service.RunDueScheduledPayments(ctx)
+	assert.NoError(suite.T(), err)
+	payment, err := suite.service.FindScheduledPayment(ctx, aliceId, created.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), service.ScheduledPaymentStateCompleted, payment.State)
+	assert.Equal(suite.T(), int64(100), payment.TotalPaid)
+	aliceBalance, err = suite.service.CurrentUserBalance(ctx, aliceId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(900), aliceBalance)
+}
+
+func (suite *ScheduledPaymentTestSuite) TestScheduledPaymentFailure() {
+	ctx := context.Background()
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	payment := &models.ScheduledPayment{
+		UserID:      aliceId,
+		Type:        service.ScheduledPaymentTypeKeysend,
+		Destination: "123456789012345678901234567890123456789012345678901234567890abcdef",
+		Amount:      100,
+		Schedule:    "@daily",
+	}
+	assert.NoError(suite.T(), suite.service.CreateScheduledPayment(ctx, payment))
+	suite.makeDue(payment.ID)
+
+	// alice has no balance, retrying wouldn't help so the payment waits for the next run
+	count, err := suite.service.RunDueScheduledPayments(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, count)
+	payment, err = suite.service.FindScheduledPayment(ctx, aliceId, payment.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), responses.NotEnoughBalanceError.Message, payment.LastError)
+	assert.Equal(suite.T(), 0, payment.Retries)
+	assert.True(suite.T(), payment.NextRunAt.After(time.Now().Add(23*time.Hour)))
+	runs, err := suite.service.ScheduledPaymentRunsFor(ctx, payment.ID)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), runs, 1)
+	assert.Equal(suite.T(), service.ScheduledPaymentRunStateFailed, runs[0].State)
+}
+
+func (suite *ScheduledPaymentTestSuite) TestScheduledPaymentStoredLimits() {
+	ctx := context.Background()
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+	maxSendAmount := int64(50)
+	payment := &models.ScheduledPayment{
+		UserID:        aliceId,
+		Type:          service.ScheduledPaymentTypeLnAddress,
+		Destination:   fmt.Sprintf("%s@example.com", suite.bobLogin.Login),
+		Amount:        100,
+		Schedule:      "@daily",
+		MaxSendAmount: &maxSendAmount,
+	}
+	assert.NoError(suite.T(), suite.service.CreateScheduledPayment(ctx, payment))
+	suite.makeDue(payment.ID)
+
+	// the limit of the token that created the payment applies to the run
+	count, err := suite.service.RunDueScheduledPayments(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, count)
+	payment, err = suite.service.FindScheduledPayment(ctx, aliceId, payment.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), responses.SendExceededError.Message, payment.LastError)
+	assert.Equal(suite.T(), 0, payment.Retries)
+	aliceBalance, err := suite.service.CurrentUserBalance(ctx, aliceId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(1000), aliceBalance)
+}
+
+func (suite *ScheduledPaymentTestSuite) TestPauseResumeScheduledPayment() {
+	ctx := context.Background()
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	payment := &models.ScheduledPayment{
+		UserID:      aliceId,
+		Type:        service.ScheduledPaymentTypeLnAddress,
+		Destination: fmt.Sprintf("%s@example.com", suite.bobLogin.Login),
+		Amount:      10,
+		Schedule:    "@every 1h",
+	}
+	assert.NoError(suite.T(), suite.service.CreateScheduledPayment(ctx, payment))
+
+	rec := suite.scheduledPaymentReq(http.MethodPost, fmt.Sprintf("/v2/payments/scheduled/%d/pause", payment.ID), nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	suite.makeDue(payment.ID)
+	count, err := suite.service.RunDueScheduledPayments(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, count)
+
+	rec = suite.scheduledPaymentReq(http.MethodPost, fmt.Sprintf("/v2/payments/scheduled/%d/resume", payment.ID), nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	resumed := &v2controllers.ScheduledPayment{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(resumed))
+	assert.Equal(suite.T(), service.ScheduledPaymentStateActive, resumed.State)
+	// the missed run is skipped
+	assert.True(suite.T(), resumed.NextRunAt.After(time.Now()))
+
+	rec = suite.scheduledPaymentReq(http.MethodPost, "/v2/payments/scheduled/0/pause", nil)
+	assert.Equal(suite.T(), http.StatusNotFound, rec.Code)
+}
+
+func (suite *ScheduledPaymentTestSuite) TestCreateScheduledPaymentInvalidSchedule() {
+	rec := suite.scheduledPaymentReq(http.MethodPost, "/v2/payments/scheduled", &v2controllers.CreateScheduledPaymentRequestBody{
+		Type:        service.ScheduledPaymentTypeLnAddress,
+		Destination: fmt.Sprintf("%s@example.com", suite.bobLogin.Login),
+		Amount:      100,
+		Schedule:    "every tuesday",
+	})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+}
+
+func (suite *ScheduledPaymentTestSuite) makeDue(id int64) {
+	_, err := suite.service.DB.NewUpdate().
+		Model((*models.ScheduledPayment)(nil)).
+		Set("next_run_at = ?", time.Now().Add(-time.Minute)).
+		Where("id = ?", id).
+		Exec(context.Background())
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *ScheduledPaymentTestSuite) scheduledPaymentReq(method, path string, body interface{}) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	if body != nil {
+		assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.aliceToken))
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestScheduledPaymentSuite(t *testing.T) {
+	suite.Run(t, new(ScheduledPaymentTestSuite))
+}
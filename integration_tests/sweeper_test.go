@@ -0,0 +1,122 @@
+package integration_tests
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/uptrace/bun"
+)
+
+type SweeperTestSuite struct {
+	TestSuite
+	service                  *service.LndhubService
+	mockLND                  *MockLND
+	userId                   int64
+	invoiceUpdateSubCancelFn context.CancelFunc
+}
+
+func (suite *SweeperTestSuite) SetupSuite() {
+	mockLND := newDefaultMockLND()
+	suite.mockLND = mockLND
+	svc, err := LndHubTestServiceInit(mockLND)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	_, userTokens, err := createUsers(svc, 1)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	// Subscribe to LND invoice updates in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.invoiceUpdateSubCancelFn = cancel
+	go svc.InvoiceUpdateSubscription(ctx)
+	suite.service = svc
+	suite.userId = getUserIdFromToken(userTokens[0])
+}
+
+func (suite *SweeperTestSuite) TearDownSuite() {
+	suite.invoiceUpdateSubCancelFn()
+}
+
+func (suite *SweeperTestSuite) TearDownTest() {
+	clearTable(suite.service, "transaction_entries")
+	clearTable(suite.service, "invoices")
+}
+
+func (suite *SweeperTestSuite) TestExpireOpenInvoices() {
+	ctx := context.Background()
+	expiring, errResp := suite.service.AddIncomingInvoice(ctx, suite.userId, 10, "expiring invoice", "")
+	assert.Nil(suite.T(), errResp)
+	open, errResp := suite.service.AddIncomingInvoice(ctx, suite.userId, 10, "open invoice", "")
+	assert.Nil(suite.T(), errResp)
+	expiring.ExpiresAt = bun.NullTime{Time: time.Now().Add(-time.Minute)}
+	_, err := suite.service.DB.NewUpdate().Model(expiring).Column("expires_at").WherePK().Exec(ctx)
+	assert.NoError(suite.T(), err)
+
+	count, err := suite.service.ExpireOpenInvoices(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, count)
+	invoice, err := suite.service.FindInvoiceByPaymentHash(ctx, suite.userId, expiring.RHash)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), common.InvoiceStateExpired, invoice.State)
+	invoice, err = suite.service.FindInvoiceByPaymentHash(ctx, suite.userId, open.RHash)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), common.InvoiceStateOpen, invoice.State)
+
+	// nothing left to expire
+	count, err = suite.service.ExpireOpenInvoices(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, count)
+}
+
+func (suite *SweeperTestSuite) TestReconcileOrphanedPayments() {
+	ctx := context.Background()
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, suite.userId, 100))
+	// an internal payment that was interrupted after the amount was taken from the balance
+	preimage, paymentHash := newPreimage()
+	invoice := models.Invoice{
+		Type:                 common.InvoiceTypeOutgoing,
+		UserID:               suite.userId,
+		Amount:               100,
+		RHash:                paymentHash,
+		Preimage:             preimage,
+		DestinationPubkeyHex: suite.mockLND.GetMainPubkey(),
+		State:                common.InvoiceStateInitialized,
+		CreatedAt:            time.Now().Add(-time.Hour),
+	}
+	_, err := suite.service.DB.NewInsert().Model(&invoice).Exec(ctx)
+	assert.NoError(suite.T(), err)
+	debitAccount, err := suite.service.AccountFor(ctx, common.AccountTypeCurrent, suite.userId)
+	assert.NoError(suite.T(), err)
+	creditAccount, err := suite.service.AccountFor(ctx, common.AccountTypeOutgoing, suite.userId)
+	assert.NoError(suite.T(), err)
+	feeAccount, err := suite.service.AccountFor(ctx, common.AccountTypeFees, suite.userId)
+	assert.NoError(suite.T(), err)
+	_, err = suite.service.InsertTransactionEntry(ctx, &invoice, creditAccount, debitAccount, feeAccount)
+	assert.NoError(suite.T(), err)
+	balance, err := suite.service.CurrentUserBalance(ctx, suite.userId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(0), balance)
+
+	count, err := suite.service.ReconcileOrphanedPayments(ctx)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, count)
+	reconciled, err := suite.service.FindInvoiceByPaymentHash(ctx, suite.userId, paymentHash)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), common.InvoiceStateError, reconciled.State)
+	assert.Equal(suite.T(), service.ErrPaymentNotInitiated.Error(), reconciled.ErrorMessage)
+	balance, err = suite.service.CurrentUserBalance(ctx, suite.userId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(100), balance)
+}
+
+func TestSweeperSuite(t *testing.T) {
+	suite.Run(t, new(SweeperTestSuite))
+}
@@ -0,0 +1,178 @@
+package integration_tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/controllers"
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type TOTPTestSuite struct {
+	TestSuite
+	service    *service.LndhubService
+	userLogins []ExpectedCreateUserResponseBody
+	userTokens []string
+}
+
+func (suite *TOTPTestSuite) SetupSuite() {
+	svc, err := LndHubTestServiceInit(newDefaultMockLND())
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	users, userTokens, err := createUsers(svc, 1)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	suite.service = svc
+	suite.userLogins = users
+	suite.userTokens = userTokens
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	e.POST("/auth", controllers.NewAuthController(svc).Auth)
+	totpCtrl := v2controllers.NewTOTPController(svc)
+	secured := e.Group("", tokens.Middleware([]byte(svc.Config.JWTSecret)), svc.ValidateUserMiddleware())
+	secured.GET("/v2/totp", totpCtrl.GetTOTP)
+	secured.POST("/v2/totp", totpCtrl.EnrolTOTP)
+	secured.GET("/v2/totp/qr", totpCtrl.TOTPQR)
+	secured.POST("/v2/totp/verify", totpCtrl.VerifyTOTP)
+	secured.PUT("/v2/totp", totpCtrl.UpdateTOTP)
+	secured.DELETE("/v2/totp", totpCtrl.DisableTOTP)
+}
+
+func (suite *TOTPTestSuite) TestTOTP() {
+	user := suite.userLogins[0]
+	token := suite.userTokens[0]
+	userId := getUserIdFromToken(token)
+
+	rec := suite.request(http.MethodPost, "/v2/totp", token, "", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	enrolment := &v2controllers.EnrolTOTPResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(enrolment))
+	assert.Contains(suite.T(), enrolment.URL, "otpauth://totp/")
+	rec = suite.request(http.MethodGet, "/v2/totp/qr", token, "", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Equal(suite.T(), "image/png", rec.Header().Get(echo.HeaderContentType))
+
+	// codes are not required before the enrolment is verified
+	rec, _ = suite.auth(user.Login, user.Password, "")
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	rec = suite.request(http.MethodPost, "/v2/totp/verify", token, "", &v2controllers.VerifyTOTPRequestBody{Code: "000000x"})
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	rec = suite.request(http.MethodPost, "/v2/totp/verify", token, "", &v2controllers.VerifyTOTPRequestBody{Code: suite.code(enrolment.Secret, 0)})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	recovery := &v2controllers.RecoveryCodesResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(recovery))
+	assert.Len(suite.T(), recovery.RecoveryCodes, 10)
+
+	rec, body := suite.auth(user.Login, user.Password, "")
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	assert.True(suite.T(), body.TOTPRequired)
+	rec, _ = suite.auth(user.Login, user.Password, "123")
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	nextCode := suite.code(enrolment.Secret, 30*time.Second)
+	rec, _ = suite.auth(user.Login, user.Password, nextCode)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	// codes can only be used once
+	rec, _ = suite.auth(user.Login, user.Password, nextCode)
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	rec, _ = suite.auth(user.Login, user.Password, recovery.RecoveryCodes[0])
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec, _ = suite.auth(user.Login, user.Password, recovery.RecoveryCodes[0])
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+
+	// payments above the threshold require a code
+	rec = suite.request(http.MethodPut, "/v2/totp", token, "", &v2controllers.UpdateTOTPRequestBody{PaymentThreshold: 100})
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	rec = suite.request(http.MethodPut, "/v2/totp", token, recovery.RecoveryCodes[1], &v2controllers.UpdateTOTPRequestBody{PaymentThreshold: 100})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	for _, tc := range []struct {
+		amount   int64
+		code     string
+		expected *responses.ErrorResponse
+	}{
+		// the user has no balance, so payments that pass the code check fail there
+		{amount: 100, expected: &responses.NotEnoughBalanceError},
+		{amount: 1000, expected: &responses.TOTPRequiredError},
+		{amount: 1000, code: "123456x", expected: &responses.InvalidTOTPCodeError},
+		{amount: 1000, code: recovery.RecoveryCodes[2], expected: &responses.NotEnoughBalanceError},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v2/payments/bolt11", nil)
+		req.Header.Set(service.TOTPCodeHeader, tc.code)
+		c := suite.echo.NewContext(req, httptest.NewRecorder())
+		result, err := suite.service.CheckOutgoingPaymentAllowed(c, &lnd.LNPayReq{PayReq: &lnrpc.PayReq{NumSatoshis: tc.amount}}, userId)
+		assert.NoError(suite.T(), err)
+		assert.Equal(suite.T(), tc.expected, result, fmt.Sprintf("amount %d code %q", tc.amount, tc.code))
+	}
+
+	rec = suite.request(http.MethodDelete, "/v2/totp", token, recovery.RecoveryCodes[3], nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec, _ = suite.auth(user.Login, user.Password, "")
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+}
+
+// code returns the TOTP code of the secret at now plus offset
+func (suite *TOTPTestSuite) code(secret string, offset time.Duration) string {
+	code, err := totp.GenerateCode(secret, time.Now().Add(offset))
+	assert.NoError(suite.T(), err)
+	return code
+}
+
+func (suite *TOTPTestSuite) auth(login, password, code string) (*httptest.ResponseRecorder, *controllers.AuthErrorResponseBody) {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(&controllers.AuthRequestBody{
+		Login:    login,
+		Password: password,
+		Code:     code,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/auth", &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	suite.echo.ServeHTTP(rec, req)
+	body := &controllers.AuthErrorResponseBody{}
+	if rec.Code != http.StatusOK {
+		assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(body))
+	}
+	return rec, body
+}
+
+func (suite *TOTPTestSuite) request(method, path, token, code string, body interface{}) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	if body != nil {
+		assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	if code != "" {
+		req.Header.Set(service.TOTPCodeHeader, code)
+	}
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTOTPSuite(t *testing.T) {
+	suite.Run(t, new(TOTPTestSuite))
+}
@@ -107,11 +107,11 @@ func LndHubTestServiceInit(lndClientMock lnd.LightningClientWrapper) (svc *servi
 
 	logger := lib.Logger(c.LogFilePath)
 	svc = &service.LndhubService{
-		Config:         c,
-		DB:             dbConn,
-		LndClient:      lndClientMock,
-		Logger:         logger,
-		RabbitMQClient: rabbitmqClient,
+		Config:       c,
+		DB:           dbConn,
+		LndClient:    lndClientMock,
+		Logger:       logger,
+		EventsClient: rabbitmqClient,
 	}
 
 	svc.InvoicePubSub = service.NewPubsub()
@@ -173,6 +173,25 @@ func createUsers(svc *service.LndhubService, usersToCreate int) (logins []Expect
 	return logins, tokens, nil
 }
 
+// fundUser pays a new invoice of the user through the mock LND,
+// the invoice update subscription of svc has to be running
+func fundUser(svc *service.LndhubService, mlnd *MockLND, userId int64, amount int64) error {
+	invoice, errResp := svc.AddIncomingInvoice(context.Background(), userId, amount, "fund user", "")
+	if errResp != nil {
+		return fmt.Errorf(errResp.Message)
+	}
+	err := mlnd.mockPaidInvoice(&ExpectedAddInvoiceResponseBody{
+		RHash:  invoice.RHash,
+		PayReq: invoice.PaymentRequest,
+	}, 0, false, nil)
+	if err != nil {
+		return err
+	}
+	//wait a bit for the payment to be processed
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
 type TestSuite struct {
 	suite.Suite
 	echo *echo.Echo
@@ -0,0 +1,180 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ValueForValueTestSuite struct {
+	TestSuite
+	service                  *service.LndhubService
+	mockLND                  *MockLND
+	aliceToken               string
+	bobLogin                 ExpectedCreateUserResponseBody
+	bobToken                 string
+	invoiceUpdateSubCancelFn context.CancelFunc
+}
+
+func (suite *ValueForValueTestSuite) SetupSuite() {
+	mockLND := newDefaultMockLND()
+	suite.mockLND = mockLND
+	svc, err := LndHubTestServiceInit(mockLND)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	svc.Config.LightningAddressDomain = "example.com"
+	users, userTokens, err := createUsers(svc, 2)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	// Subscribe to LND invoice updates in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.invoiceUpdateSubCancelFn = cancel
+	go svc.InvoiceUpdateSubscription(ctx)
+	suite.service = svc
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	suite.aliceToken = userTokens[0]
+	suite.bobLogin = users[1]
+	suite.bobToken = userTokens[1]
+	suite.echo.Use(tokens.Middleware([]byte(suite.service.Config.JWTSecret)))
+	suite.echo.POST("/v2/payments/value4value", v2controllers.NewKeySendController(svc).ValueForValue)
+	suite.echo.GET("/v2/invoices/:payment_hash", v2controllers.NewInvoiceController(svc).GetInvoice)
+}
+
+func (suite *ValueForValueTestSuite) TearDownSuite() {
+	suite.invoiceUpdateSubCancelFn()
+}
+
+func (suite *ValueForValueTestSuite) TearDownTest() {
+	clearTable(suite.service, "transaction_entries")
+	clearTable(suite.service, "invoices")
+}
+
+func (suite *ValueForValueTestSuite) TestValueForValue() {
+	ctx := context.Background()
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	bobId := getUserIdFromToken(suite.bobToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 2000))
+
+	rec := suite.valueReq(&v2controllers.ValueForValueRequestBody{
+		Amount: 1000,
+		Recipients: []service.ValueRecipient{
+			{Name: "host", Type: service.ValueRecipientTypeNode, Address: "123456789012345678901234567890123456789012345678901234567890abcdef", Split: 90},
+			{Name: "guest", Type: service.ValueRecipientTypeLnAddress, Address: fmt.Sprintf("%s@example.com", suite.bobLogin.Login), Split: 10},
+			{Name: "app", Type: service.ValueRecipientTypeNode, Address: "123456789012345678901234567890123456789012345678901234567890abcdef", Split: 10, Fee: true},
+		},
+		Boostagram: &service.Boostagram{
+			Action:  "boost",
+			Podcast: "Podcasting 2.0",
+			Message: "great show",
+		},
+	}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	response := &v2controllers.MultiKeySendResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(response))
+	assert.Len(suite.T(), response.Keysends, 3)
+	for _, result := range response.Keysends {
+		assert.Nil(suite.T(), result.Error)
+	}
+	// the fee recipient gets 10% of the amount, the rest is split 90/10
+	assert.Equal(suite.T(), int64(810), response.Keysends[0].Keysend.Amount)
+	assert.Equal(suite.T(), int64(90), response.Keysends[1].Keysend.Amount)
+	assert.Equal(suite.T(), int64(100), response.Keysends[2].Keysend.Amount)
+
+	aliceBalance, err := suite.service.CurrentUserBalance(ctx, aliceId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(2000-1000-2*suite.mockLND.fee), aliceBalance)
+	bobBalance, err := suite.service.CurrentUserBalance(ctx, bobId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(90), bobBalance)
+
+	// the recipient sees the decoded boostagram
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v2/invoices/%s", response.Keysends[1].Keysend.PaymentHash), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.bobToken))
+	suite.echo.ServeHTTP(rec, req)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	invoice := &v2controllers.Invoice{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(invoice))
+	assert.NotNil(suite.T(), invoice.Boostagram)
+	assert.Equal(suite.T(), "great show", invoice.Boostagram.Message)
+	assert.Equal(suite.T(), "guest", invoice.Boostagram.Name)
+	assert.Equal(suite.T(), int64(90000), invoice.Boostagram.ValueMsat)
+	assert.Equal(suite.T(), int64(1000000), invoice.Boostagram.ValueMsatTotal)
+}
+
+func (suite *ValueForValueTestSuite) TestValueForValueInvalidSplits() {
+	rec := suite.valueReq(&v2controllers.ValueForValueRequestBody{
+		Amount: 100,
+		Recipients: []service.ValueRecipient{
+			{Type: service.ValueRecipientTypeNode, Address: "123456789012345678901234567890123456789012345678901234567890abcdef", Split: 0},
+		},
+	}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	resp := &responses.ErrorResponse{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(resp))
+	assert.Equal(suite.T(), service.ErrInvalidValueSplits.Error(), resp.Message)
+}
+
+func (suite *ValueForValueTestSuite) TestValueForValueInvalidRecipient() {
+	ctx := context.Background()
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+
+	// the second split is invalid, the first one must not be paid
+	rec := suite.valueReq(&v2controllers.ValueForValueRequestBody{
+		Amount: 100,
+		Recipients: []service.ValueRecipient{
+			{Type: service.ValueRecipientTypeNode, Address: "123456789012345678901234567890123456789012345678901234567890abcdef", Split: 50},
+			{Type: service.ValueRecipientTypeNode, Address: "123456789012345678901234567890123456789012345678901234567890abcdef", Split: 50, CustomKey: "not-a-number", CustomValue: "x"},
+		},
+	}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	rec = suite.valueReq(&v2controllers.ValueForValueRequestBody{
+		Amount: 100,
+		Recipients: []service.ValueRecipient{
+			{Type: service.ValueRecipientTypeNode, Address: "123456789012345678901234567890123456789012345678901234567890abcdef", Split: 50},
+			{Type: service.ValueRecipientTypeLnAddress, Address: "nobody@example.com", Split: 50},
+		},
+	}, suite.aliceToken)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	aliceBalance, err := suite.service.CurrentUserBalance(ctx, aliceId)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(1000), aliceBalance)
+}
+
+func (suite *ValueForValueTestSuite) valueReq(body *v2controllers.ValueForValueRequestBody, token string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	req := httptest.NewRequest(http.MethodPost, "/v2/payments/value4value", &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestValueForValueSuite(t *testing.T) {
+	suite.Run(t, new(ValueForValueTestSuite))
+}
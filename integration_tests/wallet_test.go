@@ -0,0 +1,178 @@
+package integration_tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
+	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type WalletTestSuite struct {
+	TestSuite
+	service                  *service.LndhubService
+	mockLND                  *MockLND
+	aliceToken               string
+	bobToken                 string
+	invoiceUpdateSubCancelFn context.CancelFunc
+}
+
+func (suite *WalletTestSuite) SetupSuite() {
+	mockLND := newDefaultMockLND()
+	suite.mockLND = mockLND
+	svc, err := LndHubTestServiceInit(mockLND)
+	if err != nil {
+		log.Fatalf("Error initializing test service: %v", err)
+	}
+	_, userTokens, err := createUsers(svc, 2)
+	if err != nil {
+		log.Fatalf("Error creating test users: %v", err)
+	}
+	// Subscribe to LND invoice updates in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.invoiceUpdateSubCancelFn = cancel
+	go svc.InvoiceUpdateSubscription(ctx)
+	suite.service = svc
+	e := echo.New()
+
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	suite.echo = e
+	suite.aliceToken = userTokens[0]
+	suite.bobToken = userTokens[1]
+	secured := suite.echo.Group("", tokens.Middleware([]byte(suite.service.Config.JWTSecret)), svc.ValidateUserMiddleware())
+	walletMw := svc.WalletMiddleware()
+	walletCtrl := v2controllers.NewWalletController(svc)
+	secured.POST("/v2/wallets", walletCtrl.CreateWallet)
+	secured.GET("/v2/wallets", walletCtrl.GetWallets)
+	secured.POST("/v2/wallets/transfer", walletCtrl.WalletTransfer)
+	secured.POST("/v2/invoices", v2controllers.NewInvoiceController(svc).AddInvoice, walletMw)
+	secured.GET("/v2/invoices/:payment_hash", v2controllers.NewInvoiceController(svc).GetInvoice, walletMw)
+	secured.GET("/v2/balance", v2controllers.NewBalanceController(svc).Balance, walletMw)
+}
+
+func (suite *WalletTestSuite) TearDownSuite() {
+	suite.invoiceUpdateSubCancelFn()
+}
+
+func (suite *WalletTestSuite) TearDownTest() {
+	clearTable(suite.service, "transaction_entries")
+	clearTable(suite.service, "invoices")
+}
+
+func (suite *WalletTestSuite) TestWallets() {
+	aliceId := getUserIdFromToken(suite.aliceToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, aliceId, 1000))
+
+	rec := suite.walletReq(http.MethodPost, "/v2/wallets", suite.aliceToken, &v2controllers.WalletRequestBody{Name: "savings"})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	savings := &v2controllers.Wallet{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(savings))
+	assert.NotZero(suite.T(), savings.ID)
+
+	rec = suite.walletReq(http.MethodPost, "/v2/wallets/transfer", suite.aliceToken, &v2controllers.WalletTransferRequestBody{
+		ToWalletID: savings.ID,
+		Amount:     300,
+	})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	// the savings wallet receives payments on its own invoices
+	rec = suite.walletReq(http.MethodPost, fmt.Sprintf("/v2/invoices?wallet_id=%d", savings.ID), suite.aliceToken, &v2controllers.AddInvoiceRequestBody{Amount: 50})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	invoice := &v2controllers.AddInvoiceResponseBody{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(invoice))
+	assert.NoError(suite.T(), suite.mockLND.mockPaidInvoice(&ExpectedAddInvoiceResponseBody{
+		RHash:  invoice.PaymentHash,
+		PayReq: invoice.PaymentRequest,
+	}, 0, false, nil))
+	time.Sleep(100 * time.Millisecond)
+
+	// the invoice is only found in its wallet
+	rec = suite.walletReq(http.MethodGet, fmt.Sprintf("/v2/invoices/%s?wallet_id=%d", invoice.PaymentHash, savings.ID), suite.aliceToken, nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec = suite.walletReq(http.MethodGet, fmt.Sprintf("/v2/invoices/%s", invoice.PaymentHash), suite.aliceToken, nil)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	rec = suite.walletReq(http.MethodGet, "/v2/wallets", suite.aliceToken, nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	wallets := []v2controllers.Wallet{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&wallets))
+	assert.Len(suite.T(), wallets, 2)
+	assert.Equal(suite.T(), int64(0), wallets[0].ID)
+	assert.Equal(suite.T(), int64(700), wallets[0].Balance)
+	assert.Equal(suite.T(), savings.ID, wallets[1].ID)
+	assert.Equal(suite.T(), int64(350), wallets[1].Balance)
+
+	rec = suite.walletReq(http.MethodGet, fmt.Sprintf("/v2/balance?wallet_id=%d", savings.ID), suite.aliceToken, nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	balance := &v2controllers.BalanceResponse{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(balance))
+	assert.Equal(suite.T(), int64(350), balance.Balance)
+
+	// the savings wallet can't spend more than its balance
+	rec = suite.walletReq(http.MethodPost, "/v2/wallets/transfer", suite.aliceToken, &v2controllers.WalletTransferRequestBody{
+		FromWalletID: savings.ID,
+		Amount:       351,
+	})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	// other users can't use the wallet
+	rec = suite.walletReq(http.MethodGet, fmt.Sprintf("/v2/balance?wallet_id=%d", savings.ID), suite.bobToken, nil)
+	assert.Equal(suite.T(), http.StatusNotFound, rec.Code)
+	rec = suite.walletReq(http.MethodPost, "/v2/wallets/transfer", suite.bobToken, &v2controllers.WalletTransferRequestBody{
+		FromWalletID: savings.ID,
+		Amount:       100,
+	})
+	assert.Equal(suite.T(), http.StatusNotFound, rec.Code)
+}
+
+func (suite *WalletTestSuite) TestMaxAccountBalanceOfAllWallets() {
+	bobId := getUserIdFromToken(suite.bobToken)
+	assert.NoError(suite.T(), fundUser(suite.service, suite.mockLND, bobId, 500))
+	rec := suite.walletReq(http.MethodPost, "/v2/wallets", suite.bobToken, &v2controllers.WalletRequestBody{Name: "tips"})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	tips := &v2controllers.Wallet{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(tips))
+
+	// the default wallet is at the limit, so the other wallet can't receive either
+	suite.service.Config.MaxAccountBalance = 500
+	defer func() {
+		suite.service.Config.MaxAccountBalance = -1
+	}()
+	rec = suite.walletReq(http.MethodPost, fmt.Sprintf("/v2/invoices?wallet_id=%d", tips.ID), suite.bobToken, &v2controllers.AddInvoiceRequestBody{Amount: 10})
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	errResp := &responses.ErrorResponse{}
+	assert.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(errResp))
+	assert.Equal(suite.T(), responses.BalanceExceededError.Message, errResp.Message)
+}
+
+func (suite *WalletTestSuite) walletReq(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	var buf bytes.Buffer
+	if body != nil {
+		assert.NoError(suite.T(), json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	suite.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWalletSuite(t *testing.T) {
+	suite.Run(t, new(WalletTestSuite))
+}
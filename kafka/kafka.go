@@ -0,0 +1,307 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getAlby/lndhub.go/events"
+	"github.com/labstack/gommon/log"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/ziflex/lecho/v3"
+)
+
+const (
+	contentTypeJSON = "application/json"
+
+	// header used to count how often a message has been requeued
+	redeliveryCountHeader = "x-redelivery-count"
+
+	// A safety mechanism, analogous to the RabbitMQ delivery-limit. If our code
+	// would requeue failed messages we want to limit the amount of redeliveries
+	// as to avoid infinite loops.
+	defaultMaxDeliver = 10
+)
+
+// MessageReader is the subset of *kafkago.Reader used by the broker
+type MessageReader interface {
+	FetchMessage(ctx context.Context) (kafkago.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafkago.Message) error
+	Close() error
+}
+
+// MessageWriter is the subset of *kafkago.Writer used by the broker
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafkago.Message) error
+	Close() error
+}
+
+type ReaderFactory = func(topic, groupID string) MessageReader
+
+// Broker implements events.Broker on top of Kafka.
+//
+// Topics map to Kafka topics and routing keys are used as message keys, so all
+// updates with the same key end up in the same partition. Consumers are consumer
+// groups, so multiple lndhub.go instances listening with the same consumer name
+// will spread the partitions between them. Kafka can't filter on keys server side,
+// messages not matching the routing key of a consumer are committed and skipped.
+type Broker struct {
+	writer    MessageWriter
+	newReader ReaderFactory
+
+	readersMu sync.Mutex
+	readers   []MessageReader
+
+	maxDeliver int
+	logger     *lecho.Logger
+}
+
+type DialOption = func(*Broker)
+
+func WithLogger(logger *lecho.Logger) DialOption {
+	return func(broker *Broker) {
+		broker.logger = logger
+	}
+}
+
+func WithMaxDeliver(maxDeliver int) DialOption {
+	return func(broker *Broker) {
+		broker.maxDeliver = maxDeliver
+	}
+}
+
+// WithWriter replaces the kafka writer, e.g. with an in-memory fake for testing
+func WithWriter(writer MessageWriter) DialOption {
+	return func(broker *Broker) {
+		broker.writer = writer
+	}
+}
+
+// WithReaderFactory replaces the kafka consumer group readers, e.g. with an in-memory fake for testing
+func WithReaderFactory(factory ReaderFactory) DialOption {
+	return func(broker *Broker) {
+		broker.newReader = factory
+	}
+}
+
+// Dial sets up a broker for the comma separated list of kafka bootstrap servers.
+// Connections are established lazily on the first read or write.
+func Dial(brokers string, options ...DialOption) (*Broker, error) {
+	addrs := strings.Split(brokers, ",")
+	broker := &Broker{
+		writer: &kafkago.Writer{
+			Addr:                   kafkago.TCP(addrs...),
+			Balancer:               &kafkago.Hash{},
+			RequiredAcks:           kafkago.RequireAll,
+			AllowAutoTopicCreation: true,
+		},
+		newReader: func(topic, groupID string) MessageReader {
+			return kafkago.NewReader(kafkago.ReaderConfig{
+				Brokers:     addrs,
+				GroupID:     groupID,
+				Topic:       topic,
+				StartOffset: kafkago.FirstOffset,
+				// commit synchronously on every ack
+				CommitInterval: 0,
+			})
+		},
+		maxDeliver: defaultMaxDeliver,
+		logger: lecho.New(
+			os.Stdout,
+			lecho.WithLevel(log.DEBUG),
+			lecho.WithTimestamp(),
+		),
+	}
+	for _, opt := range options {
+		opt(broker)
+	}
+	return broker, nil
+}
+
+// NewClient creates an events client that uses Kafka as message broker
+func NewClient(broker *Broker, options ...events.ClientOption) (events.Client, error) {
+	return events.NewClient(broker, options...)
+}
+
+// Declare is a no-op, topics are created automatically on the first write
+func (broker *Broker) Declare(ctx context.Context, topic string) error {
+	return nil
+}
+
+// Bind is a no-op, new consumer groups start reading at the oldest retained message
+func (broker *Broker) Bind(ctx context.Context, topic, routingKey, consumerName string) error {
+	return nil
+}
+
+func (broker *Broker) Listen(ctx context.Context, topic, routingKey, consumerName string) (<-chan events.Delivery, error) {
+	reader := broker.newReader(topic, consumerName)
+	broker.readersMu.Lock()
+	broker.readers = append(broker.readers, reader)
+	broker.readersMu.Unlock()
+
+	deliveries := make(chan events.Delivery)
+	go func() {
+		defer close(deliveries)
+		// leave the consumer group, so its partitions are handed to the next listener right away
+		defer broker.closeReader(reader)
+		for {
+			msg, err := reader.FetchMessage(ctx)
+			if err != nil {
+				if ctx.Err() != nil || errors.Is(err, io.EOF) {
+					// context canceled or reader closed
+					return
+				}
+				broker.logger.Errorf("kafka: error fetching message from topic %s: %v", topic, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+
+			if !events.MatchRoutingKey(routingKey, string(msg.Key)) {
+				err = reader.CommitMessages(ctx, msg)
+				if err != nil {
+					broker.logger.Errorf("kafka: error committing skipped message from topic %s: %v", topic, err)
+				}
+				continue
+			}
+
+			d := &delivery{
+				broker: broker,
+				reader: reader,
+				msg:    msg,
+				ctx:    ctx,
+				done:   make(chan struct{}),
+			}
+			select {
+			case deliveries <- d:
+			case <-ctx.Done():
+				return
+			}
+			// Offsets are committed per partition, committing a later message
+			// implicitly commits all earlier ones. So we wait until this message
+			// is acked or nacked before moving on.
+			select {
+			case <-d.done:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return deliveries, nil
+}
+
+func (broker *Broker) Publish(ctx context.Context, topic, routingKey string, body []byte) error {
+	return broker.writer.WriteMessages(ctx, kafkago.Message{
+		Topic: topic,
+		Key:   []byte(routingKey),
+		Value: body,
+		Headers: []kafkago.Header{
+			{Key: "Content-Type", Value: []byte(contentTypeJSON)},
+		},
+	})
+}
+
+func (broker *Broker) Close() error {
+	broker.readersMu.Lock()
+	defer broker.readersMu.Unlock()
+	for _, reader := range broker.readers {
+		if err := reader.Close(); err != nil {
+			broker.logger.Errorf("kafka: error closing reader: %v", err)
+		}
+	}
+	broker.readers = nil
+	return broker.writer.Close()
+}
+
+func (broker *Broker) closeReader(reader MessageReader) {
+	broker.readersMu.Lock()
+	defer broker.readersMu.Unlock()
+	for i, r := range broker.readers {
+		if r == reader {
+			broker.readers = append(broker.readers[:i], broker.readers[i+1:]...)
+			err := reader.Close()
+			if err != nil {
+				broker.logger.Errorf("kafka: error closing reader: %v", err)
+			}
+			return
+		}
+	}
+}
+
+type delivery struct {
+	broker *Broker
+	reader MessageReader
+	msg    kafkago.Message
+	ctx    context.Context
+
+	once sync.Once
+	done chan struct{}
+}
+
+func (d *delivery) Body() []byte { return d.msg.Value }
+
+func (d *delivery) RoutingKey() string { return string(d.msg.Key) }
+
+func (d *delivery) Ack() error {
+	defer d.finish()
+	return d.reader.CommitMessages(d.ctx, d.msg)
+}
+
+// Nack commits the message so it is not consumed again. Kafka has no notion of
+// requeueing a single message, so when requeue is true the message is appended
+// to the end of the topic again, up to maxDeliver times.
+func (d *delivery) Nack(requeue bool) error {
+	defer d.finish()
+	if requeue {
+		count := redeliveryCount(d.msg) + 1
+		if count < d.broker.maxDeliver {
+			err := d.broker.writer.WriteMessages(d.ctx, kafkago.Message{
+				Topic:   d.msg.Topic,
+				Key:     d.msg.Key,
+				Value:   d.msg.Value,
+				Headers: withRedeliveryCount(d.msg.Headers, count),
+			})
+			if err != nil {
+				// don't commit, the message will be consumed again after a restart
+				return err
+			}
+		}
+	}
+	return d.reader.CommitMessages(d.ctx, d.msg)
+}
+
+func (d *delivery) finish() {
+	d.once.Do(func() { close(d.done) })
+}
+
+func redeliveryCount(msg kafkago.Message) int {
+	for _, header := range msg.Headers {
+		if header.Key == redeliveryCountHeader {
+			count, err := strconv.Atoi(string(header.Value))
+			if err != nil {
+				return 0
+			}
+			return count
+		}
+	}
+	return 0
+}
+
+func withRedeliveryCount(headers []kafkago.Header, count int) []kafkago.Header {
+	result := []kafkago.Header{}
+	for _, header := range headers {
+		if header.Key != redeliveryCountHeader {
+			result = append(result, header)
+		}
+	}
+	return append(result, kafkago.Header{Key: redeliveryCountHeader, Value: []byte(strconv.Itoa(count))})
+}
@@ -0,0 +1,277 @@
+package kafka_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/kafka"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCluster is an in-memory stand-in for a kafka cluster with a single partition per topic
+type fakeCluster struct {
+	mu        sync.Mutex
+	topics    map[string][]kafkago.Message
+	committed map[string]int64
+	notify    chan struct{}
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{
+		topics:    map[string][]kafkago.Message{},
+		committed: map[string]int64{},
+		notify:    make(chan struct{}),
+	}
+}
+
+func (c *fakeCluster) WriteMessages(ctx context.Context, msgs ...kafkago.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, msg := range msgs {
+		msg.Offset = int64(len(c.topics[msg.Topic]))
+		msg.Value = append([]byte{}, msg.Value...)
+		c.topics[msg.Topic] = append(c.topics[msg.Topic], msg)
+	}
+	close(c.notify)
+	c.notify = make(chan struct{})
+	return nil
+}
+
+func (c *fakeCluster) Close() error { return nil }
+
+func (c *fakeCluster) committedOffset(topic, groupID string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.committed[groupID+"/"+topic]
+}
+
+func (c *fakeCluster) messages(topic string) []kafkago.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]kafkago.Message{}, c.topics[topic]...)
+}
+
+func (c *fakeCluster) NewReader(topic, groupID string) kafka.MessageReader {
+	return &fakeReader{cluster: c, topic: topic, groupID: groupID, next: c.committedOffset(topic, groupID)}
+}
+
+type fakeReader struct {
+	cluster *fakeCluster
+	topic   string
+	groupID string
+	next    int64
+	closed  bool
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafkago.Message, error) {
+	for {
+		r.cluster.mu.Lock()
+		if r.closed {
+			r.cluster.mu.Unlock()
+			return kafkago.Message{}, io.EOF
+		}
+		if msgs := r.cluster.topics[r.topic]; r.next < int64(len(msgs)) {
+			msg := msgs[r.next]
+			r.next++
+			r.cluster.mu.Unlock()
+			return msg, nil
+		}
+		wait := r.cluster.notify
+		r.cluster.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return kafkago.Message{}, ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafkago.Message) error {
+	r.cluster.mu.Lock()
+	defer r.cluster.mu.Unlock()
+	for _, msg := range msgs {
+		r.cluster.committed[r.groupID+"/"+msg.Topic] = msg.Offset + 1
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	r.cluster.mu.Lock()
+	defer r.cluster.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func newFakeBroker(t *testing.T, cluster *fakeCluster) *kafka.Broker {
+	broker, err := kafka.Dial("fake:9092", kafka.WithWriter(cluster), kafka.WithReaderFactory(cluster.NewReader))
+	require.NoError(t, err)
+	return broker
+}
+
+func TestSubscribeToLndInvoices(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cluster := newFakeCluster()
+	broker := newFakeBroker(t, cluster)
+	client, err := kafka.NewClient(broker)
+	require.NoError(t, err)
+	defer client.Close()
+
+	rHash := []byte("69e5f0f0590be75e30f671d56afe1d55")
+	failingHash := []byte("ffff0f0590be75e30f671d56afe1d55")
+
+	// badly formatted message, should be dropped
+	require.NoError(t, broker.Publish(ctx, "lnd_invoice", "invoice.incoming.settled", []byte("{not json")))
+	// handler fails on this one, should be dropped as well
+	payload, err := json.Marshal(&lnrpc.Invoice{RHash: failingHash, State: lnrpc.Invoice_SETTLED})
+	require.NoError(t, err)
+	require.NoError(t, broker.Publish(ctx, "lnd_invoice", "invoice.incoming.settled", payload))
+	// not matching the consumer's routing key, should never reach the handler
+	payload, err = json.Marshal(&lnrpc.Invoice{RHash: []byte("unrelated"), State: lnrpc.Invoice_OPEN})
+	require.NoError(t, err)
+	require.NoError(t, broker.Publish(ctx, "lnd_invoice", "invoice.incoming.open", payload))
+	payload, err = json.Marshal(&lnrpc.Invoice{RHash: rHash, State: lnrpc.Invoice_SETTLED})
+	require.NoError(t, err)
+	require.NoError(t, broker.Publish(ctx, "lnd_invoice", "invoice.incoming.settled", payload))
+
+	received := make(chan *lnrpc.Invoice, 3)
+	go func() {
+		err := client.SubscribeToLndInvoices(ctx, func(ctx context.Context, invoice *lnrpc.Invoice) error {
+			received <- invoice
+			if string(invoice.RHash) == string(failingHash) {
+				return errors.New("handler failed")
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+	}()
+
+	for _, expected := range [][]byte{failingHash, rHash} {
+		select {
+		case invoice := <-received:
+			assert.Equal(t, string(expected), string(invoice.RHash))
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for invoice")
+		}
+	}
+	assert.Eventually(t, func() bool {
+		return cluster.committedOffset("lnd_invoice", "lnd_invoice_consumer") == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	// nothing was requeued
+	assert.Len(t, cluster.messages("lnd_invoice"), 4)
+}
+
+func TestNackWithRequeueRedelivers(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cluster := newFakeCluster()
+	broker := newFakeBroker(t, cluster)
+	defer broker.Close()
+
+	deliveries, err := broker.Listen(ctx, "lnd_payment", "payment.outgoing.*", "lnd_payment_consumer")
+	require.NoError(t, err)
+	require.NoError(t, broker.Publish(ctx, "lnd_payment", "payment.outgoing.succeeded", []byte("payment")))
+
+	first := <-deliveries
+	assert.Equal(t, "payment", string(first.Body()))
+	require.NoError(t, first.Nack(true))
+
+	select {
+	case second := <-deliveries:
+		assert.Equal(t, "payment", string(second.Body()))
+		require.NoError(t, second.Ack())
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not redelivered")
+	}
+	assert.Eventually(t, func() bool {
+		return cluster.committedOffset("lnd_payment", "lnd_payment_consumer") == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNackWithRequeueStopsAtMaxDeliver(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cluster := newFakeCluster()
+	broker, err := kafka.Dial("fake:9092",
+		kafka.WithWriter(cluster),
+		kafka.WithReaderFactory(cluster.NewReader),
+		kafka.WithMaxDeliver(3),
+	)
+	require.NoError(t, err)
+	defer broker.Close()
+
+	deliveries, err := broker.Listen(ctx, "lnd_payment", "payment.outgoing.*", "lnd_payment_consumer")
+	require.NoError(t, err)
+	require.NoError(t, broker.Publish(ctx, "lnd_payment", "payment.outgoing.failed", []byte("poison")))
+
+	for i := 0; i < 3; i++ {
+		select {
+		case d := <-deliveries:
+			require.NoError(t, d.Nack(true))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("delivery %d did not arrive", i)
+		}
+	}
+	select {
+	case <-deliveries:
+		t.Fatal("message was delivered more than max deliver times")
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.Len(t, cluster.messages("lnd_payment"), 3)
+}
+
+func TestStartPublishInvoices(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cluster := newFakeCluster()
+	client, err := kafka.NewClient(newFakeBroker(t, cluster))
+	require.NoError(t, err)
+	defer client.Close()
+
+	in := make(chan models.Invoice)
+	out := make(chan models.Invoice)
+	go func() {
+		err := client.StartPublishInvoices(ctx,
+			func() (chan models.Invoice, chan models.Invoice, error) { return in, out, nil },
+			func(ctx context.Context, w io.Writer, invoice models.Invoice) error {
+				return json.NewEncoder(w).Encode(invoice)
+			},
+		)
+		assert.ErrorIs(t, err, context.Canceled)
+	}()
+
+	in <- models.Invoice{RHash: "incoming_hash", Type: common.InvoiceTypeIncoming, State: common.InvoiceStateSettled}
+	out <- models.Invoice{RHash: "outgoing_hash", Type: common.InvoiceTypeOutgoing, State: common.InvoiceStateError}
+
+	assert.Eventually(t, func() bool {
+		return len(cluster.messages("lndhub_invoice")) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	msgs := cluster.messages("lndhub_invoice")
+	for i, expected := range []struct{ key, rHash string }{
+		{"invoice.incoming.settled", "incoming_hash"},
+		{"invoice.outgoing.error", "outgoing_hash"},
+	} {
+		assert.Equal(t, expected.key, string(msgs[i].Key))
+		invoice := models.Invoice{}
+		require.NoError(t, json.Unmarshal(msgs[i].Value, &invoice))
+		assert.Equal(t, expected.rHash, invoice.RHash)
+	}
+}
@@ -0,0 +1,191 @@
+// Package lockout tracks failed login attempts per login and per client IP and locks them out
+// for exponentially growing periods. The failures are kept in a Store, which has to be shared
+// by all replicas (e.g. Postgres) so that a lockout applies to all of them.
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// Entry holds the failed attempts of a key
+type Entry struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// Store keeps the failed attempts of keys
+type Store interface {
+	// Get returns the entry of key, a zero entry if there were no failures
+	Get(ctx context.Context, key string) (Entry, error)
+	// Fail adds a failure at now to key and returns the updated entry.
+	// Failures are counted from 1 again if the last one was before resetBefore.
+	Fail(ctx context.Context, key string, now, resetBefore time.Time) (Entry, error)
+	// Lock locks key until the given time, earlier times don't shorten an existing lock
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Delete forgets the failures and the lock of key
+	Delete(ctx context.Context, key string) error
+	// Prune forgets all keys without failures since before that are not locked anymore
+	Prune(ctx context.Context, before, now time.Time) error
+}
+
+// Policy locks a key for Lockout after MaxFailures failures, every further failure doubles the lockout up to MaxLockout.
+// Failures are forgotten if there was none within Reset.
+type Policy struct {
+	MaxFailures int
+	Lockout     time.Duration
+	MaxLockout  time.Duration
+	Reset       time.Duration
+	// CaptchaAfter is the number of failures after which clients should solve a captcha, 0 never asks for one
+	CaptchaAfter int
+}
+
+// LockoutFor returns how long a key with the given number of failures is locked, 0 if it is not locked.
+// Without MaxLockout the lockout doesn't grow.
+func (p Policy) LockoutFor(failures int) time.Duration {
+	if p.MaxFailures <= 0 || failures < p.MaxFailures {
+		return 0
+	}
+	lockout := p.Lockout
+	for i := p.MaxFailures; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if p.MaxLockout > 0 && lockout > p.MaxLockout {
+		return p.MaxLockout
+	}
+	return lockout
+}
+
+// Status is the lockout state of a login attempt
+type Status struct {
+	Failures        int
+	LockedUntil     time.Time
+	CaptchaRequired bool
+}
+
+// Locked returns true if attempts are rejected at now
+func (s Status) Locked(now time.Time) bool {
+	return now.Before(s.LockedUntil)
+}
+
+// merge combines the status of the login and the IP, the stricter one wins
+func (s Status) merge(entry Entry, policy Policy) Status {
+	if entry.Failures > s.Failures {
+		s.Failures = entry.Failures
+	}
+	if entry.LockedUntil.After(s.LockedUntil) {
+		s.LockedUntil = entry.LockedUntil
+	}
+	if policy.CaptchaAfter > 0 && entry.Failures >= policy.CaptchaAfter {
+		s.CaptchaRequired = true
+	}
+	return s
+}
+
+// Guard applies a policy per login and another, usually more lenient, one per client IP
+type Guard struct {
+	store       Store
+	loginPolicy Policy
+	ipPolicy    Policy
+	now         func() time.Time
+}
+
+func NewGuard(store Store, loginPolicy, ipPolicy Policy) *Guard {
+	return &Guard{
+		store:       store,
+		loginPolicy: loginPolicy,
+		ipPolicy:    ipPolicy,
+		now:         time.Now,
+	}
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns the status of a login attempt before the password is checked, an empty ip is not tracked
+func (g *Guard) Check(ctx context.Context, login, ip string) (Status, error) {
+	status := Status{}
+	entry, err := g.store.Get(ctx, loginKey(login))
+	if err != nil {
+		return status, err
+	}
+	status = status.merge(g.current(entry, g.loginPolicy), g.loginPolicy)
+	if ip != "" && g.ipPolicy.MaxFailures > 0 {
+		entry, err := g.store.Get(ctx, ipKey(ip))
+		if err != nil {
+			return status, err
+		}
+		status = status.merge(g.current(entry, g.ipPolicy), g.ipPolicy)
+	}
+	return status, nil
+}
+
+// Fail records a failed attempt of the login from ip, locks them if their policy says so and returns the new status
+func (g *Guard) Fail(ctx context.Context, login, ip string) (Status, error) {
+	status, err := g.fail(ctx, loginKey(login), g.loginPolicy, Status{})
+	if err != nil {
+		return status, err
+	}
+	if ip != "" && g.ipPolicy.MaxFailures > 0 {
+		return g.fail(ctx, ipKey(ip), g.ipPolicy, status)
+	}
+	return status, nil
+}
+
+func (g *Guard) fail(ctx context.Context, key string, policy Policy, status Status) (Status, error) {
+	now := g.now()
+	entry, err := g.store.Fail(ctx, key, now, now.Add(-policy.Reset))
+	if err != nil {
+		return status, err
+	}
+	if lockout := policy.LockoutFor(entry.Failures); lockout > 0 && now.Add(lockout).After(entry.LockedUntil) {
+		entry.LockedUntil = now.Add(lockout)
+		if err := g.store.Lock(ctx, key, entry.LockedUntil); err != nil {
+			return status, err
+		}
+	}
+	return status.merge(entry, policy), nil
+}
+
+// Succeed forgets the failures of a login after a successful attempt.
+// The failures of the IP are kept, otherwise a valid account would allow to guess the passwords of others.
+func (g *Guard) Succeed(ctx context.Context, login string) error {
+	return g.store.Delete(ctx, loginKey(login))
+}
+
+// Unlock forgets the failures and the lock of a login, and of an IP if it is not empty
+func (g *Guard) Unlock(ctx context.Context, login, ip string) error {
+	if login != "" {
+		if err := g.store.Delete(ctx, loginKey(login)); err != nil {
+			return err
+		}
+	}
+	if ip != "" {
+		return g.store.Delete(ctx, ipKey(ip))
+	}
+	return nil
+}
+
+// Prune forgets the failures that are older than the reset period of both policies
+func (g *Guard) Prune(ctx context.Context) error {
+	reset := g.loginPolicy.Reset
+	if g.ipPolicy.Reset > reset {
+		reset = g.ipPolicy.Reset
+	}
+	now := g.now()
+	return g.store.Prune(ctx, now.Add(-reset), now)
+}
+
+// current drops failures that are older than the reset period, locks still apply until they expire
+func (g *Guard) current(entry Entry, policy Policy) Entry {
+	if entry.LastFailureAt.Before(g.now().Add(-policy.Reset)) {
+		entry.Failures = 0
+	}
+	return entry
+}
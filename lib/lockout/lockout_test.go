@@ -0,0 +1,89 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockoutFor(t *testing.T) {
+	policy := Policy{MaxFailures: 3, Lockout: time.Minute, MaxLockout: 10 * time.Minute}
+	assert.Equal(t, time.Duration(0), policy.LockoutFor(2))
+	assert.Equal(t, time.Minute, policy.LockoutFor(3))
+	assert.Equal(t, 2*time.Minute, policy.LockoutFor(4))
+	assert.Equal(t, 8*time.Minute, policy.LockoutFor(6))
+	assert.Equal(t, 10*time.Minute, policy.LockoutFor(7))
+	assert.Equal(t, 10*time.Minute, policy.LockoutFor(1000))
+
+	assert.Equal(t, time.Duration(0), Policy{Lockout: time.Minute}.LockoutFor(1000))
+	assert.Equal(t, time.Minute, Policy{MaxFailures: 1, Lockout: time.Minute}.LockoutFor(1000))
+}
+
+func TestGuard(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 19, 12, 0, 0, 0, time.UTC)
+	guard := NewGuard(NewMemoryStore(),
+		Policy{MaxFailures: 3, Lockout: time.Minute, MaxLockout: time.Hour, Reset: 24 * time.Hour, CaptchaAfter: 2},
+		Policy{MaxFailures: 5, Lockout: time.Minute, MaxLockout: time.Hour, Reset: 24 * time.Hour},
+	)
+	guard.now = func() time.Time { return now }
+
+	status, err := guard.Fail(ctx, "alice", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.False(t, status.Locked(now))
+	assert.False(t, status.CaptchaRequired)
+	status, err = guard.Fail(ctx, "alice", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, status.CaptchaRequired)
+	status, err = guard.Fail(ctx, "alice", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, status.Locked(now))
+	assert.Equal(t, now.Add(time.Minute), status.LockedUntil)
+
+	// the lockout doubles with every further failure
+	now = now.Add(2 * time.Minute)
+	status, err = guard.Check(ctx, "alice", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.False(t, status.Locked(now))
+	status, err = guard.Fail(ctx, "alice", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(2*time.Minute), status.LockedUntil)
+
+	// the ip is locked for other logins after 5 failures
+	status, err = guard.Fail(ctx, "bob", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, status.Locked(now))
+	status, err = guard.Check(ctx, "carol", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, status.Locked(now))
+	status, err = guard.Check(ctx, "carol", "10.0.0.2")
+	assert.NoError(t, err)
+	assert.False(t, status.Locked(now))
+
+	// a successful login only resets the login
+	assert.NoError(t, guard.Succeed(ctx, "alice"))
+	status, err = guard.Check(ctx, "alice", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status.Failures)
+	status, err = guard.Check(ctx, "alice", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, status.Locked(now))
+
+	assert.NoError(t, guard.Unlock(ctx, "", "10.0.0.1"))
+	status, err = guard.Check(ctx, "carol", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.False(t, status.Locked(now))
+
+	// failures are forgotten after the reset period
+	_, err = guard.Fail(ctx, "dave", "")
+	assert.NoError(t, err)
+	now = now.Add(25 * time.Hour)
+	status, err = guard.Fail(ctx, "dave", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status.Failures)
+	now = now.Add(25 * time.Hour)
+	assert.NoError(t, guard.Prune(ctx))
+	assert.Empty(t, guard.store.(*MemoryStore).entries)
+}
@@ -0,0 +1,81 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/uptrace/bun"
+)
+
+// PostgresStore keeps the failures in the login_failures table, so they are shared by all replicas
+type PostgresStore struct {
+	db *bun.DB
+}
+
+func NewPostgresStore(db *bun.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (Entry, error) {
+	row := &models.LoginFailure{}
+	err := s.db.NewSelect().Model(row).Where("key = ?", key).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, nil
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	return toEntry(row), nil
+}
+
+func (s *PostgresStore) Fail(ctx context.Context, key string, now, resetBefore time.Time) (Entry, error) {
+	row := &models.LoginFailure{
+		Key:           key,
+		Failures:      1,
+		LastFailureAt: bun.NullTime{Time: now},
+	}
+	// the failures are counted in the upsert so concurrent attempts on different replicas are all counted
+	_, err := s.db.NewInsert().Model(row).
+		On("CONFLICT (key) DO UPDATE").
+		Set("failures = CASE WHEN login_failure.last_failure_at < ? THEN 1 ELSE login_failure.failures + 1 END", resetBefore).
+		Set("last_failure_at = EXCLUDED.last_failure_at").
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return Entry{}, err
+	}
+	return toEntry(row), nil
+}
+
+func (s *PostgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := s.db.NewUpdate().Model((*models.LoginFailure)(nil)).
+		Set("locked_until = ?", until).
+		Where("key = ?", key).
+		Where("locked_until IS NULL OR locked_until < ?", until).
+		Exec(ctx)
+	return err
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.NewDelete().Model((*models.LoginFailure)(nil)).Where("key = ?", key).Exec(ctx)
+	return err
+}
+
+func (s *PostgresStore) Prune(ctx context.Context, before, now time.Time) error {
+	_, err := s.db.NewDelete().Model((*models.LoginFailure)(nil)).
+		Where("last_failure_at < ?", before).
+		Where("locked_until IS NULL OR locked_until <= ?", now).
+		Exec(ctx)
+	return err
+}
+
+func toEntry(row *models.LoginFailure) Entry {
+	return Entry{
+		Failures:      row.Failures,
+		LastFailureAt: row.LastFailureAt.Time,
+		LockedUntil:   row.LockedUntil.Time,
+	}
+}
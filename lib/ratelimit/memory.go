@@ -0,0 +1,37 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryStoreSweepSize is the number of keys after which keys with a full bucket are removed
+const memoryStoreSweepSize = 10000
+
+// MemoryStore keeps the buckets in memory, it is only suited for a single instance
+type MemoryStore struct {
+	mu   sync.Mutex
+	tats map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tats: map[string]time.Time{}}
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, now time.Time, emission, tolerance time.Duration) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.tats) >= memoryStoreSweepSize {
+		for k, tat := range s.tats {
+			if tat.Before(now) {
+				delete(s.tats, k)
+			}
+		}
+	}
+	tat, allowed := nextTAT(s.tats[key], now, emission, tolerance)
+	if allowed {
+		s.tats[key] = tat
+	}
+	return tat, allowed, nil
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// allowQuery is the GCRA step of nextTAT in a single upsert, so concurrent requests on different replicas are all counted.
+// Times are in microseconds. New keys are always allowed, as every limit allows at least one request.
+const allowQuery = `
+INSERT INTO rate_limits AS rate_limit (key, tat, allowed) VALUES (?0, ?1 + ?2, true)
+ON CONFLICT (key) DO UPDATE SET
+	allowed = greatest(rate_limit.tat, ?1) + ?2 - ?1 <= ?3,
+	tat = CASE WHEN greatest(rate_limit.tat, ?1) + ?2 - ?1 <= ?3 THEN greatest(rate_limit.tat, ?1) + ?2 ELSE rate_limit.tat END
+RETURNING tat, allowed`
+
+// PostgresStore keeps the buckets in the rate_limits table
+type PostgresStore struct {
+	db *bun.DB
+}
+
+func NewPostgresStore(db *bun.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Allow(ctx context.Context, key string, now time.Time, emission, tolerance time.Duration) (time.Time, bool, error) {
+	var row struct {
+		Tat     int64 `bun:"tat"`
+		Allowed bool  `bun:"allowed"`
+	}
+	err := s.db.NewRaw(allowQuery, key, now.UnixMicro(), emission.Microseconds(), tolerance.Microseconds()).Scan(ctx, &row)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMicro(row.Tat), row.Allowed, nil
+}
+
+// Prune removes the keys whose bucket is full again at now
+func (s *PostgresStore) Prune(ctx context.Context, now time.Time) error {
+	_, err := s.db.NewDelete().TableExpr("rate_limits").Where("tat < ?", now.UnixMicro()).Exec(ctx)
+	return err
+}
@@ -0,0 +1,108 @@
+// Package ratelimit limits requests per key with the generic cell rate algorithm (GCRA), which behaves like a token bucket
+// but only needs to store a single timestamp per key. The timestamps are kept in a Store, which has to be shared by
+// all replicas (e.g. Postgres or Redis) so that a limit applies to all of them together.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Endpoint classes have their own buckets, so e.g. payments don't use up the limit of other requests
+const (
+	ClassAPI      = "api"
+	ClassPayments = "payments"
+	ClassAuth     = "auth"
+	ClassPublic   = "public"
+)
+
+// Store keeps the theoretical arrival time (TAT) of every key
+type Store interface {
+	// Allow takes a request of key at now. The request is allowed if the TAT after it, at least now plus emission,
+	// is no more than tolerance ahead of now, only then the new TAT is stored. It returns the TAT after the request.
+	Allow(ctx context.Context, key string, now time.Time, emission, tolerance time.Duration) (tat time.Time, allowed bool, err error)
+}
+
+// Limit allows Rate requests per second on average and Burst requests at once
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled returns false if the limit doesn't limit anything
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Result is the state of the bucket of a key after a request
+type Result struct {
+	Allowed bool
+	Limit   int
+	// Remaining is the number of requests that can be made right now
+	Remaining int
+	// ResetAfter is the time until the bucket is full again
+	ResetAfter time.Duration
+	// RetryAfter is the time until the next request is allowed, 0 if the request was allowed
+	RetryAfter time.Duration
+}
+
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Take takes a request of key from its bucket
+func (l *Limiter) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := l.now()
+	emission := time.Duration(float64(time.Second) / limit.Rate)
+	tolerance := emission * time.Duration(limit.Burst)
+	tat, allowed, err := l.store.Allow(ctx, key, now, emission, tolerance)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		Allowed:    allowed,
+		Limit:      limit.Burst,
+		ResetAfter: tat.Sub(now),
+	}
+	if result.ResetAfter < 0 {
+		result.ResetAfter = 0
+	}
+	if allowed {
+		result.Remaining = int(math.Floor(float64(tolerance-result.ResetAfter) / float64(emission)))
+	} else {
+		result.RetryAfter = result.ResetAfter + emission - tolerance
+	}
+	return result, nil
+}
+
+// nextTAT applies a request at now to the TAT of a key
+func nextTAT(tat, now time.Time, emission, tolerance time.Duration) (time.Time, bool) {
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(emission)
+	if next.Sub(now) > tolerance {
+		return tat, false
+	}
+	return next, true
+}
+
+// Pruner is implemented by stores that don't expire keys by themselves
+type Pruner interface {
+	// Prune removes the keys whose bucket is full again at now
+	Prune(ctx context.Context, now time.Time) error
+}
+
+// Prune removes the keys whose bucket is full again from the store, if it doesn't expire them by itself
+func (l *Limiter) Prune(ctx context.Context) error {
+	if pruner, ok := l.store.(Pruner); ok {
+		return pruner.Prune(ctx, l.now())
+	}
+	return nil
+}
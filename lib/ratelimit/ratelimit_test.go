@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 20, 12, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(NewMemoryStore())
+	limiter.now = func() time.Time { return now }
+	limit := Limit{Rate: 1, Burst: 3}
+
+	// a full bucket allows a burst
+	for remaining := 2; remaining >= 0; remaining-- {
+		result, err := limiter.Take(ctx, "user:1", limit)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, remaining, result.Remaining)
+	}
+	result, err := limiter.Take(ctx, "user:1", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, time.Second, result.RetryAfter)
+	assert.Equal(t, 3*time.Second, result.ResetAfter)
+
+	// other keys have their own bucket
+	result, err = limiter.Take(ctx, "user:2", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	// the bucket refills at the rate
+	now = now.Add(time.Second)
+	result, err = limiter.Take(ctx, "user:1", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	result, err = limiter.Take(ctx, "user:1", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	now = now.Add(time.Minute)
+	result, err = limiter.Take(ctx, "user:1", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+	assert.Equal(t, time.Second, result.ResetAfter)
+}
+
+func TestEnabled(t *testing.T) {
+	assert.True(t, Limit{Rate: 0.5, Burst: 1}.Enabled())
+	assert.False(t, Limit{Rate: 0, Burst: 10}.Enabled())
+	assert.False(t, Limit{Rate: 10, Burst: 0}.Enabled())
+}
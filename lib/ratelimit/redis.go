@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// allowScript is the GCRA step of nextTAT, run atomically in Redis. Times are in microseconds.
+// Keys expire once their bucket is full again.
+var allowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local emission = tonumber(ARGV[2])
+local tolerance = tonumber(ARGV[3])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local next = tat + emission
+if next - now > tolerance then
+	return {tat, 0}
+end
+redis.call('SET', KEYS[1], next, 'PX', math.ceil((next - now) / 1000))
+return {next, 1}
+`)
+
+// RedisStore keeps the buckets in Redis, or any server that speaks its protocol and supports scripts
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Allow(ctx context.Context, key string, now time.Time, emission, tolerance time.Duration) (time.Time, bool, error) {
+	res, err := allowScript.Run(ctx, s.client, []string{s.prefix + key}, now.UnixMicro(), emission.Microseconds(), tolerance.Microseconds()).Int64Slice()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if len(res) != 2 {
+		return time.Time{}, false, fmt.Errorf("unexpected rate limit script result %v", res)
+	}
+	return time.UnixMicro(res[0]), res[1] == 1, nil
+}
@@ -92,6 +92,13 @@ var SendExceededError = ErrorResponse{
 	HttpStatusCode: 400,
 }
 
+var BudgetExceededError = ErrorResponse{
+	Error:          true,
+	Code:           2,
+	Message:        "budget exceeded. the remaining budget of this token is too low for this payment.",
+	HttpStatusCode: 400,
+}
+
 var AccountDeactivatedError = ErrorResponse{
 	Error:          true,
 	Code:           1,
@@ -106,6 +113,41 @@ var TooManyRequestsError = ErrorResponse{
 	HttpStatusCode: 429,
 }
 
+var LoginLockedError = ErrorResponse{
+	Error:          true,
+	Code:           1,
+	Message:        "too many failed login attempts. please try again later.",
+	HttpStatusCode: 429,
+}
+
+var PaymentsLockedError = ErrorResponse{
+	Error:          true,
+	Code:           2,
+	Message:        "payments are locked after repeatedly exceeding limits. please try again later.",
+	HttpStatusCode: 429,
+}
+
+var TOTPRequiredError = ErrorResponse{
+	Error:          true,
+	Code:           1,
+	Message:        "totp code required",
+	HttpStatusCode: 401,
+}
+
+var InvalidTOTPCodeError = ErrorResponse{
+	Error:          true,
+	Code:           1,
+	Message:        "invalid totp code",
+	HttpStatusCode: 401,
+}
+
+var AdminPermissionError = ErrorResponse{
+	Error:          true,
+	Code:           1,
+	Message:        "your admin role does not allow this action",
+	HttpStatusCode: 403,
+}
+
 func HTTPErrorHandler(err error, c echo.Context) {
 	if c.Response().Committed {
 		return
@@ -1,9 +1,15 @@
 package security
 
 import (
+	"errors"
+	"fmt"
+
+	passwordvalidator "github.com/wagslane/go-password-validator"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrWeakPassword = errors.New("password entropy is too low")
+
 // HashPassword : Hash Password
 func HashPassword(password string) string {
 	bytes, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -11,3 +17,20 @@ func HashPassword(password string) string {
 
 	return password
 }
+
+// CheckPassword : Check a password against its hash
+func CheckPassword(hashedPassword, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
+}
+
+// ValidatePassword : Check the entropy of a new password, a minEntropy of 0 disables the check
+func ValidatePassword(password string, minEntropy int) error {
+	if minEntropy <= 0 {
+		return nil
+	}
+	entropy := passwordvalidator.GetEntropy(password)
+	if entropy < float64(minEntropy) {
+		return fmt.Errorf("%w (%f), required is %d", ErrWeakPassword, entropy, minEntropy)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package security
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePassword(t *testing.T) {
+	assert.NoError(t, ValidatePassword("123", 0))
+	assert.ErrorIs(t, ValidatePassword("123", 50), ErrWeakPassword)
+	assert.NoError(t, ValidatePassword("correct horse battery staple 42", 50))
+}
+
+func TestCheckPassword(t *testing.T) {
+	hashed := HashPassword("secret")
+	assert.True(t, CheckPassword(hashed, "secret"))
+	assert.False(t, CheckPassword(hashed, "wrong"))
+}
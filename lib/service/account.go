@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/security"
+	"github.com/getAlby/lndhub.go/lnd"
+)
+
+var (
+	ErrWrongPassword  = errors.New("wrong password")
+	ErrLoginTaken     = errors.New("login is already taken")
+	ErrBalanceNotZero = errors.New("balance is not zero")
+	ErrInvalidSweep   = errors.New("invoice amount does not sweep the balance")
+)
+
+// CheckUserPassword checks the current password of a user before changes of the account.
+// Wrong passwords count as failed logins, so passwords can't be guessed with a stolen token either.
+func (svc *LndhubService) CheckUserPassword(ctx context.Context, userId int64, password string) (*models.User, error) {
+	user, err := svc.FindUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.checkLogin(ctx, user.Login); err != nil {
+		return nil, err
+	}
+	if !security.CheckPassword(user.Password, password) {
+		if err := svc.failLogin(ctx, user.Login, user, "wrong password"); errors.Is(err, ErrLoginLocked) {
+			return nil, err
+		}
+		return nil, ErrWrongPassword
+	}
+	return user, nil
+}
+
+// ChangePassword sets a new password after checking the current one.
+// Users that enrolled TOTP with require_on_password_change also need a code.
+func (svc *LndhubService) ChangePassword(ctx context.Context, userId int64, currentPassword, newPassword, totpCode string) (*models.User, error) {
+	if _, err := svc.CheckUserPassword(ctx, userId, currentPassword); err != nil {
+		return nil, err
+	}
+	// weak passwords are rejected before a TOTP code is used up
+	if err := security.ValidatePassword(newPassword, svc.Config.MinPasswordEntropy); err != nil {
+		return nil, err
+	}
+	if err := svc.RequireTOTPForPasswordChange(ctx, userId, totpCode); err != nil {
+		return nil, err
+	}
+	return svc.UpdateUser(ctx, userId, nil, &newPassword, nil, nil)
+}
+
+// ChangeLogin sets a new login after checking the password
+func (svc *LndhubService) ChangeLogin(ctx context.Context, userId int64, password, login string) (*models.User, error) {
+	if _, err := svc.CheckUserPassword(ctx, userId, password); err != nil {
+		return nil, err
+	}
+	existing, err := svc.FindUserByLogin(ctx, login)
+	if err == nil && existing.ID != userId {
+		return nil, ErrLoginTaken
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return svc.UpdateUser(ctx, userId, &login, nil, nil, nil)
+}
+
+// SweepAmount returns the amount of a payment of the whole balance to the destination,
+// the fee reserve and the service fee are kept back
+func (svc *LndhubService) SweepAmount(balance int64, destination string) int64 {
+	amount := balance - svc.CalcServiceFee(balance)
+	if svc.Config.FeeReserve {
+		amount -= svc.CalcFeeLimit(destination, balance)
+	}
+	if amount < 0 {
+		return 0
+	}
+	return amount
+}
+
+// PrepareSweep sets the amount of an amountless invoice to the balance of the default wallet,
+// invoices with an amount must pay at least as much. It returns the balance that may be left after the payment.
+func (svc *LndhubService) PrepareSweep(ctx context.Context, userId int64, lnPayReq *lnd.LNPayReq) (int64, error) {
+	balance, err := svc.WalletBalance(ctx, userId, 0)
+	if err != nil {
+		return 0, err
+	}
+	amount := svc.SweepAmount(balance, lnPayReq.PayReq.Destination)
+	if amount <= 0 {
+		return 0, ErrInvalidSweep
+	}
+	if lnPayReq.PayReq.NumSatoshis == 0 {
+		lnPayReq.PayReq.NumSatoshis = amount
+	}
+	if lnPayReq.PayReq.NumSatoshis < amount {
+		return 0, ErrInvalidSweep
+	}
+	return balance - lnPayReq.PayReq.NumSatoshis, nil
+}
+
+// DeleteAccount marks the user as deleted if all wallets are empty.
+// maxRemainder is the balance that may be left in the default wallet after a sweep, like an unused fee reserve.
+// The caller must have checked the password.
+func (svc *LndhubService) DeleteAccount(ctx context.Context, userId int64, maxRemainder int64) (*models.User, error) {
+	balance, err := svc.WalletBalance(ctx, userId, 0)
+	if err != nil {
+		return nil, err
+	}
+	if balance < 0 || balance > maxRemainder {
+		return nil, ErrBalanceNotZero
+	}
+	wallets, err := svc.WalletsFor(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, wallet := range wallets {
+		balance, err := svc.WalletBalance(ctx, userId, wallet.ID)
+		if err != nil {
+			return nil, err
+		}
+		if balance != 0 {
+			return nil, ErrBalanceNotZero
+		}
+	}
+	deleted := true
+	return svc.UpdateUser(ctx, userId, nil, nil, nil, &deleted)
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/schema"
+)
+
+var (
+	ErrInvalidAdjustment      = errors.New("invalid adjustment")
+	ErrUserNotFound           = errors.New("user not found")
+	ErrInvoiceNotFound        = errors.New("invoice not found")
+	ErrInvoiceNotRefundable   = errors.New("only settled outgoing invoices can be refunded")
+	ErrInvoiceAlreadyRefunded = errors.New("invoice was already refunded")
+)
+
+const (
+	adjustmentMemo = "Balance adjustment"
+	refundMemo     = "Refund"
+)
+
+// adjustmentActions are the admin audit log actions of the adjustment types
+var adjustmentActions = map[string]string{
+	models.LedgerAdjustmentTypeCredit: "credit_user",
+	models.LedgerAdjustmentTypeDebit:  "debit_user",
+	models.LedgerAdjustmentTypeRefund: "refund_invoice",
+}
+
+// LedgerAdjustment is a manual credit or debit of a user balance by an admin
+type LedgerAdjustment struct {
+	UserID    int64
+	WalletID  int64
+	Type      string
+	Amount    int64
+	Reason    string
+	Reference string
+}
+
+// AdjustBalance credits or debits the current account of a user from the operator account of the wallet.
+// Operator accounts can go negative, the DB constraints make sure a debit does not exceed the user balance.
+func (svc *LndhubService) AdjustBalance(ctx context.Context, adjustment LedgerAdjustment) (*models.LedgerAdjustment, error) {
+	if adjustment.Amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidAdjustment)
+	}
+	if adjustment.Reason == "" || adjustment.Reference == "" {
+		return nil, fmt.Errorf("%w: reason and reference are required", ErrInvalidAdjustment)
+	}
+	invoiceType, entryType := common.InvoiceTypeIncoming, models.EntryTypeAdminCredit
+	switch adjustment.Type {
+	case models.LedgerAdjustmentTypeCredit:
+	case models.LedgerAdjustmentTypeDebit:
+		invoiceType, entryType = common.InvoiceTypeOutgoing, models.EntryTypeAdminDebit
+	default:
+		return nil, fmt.Errorf("%w: unknown type %s", ErrInvalidAdjustment, adjustment.Type)
+	}
+	if adjustment.WalletID != 0 {
+		if _, err := svc.FindWallet(ctx, adjustment.UserID, adjustment.WalletID); err != nil {
+			return nil, err
+		}
+	}
+	record := &models.LedgerAdjustment{
+		UserID:    adjustment.UserID,
+		WalletID:  adjustment.WalletID,
+		Type:      adjustment.Type,
+		Amount:    adjustment.Amount,
+		Reason:    adjustment.Reason,
+		Reference: adjustment.Reference,
+	}
+	var invoice *models.Invoice
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) (err error) {
+		invoice, err = svc.insertAdjustment(ctx, tx, record, invoiceType, entryType, adjustmentMemo)
+		return err
+	})
+	if err != nil {
+		svc.Logger.Errorf("Ledger adjustment failed user_id:%v type:%v amount:%v error: %v", adjustment.UserID, adjustment.Type, adjustment.Amount, err)
+		return nil, err
+	}
+	svc.InvoicePubSub.Publish(strconv.FormatInt(invoice.UserID, 10), *invoice)
+	return record, nil
+}
+
+// RefundInvoice credits the amount and the fee of a settled outgoing invoice back to the wallet that paid it.
+// An invoice can only be refunded once.
+func (svc *LndhubService) RefundInvoice(ctx context.Context, userId int64, paymentHash, reason, reference string) (*models.LedgerAdjustment, error) {
+	if reason == "" || reference == "" {
+		return nil, fmt.Errorf("%w: reason and reference are required", ErrInvalidAdjustment)
+	}
+	record := &models.LedgerAdjustment{
+		UserID:    userId,
+		Type:      models.LedgerAdjustmentTypeRefund,
+		Reason:    reason,
+		Reference: reference,
+	}
+	var refund *models.Invoice
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		invoice := models.Invoice{}
+		err := tx.NewSelect().Model(&invoice).
+			Where("user_id = ? AND r_hash = ? AND type = ?", userId, paymentHash, common.InvoiceTypeOutgoing).
+			OrderExpr("id DESC").
+			Limit(1).
+			For("UPDATE").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvoiceNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if invoice.State != common.InvoiceStateSettled {
+			return ErrInvoiceNotRefundable
+		}
+		// adjustments are corrected with another adjustment, not with a refund
+		adjusted, err := tx.NewSelect().Model((*models.LedgerAdjustment)(nil)).Where("invoice_id = ?", invoice.ID).Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if adjusted {
+			return ErrInvoiceNotRefundable
+		}
+		refunded, err := tx.NewSelect().Model((*models.LedgerAdjustment)(nil)).Where("refunded_invoice_id = ?", invoice.ID).Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if refunded {
+			return ErrInvoiceAlreadyRefunded
+		}
+		record.WalletID = invoice.WalletID
+		record.Amount = invoice.Amount + invoice.Fee
+		record.RefundedInvoiceID = invoice.ID
+		refund, err = svc.insertAdjustment(ctx, tx, record, common.InvoiceTypeIncoming, models.EntryTypeRefund, refundMemo)
+		return err
+	})
+	if err != nil {
+		svc.Logger.Errorf("Refund failed user_id:%v payment_hash:%v error: %v", userId, paymentHash, err)
+		return nil, err
+	}
+	svc.InvoicePubSub.Publish(strconv.FormatInt(refund.UserID, 10), *refund)
+	return record, nil
+}
+
+// LedgerAdjustmentsFor returns the adjustments of a user, newest first
+func (svc *LndhubService) LedgerAdjustmentsFor(ctx context.Context, userId int64) ([]models.LedgerAdjustment, error) {
+	adjustments := []models.LedgerAdjustment{}
+	err := svc.DB.NewSelect().Model(&adjustments).Where("user_id = ?", userId).OrderExpr("id DESC").Scan(ctx)
+	return adjustments, err
+}
+
+// insertAdjustment writes a settled internal invoice for the history of the user, the ledger entry and the audit record
+func (svc *LndhubService) insertAdjustment(ctx context.Context, tx bun.Tx, record *models.LedgerAdjustment, invoiceType, entryType, memo string) (*models.Invoice, error) {
+	// lock the user so that concurrent adjustments don't create the operator account twice
+	user := models.User{}
+	err := tx.NewSelect().Model(&user).Where("id = ?", record.UserID).For("UPDATE").Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	current, err := svc.WalletAccountFor(ctx, common.AccountTypeCurrent, record.UserID, record.WalletID)
+	if err != nil {
+		return nil, err
+	}
+	operator, err := svc.operatorAccount(ctx, tx, record.UserID, record.WalletID)
+	if err != nil {
+		return nil, err
+	}
+	var balance int64
+	err = tx.NewSelect().Table("account_ledgers").ColumnExpr("coalesce(sum(account_ledgers.amount), 0)").Where("account_ledgers.account_id = ?", current.ID).Scan(ctx, &balance)
+	if err != nil {
+		return nil, err
+	}
+
+	preimage, err := makePreimageHex()
+	if err != nil {
+		return nil, err
+	}
+	paymentHash := sha256.Sum256(preimage)
+	invoice := &models.Invoice{
+		Type:                 invoiceType,
+		UserID:               record.UserID,
+		WalletID:             record.WalletID,
+		Amount:               record.Amount,
+		Memo:                 memo,
+		DestinationPubkeyHex: svc.LndClient.GetMainPubkey(),
+		RHash:                hex.EncodeToString(paymentHash[:]),
+		Preimage:             hex.EncodeToString(preimage),
+		Internal:             true,
+		State:                common.InvoiceStateSettled,
+		SettledAt:            schema.NullTime{Time: time.Now()},
+	}
+	if _, err := tx.NewInsert().Model(invoice).Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	entry := models.TransactionEntry{
+		UserID:          record.UserID,
+		InvoiceID:       invoice.ID,
+		CreditAccountID: current.ID,
+		DebitAccountID:  operator.ID,
+		Amount:          record.Amount,
+		EntryType:       entryType,
+	}
+	if record.Type == models.LedgerAdjustmentTypeDebit {
+		entry.CreditAccountID, entry.DebitAccountID = operator.ID, current.ID
+	}
+	if _, err := tx.NewInsert().Model(&entry).Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	record.InvoiceID = invoice.ID
+	record.TransactionEntryID = entry.ID
+	if _, err := tx.NewInsert().Model(record).Exec(ctx); err != nil {
+		return nil, err
+	}
+	after := balance + record.Amount
+	if record.Type == models.LedgerAdjustmentTypeDebit {
+		after = balance - record.Amount
+	}
+	err = svc.recordAdminAction(ctx, tx, adjustmentActions[record.Type], record.UserID, "",
+		map[string]interface{}{"wallet_id": record.WalletID, "balance": balance},
+		map[string]interface{}{"wallet_id": record.WalletID, "balance": after, "ledger_adjustment_id": record.ID})
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// operatorAccount returns the operator account of a wallet, it is created with the first adjustment
+func (svc *LndhubService) operatorAccount(ctx context.Context, tx bun.Tx, userId, walletId int64) (models.Account, error) {
+	account := models.Account{}
+	query := tx.NewSelect().Model(&account).Where("user_id = ? AND type = ?", userId, common.AccountTypeOperator)
+	if walletId == 0 {
+		query.Where("wallet_id IS NULL")
+	} else {
+		query.Where("wallet_id = ?", walletId)
+	}
+	err := query.Limit(1).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		account = models.Account{UserID: userId, WalletID: walletId, Type: common.AccountTypeOperator}
+		_, err = tx.NewInsert().Model(&account).Exec(ctx)
+	}
+	return account, err
+}
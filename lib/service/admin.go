@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/uptrace/bun"
+)
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+var ErrNoUsersGiven = errors.New("no user ids given")
+
+// likeEscaper escapes the wildcards of LIKE patterns, backslash is the default escape character of postgres
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// containsPattern returns a LIKE pattern that matches values containing s literally
+func containsPattern(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+// Page is the limit and offset of a paginated admin list
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+func (p Page) apply(query *bun.SelectQuery) *bun.SelectQuery {
+	limit := p.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	return query.Limit(limit).Offset(p.Offset)
+}
+
+// UserVolumes are the settled amounts of a user over the volume limit period
+type UserVolumes struct {
+	Period   time.Duration
+	Sent     int64
+	Received int64
+}
+
+// SearchUsers returns users whose login or email contains query, all users for an empty query, and the total count
+func (svc *LndhubService) SearchUsers(ctx context.Context, query string, page Page) ([]models.User, int, error) {
+	users := []models.User{}
+	q := svc.DB.NewSelect().Model(&users).Order("id")
+	if query != "" {
+		pattern := containsPattern(query)
+		q.Where("login ILIKE ? OR email ILIKE ?", pattern, pattern)
+	}
+	count, err := page.apply(q).ScanAndCount(ctx)
+	return users, count, err
+}
+
+// VolumesFor returns the volumes of a user over the period of the volume limits
+func (svc *LndhubService) VolumesFor(ctx context.Context, userId int64) (*UserVolumes, error) {
+	volumes := &UserVolumes{Period: time.Duration(svc.Config.MaxVolumePeriod * int64(time.Second))}
+	var err error
+	volumes.Sent, err = svc.GetVolumeOverPeriod(ctx, userId, common.InvoiceTypeOutgoing, volumes.Period)
+	if err != nil {
+		return nil, err
+	}
+	volumes.Received, err = svc.GetVolumeOverPeriod(ctx, userId, common.InvoiceTypeIncoming, volumes.Period)
+	if err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
+
+// PagedInvoicesFor returns the invoices of a user in all wallets, newest first, optionally only of one type
+func (svc *LndhubService) PagedInvoicesFor(ctx context.Context, userId int64, invoiceType string, page Page) ([]models.Invoice, int, error) {
+	invoices := []models.Invoice{}
+	query := svc.DB.NewSelect().Model(&invoices).Where("user_id = ?", userId).OrderExpr("id DESC")
+	if invoiceType != "" {
+		query.Where("type = ?", invoiceType)
+	}
+	count, err := page.apply(query).ScanAndCount(ctx)
+	return invoices, count, err
+}
+
+// PagedTransactionEntriesFor returns a page of the entries of TransactionEntriesFor, newest first
+func (svc *LndhubService) PagedTransactionEntriesFor(ctx context.Context, userId int64, page Page) ([]models.TransactionEntry, int, error) {
+	transactionEntries := []models.TransactionEntry{}
+	query := svc.transactionEntriesQuery(&transactionEntries, userId).OrderExpr("id DESC")
+	count, err := page.apply(query).ScanAndCount(ctx)
+	return transactionEntries, count, err
+}
+
+// SetUsersDeactivated deactivates or reactivates users and returns the updated users.
+// Deleted users stay deactivated.
+func (svc *LndhubService) SetUsersDeactivated(ctx context.Context, userIds []int64, deactivated bool) ([]models.User, error) {
+	if len(userIds) == 0 {
+		return nil, ErrNoUsersGiven
+	}
+	users := []models.User{}
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		query := tx.NewSelect().Model(&users).Where("id IN (?)", bun.In(userIds)).Order("id").For("UPDATE")
+		if !deactivated {
+			query.Where("deleted = false")
+		}
+		if err := query.Scan(ctx); err != nil {
+			return err
+		}
+		action := "reactivate_user"
+		if deactivated {
+			action = "deactivate_user"
+		}
+		for i := range users {
+			before := userSnapshot(&users[i])
+			users[i].Deactivated = deactivated
+			if _, err := tx.NewUpdate().Model(&users[i]).Column("deactivated", "updated_at").WherePK().Exec(ctx); err != nil {
+				return err
+			}
+			after := userSnapshot(&users[i])
+			if err := svc.recordAdminAction(ctx, tx, action, users[i].ID, "", before, after); err != nil {
+				return err
+			}
+			if err := svc.recordUserChanges(ctx, tx, &users[i], before, after); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -0,0 +1,15 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainsPattern(t *testing.T) {
+	assert.Equal(t, "%alice%", containsPattern("alice"))
+	// wildcards in the search query match literally
+	assert.Equal(t, `%100\%%`, containsPattern("100%"))
+	assert.Equal(t, `%bob\_smith%`, containsPattern("bob_smith"))
+	assert.Equal(t, `%back\\slash%`, containsPattern(`back\slash`))
+}
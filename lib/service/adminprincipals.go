@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+const (
+	AdminRoleSupportReadOnly = "support_read_only"
+	AdminRoleSupportRefunds  = "support_refunds"
+	AdminRoleOperator        = "operator"
+	AdminRoleSuperadmin      = "superadmin"
+)
+
+const (
+	// PermissionRead allows to inspect users, their ledger and the cluster
+	PermissionRead = "read"
+	// PermissionRefund allows to refund payments
+	PermissionRefund = "refund"
+	// PermissionAdjustBalance allows to credit and debit users
+	PermissionAdjustBalance = "adjust_balance"
+	// PermissionManageUsers allows to create, update, deactivate and reactivate users
+	PermissionManageUsers = "manage_users"
+	// PermissionManageCluster allows to switch and drain LND cluster nodes
+	PermissionManageCluster = "manage_cluster"
+	// PermissionManageAdmins allows to manage admin principals and to read the admin audit log
+	PermissionManageAdmins = "manage_admins"
+)
+
+// rolePermissions are the permissions of every admin role, every role can do what the roles before it can do
+var rolePermissions = map[string][]string{
+	AdminRoleSupportReadOnly: {PermissionRead},
+	AdminRoleSupportRefunds:  {PermissionRead, PermissionRefund},
+	AdminRoleOperator:        {PermissionRead, PermissionRefund, PermissionAdjustBalance, PermissionManageUsers, PermissionManageCluster},
+	AdminRoleSuperadmin:      {PermissionRead, PermissionRefund, PermissionAdjustBalance, PermissionManageUsers, PermissionManageCluster, PermissionManageAdmins},
+}
+
+// adminTokenActor is the name of the principal that uses the static ADMIN_TOKEN, it is a superadmin
+const adminTokenActor = "admin_token"
+
+var (
+	ErrAdminPrincipalNotFound = errors.New("admin principal not found")
+	ErrInvalidAdminPrincipal  = errors.New("invalid admin principal")
+)
+
+// HasPermission returns true if the role of the principal grants the permission
+func HasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type adminPrincipalContextKey struct{}
+
+// WithAdminPrincipal returns a context for the actions of an admin, they are recorded in the admin audit log
+func WithAdminPrincipal(ctx context.Context, principal *models.AdminPrincipal) context.Context {
+	return context.WithValue(ctx, adminPrincipalContextKey{}, principal)
+}
+
+// AdminPrincipalFromContext returns the admin of ctx, nil if the action is not made by an admin
+func AdminPrincipalFromContext(ctx context.Context) *models.AdminPrincipal {
+	principal, _ := ctx.Value(adminPrincipalContextKey{}).(*models.AdminPrincipal)
+	return principal
+}
+
+// CreateAdminPrincipal creates an admin with a role and returns its token, only a hash of the token is stored
+func (svc *LndhubService) CreateAdminPrincipal(ctx context.Context, name, role string) (*models.AdminPrincipal, string, error) {
+	if name == "" || name == adminTokenActor {
+		return nil, "", fmt.Errorf("%w: invalid name", ErrInvalidAdminPrincipal)
+	}
+	if _, ok := rolePermissions[role]; !ok {
+		return nil, "", fmt.Errorf("%w: unknown role %s", ErrInvalidAdminPrincipal, role)
+	}
+	exists, err := svc.DB.NewSelect().Model((*models.AdminPrincipal)(nil)).Where("name = ?", name).Exists(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if exists {
+		return nil, "", fmt.Errorf("%w: name is taken", ErrInvalidAdminPrincipal)
+	}
+	tokenBytes, err := randBytesFromStr(40, alphaNumBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	token := string(tokenBytes)
+	principal := &models.AdminPrincipal{
+		Name:      name,
+		Role:      role,
+		TokenHash: hashAdminToken(token),
+	}
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(principal).Exec(ctx); err != nil {
+			return err
+		}
+		return svc.recordAdminAction(ctx, tx, "create_admin_principal", 0, principal.Name, nil, adminPrincipalSnapshot(principal))
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return principal, token, nil
+}
+
+func (svc *LndhubService) AdminPrincipals(ctx context.Context) ([]models.AdminPrincipal, error) {
+	principals := []models.AdminPrincipal{}
+	err := svc.DB.NewSelect().Model(&principals).Order("id").Scan(ctx)
+	return principals, err
+}
+
+// RevokeAdminPrincipal revokes an admin, its token can't be used anymore
+func (svc *LndhubService) RevokeAdminPrincipal(ctx context.Context, id int64) (*models.AdminPrincipal, error) {
+	principal := &models.AdminPrincipal{}
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().Model(principal).Where("id = ? AND revoked_at IS NULL", id).For("UPDATE").Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAdminPrincipalNotFound
+		}
+		if err != nil {
+			return err
+		}
+		before := adminPrincipalSnapshot(principal)
+		principal.RevokedAt = bun.NullTime{Time: time.Now()}
+		if _, err := tx.NewUpdate().Model(principal).Column("revoked_at", "updated_at").WherePK().Exec(ctx); err != nil {
+			return err
+		}
+		return svc.recordAdminAction(ctx, tx, "revoke_admin_principal", 0, principal.Name, before, adminPrincipalSnapshot(principal))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
+
+// FindAdminPrincipalByToken returns the admin of a token, the static ADMIN_TOKEN is a superadmin
+func (svc *LndhubService) FindAdminPrincipalByToken(ctx context.Context, token string) (*models.AdminPrincipal, error) {
+	if token == "" {
+		return nil, ErrAdminPrincipalNotFound
+	}
+	if svc.Config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(svc.Config.AdminToken)) == 1 {
+		return &models.AdminPrincipal{Name: adminTokenActor, Role: AdminRoleSuperadmin}, nil
+	}
+	principal := &models.AdminPrincipal{}
+	err := svc.DB.NewSelect().Model(principal).Where("token_hash = ? AND revoked_at IS NULL", hashAdminToken(token)).Limit(1).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAdminPrincipalNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
+
+// AdminMiddleware authenticates admins with the token in the Authorization header and checks that their role grants the permission.
+// Without ADMIN_TOKEN no admin is configured and the endpoint is public.
+func (svc *LndhubService) AdminMiddleware(permission string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if svc.Config.AdminToken == "" {
+			return next
+		}
+		return func(c echo.Context) error {
+			token := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			principal, err := svc.FindAdminPrincipalByToken(c.Request().Context(), token)
+			if errors.Is(err, ErrAdminPrincipalNotFound) {
+				return c.JSON(http.StatusUnauthorized, responses.BadAuthError)
+			}
+			if err != nil {
+				c.Logger().Errorf("Failed to authenticate admin: %v", err)
+				return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+			}
+			if !HasPermission(principal.Role, permission) {
+				return c.JSON(http.StatusForbidden, responses.AdminPermissionError)
+			}
+			ctx := WithClientIP(WithAdminPrincipal(c.Request().Context(), principal), c.RealIP())
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// RecordAdminAction writes an action of the admin of ctx with the state of the target before and after it to the admin audit log.
+// Actions that are not made by an admin are not recorded.
+func (svc *LndhubService) RecordAdminAction(ctx context.Context, action string, targetUserId int64, target string, before, after interface{}) error {
+	return svc.recordAdminAction(ctx, svc.DB, action, targetUserId, target, before, after)
+}
+
+func (svc *LndhubService) recordAdminAction(ctx context.Context, db bun.IDB, action string, targetUserId int64, target string, before, after interface{}) error {
+	principal := AdminPrincipalFromContext(ctx)
+	if principal == nil {
+		return nil
+	}
+	entry := &models.AdminAuditLog{
+		PrincipalID:  principal.ID,
+		Actor:        principal.Name,
+		Role:         principal.Role,
+		Action:       action,
+		TargetUserID: targetUserId,
+		Target:       target,
+		Before:       before,
+		After:        after,
+	}
+	if _, err := db.NewInsert().Model(entry).Exec(ctx); err != nil {
+		return err
+	}
+	return svc.recordAuditEvent(ctx, db, &models.AuditEvent{
+		Type:    models.AuditEventAdminAction,
+		UserID:  targetUserId,
+		Details: map[string]interface{}{"action": action, "target": target, "role": principal.Role},
+	})
+}
+
+// AdminAuditLogs returns the admin audit log newest first, optionally only the actions on a user
+func (svc *LndhubService) AdminAuditLogs(ctx context.Context, targetUserId int64, page Page) ([]models.AdminAuditLog, int, error) {
+	entries := []models.AdminAuditLog{}
+	query := svc.DB.NewSelect().Model(&entries).OrderExpr("id DESC")
+	if targetUserId != 0 {
+		query.Where("target_user_id = ?", targetUserId)
+	}
+	count, err := page.apply(query).ScanAndCount(ctx)
+	return entries, count, err
+}
+
+func hashAdminToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func adminPrincipalSnapshot(principal *models.AdminPrincipal) map[string]interface{} {
+	return map[string]interface{}{
+		"name":    principal.Name,
+		"role":    principal.Role,
+		"revoked": !principal.RevokedAt.IsZero(),
+	}
+}
+
+func userSnapshot(user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"login":       user.Login,
+		"deactivated": user.Deactivated,
+		"deleted":     user.Deleted,
+	}
+}
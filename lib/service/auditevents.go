@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+// auditExportBatchSize is the number of audit events that are loaded at once when exporting them
+const auditExportBatchSize = 1000
+
+// AuditEventFilter selects audit events, zero values match all events
+type AuditEventFilter struct {
+	UserID int64
+	Login  string
+	Type   string
+	From   time.Time
+	To     time.Time
+}
+
+func (f AuditEventFilter) apply(query *bun.SelectQuery) *bun.SelectQuery {
+	if f.UserID != 0 {
+		query.Where("user_id = ?", f.UserID)
+	}
+	if f.Login != "" {
+		query.Where("login = ?", f.Login)
+	}
+	if f.Type != "" {
+		query.Where("type = ?", f.Type)
+	}
+	if !f.From.IsZero() {
+		query.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query.Where("created_at < ?", f.To)
+	}
+	return query
+}
+
+type clientIPContextKey struct{}
+
+// WithClientIP returns a context with the IP of the client of a request, it is recorded with audit events
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPContextKey{}, ip)
+}
+
+// ClientIPFromContext returns the IP of the client of ctx, empty if there is none
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPContextKey{}).(string)
+	return ip
+}
+
+// RecordAuditEvent appends a security relevant event to the audit events.
+// The client IP and the admin that made the change are taken from ctx if the event does not have them.
+func (svc *LndhubService) RecordAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	return svc.recordAuditEvent(ctx, svc.DB, event)
+}
+
+func (svc *LndhubService) recordAuditEvent(ctx context.Context, db bun.IDB, event *models.AuditEvent) error {
+	if event.IP == "" {
+		event.IP = ClientIPFromContext(ctx)
+	}
+	if event.Actor == "" {
+		if principal := AdminPrincipalFromContext(ctx); principal != nil {
+			event.Actor = principal.Name
+		}
+	}
+	_, err := db.NewInsert().Model(event).Exec(ctx)
+	return err
+}
+
+// recordAuditEventOrLog records an event that is not part of a transaction, failing to record it does not fail the action
+func (svc *LndhubService) recordAuditEventOrLog(ctx context.Context, event *models.AuditEvent) {
+	if err := svc.RecordAuditEvent(ctx, event); err != nil {
+		svc.Logger.Errorj(log.JSON{
+			"message":        "failed to record audit event",
+			"type":           event.Type,
+			"lndhub_user_id": event.UserID,
+			"error":          err,
+		})
+	}
+}
+
+// recordUserChanges records the security relevant changes of a user between the before and after snapshots
+func (svc *LndhubService) recordUserChanges(ctx context.Context, db bun.IDB, user *models.User, before, after map[string]interface{}) error {
+	events := []*models.AuditEvent{}
+	if before["login"] != after["login"] {
+		events = append(events, &models.AuditEvent{Type: models.AuditEventLoginChanged, Details: map[string]interface{}{"from": before["login"], "to": after["login"]}})
+	}
+	if after["password_changed"] == true {
+		events = append(events, &models.AuditEvent{Type: models.AuditEventPasswordChanged})
+	}
+	if before["deleted"] != after["deleted"] {
+		events = append(events, &models.AuditEvent{Type: models.AuditEventUserDeleted})
+	} else if before["deactivated"] != after["deactivated"] {
+		eventType := models.AuditEventUserReactivated
+		if user.Deactivated {
+			eventType = models.AuditEventUserDeactivated
+		}
+		events = append(events, &models.AuditEvent{Type: eventType})
+	}
+	for _, event := range events {
+		event.UserID = user.ID
+		event.Login = user.Login
+		if err := svc.recordAuditEvent(ctx, db, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordLoginAttempt records a successful login, or a failed one with the reason
+func (svc *LndhubService) recordLoginAttempt(ctx context.Context, login string, user *models.User, failure string) {
+	event := &models.AuditEvent{Type: models.AuditEventLoginSucceeded, Login: login}
+	if user != nil {
+		event.UserID = user.ID
+	}
+	if failure != "" {
+		event.Type = models.AuditEventLoginFailed
+		event.Details = map[string]interface{}{"reason": failure}
+	}
+	svc.recordAuditEventOrLog(ctx, event)
+}
+
+// recordLimitBreach records that a payment of the user exceeded a limit and, once LOCKOUT_LIMIT_BREACHES is reached, that payments are locked
+func (svc *LndhubService) recordLimitBreach(ctx context.Context, userId int64, limitError string) {
+	svc.recordAuditEventOrLog(ctx, &models.AuditEvent{
+		Type:    models.AuditEventLimitExceeded,
+		UserID:  userId,
+		Details: map[string]interface{}{"error": limitError},
+	})
+	if svc.Config.LockoutLimitBreaches <= 0 {
+		return
+	}
+	breaches, err := svc.limitBreachesInLockoutPeriod(ctx, userId)
+	if err != nil {
+		svc.Logger.Errorj(log.JSON{"message": "failed to count limit breaches", "lndhub_user_id": userId, "error": err})
+		return
+	}
+	if breaches == svc.Config.LockoutLimitBreaches {
+		svc.recordAuditEventOrLog(ctx, &models.AuditEvent{Type: models.AuditEventPaymentsLocked, UserID: userId})
+	}
+}
+
+// PaymentsLocked returns true if the payments of the user exceeded limits LOCKOUT_LIMIT_BREACHES times within LOCKOUT_PERIOD
+func (svc *LndhubService) PaymentsLocked(ctx context.Context, userId int64) (bool, error) {
+	if svc.Config.LockoutLimitBreaches <= 0 {
+		return false, nil
+	}
+	breaches, err := svc.limitBreachesInLockoutPeriod(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	return breaches >= svc.Config.LockoutLimitBreaches, nil
+}
+
+func (svc *LndhubService) limitBreachesInLockoutPeriod(ctx context.Context, userId int64) (int, error) {
+	return svc.DB.NewSelect().Model((*models.AuditEvent)(nil)).
+		Where("user_id = ? AND type = ?", userId, models.AuditEventLimitExceeded).
+		Where("created_at >= ?", time.Now().Add(-svc.lockoutPeriod())).
+		Count(ctx)
+}
+
+func (svc *LndhubService) lockoutPeriod() time.Duration {
+	return time.Duration(svc.Config.LockoutPeriod) * time.Second
+}
+
+// AuditEvents returns the audit events that match the filter newest first, and their total count
+func (svc *LndhubService) AuditEvents(ctx context.Context, filter AuditEventFilter, page Page) ([]models.AuditEvent, int, error) {
+	events := []models.AuditEvent{}
+	query := filter.apply(svc.DB.NewSelect().Model(&events)).OrderExpr("id DESC")
+	count, err := page.apply(query).ScanAndCount(ctx)
+	return events, count, err
+}
+
+// ExportAuditEvents calls fn with every audit event that matches the filter, oldest first.
+// The events are loaded in batches so that exports of the whole log don't have to fit in memory.
+func (svc *LndhubService) ExportAuditEvents(ctx context.Context, filter AuditEventFilter, fn func(event *models.AuditEvent) error) error {
+	var lastID int64
+	for {
+		events := []models.AuditEvent{}
+		query := filter.apply(svc.DB.NewSelect().Model(&events)).
+			Where("id > ?", lastID).
+			OrderExpr("id ASC").
+			Limit(auditExportBatchSize)
+		if err := query.Scan(ctx); err != nil {
+			return err
+		}
+		for i := range events {
+			if err := fn(&events[i]); err != nil {
+				return err
+			}
+		}
+		if len(events) < auditExportBatchSize {
+			return nil
+		}
+		lastID = events[len(events)-1].ID
+	}
+}
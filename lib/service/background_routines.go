@@ -5,8 +5,8 @@ import (
 )
 
 func (svc *LndhubService) StartInvoiceRoutine(ctx context.Context) (err error) {
-	if svc.RabbitMQClient != nil {
-		err = svc.RabbitMQClient.SubscribeToLndInvoices(ctx, svc.ProcessInvoiceUpdate)
+	if svc.EventsClient != nil {
+		err = svc.EventsClient.SubscribeToLndInvoices(ctx, svc.ProcessInvoiceUpdate)
 		if err != nil && err != context.Canceled {
 			return err
 		}
@@ -24,8 +24,8 @@ func (svc *LndhubService) StartInvoiceRoutine(ctx context.Context) (err error) {
 }
 
 func (svc *LndhubService) StartPendingPaymentRoutine(ctx context.Context) (err error) {
-	if svc.RabbitMQClient != nil {
-		return svc.RabbitMQClient.FinalizeInitializedPayments(ctx, svc)
+	if svc.EventsClient != nil {
+		return svc.EventsClient.FinalizeInitializedPayments(ctx, svc)
 	} else {
 		pending, err := svc.GetAllPendingPayments(ctx)
 		if err != nil {
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+const (
+	BudgetRenewalDaily   = "daily"
+	BudgetRenewalWeekly  = "weekly"
+	BudgetRenewalMonthly = "monthly"
+	BudgetRenewalYearly  = "yearly"
+	BudgetRenewalNever   = "never"
+
+	// a rolling budget counts the payments of the last period, e.g. the last 7 days
+	BudgetRenewalTypeRolling = "rolling"
+	// a calendar budget is reset at the start of every period, e.g. every monday at 00:00 UTC
+	BudgetRenewalTypeCalendar = "calendar"
+)
+
+var (
+	ErrBudgetNotFound = errors.New("budget not found")
+	ErrBudgetExceeded = errors.New("budget exceeded")
+	ErrInvalidBudget  = errors.New("invalid budget")
+)
+
+type budgetContextKey struct{}
+
+// WithBudget returns a context for payments that are charged to a budget
+func WithBudget(ctx context.Context, budgetId int64) context.Context {
+	if budgetId == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, budgetContextKey{}, budgetId)
+}
+
+// BudgetIDFromContext returns the id of the budget payments made with ctx are charged to, 0 if there is none
+func BudgetIDFromContext(ctx context.Context) int64 {
+	budgetId, _ := ctx.Value(budgetContextKey{}).(int64)
+	return budgetId
+}
+
+// GetBudgetID returns the id of the budget the token of the request is bound to, 0 if there is none
+func (svc *LndhubService) GetBudgetID(c echo.Context) int64 {
+	budgetId, _ := c.Get("BudgetID").(int64)
+	return budgetId
+}
+
+// BudgetUsage is the state of a budget in its current period
+type BudgetUsage struct {
+	Spent       int64
+	Remaining   int64
+	PeriodStart time.Time
+	RenewsAt    time.Time
+}
+
+// BudgetPeriod returns the start of the current period of a budget and, for calendar budgets, the start of the next one.
+// Budgets that are never renewed have no period, all payments count.
+func BudgetPeriod(budget *models.Budget, now time.Time) (start, renewsAt time.Time) {
+	now = now.UTC()
+	if budget.RenewalType == BudgetRenewalTypeRolling {
+		switch budget.RenewalPeriod {
+		case BudgetRenewalDaily:
+			start = now.AddDate(0, 0, -1)
+		case BudgetRenewalWeekly:
+			start = now.AddDate(0, 0, -7)
+		case BudgetRenewalMonthly:
+			start = now.AddDate(0, -1, 0)
+		case BudgetRenewalYearly:
+			start = now.AddDate(-1, 0, 0)
+		}
+		return start, time.Time{}
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch budget.RenewalPeriod {
+	case BudgetRenewalDaily:
+		start = today
+		renewsAt = start.AddDate(0, 0, 1)
+	case BudgetRenewalWeekly:
+		// weeks start on monday
+		start = today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		renewsAt = start.AddDate(0, 0, 7)
+	case BudgetRenewalMonthly:
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		renewsAt = start.AddDate(0, 1, 0)
+	case BudgetRenewalYearly:
+		start = time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+		renewsAt = start.AddDate(1, 0, 0)
+	}
+	return start, renewsAt
+}
+
+func validateBudget(budget *models.Budget) error {
+	if budget.Amount <= 0 {
+		return fmt.Errorf("%w: amount must be greater than 0", ErrInvalidBudget)
+	}
+	switch budget.RenewalPeriod {
+	case BudgetRenewalDaily, BudgetRenewalWeekly, BudgetRenewalMonthly, BudgetRenewalYearly, BudgetRenewalNever:
+	default:
+		return fmt.Errorf("%w: unknown renewal period %s", ErrInvalidBudget, budget.RenewalPeriod)
+	}
+	switch budget.RenewalType {
+	case BudgetRenewalTypeRolling, BudgetRenewalTypeCalendar:
+	default:
+		return fmt.Errorf("%w: unknown renewal type %s", ErrInvalidBudget, budget.RenewalType)
+	}
+	return nil
+}
+
+func (svc *LndhubService) CreateBudget(ctx context.Context, budget *models.Budget) error {
+	if budget.RenewalType == "" {
+		budget.RenewalType = BudgetRenewalTypeCalendar
+	}
+	if err := validateBudget(budget); err != nil {
+		return err
+	}
+	_, err := svc.DB.NewInsert().Model(budget).Exec(ctx)
+	return err
+}
+
+// BudgetsFor returns the budgets of a user that were not revoked
+func (svc *LndhubService) BudgetsFor(ctx context.Context, userId int64) ([]models.Budget, error) {
+	budgets := []models.Budget{}
+	err := svc.DB.NewSelect().Model(&budgets).Where("user_id = ? AND revoked_at IS NULL", userId).Order("id").Scan(ctx)
+	return budgets, err
+}
+
+// FindBudget returns a budget of a user that was not revoked
+func (svc *LndhubService) FindBudget(ctx context.Context, userId, id int64) (*models.Budget, error) {
+	var budget models.Budget
+	err := svc.DB.NewSelect().Model(&budget).Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userId).Limit(1).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrBudgetNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &budget, nil
+}
+
+// RevokeBudget revokes a budget, the tokens that are bound to it can't be used anymore
+func (svc *LndhubService) RevokeBudget(ctx context.Context, userId, id int64) error {
+	result, err := svc.DB.NewUpdate().Model((*models.Budget)(nil)).
+		Set("revoked_at = ?", time.Now()).
+		Set("updated_at = ?", time.Now()).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userId).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrBudgetNotFound
+	}
+	return nil
+}
+
+// GenerateBudgetTokens issues an access and refresh token of the user that can only spend within the budget
+func (svc *LndhubService) GenerateBudgetTokens(ctx context.Context, budget *models.Budget) (accessToken, refreshToken string, err error) {
+	user, err := svc.FindUser(ctx, budget.UserID)
+	if err != nil {
+		return "", "", err
+	}
+	accessToken, err = tokens.GenerateBudgetAccessToken(svc.Config.JWTSecret, svc.Config.JWTAccessTokenExpiry, user, budget.ID)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err = tokens.GenerateBudgetRefreshToken(svc.Config.JWTSecret, svc.Config.JWTRefreshTokenExpiry, user, budget.ID)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+func (svc *LndhubService) BudgetUsage(ctx context.Context, budget *models.Budget) (*BudgetUsage, error) {
+	start, renewsAt := BudgetPeriod(budget, time.Now())
+	spent, err := budgetSpent(ctx, svc.DB, budget.ID, start)
+	if err != nil {
+		return nil, err
+	}
+	remaining := budget.Amount - spent
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &BudgetUsage{
+		Spent:       spent,
+		Remaining:   remaining,
+		PeriodStart: start,
+		RenewsAt:    renewsAt,
+	}, nil
+}
+
+// CheckBudget checks if the payments fit into the remaining budget, the budget is enforced again when the payments are made
+func (svc *LndhubService) CheckBudget(ctx context.Context, budgetId int64, lnpayReqs []*lnd.LNPayReq, userId int64) (result *responses.ErrorResponse, err error) {
+	if budgetId == 0 {
+		return nil, nil
+	}
+	budget, err := svc.FindBudget(ctx, userId, budgetId)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := svc.BudgetUsage(ctx, budget)
+	if err != nil {
+		return nil, err
+	}
+	var amount int64
+	for _, lnpayReq := range lnpayReqs {
+		amount += svc.budgetCharge(lnpayReq.PayReq.Destination, lnpayReq.PayReq.NumSatoshis)
+	}
+	if amount > usage.Remaining {
+		svc.Logger.Warnj(
+			log.JSON{
+				"message":        "budget exceeded",
+				"lndhub_user_id": userId,
+				"budget_id":      budgetId,
+				"amount":         amount,
+				"remaining":      usage.Remaining,
+			},
+		)
+		return &responses.BudgetExceededError, nil
+	}
+	return nil, nil
+}
+
+// budgetCharge is the amount a payment takes from a budget: the amount, the fee reserve and the service fee.
+// Unused fee reserves are returned to the budget once the payment is completed.
+func (svc *LndhubService) budgetCharge(destination string, amount int64) int64 {
+	return amount + svc.CalcFeeLimit(destination, amount) + svc.CalcServiceFee(amount)
+}
+
+// chargeBudget makes sure an outgoing payment fits into the remaining budget of the invoice.
+// It must be called in the transaction that inserts the transaction entries of the payment,
+// the budget is locked until the transaction is done, so concurrent payments can't overspend it.
+func (svc *LndhubService) chargeBudget(ctx context.Context, tx bun.Tx, invoice *models.Invoice, amount int64) error {
+	if invoice.BudgetID == 0 {
+		return nil
+	}
+	var budget models.Budget
+	err := tx.NewSelect().Model(&budget).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", invoice.BudgetID, invoice.UserID).
+		For("UPDATE").
+		Limit(1).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrBudgetNotFound
+	}
+	if err != nil {
+		return err
+	}
+	start, _ := BudgetPeriod(&budget, time.Now())
+	spent, err := budgetSpent(ctx, tx, budget.ID, start)
+	if err != nil {
+		return err
+	}
+	if spent+amount > budget.Amount {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+// budgetSpent sums what the outgoing payments charged to a budget since a point in time took from the user's balance
+func budgetSpent(ctx context.Context, db bun.IDB, budgetId int64, since time.Time) (int64, error) {
+	var spent int64
+	query := db.NewSelect().
+		TableExpr("transaction_entries AS te").
+		Join("JOIN invoices AS i ON i.id = te.invoice_id").
+		ColumnExpr("COALESCE(SUM(CASE WHEN te.entry_type IN (?) THEN -te.amount ELSE te.amount END), 0)",
+			bun.In([]string{models.EntryTypeOutgoingReversal, models.EntryTypeFeeReserveReversal, models.EntryTypeServiceFeeReversal})).
+		Where("i.budget_id = ?", budgetId).
+		Where("te.entry_type IN (?)", bun.In([]string{
+			models.EntryTypeOutgoing, models.EntryTypeFee, models.EntryTypeFeeReserve, models.EntryTypeServiceFee,
+			models.EntryTypeOutgoingReversal, models.EntryTypeFeeReserveReversal, models.EntryTypeServiceFeeReversal,
+		}))
+	if !since.IsZero() {
+		query = query.Where("i.created_at >= ?", since)
+	}
+	err := query.Scan(ctx, &spent)
+	return spent, err
+}
+
+// RequireFullAccessMiddleware rejects tokens that are bound to a budget, e.g. for endpoints that manage budgets
+// or make payments later without the token
+func (svc *LndhubService) RequireFullAccessMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if svc.GetBudgetID(c) != 0 {
+				return c.JSON(http.StatusForbidden, responses.ErrorResponse{
+					Error:          true,
+					Code:           1,
+					Message:        "this token can't be used for this endpoint",
+					HttpStatusCode: http.StatusForbidden,
+				})
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudgetPeriod(t *testing.T) {
+	// a thursday
+	now := time.Date(2024, 6, 13, 12, 30, 0, 0, time.UTC)
+
+	start, renewsAt := BudgetPeriod(&models.Budget{RenewalPeriod: BudgetRenewalWeekly, RenewalType: BudgetRenewalTypeCalendar}, now)
+	assert.Equal(t, time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC), renewsAt)
+
+	// weeks start on monday, also on sundays
+	start, _ = BudgetPeriod(&models.Budget{RenewalPeriod: BudgetRenewalWeekly, RenewalType: BudgetRenewalTypeCalendar}, time.Date(2024, 6, 16, 8, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC), start)
+
+	start, renewsAt = BudgetPeriod(&models.Budget{RenewalPeriod: BudgetRenewalMonthly, RenewalType: BudgetRenewalTypeCalendar}, now)
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), renewsAt)
+
+	start, renewsAt = BudgetPeriod(&models.Budget{RenewalPeriod: BudgetRenewalDaily, RenewalType: BudgetRenewalTypeRolling}, now)
+	assert.Equal(t, now.Add(-24*time.Hour), start)
+	assert.True(t, renewsAt.IsZero())
+
+	start, renewsAt = BudgetPeriod(&models.Budget{RenewalPeriod: BudgetRenewalNever, RenewalType: BudgetRenewalTypeCalendar}, now)
+	assert.True(t, start.IsZero())
+	assert.True(t, renewsAt.IsZero())
+}
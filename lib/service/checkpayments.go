@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lnd"
 	"github.com/getsentry/sentry-go"
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
@@ -99,7 +100,7 @@ func (svc *LndhubService) TrackOutgoingPaymentstatus(ctx context.Context, invoic
 		svc.Logger.Errorf("Error tracking payment %s: %s", invoice.RHash, err.Error())
 		return
 	}
-	paymentTracker, err := svc.LndClient.SubscribePayment(ctx, &routerrpc.TrackPaymentRequest{
+	paymentTracker, err := lnd.SubscribePayment(ctx, svc.LndClient, invoice.NodePubkey, &routerrpc.TrackPaymentRequest{
 		PaymentHash:       rawHash,
 		NoInflightUpdates: true,
 	})
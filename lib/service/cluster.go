@@ -0,0 +1,26 @@
+package service
+
+import (
+	"context"
+
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/getsentry/sentry-go"
+	"github.com/labstack/gommon/log"
+)
+
+const nodeSwitchedRoutingKey = "cluster.node.switched"
+
+// PublishNodeSwitch publishes a switch of the active LND cluster node to the message broker
+func (svc *LndhubService) PublishNodeSwitch(nodeSwitch lnd.NodeSwitch) {
+	err := svc.EventsClient.PublishClusterEvent(context.Background(), nodeSwitchedRoutingKey, nodeSwitch)
+	if err != nil {
+		sentry.CaptureException(err)
+		svc.Logger.Errorj(log.JSON{
+			"message": "error publishing node switch",
+			"from":    nodeSwitch.From,
+			"to":      nodeSwitch.To,
+			"reason":  nodeSwitch.Reason,
+			"error":   err,
+		})
+	}
+}
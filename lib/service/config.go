@@ -3,6 +3,7 @@ package service
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -27,6 +28,7 @@ type Config struct {
 	DefaultRateLimit                 int     `envconfig:"DEFAULT_RATE_LIMIT" default:"10"`
 	StrictRateLimit                  int     `envconfig:"STRICT_RATE_LIMIT" default:"10"`
 	BurstRateLimit                   int     `envconfig:"BURST_RATE_LIMIT" default:"1"`
+	TrustedProxies                   string  `envconfig:"TRUSTED_PROXIES"` // comma separated CIDRs of reverse proxies whose X-Forwarded-For header is trusted
 	EnablePrometheus                 bool    `envconfig:"ENABLE_PROMETHEUS" default:"false"`
 	PrometheusPort                   int     `envconfig:"PROMETHEUS_PORT" default:"9092"`
 	WebhookUrl                       string  `envconfig:"WEBHOOK_URL"`
@@ -34,13 +36,17 @@ type Config struct {
 	ServiceFee                       int     `envconfig:"SERVICE_FEE" default:"0"`
 	NoServiceFeeUpToAmount           int     `envconfig:"NO_SERVICE_FEE_UP_TO_AMOUNT" default:"0"`
 	AllowAccountCreation             bool    `envconfig:"ALLOW_ACCOUNT_CREATION" default:"true"`
+	LnurlAuthEnabled                 bool    `envconfig:"LNURL_AUTH_ENABLED" default:"false"`
+	NostrAuthEnabled                 bool    `envconfig:"NOSTR_AUTH_ENABLED" default:"false"`
+	PublicUrl                        string  `envconfig:"PUBLIC_URL"`
+	LightningAddressDomain           string  `envconfig:"LIGHTNING_ADDRESS_DOMAIN"`
 	MinPasswordEntropy               int     `envconfig:"MIN_PASSWORD_ENTROPY" default:"0"`
 	MaxReceiveAmount                 int64   `envconfig:"MAX_RECEIVE_AMOUNT" default:"-1"`
 	MaxSendAmount                    int64   `envconfig:"MAX_SEND_AMOUNT" default:"-1"`
 	MaxAccountBalance                int64   `envconfig:"MAX_ACCOUNT_BALANCE" default:"-1"`
 	MaxFeeAmount                     int64   `envconfig:"MAX_FEE_AMOUNT" default:"5000"`
-	MaxSendVolume                    int64   `envconfig:"MAX_SEND_VOLUME" default:"-1"`         //-1 means the volume check is disabled by default
-	MaxReceiveVolume                 int64   `envconfig:"MAX_RECEIVE_VOLUME" default:"-1"`      //-1 means the volume check is disabled by default
+	MaxSendVolume                    int64   `envconfig:"MAX_SEND_VOLUME" default:"-1"`        //-1 means the volume check is disabled by default
+	MaxReceiveVolume                 int64   `envconfig:"MAX_RECEIVE_VOLUME" default:"-1"`     //-1 means the volume check is disabled by default
 	MaxVolumePeriod                  int64   `envconfig:"MAX_VOLUME_PERIOD" default:"2592000"` //in seconds, default 1 month
 	RabbitMQUri                      string  `envconfig:"RABBITMQ_URI"`
 	RabbitMQLndhubInvoiceExchange    string  `envconfig:"RABBITMQ_INVOICE_EXCHANGE" default:"lndhub_invoice"`
@@ -48,8 +54,55 @@ type Config struct {
 	RabbitMQLndPaymentExchange       string  `envconfig:"RABBITMQ_LND_PAYMENT_EXCHANGE" default:"lnd_payment"`
 	RabbitMQInvoiceConsumerQueueName string  `envconfig:"RABBITMQ_INVOICE_CONSUMER_QUEUE_NAME" default:"lnd_invoice_consumer"`
 	RabbitMQPaymentConsumerQueueName string  `envconfig:"RABBITMQ_PAYMENT_CONSUMER_QUEUE_NAME" default:"lnd_payment_consumer"`
+	RabbitMQClusterExchange          string  `envconfig:"RABBITMQ_CLUSTER_EXCHANGE" default:"lndhub_cluster"`
+	RabbitMQScheduledPaymentExchange string  `envconfig:"RABBITMQ_SCHEDULED_PAYMENT_EXCHANGE" default:"lndhub_scheduled_payment"`
+	RabbitMQRetryExchange            string  `envconfig:"RABBITMQ_RETRY_EXCHANGE" default:"lndhub_retry"`
+	RabbitMQDeadLetterExchange       string  `envconfig:"RABBITMQ_DEAD_LETTER_EXCHANGE" default:"lndhub_dead_letter"`
+	RabbitMQDeadLetterQueueName      string  `envconfig:"RABBITMQ_DEAD_LETTER_QUEUE_NAME" default:"lndhub_dead_letter"`
+	EventsRetryDelays                string  `envconfig:"EVENTS_RETRY_DELAYS"` //comma-seperated durations, e.g. 10s,1m,10m
+	NatsUri                          string  `envconfig:"NATS_URI"`
+	KafkaBrokers                     string  `envconfig:"KAFKA_BROKERS"`                          //comma-seperated list of bootstrap servers
+	InvoiceSweepInterval             int     `envconfig:"INVOICE_SWEEP_INTERVAL" default:"60"`    // in seconds, 0 disables the sweeper
+	OrphanedPaymentTimeout           int     `envconfig:"ORPHANED_PAYMENT_TIMEOUT" default:"600"` // in seconds, default 10 minutes
+	BatchPaymentMaxInvoices          int     `envconfig:"BATCH_PAYMENT_MAX_INVOICES" default:"100"`
+	BatchPaymentWorkers              int     `envconfig:"BATCH_PAYMENT_WORKERS" default:"10"`
+	ScheduledPaymentInterval         int     `envconfig:"SCHEDULED_PAYMENT_INTERVAL" default:"60"`
+	ScheduledPaymentMaxRetries       int     `envconfig:"SCHEDULED_PAYMENT_MAX_RETRIES" default:"3"`
+	ScheduledPaymentRetryDelay       int     `envconfig:"SCHEDULED_PAYMENT_RETRY_DELAY" default:"60"`
+	LockoutLimitBreaches             int     `envconfig:"LOCKOUT_LIMIT_BREACHES" default:"0"`    // limit breaches within LOCKOUT_PERIOD that lock the payments of a user, 0 disables it
+	LockoutPeriod                    int     `envconfig:"LOCKOUT_PERIOD" default:"900"`          // in seconds, default 15 minutes
+	AuthLockoutStore                 string  `envconfig:"AUTH_LOCKOUT_STORE" default:"postgres"` // postgres, memory or none
+	AuthMaxFailedLogins              int     `envconfig:"AUTH_MAX_FAILED_LOGINS" default:"5"`
+	AuthMaxFailedLoginsPerIP         int     `envconfig:"AUTH_MAX_FAILED_LOGINS_PER_IP" default:"20"`
+	AuthLockoutDuration              int     `envconfig:"AUTH_LOCKOUT_DURATION" default:"60"`       // in seconds, doubled for every further failure
+	AuthMaxLockoutDuration           int     `envconfig:"AUTH_MAX_LOCKOUT_DURATION" default:"3600"` // in seconds
+	AuthFailedLoginsReset            int     `envconfig:"AUTH_FAILED_LOGINS_RESET" default:"86400"` // in seconds, failures are forgotten after this period without failures
+	AuthCaptchaAfter                 int     `envconfig:"AUTH_CAPTCHA_AFTER" default:"3"`           // failures after which clients are asked to show a captcha, 0 disables it
+	RateLimitStore                   string  `envconfig:"RATE_LIMIT_STORE" default:"memory"`        // memory, postgres or redis
+	RedisUrl                         string  `envconfig:"REDIS_URL"`
+	ApiRateLimit                     int     `envconfig:"API_RATE_LIMIT" default:"0"` // requests per second of a user or API key, 0 disables it
+	ApiBurstRateLimit                int     `envconfig:"API_BURST_RATE_LIMIT" default:"20"`
+	AuthRateLimit                    int     `envconfig:"AUTH_RATE_LIMIT" default:"1"` // requests per second of a client IP to /auth
+	AuthBurstRateLimit               int     `envconfig:"AUTH_BURST_RATE_LIMIT" default:"5"`
 	Branding                         BrandingConfig
 }
+
+// RetryDelays parses EVENTS_RETRY_DELAYS, no delays means failed deliveries are not retried
+func (c *Config) RetryDelays() ([]time.Duration, error) {
+	delays := []time.Duration{}
+	if c.EventsRetryDelays == "" {
+		return delays, nil
+	}
+	for _, value := range strings.Split(c.EventsRetryDelays, ",") {
+		delay, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry delay %q: %w", value, err)
+		}
+		delays = append(delays, delay)
+	}
+	return delays, nil
+}
+
 type Limits struct {
 	MaxSendVolume     int64
 	MaxSendAmount     int64
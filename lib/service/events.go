@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/getAlby/lndhub.go/events"
+	"github.com/getAlby/lndhub.go/kafka"
+	"github.com/getAlby/lndhub.go/natsjs"
+	"github.com/getAlby/lndhub.go/rabbitmq"
+	"github.com/getsentry/sentry-go"
+	"github.com/ziflex/lecho/v3"
+)
+
+// InitEventsClient connects to the configured message broker.
+// If no broker is configured it returns nil and no broker features will be available.
+// The RABBITMQ_* exchange and queue names are used as topic and consumer names for all brokers.
+func InitEventsClient(c *Config, logger *lecho.Logger) (events.Client, error) {
+	retryDelays, err := c.RetryDelays()
+	if err != nil {
+		return nil, err
+	}
+
+	var broker events.Broker
+	switch {
+	case c.RabbitMQUri != "":
+		amqpClient, err := rabbitmq.DialAMQP(c.RabbitMQUri, rabbitmq.WithAmqpLogger(logger))
+		if err != nil {
+			return nil, err
+		}
+		broker = rabbitmq.NewBroker(amqpClient)
+	case c.NatsUri != "":
+		natsBroker, err := natsjs.Dial(c.NatsUri, natsjs.WithLogger(logger))
+		if err != nil {
+			return nil, err
+		}
+		broker = natsBroker
+	case c.KafkaBrokers != "":
+		kafkaBroker, err := kafka.Dial(c.KafkaBrokers, kafka.WithLogger(logger))
+		if err != nil {
+			return nil, err
+		}
+		broker = kafkaBroker
+	default:
+		return nil, nil
+	}
+
+	return events.NewClient(broker,
+		events.WithLogger(logger),
+		events.WithLndInvoiceTopic(c.RabbitMQLndInvoiceExchange),
+		events.WithLndHubInvoiceTopic(c.RabbitMQLndhubInvoiceExchange),
+		events.WithLndHubClusterTopic(c.RabbitMQClusterExchange),
+		events.WithLndHubScheduledPaymentTopic(c.RabbitMQScheduledPaymentExchange),
+		events.WithLndInvoiceConsumerName(c.RabbitMQInvoiceConsumerQueueName),
+		events.WithLndPaymentTopic(c.RabbitMQLndPaymentExchange),
+		events.WithLndPaymentConsumerName(c.RabbitMQPaymentConsumerQueueName),
+		events.WithRetryTopic(c.RabbitMQRetryExchange),
+		events.WithDeadLetterTopic(c.RabbitMQDeadLetterExchange),
+		events.WithDeadLetterConsumerName(c.RabbitMQDeadLetterQueueName),
+		events.WithRetryPolicy(events.RetryPolicy{Delays: retryDelays}),
+		events.WithDeadLetterHook(alertDeadLetter),
+	)
+}
+
+// alertDeadLetter reports dead lettered deliveries to sentry, as they need manual attention
+func alertDeadLetter(ctx context.Context, failed events.FailedDelivery) {
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelError)
+		scope.SetTag("consumer", failed.Consumer)
+		scope.SetExtra("delivery_id", failed.ID)
+		scope.SetExtra("attempts", failed.Attempts)
+		scope.SetExtra("error", failed.Error)
+		sentry.CaptureMessage(fmt.Sprintf("Delivery %s moved to dead letter topic by %s", failed.ID, failed.Consumer))
+	})
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"github.com/uptrace/bun"
+)
+
+var (
+	ErrInternalHoldInvoicePayment = errors.New("hold invoices can't be paid internally")
+	ErrNotHoldInvoice             = errors.New("invoice is not a hold invoice")
+	ErrHoldInvoiceNotAccepted     = errors.New("hold invoice has not been paid yet")
+	ErrInvalidPreimage            = errors.New("preimage does not match the payment hash")
+)
+
+// AddHoldInvoice creates an invoice for a payment hash chosen by the user. Payments to it are
+// held by LND until the user settles the invoice with the preimage, or cancels it.
+func (svc *LndhubService) AddHoldInvoice(ctx context.Context, userID int64, amount int64, memo, descriptionHashStr, paymentHashStr string) (*models.Invoice, *responses.ErrorResponse) {
+	// payment hashes have to be unique, otherwise updates can't be matched to the invoice.
+	// Concurrent requests for the same hash are caught by the unique index on hold invoices below.
+	count, err := svc.DB.NewSelect().Model((*models.Invoice)(nil)).Where("type = ? AND r_hash = ?", common.InvoiceTypeIncoming, paymentHashStr).Count(ctx)
+	if err != nil {
+		return nil, &responses.GeneralServerError
+	}
+	if count > 0 {
+		return nil, &responses.BadArgumentsError
+	}
+
+	expiry := time.Hour * 24 // invoice expires in 24h
+	// Initialize new DB invoice
+	invoice := models.Invoice{
+		Type:            common.InvoiceTypeIncoming,
+		UserID:          userID,
+		Amount:          amount,
+		Memo:            memo,
+		DescriptionHash: descriptionHashStr,
+		RHash:           paymentHashStr,
+		Hold:            true,
+		State:           common.InvoiceStateInitialized,
+		WalletID:        WalletIDFromContext(ctx),
+		ExpiresAt:       bun.NullTime{Time: time.Now().Add(expiry)},
+	}
+
+	// Save invoice - we save the invoice early to have a record in case the LN call fails
+	res, err := svc.DB.NewInsert().Model(&invoice).
+		On("CONFLICT (r_hash) WHERE type = 'incoming' AND hold DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return nil, &responses.GeneralServerError
+	}
+	if rows, err := res.RowsAffected(); err != nil || rows == 0 {
+		return nil, &responses.BadArgumentsError
+	}
+
+	descriptionHash, err := hex.DecodeString(descriptionHashStr)
+	if err != nil {
+		return nil, &responses.GeneralServerError
+	}
+	paymentHash, err := hex.DecodeString(paymentHashStr)
+	if err != nil {
+		return nil, &responses.BadArgumentsError
+	}
+	// Call LND
+	lnInvoiceResult, nodePubkey, err := lnd.AddHoldInvoice(ctx, svc.LndClient, &invoicesrpc.AddHoldInvoiceRequest{
+		Memo:            memo,
+		DescriptionHash: descriptionHash,
+		Hash:            paymentHash,
+		Value:           amount,
+		Expiry:          int64(expiry.Seconds()),
+	})
+	if err != nil {
+		svc.Logger.Errorf("Error creating hold invoice: user_id:%v error: %v", userID, err)
+		return nil, &responses.GeneralServerError
+	}
+
+	// Update the DB invoice with the data from the LND gRPC call
+	invoice.PaymentRequest = lnInvoiceResult.PaymentRequest
+	invoice.AddIndex = lnInvoiceResult.AddIndex
+	invoice.DestinationPubkeyHex = svc.LndClient.GetMainPubkey() // Our node pubkey for incoming invoices
+	invoice.NodePubkey = nodePubkey                              // The node that issued the invoice, differs from the main pubkey in a cluster
+	invoice.State = common.InvoiceStateOpen
+
+	_, err = svc.DB.NewUpdate().Model(&invoice).WherePK().Exec(ctx)
+	if err != nil {
+		return nil, &responses.GeneralServerError
+	}
+
+	return &invoice, nil
+}
+
+// SettleHoldInvoice releases the preimage of a paid hold invoice to LND. The user is credited
+// when the settled invoice update comes in, like for any other invoice.
+func (svc *LndhubService) SettleHoldInvoice(ctx context.Context, userID int64, rHash, preimageStr string) (*models.Invoice, error) {
+	invoice, err := svc.FindWalletInvoiceByPaymentHash(ctx, userID, rHash)
+	if err != nil {
+		return nil, err
+	}
+	if !invoice.Hold {
+		return nil, ErrNotHoldInvoice
+	}
+	if invoice.State != common.InvoiceStateAccepted {
+		return nil, ErrHoldInvoiceNotAccepted
+	}
+	preimage, err := hex.DecodeString(preimageStr)
+	if err != nil {
+		return nil, ErrInvalidPreimage
+	}
+	paymentHash, err := hex.DecodeString(invoice.RHash)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(preimage)
+	if !bytes.Equal(hash[:], paymentHash) {
+		return nil, ErrInvalidPreimage
+	}
+
+	_, err = lnd.SettleInvoice(ctx, svc.LndClient, invoice.NodePubkey, &invoicesrpc.SettleInvoiceMsg{Preimage: preimage})
+	if err != nil {
+		svc.Logger.Errorf("Error settling hold invoice: user_id:%v invoice_id:%v error: %v", userID, invoice.ID, err)
+		return nil, err
+	}
+
+	invoice.Preimage = preimageStr
+	_, err = svc.DB.NewUpdate().Model(invoice).Column("preimage", "updated_at").WherePK().Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// CancelHoldInvoice cancels a hold invoice, payments that are held are returned to the sender
+func (svc *LndhubService) CancelHoldInvoice(ctx context.Context, userID int64, rHash string) (*models.Invoice, error) {
+	invoice, err := svc.FindWalletInvoiceByPaymentHash(ctx, userID, rHash)
+	if err != nil {
+		return nil, err
+	}
+	if !invoice.Hold {
+		return nil, ErrNotHoldInvoice
+	}
+	return svc.CancelInvoice(ctx, userID, rHash)
+}
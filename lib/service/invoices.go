@@ -17,8 +17,19 @@ import (
 	"github.com/getAlby/lndhub.go/lnd"
 	"github.com/getsentry/sentry-go"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrInvoiceNotCancelable = errors.New("invoice can't be canceled anymore")
+	ErrInvoiceCanceled      = errors.New("invoice has been canceled")
+	// ErrPaymentOutcomeUnknown is returned when LND could not tell if a payment was sent, e.g. on a timeout.
+	// The payment stays initialized until the pending payment routines resolve it.
+	ErrPaymentOutcomeUnknown = errors.New("payment outcome is unknown")
 )
 
 type Route struct {
@@ -47,6 +58,74 @@ func (svc *LndhubService) FindInvoiceByPaymentHash(ctx context.Context, userId i
 	return &invoice, nil
 }
 
+// FindWalletInvoiceByPaymentHash finds an invoice of the wallet of ctx, invoices of other wallets of the user are not found
+func (svc *LndhubService) FindWalletInvoiceByPaymentHash(ctx context.Context, userId int64, rHash string) (*models.Invoice, error) {
+	var invoice models.Invoice
+
+	query := svc.DB.NewSelect().Model(&invoice).Where("invoice.user_id = ? AND invoice.r_hash = ?", userId, rHash)
+	if walletId := WalletIDFromContext(ctx); walletId != 0 {
+		query.Where("invoice.wallet_id = ?", walletId)
+	} else {
+		query.Where("invoice.wallet_id IS NULL")
+	}
+	err := query.Limit(1).Scan(ctx)
+	if err != nil {
+		return &invoice, err
+	}
+	return &invoice, nil
+}
+
+// CancelInvoice cancels an incoming invoice of the user on LND, so that it can't be paid anymore.
+// Only open invoices and accepted hold invoices can be canceled.
+func (svc *LndhubService) CancelInvoice(ctx context.Context, userID int64, rHash string) (*models.Invoice, error) {
+	invoice, err := svc.FindWalletInvoiceByPaymentHash(ctx, userID, rHash)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.Type != common.InvoiceTypeIncoming {
+		return nil, ErrInvoiceNotCancelable
+	}
+	if invoice.State != common.InvoiceStateOpen && !(invoice.Hold && invoice.State == common.InvoiceStateAccepted) {
+		return nil, ErrInvoiceNotCancelable
+	}
+	paymentHash, err := hex.DecodeString(invoice.RHash)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = lnd.CancelInvoice(ctx, svc.LndClient, invoice.NodePubkey, &invoicesrpc.CancelInvoiceMsg{PaymentHash: paymentHash})
+	if err != nil {
+		svc.Logger.Errorf("Error canceling invoice: user_id:%v invoice_id:%v error: %v", userID, invoice.ID, err)
+		return nil, err
+	}
+
+	// the invoice may have been settled by an internal payment since it was read
+	previousState := invoice.State
+	invoice.State = common.InvoiceStateCanceled
+	result, err := svc.DB.NewUpdate().Model(invoice).Column("state", "updated_at").WherePK().Where("state = ?", previousState).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, ErrInvoiceNotCancelable
+	}
+	return invoice, nil
+}
+
+// CheckInvoiceNotCanceled rejects payment hashes of incoming invoices of this hub that were canceled
+func (svc *LndhubService) CheckInvoiceNotCanceled(ctx context.Context, rHash string) error {
+	count, err := svc.DB.NewSelect().Model((*models.Invoice)(nil)).
+		Where("type = ? AND r_hash = ? AND state = ?", common.InvoiceTypeIncoming, rHash, common.InvoiceStateCanceled).
+		Count(ctx)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrInvoiceCanceled
+	}
+	return nil
+}
+
 func (svc *LndhubService) SendInternalPayment(ctx context.Context, invoice *models.Invoice) (sendPaymentResponse SendPaymentResponse, err error) {
 	//Check if it's a keysend payment
 	//If it is, an invoice will be created on-the-fly
@@ -59,20 +138,27 @@ func (svc *LndhubService) SendInternalPayment(ctx context.Context, invoice *mode
 		incomingInvoice = *keysendInvoice
 	} else {
 		// find invoice
-		err := svc.DB.NewSelect().Model(&incomingInvoice).Where("type = ? AND r_hash = ? AND state = ? ", common.InvoiceTypeIncoming, invoice.RHash, common.InvoiceStateOpen).Limit(1).Scan(ctx)
+		err := svc.DB.NewSelect().Model(&incomingInvoice).Where("type = ? AND r_hash = ? AND state IN (?) ", common.InvoiceTypeIncoming, invoice.RHash, bun.In([]string{common.InvoiceStateOpen, common.InvoiceStateCanceled})).Limit(1).Scan(ctx)
 		if err != nil {
 			// invoice not found or already settled
 			// TODO: logging
 			return sendPaymentResponse, err
 		}
+		if incomingInvoice.State == common.InvoiceStateCanceled {
+			return sendPaymentResponse, ErrInvoiceCanceled
+		}
+		// we don't know the preimage of hold invoices, so we can't settle them here
+		if incomingInvoice.Hold {
+			return sendPaymentResponse, ErrInternalHoldInvoicePayment
+		}
 	}
 
 	// Get the user's current and incoming account for the transaction entry
-	recipientCreditAccount, err := svc.AccountFor(ctx, common.AccountTypeCurrent, incomingInvoice.UserID)
+	recipientCreditAccount, err := svc.WalletAccountFor(ctx, common.AccountTypeCurrent, incomingInvoice.UserID, incomingInvoice.WalletID)
 	if err != nil {
 		return sendPaymentResponse, err
 	}
-	recipientDebitAccount, err := svc.AccountFor(ctx, common.AccountTypeIncoming, incomingInvoice.UserID)
+	recipientDebitAccount, err := svc.WalletAccountFor(ctx, common.AccountTypeIncoming, incomingInvoice.UserID, incomingInvoice.WalletID)
 	if err != nil {
 		return sendPaymentResponse, err
 	}
@@ -85,8 +171,25 @@ func (svc *LndhubService) SendInternalPayment(ctx context.Context, invoice *mode
 		Amount:          invoice.Amount,
 		EntryType:       models.EntryTypeIncoming,
 	}
-	_, err = svc.DB.NewInsert().Model(&recipientEntry).Exec(ctx)
+	previousState := incomingInvoice.State
+	incomingInvoice.Internal = true // mark incoming invoice as internal, just for documentation/debugging
+	incomingInvoice.State = common.InvoiceStateSettled
+	incomingInvoice.SettledAt = schema.NullTime{Time: time.Now()}
+	incomingInvoice.Amount = invoice.Amount // set just in case of 0 amount invoice
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		// the invoice may have been canceled since it was read, it is only settled and credited if its state is unchanged
+		result, err := tx.NewUpdate().Model(&incomingInvoice).WherePK().Where("state = ?", previousState).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return ErrInvoiceCanceled
+		}
+		_, err = tx.NewInsert().Model(&recipientEntry).Exec(ctx)
+		return err
+	})
 	if err != nil {
+		// could not settle the invoice of the recipient
 		return sendPaymentResponse, err
 	}
 
@@ -101,15 +204,6 @@ func (svc *LndhubService) SendInternalPayment(ctx context.Context, invoice *mode
 	sendPaymentResponse.PaymentHash = paymentHash
 	sendPaymentResponse.PaymentRoute = &Route{TotalAmt: incomingInvoice.Amount, TotalFees: 0}
 
-	incomingInvoice.Internal = true // mark incoming invoice as internal, just for documentation/debugging
-	incomingInvoice.State = common.InvoiceStateSettled
-	incomingInvoice.SettledAt = schema.NullTime{Time: time.Now()}
-	incomingInvoice.Amount = invoice.Amount // set just in case of 0 amount invoice
-	_, err = svc.DB.NewUpdate().Model(&incomingInvoice).WherePK().Exec(ctx)
-	if err != nil {
-		// could not save the invoice of the recipient
-		return sendPaymentResponse, err
-	}
 	svc.InvoicePubSub.Publish(strconv.FormatInt(incomingInvoice.UserID, 10), incomingInvoice)
 	svc.InvoicePubSub.Publish(common.InvoiceTypeIncoming, incomingInvoice)
 
@@ -125,7 +219,9 @@ func (svc *LndhubService) SendPaymentSync(ctx context.Context, invoice *models.I
 	}
 
 	// Execute the payment
-	sendPaymentResult, err := svc.LndClient.SendPaymentSync(ctx, sendPaymentRequest)
+	sendPaymentResult, nodePubkey, err := lnd.SendPaymentSync(ctx, svc.LndClient, sendPaymentRequest, invoice.DestinationPubkeyHex)
+	// remember the node the payment was sent from, pending payments are tracked there
+	invoice.NodePubkey = nodePubkey
 	if err != nil {
 		return sendPaymentResponse, err
 	}
@@ -190,17 +286,17 @@ func (svc *LndhubService) PayInvoice(ctx context.Context, invoice *models.Invoic
 	userId := invoice.UserID
 
 	// Get the user's current and outgoing account for the transaction entry
-	debitAccount, err := svc.AccountFor(ctx, common.AccountTypeCurrent, userId)
+	debitAccount, err := svc.WalletAccountFor(ctx, common.AccountTypeCurrent, userId, invoice.WalletID)
 	if err != nil {
 		svc.Logger.Errorf("Could not find current account user_id:%v", userId)
 		return nil, err
 	}
-	creditAccount, err := svc.AccountFor(ctx, common.AccountTypeOutgoing, userId)
+	creditAccount, err := svc.WalletAccountFor(ctx, common.AccountTypeOutgoing, userId, invoice.WalletID)
 	if err != nil {
 		svc.Logger.Errorf("Could not find outgoing account user_id:%v", userId)
 		return nil, err
 	}
-	feeAccount, err := svc.AccountFor(ctx, common.AccountTypeFees, userId)
+	feeAccount, err := svc.WalletAccountFor(ctx, common.AccountTypeFees, userId, invoice.WalletID)
 	if err != nil {
 		svc.Logger.Errorf("Could not find outgoing account user_id:%v", userId)
 		return nil, err
@@ -212,7 +308,13 @@ func (svc *LndhubService) PayInvoice(ctx context.Context, invoice *models.Invoic
 		return nil, err
 	}
 
+	return svc.PayInvoiceWithEntry(ctx, invoice, entry)
+}
+
+// PayInvoiceWithEntry pays an invoice for which the amount was already taken from the user's balance with entry
+func (svc *LndhubService) PayInvoiceWithEntry(ctx context.Context, invoice *models.Invoice, entry models.TransactionEntry) (*SendPaymentResponse, error) {
 	var paymentResponse SendPaymentResponse
+	var err error
 	// Check the destination pubkey if it is an internal invoice and going to our node
 	// Here we start using context.Background because we want to complete these calls
 	// regardless of if the request's context is canceled or not.
@@ -224,6 +326,15 @@ func (svc *LndhubService) PayInvoice(ctx context.Context, invoice *models.Invoic
 		}
 	} else {
 		paymentResponse, err = svc.SendPaymentSync(context.Background(), invoice)
+		if err != nil && PaymentOutcomeUnknown(err) {
+			// the payment may still be in flight, reverting it now could pay twice
+			svc.Logger.Errorf("Payment outcome unknown, leaving it to reconciliation: invoice_id:%v r_hash:%s error: %v", invoice.ID, invoice.RHash, err)
+			_, updateErr := svc.DB.NewUpdate().Model(invoice).Column("node_pubkey", "updated_at").WherePK().Exec(context.Background())
+			if updateErr != nil {
+				svc.Logger.Errorf("Could not save the node of the payment invoice_id:%v: %v", invoice.ID, updateErr)
+			}
+			return nil, fmt.Errorf("%w: %v", ErrPaymentOutcomeUnknown, err)
+		}
 		if err != nil {
 			svc.HandleFailedPayment(context.Background(), invoice, entry, err)
 			return nil, err
@@ -241,6 +352,19 @@ func (svc *LndhubService) PayInvoice(ctx context.Context, invoice *models.Invoic
 	return &paymentResponse, err
 }
 
+// PaymentOutcomeUnknown returns true for errors of a payment call to LND after which the payment may still be in flight,
+// like timeouts and lost connections. Errors that LND reports for the payment itself mean that it failed.
+func PaymentOutcomeUnknown(err error) bool {
+	if errors.Is(err, ErrPaymentOutcomeUnknown) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Canceled, codes.Unavailable:
+		return true
+	}
+	return false
+}
+
 func (svc *LndhubService) HandleFailedPayment(ctx context.Context, invoice *models.Invoice, entryToRevert models.TransactionEntry, failedPaymentError error) error {
 	// Process the tx insertion and invoice update in a DB transaction
 	// analogous with the incoming invoice update
@@ -317,6 +441,81 @@ func (svc *LndhubService) InsertTransactionEntry(ctx context.Context, invoice *m
 	if err != nil {
 		return entry, err
 	}
+	entry, err = svc.insertTransactionEntry(ctx, tx, invoice, creditAccount, debitAccount, feeAccount)
+	if err != nil {
+		tx.Rollback()
+		return entry, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return entry, err
+	}
+	return entry, err
+}
+
+// ReserveOutgoingPayments takes the amounts and fees of all invoices from the user's balance in one DB transaction.
+// Either all payments are funded or none is.
+func (svc *LndhubService) ReserveOutgoingPayments(ctx context.Context, userId int64, invoices []*models.Invoice) (entries []models.TransactionEntry, err error) {
+	// the invoices are all created for the wallet of ctx
+	walletId := WalletIDFromContext(ctx)
+	debitAccount, err := svc.WalletAccountFor(ctx, common.AccountTypeCurrent, userId, walletId)
+	if err != nil {
+		return nil, err
+	}
+	creditAccount, err := svc.WalletAccountFor(ctx, common.AccountTypeOutgoing, userId, walletId)
+	if err != nil {
+		return nil, err
+	}
+	feeAccount, err := svc.WalletAccountFor(ctx, common.AccountTypeFees, userId, walletId)
+	if err != nil {
+		return nil, err
+	}
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		entries = make([]models.TransactionEntry, len(invoices))
+		for i, invoice := range invoices {
+			entries[i], err = svc.insertTransactionEntry(ctx, tx, invoice, creditAccount, debitAccount, feeAccount)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// none of the payments is going to be made, close the invoices right away
+		svc.FailOutgoingInvoices(ctx, invoices, err.Error())
+		return nil, err
+	}
+	return entries, nil
+}
+
+// FailOutgoingInvoices closes outgoing invoices that are not going to be paid and for which nothing was taken from the balance
+func (svc *LndhubService) FailOutgoingInvoices(ctx context.Context, invoices []*models.Invoice, errorMessage string) {
+	for _, invoice := range invoices {
+		invoice.State = common.InvoiceStateError
+		invoice.ErrorMessage = errorMessage
+		_, err := svc.DB.NewUpdate().Model(invoice).Column("state", "error_message", "updated_at").WherePK().Exec(ctx)
+		if err != nil {
+			svc.Logger.Errorf("Could not update failed payment invoice_id:%v error: %v", invoice.ID, err)
+		}
+	}
+}
+
+func (svc *LndhubService) insertTransactionEntry(ctx context.Context, tx bun.Tx, invoice *models.Invoice, creditAccount, debitAccount, feeAccount models.Account) (entry models.TransactionEntry, err error) {
+	entry = models.TransactionEntry{
+		UserID:          invoice.UserID,
+		InvoiceID:       invoice.ID,
+		CreditAccountID: creditAccount.ID,
+		DebitAccountID:  debitAccount.ID,
+		Amount:          invoice.Amount,
+		EntryType:       models.EntryTypeOutgoing,
+	}
+	feeLimit := svc.CalcFeeLimit(invoice.DestinationPubkeyHex, invoice.Amount)
+	serviceFee := svc.CalcServiceFee(invoice.Amount)
+
+	err = svc.chargeBudget(ctx, tx, invoice, invoice.Amount+feeLimit+serviceFee)
+	if err != nil {
+		return entry, err
+	}
 
 	// The DB constraints make sure the user actually has enough balance for the transaction
 	// If the user does not have enough balance this call fails
@@ -326,8 +525,6 @@ func (svc *LndhubService) InsertTransactionEntry(ctx context.Context, invoice *m
 	}
 
 	// add fee entries (fee reserve and service fee)
-	feeLimit := svc.CalcFeeLimit(invoice.DestinationPubkeyHex, invoice.Amount)
-	serviceFee := svc.CalcServiceFee(invoice.Amount)
 
 	if feeLimit != 0 {
 		feeReserveEntry := models.TransactionEntry{
@@ -361,11 +558,7 @@ func (svc *LndhubService) InsertTransactionEntry(ctx context.Context, invoice *m
 		}
 		entry.ServiceFee = &serviceFeeEntry
 	}
-	err = tx.Commit()
-	if err != nil {
-		return entry, err
-	}
-	return entry, err
+	return entry, nil
 }
 
 func (svc *LndhubService) RevertFeeReserve(ctx context.Context, entry *models.TransactionEntry, invoice *models.Invoice, tx bun.Tx) (err error) {
@@ -462,7 +655,7 @@ func (svc *LndhubService) HandleSuccessfulPayment(ctx context.Context, invoice *
 		return err
 	}
 
-	userBalance, err := svc.CurrentUserBalance(ctx, parentEntry.UserID)
+	userBalance, err := svc.WalletBalance(ctx, parentEntry.UserID, invoice.WalletID)
 	if err != nil {
 		sentry.CaptureException(err)
 		svc.Logger.Errorf("Could not fetch user balance user_id:%v invoice_id:%v error %s", invoice.UserID, invoice.ID, err.Error())
@@ -492,6 +685,8 @@ func (svc *LndhubService) AddOutgoingInvoice(ctx context.Context, userID int64,
 		DescriptionHash:      lnPayReq.PayReq.DescriptionHash,
 		Memo:                 lnPayReq.PayReq.Description,
 		Keysend:              lnPayReq.Keysend,
+		BudgetID:             BudgetIDFromContext(ctx),
+		WalletID:             WalletIDFromContext(ctx),
 		ExpiresAt:            bun.NullTime{Time: time.Unix(lnPayReq.PayReq.Timestamp, 0).Add(time.Duration(lnPayReq.PayReq.Expiry) * time.Second)},
 	}
 
@@ -531,6 +726,7 @@ func (svc *LndhubService) AddIncomingInvoice(ctx context.Context, userID int64,
 		Memo:            memo,
 		DescriptionHash: descriptionHashStr,
 		State:           common.InvoiceStateInitialized,
+		WalletID:        WalletIDFromContext(ctx),
 		ExpiresAt:       bun.NullTime{Time: time.Now().Add(expiry)},
 	}
 
@@ -553,7 +749,7 @@ func (svc *LndhubService) AddIncomingInvoice(ctx context.Context, userID int64,
 		Expiry:          int64(expiry.Seconds()),
 	}
 	// Call LND
-	lnInvoiceResult, err := svc.LndClient.AddInvoice(ctx, &lnInvoice)
+	lnInvoiceResult, nodePubkey, err := lnd.AddInvoice(ctx, svc.LndClient, &lnInvoice)
 	if err != nil {
 		svc.Logger.Errorf("Error creating invoice: user_id:%v error: %v", userID, err)
 		return nil, &responses.GeneralServerError
@@ -565,6 +761,7 @@ func (svc *LndhubService) AddIncomingInvoice(ctx context.Context, userID int64,
 	invoice.Preimage = hex.EncodeToString(preimage)
 	invoice.AddIndex = lnInvoiceResult.AddIndex
 	invoice.DestinationPubkeyHex = svc.LndClient.GetMainPubkey() // Our node pubkey for incoming invoices
+	invoice.NodePubkey = nodePubkey                              // The node that issued the invoice, differs from the main pubkey in a cluster
 	invoice.State = common.InvoiceStateOpen
 
 	_, err = svc.DB.NewUpdate().Model(&invoice).WherePK().Exec(ctx)
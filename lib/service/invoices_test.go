@@ -1,11 +1,16 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/getAlby/lndhub.go/db/models"
 	"github.com/getAlby/lndhub.go/lnd"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var svc = &LndhubService{
@@ -113,3 +118,13 @@ func TestSetFeeOnInvoice(t *testing.T) {
 	assert.Equal(t, int64(42), invoice.ServiceFee)
 	assert.Equal(t, int64(63), invoice.Fee)
 }
+
+func TestPaymentOutcomeUnknown(t *testing.T) {
+	assert.True(t, PaymentOutcomeUnknown(status.Error(codes.DeadlineExceeded, "context deadline exceeded")))
+	assert.True(t, PaymentOutcomeUnknown(status.Error(codes.Unavailable, "connection closed")))
+	assert.True(t, PaymentOutcomeUnknown(fmt.Errorf("%w: timeout", ErrPaymentOutcomeUnknown)))
+	assert.True(t, PaymentOutcomeUnknown(context.DeadlineExceeded))
+	// errors that LND reports for the payment mean that it failed
+	assert.False(t, PaymentOutcomeUnknown(errors.New("no_route")))
+	assert.False(t, PaymentOutcomeUnknown(status.Error(codes.Unknown, "invoice is already paid")))
+}
@@ -47,7 +47,7 @@ func (svc *LndhubService) HandleInternalKeysendPayment(ctx context.Context, invo
 	return &incomingInvoice, err
 }
 
-func (svc *LndhubService) HandleKeysendPayment(ctx context.Context, rawInvoice *lnrpc.Invoice) error {
+func (svc *LndhubService) HandleKeysendPayment(ctx context.Context, rawInvoice *lnrpc.Invoice, nodePubkey string) error {
 	var invoice models.Invoice
 	rHashStr := hex.EncodeToString(rawInvoice.RHash)
 	//First check if this keysend payment was already processed
@@ -63,7 +63,7 @@ func (svc *LndhubService) HandleKeysendPayment(ctx context.Context, rawInvoice *
 	}
 
 	//construct the invoice
-	invoice, err = svc.createKeysendInvoice(ctx, rawInvoice)
+	invoice, err = svc.createKeysendInvoice(ctx, rawInvoice, nodePubkey)
 	if err != nil {
 		return err
 	}
@@ -76,15 +76,21 @@ func (svc *LndhubService) HandleKeysendPayment(ctx context.Context, rawInvoice *
 }
 
 func (svc *LndhubService) ProcessInvoiceUpdate(ctx context.Context, rawInvoice *lnrpc.Invoice) error {
+	return svc.ProcessInvoiceUpdateFromNode(ctx, rawInvoice, "")
+}
+
+// ProcessInvoiceUpdateFromNode processes an invoice update received from the node with nodePubkey.
+// The node pubkey is unknown (empty) for updates that come in through the message broker.
+func (svc *LndhubService) ProcessInvoiceUpdateFromNode(ctx context.Context, rawInvoice *lnrpc.Invoice, nodePubkey string) error {
 	var invoice models.Invoice
 	rHashStr := hex.EncodeToString(rawInvoice.RHash)
 
-	svc.Logger.Infof("Invoice update: r_hash:%s state:%v", rHashStr, rawInvoice.State.String())
+	svc.Logger.Infof("Invoice update: r_hash:%s state:%v node:%s", rHashStr, rawInvoice.State.String(), nodePubkey)
 
 	//Check if it's a keysend payment
 	//If it is, an invoice will be created on-the-fly
 	if rawInvoice.IsKeysend {
-		err := svc.HandleKeysendPayment(ctx, rawInvoice)
+		err := svc.HandleKeysendPayment(ctx, rawInvoice, nodePubkey)
 		if err != nil {
 			if err == AlreadyProcessedKeysendError {
 				return nil
@@ -101,6 +107,10 @@ func (svc *LndhubService) ProcessInvoiceUpdate(ctx context.Context, rawInvoice *
 		svc.Logger.Infof("Invoice not found. Ignoring. r_hash:%s", rHashStr)
 		return nil
 	}
+	// LND cancels invoices once they expire, they keep the state the expiry sweeper gave them
+	if invoice.State == common.InvoiceStateExpired && rawInvoice.State == lnrpc.Invoice_CANCELED {
+		return nil
+	}
 
 	// Update the DB entry of the invoice
 	// If the invoice is settled we save the settle date and the status otherwise we just store the lnd status
@@ -108,14 +118,25 @@ func (svc *LndhubService) ProcessInvoiceUpdate(ctx context.Context, rawInvoice *
 	// This transaction entry makes the balance available for the user
 	svc.Logger.Infof("Invoice update: invoice_id:%v settled:%v value:%v state:%v", invoice.ID, rawInvoice.Settled, rawInvoice.AmtPaidSat, rawInvoice.State)
 
+	// Attribute the update to the node it came from
+	if nodePubkey != "" {
+		if invoice.NodePubkey == "" {
+			invoice.NodePubkey = nodePubkey
+		} else if invoice.NodePubkey != nodePubkey {
+			msg := fmt.Sprintf("Invoice update from unexpected node invoice_id:%v issued by:%s update from:%s", invoice.ID, invoice.NodePubkey, nodePubkey)
+			svc.Logger.Error(msg)
+			sentry.CaptureMessage(msg)
+		}
+	}
+
 	// Get the user's current account for the transaction entry
-	creditAccount, err := svc.AccountFor(ctx, common.AccountTypeCurrent, invoice.UserID)
+	creditAccount, err := svc.WalletAccountFor(ctx, common.AccountTypeCurrent, invoice.UserID, invoice.WalletID)
 	if err != nil {
 		svc.Logger.Errorf("Could not find current account user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
 		return err
 	}
 	// Get the user's incoming account for the transaction entry
-	debitAccount, err := svc.AccountFor(ctx, common.AccountTypeIncoming, invoice.UserID)
+	debitAccount, err := svc.WalletAccountFor(ctx, common.AccountTypeIncoming, invoice.UserID, invoice.WalletID)
 	if err != nil {
 		svc.Logger.Errorf("Could not find incoming account user_id:%v invoice_id:%v", invoice.UserID, invoice.ID)
 		return err
@@ -133,15 +154,25 @@ func (svc *LndhubService) ProcessInvoiceUpdate(ctx context.Context, rawInvoice *
 	}
 
 	// if the invoice is NOT settled we just update the invoice state
+	// e.g. hold invoices are "accepted" once they are paid, but they are only credited when they get settled
 	if !rawInvoice.Settled {
 		svc.Logger.Infof("Invoice not settled invoice_id:%v state: %s", invoice.ID, rawInvoice.State.String())
 		invoice.State = strings.ToLower(rawInvoice.State.String())
-
+		_, err = tx.NewUpdate().Model(&invoice).Column("state", "node_pubkey", "updated_at").WherePK().Exec(ctx)
+		if err != nil {
+			tx.Rollback()
+			svc.Logger.Errorf("Could not update invoice invoice_id:%v", invoice.ID)
+			return err
+		}
 	} else {
 		// if the invoice is settled we update the state and create an transaction entry to the current account
 		invoice.SettledAt = bun.NullTime{Time: time.Unix(rawInvoice.SettleDate, 0)}
 		invoice.State = common.InvoiceStateSettled
 		invoice.Amount = rawInvoice.AmtPaidSat
+		// the preimage of hold invoices is only known after they were settled
+		if invoice.Preimage == "" {
+			invoice.Preimage = hex.EncodeToString(rawInvoice.RPreimage)
+		}
 		_, err = tx.NewUpdate().Model(&invoice).WherePK().Exec(ctx)
 		if err != nil {
 			tx.Rollback()
@@ -178,7 +209,7 @@ func (svc *LndhubService) ProcessInvoiceUpdate(ctx context.Context, rawInvoice *
 	return nil
 }
 
-func (svc *LndhubService) createKeysendInvoice(ctx context.Context, rawInvoice *lnrpc.Invoice) (result models.Invoice, err error) {
+func (svc *LndhubService) createKeysendInvoice(ctx context.Context, rawInvoice *lnrpc.Invoice, nodePubkey string) (result models.Invoice, err error) {
 	//Look for the user-identifying TLV record
 	//which are located in the HTLC's.
 	//TODO: can the records differe from HTLC to HTLC? Probably not
@@ -208,17 +239,46 @@ func (svc *LndhubService) createKeysendInvoice(ctx context.Context, rawInvoice *
 		DestinationCustomRecords: rawInvoice.Htlcs[0].CustomRecords,
 		DestinationPubkeyHex:     svc.LndClient.GetMainPubkey(),
 		AddIndex:                 rawInvoice.AddIndex,
+		NodePubkey:               nodePubkey,
 	}
 	return result, nil
 }
 
 func (svc *LndhubService) ConnectInvoiceSubscription(ctx context.Context) (lnd.SubscribeInvoicesWrapper, error) {
+	if multiNodeClient, ok := svc.LndClient.(lnd.MultiNodeClient); ok {
+		// add indices are per node, so every node gets its own starting point
+		reqs := map[string]*lnrpc.InvoiceSubscription{}
+		for _, pubkey := range multiNodeClient.NodePubkeys() {
+			req, err := svc.invoiceSubscriptionOptions(ctx, pubkey)
+			if err != nil {
+				return nil, err
+			}
+			reqs[pubkey] = req
+		}
+		return multiNodeClient.SubscribeInvoicesOnNodes(ctx, reqs)
+	}
+
+	invoiceSubscriptionOptions, err := svc.invoiceSubscriptionOptions(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return svc.LndClient.SubscribeInvoices(ctx, invoiceSubscriptionOptions)
+}
+
+// invoiceSubscriptionOptions finds the add index to start the invoice subscription from.
+// If nodePubkey is set only invoices issued by that node are considered, invoices from
+// before we recorded the issuing node count for every node.
+func (svc *LndhubService) invoiceSubscriptionOptions(ctx context.Context, nodePubkey string) (*lnrpc.InvoiceSubscription, error) {
 	var invoice models.Invoice
 	invoiceSubscriptionOptions := lnrpc.InvoiceSubscription{}
 	// Find the oldest NOT settled AND NOT expired invoice with an add_index
 	// Build in a safety buffer of 14h to account for lndhub downtime
 	// Note: expired invoices will not be settled anymore, so we don't care about those
-	err := svc.DB.NewSelect().Model(&invoice).Where("invoice.settled_at IS NULL AND invoice.add_index IS NOT NULL AND invoice.expires_at >= (now() - interval '14 hours')").OrderExpr("invoice.id ASC").Limit(1).Scan(ctx)
+	query := svc.DB.NewSelect().Model(&invoice).Where("invoice.settled_at IS NULL AND invoice.add_index IS NOT NULL AND invoice.expires_at >= (now() - interval '14 hours')")
+	if nodePubkey != "" {
+		query = query.Where("(invoice.node_pubkey = ? OR invoice.node_pubkey IS NULL)", nodePubkey)
+	}
+	err := query.OrderExpr("invoice.id ASC").Limit(1).Scan(ctx)
 	// IF we found an invoice we use that index to start the subscription
 	// if we get an error there might be a serious issue here
 	// and we are at risk of missing paid invoices, so we should not continue
@@ -229,8 +289,8 @@ func (svc *LndhubService) ConnectInvoiceSubscription(ctx context.Context) (lnd.S
 	}
 	// subtract 1 (read invoiceSubscriptionOptions.Addindex docs)
 	invoiceSubscriptionOptions.AddIndex = invoice.AddIndex - 1
-	svc.Logger.Infof("Starting invoice subscription from index: %v", invoiceSubscriptionOptions.AddIndex)
-	return svc.LndClient.SubscribeInvoices(ctx, &invoiceSubscriptionOptions)
+	svc.Logger.Infof("Starting invoice subscription from index: %v node: %s", invoiceSubscriptionOptions.AddIndex, nodePubkey)
+	return &invoiceSubscriptionOptions, nil
 }
 
 func (svc *LndhubService) InvoiceUpdateSubscription(ctx context.Context) error {
@@ -245,7 +305,7 @@ func (svc *LndhubService) InvoiceUpdateSubscription(ctx context.Context) error {
 			return context.Canceled
 		default:
 			// receive the next invoice update
-			rawInvoice, err := invoiceSubscriptionStream.Recv()
+			rawInvoice, nodePubkey, err := recvInvoiceUpdate(invoiceSubscriptionStream)
 			// in case of an error, we want to return and restart LNDhub
 			// in order to try and reconnect the gRPC subscription
 			if err != nil {
@@ -263,7 +323,7 @@ func (svc *LndhubService) InvoiceUpdateSubscription(ctx context.Context) error {
 				continue
 			}
 
-			processingError := svc.ProcessInvoiceUpdate(ctx, rawInvoice)
+			processingError := svc.ProcessInvoiceUpdateFromNode(ctx, rawInvoice, nodePubkey)
 			if processingError != nil && processingError != AlreadyProcessedKeysendError {
 				svc.Logger.Error(fmt.Errorf("Error %s, invoice hash %s", processingError.Error(), hex.EncodeToString(rawInvoice.RHash)))
 				sentry.CaptureException(fmt.Errorf("Error %s, invoice hash %s", processingError.Error(), hex.EncodeToString(rawInvoice.RHash)))
@@ -271,3 +331,12 @@ func (svc *LndhubService) InvoiceUpdateSubscription(ctx context.Context) error {
 		}
 	}
 }
+
+// recvInvoiceUpdate returns the next update of the stream and the node it came from, if known
+func recvInvoiceUpdate(stream lnd.SubscribeInvoicesWrapper) (*lnrpc.Invoice, string, error) {
+	if nodeStream, ok := stream.(lnd.NodeSubscribeInvoicesWrapper); ok {
+		return nodeStream.RecvFromNode()
+	}
+	invoice, err := stream.Recv()
+	return invoice, "", err
+}
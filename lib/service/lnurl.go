@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// maxLnurlResponseSize is the maximum size of a response of a LNURL server
+const maxLnurlResponseSize = 64 * 1024
+
+var (
+	ErrInvalidLightningAddress = errors.New("invalid lightning address")
+	ErrInvalidLnurlInvoice     = errors.New("lnurl invoice does not match the request")
+	ErrLnurlForbiddenAddress   = errors.New("lnurl server address is not allowed")
+)
+
+// lnurlClient only connects to public addresses, so Lightning Addresses can't be used to reach internal services
+var lnurlClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: lnurlDialControl,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("%w: lnurl redirects must use https", ErrLnurlForbiddenAddress)
+		}
+		if len(via) >= 5 {
+			return errors.New("too many lnurl redirects")
+		}
+		return nil
+	},
+}
+
+type lnurlPayParams struct {
+	Status      string `json:"status"`
+	Reason      string `json:"reason"`
+	Tag         string `json:"tag"`
+	Callback    string `json:"callback"`
+	MinSendable int64  `json:"minSendable"`
+	MaxSendable int64  `json:"maxSendable"`
+	Metadata    string `json:"metadata"`
+}
+
+type lnurlPayResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	PR     string `json:"pr"`
+}
+
+// FetchLightningAddressInvoice requests a bolt11 invoice over amount sats from a Lightning Address (LUD-16).
+// Addresses of this hub are resolved without a request, the invoice is created for the user right away.
+func (svc *LndhubService) FetchLightningAddressInvoice(ctx context.Context, address string, amount int64, memo string) (string, error) {
+	i := strings.LastIndex(address, "@")
+	if i <= 0 || i == len(address)-1 {
+		return "", ErrInvalidLightningAddress
+	}
+	username, domain := address[:i], address[i+1:]
+	if svc.Config.LightningAddressDomain != "" && strings.EqualFold(domain, svc.Config.LightningAddressDomain) {
+		recipient, err := svc.FindTransferRecipient(ctx, address)
+		if err != nil {
+			return "", err
+		}
+		invoice, errResp := svc.AddIncomingInvoice(ctx, recipient.ID, amount, memo, "")
+		if errResp != nil {
+			return "", errors.New(errResp.Message)
+		}
+		return invoice.PaymentRequest, nil
+	}
+
+	if strings.ContainsAny(domain, "/\\?#") {
+		return "", ErrInvalidLightningAddress
+	}
+	params := lnurlPayParams{}
+	err := getLnurlJSON(ctx, fmt.Sprintf("https://%s/.well-known/lnurlp/%s", domain, url.PathEscape(username)), &params)
+	if err != nil {
+		return "", err
+	}
+	if params.Status == "ERROR" {
+		return "", fmt.Errorf("lnurl error: %s", params.Reason)
+	}
+	if params.Tag != "payRequest" || params.Callback == "" {
+		return "", ErrInvalidLightningAddress
+	}
+	if amount*1000 < params.MinSendable || amount*1000 > params.MaxSendable {
+		return "", fmt.Errorf("amount must be between %d and %d msat", params.MinSendable, params.MaxSendable)
+	}
+
+	callback, err := url.Parse(params.Callback)
+	if err != nil {
+		return "", err
+	}
+	query := callback.Query()
+	query.Set("amount", strconv.FormatInt(amount*1000, 10))
+	callback.RawQuery = query.Encode()
+	payResponse := lnurlPayResponse{}
+	err = getLnurlJSON(ctx, callback.String(), &payResponse)
+	if err != nil {
+		return "", err
+	}
+	if payResponse.Status == "ERROR" {
+		return "", fmt.Errorf("lnurl error: %s", payResponse.Reason)
+	}
+	if payResponse.PR == "" {
+		return "", ErrInvalidLightningAddress
+	}
+	payReq, err := svc.DecodePaymentRequest(ctx, payResponse.PR)
+	if err != nil {
+		return "", err
+	}
+	if err := checkLnurlInvoice(payReq, amount, params.Metadata); err != nil {
+		return "", err
+	}
+	// the callback may hand out an invoice of this hub that was canceled
+	if err := svc.CheckInvoiceNotCanceled(ctx, payReq.PaymentHash); err != nil {
+		return "", err
+	}
+	return payResponse.PR, nil
+}
+
+// checkLnurlInvoice checks that the invoice of a LNURL-pay callback is for the requested amount
+// and commits to the metadata of the pay request (LUD-06)
+func checkLnurlInvoice(payReq *lnrpc.PayReq, amount int64, metadata string) error {
+	if payReq.NumMsat != amount*1000 {
+		return fmt.Errorf("%w: invoice amount is %d msat instead of %d msat", ErrInvalidLnurlInvoice, payReq.NumMsat, amount*1000)
+	}
+	hash := sha256.Sum256([]byte(metadata))
+	if !strings.EqualFold(payReq.DescriptionHash, hex.EncodeToString(hash[:])) {
+		return fmt.Errorf("%w: description hash does not match the metadata", ErrInvalidLnurlInvoice)
+	}
+	return nil
+}
+
+// getLnurlJSON decodes the response of a https request to a LNURL server, responses over maxLnurlResponseSize are rejected
+func getLnurlJSON(ctx context.Context, rawUrl string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return err
+	}
+	if req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: lnurl requests must use https", ErrLnurlForbiddenAddress)
+	}
+	resp, err := lnurlClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("lnurl request to %s failed with status %d", req.URL.Host, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxLnurlResponseSize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxLnurlResponseSize {
+		return fmt.Errorf("lnurl response of %s is larger than %d bytes", req.URL.Host, maxLnurlResponseSize)
+	}
+	return json.Unmarshal(body, result)
+}
+
+// lnurlDialControl rejects connections to loopback, private and other non-public addresses.
+// It runs after the name was resolved, so it also covers redirects and DNS names of internal addresses.
+func lnurlDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrLnurlForbiddenAddress, host)
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLnurlInvoice(t *testing.T) {
+	metadata := `[["text/plain","pay bob"]]`
+	hash := sha256.Sum256([]byte(metadata))
+	payReq := &lnrpc.PayReq{NumMsat: 100000, DescriptionHash: hex.EncodeToString(hash[:])}
+	assert.NoError(t, checkLnurlInvoice(payReq, 100, metadata))
+
+	assert.ErrorIs(t, checkLnurlInvoice(payReq, 200, metadata), ErrInvalidLnurlInvoice)
+	assert.ErrorIs(t, checkLnurlInvoice(payReq, 100, `[["text/plain","pay mallory"]]`), ErrInvalidLnurlInvoice)
+	assert.ErrorIs(t, checkLnurlInvoice(&lnrpc.PayReq{NumMsat: 100000, Description: metadata}, 100, metadata), ErrInvalidLnurlInvoice)
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "::1", "10.0.0.1", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "fd00::1", "fe80::1"} {
+		assert.False(t, isPublicIP(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"1.1.1.1", "2606:4700:4700::1111"} {
+		assert.True(t, isPublicIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestGetLnurlJSONRejectsInternalServers(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tag":"payRequest"}`))
+	}))
+	defer server.Close()
+
+	params := lnurlPayParams{}
+	err := getLnurlJSON(context.Background(), server.URL, &params)
+	assert.ErrorIs(t, err, ErrLnurlForbiddenAddress)
+	err = getLnurlJSON(context.Background(), "http://example.com/.well-known/lnurlp/bob", &params)
+	assert.ErrorIs(t, err, ErrLnurlForbiddenAddress)
+}
@@ -0,0 +1,329 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/uptrace/bun"
+)
+
+const (
+	LnurlAuthActionLogin = "login"
+	LnurlAuthActionLink  = "link"
+
+	// lnurlAuthChallengeExpiry is how long a wallet has to sign a k1 and the browser to collect the tokens
+	lnurlAuthChallengeExpiry = 5 * time.Minute
+)
+
+var (
+	ErrLnurlAuthDisabled          = errors.New("lnurl-auth is disabled")
+	ErrLnurlAuthChallengeNotFound = errors.New("unknown or expired k1")
+	ErrInvalidLnurlAuthSignature  = errors.New("invalid signature")
+	ErrLnurlAuthKeyNotLinked      = errors.New("linking key is not linked to an account")
+	ErrLnurlAuthKeyAlreadyLinked  = errors.New("linking key is already linked to an account")
+	ErrLnurlAuthKeyNotFound       = errors.New("linking key not found")
+)
+
+// LnurlAuthChallenge is a new k1. The browser shows LNURL as a QR code for the wallet
+// and collects the result with the Secret, which is only known to it.
+type LnurlAuthChallenge struct {
+	K1        string
+	Secret    string
+	LNURL     string
+	ExpiresAt time.Time
+}
+
+// LnurlAuthResult is the state of a challenge, the tokens are only set once for completed logins
+type LnurlAuthResult struct {
+	Completed    bool
+	AccessToken  string
+	RefreshToken string
+}
+
+// CreateLnurlAuthChallenge creates a k1 to log in, or to link a wallet to the user if the action is link.
+// callbackUrl is the URL of the LNURL-auth callback the wallet calls with its signature.
+func (svc *LndhubService) CreateLnurlAuthChallenge(ctx context.Context, callbackUrl, action string, userId int64) (*LnurlAuthChallenge, error) {
+	if !svc.Config.LnurlAuthEnabled {
+		return nil, ErrLnurlAuthDisabled
+	}
+	k1, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	lnurl, err := encodeLnurlAuth(callbackUrl, k1, action)
+	if err != nil {
+		return nil, err
+	}
+	// expired challenges are removed when new ones are created, there are only a few at any time
+	_, err = svc.DB.NewDelete().Model((*models.LnurlAuthChallenge)(nil)).Where("expires_at < ?", time.Now()).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	challenge := &models.LnurlAuthChallenge{
+		K1:         k1,
+		SecretHash: hashLnurlAuthSecret(secret),
+		Action:     action,
+		UserID:     userId,
+		ExpiresAt:  time.Now().Add(lnurlAuthChallengeExpiry),
+	}
+	if _, err := svc.DB.NewInsert().Model(challenge).Exec(ctx); err != nil {
+		return nil, err
+	}
+	return &LnurlAuthChallenge{K1: k1, Secret: secret, LNURL: lnurl, ExpiresAt: challenge.ExpiresAt}, nil
+}
+
+// CompleteLnurlAuth verifies the signature of a wallet for a k1 and logs in the user of the linking key,
+// or links the key to the user of the challenge. Unknown keys get a new account if account creation is open.
+func (svc *LndhubService) CompleteLnurlAuth(ctx context.Context, k1, sig, key string) error {
+	if !svc.Config.LnurlAuthEnabled {
+		return ErrLnurlAuthDisabled
+	}
+	challenge := &models.LnurlAuthChallenge{}
+	err := svc.DB.NewSelect().Model(challenge).
+		Where("k1 = ? AND completed_at IS NULL AND expires_at > ?", k1, time.Now()).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrLnurlAuthChallengeNotFound
+	}
+	if err != nil {
+		return err
+	}
+	linkingKey, err := VerifyLnurlAuthSignature(k1, sig, key)
+	if err != nil {
+		return err
+	}
+
+	var user *models.User
+	if challenge.Action == LnurlAuthActionLink {
+		user, err = svc.FindUser(ctx, challenge.UserID)
+		if err != nil {
+			return err
+		}
+		if err := svc.linkLnurlAuthKey(ctx, user, linkingKey); err != nil {
+			return err
+		}
+	} else {
+		user, err = svc.lnurlAuthUser(ctx, linkingKey)
+		if err != nil {
+			return err
+		}
+	}
+	if user.Deactivated || user.Deleted {
+		return fmt.Errorf(responses.AccountDeactivatedError.Message)
+	}
+
+	result, err := svc.DB.NewUpdate().Model((*models.LnurlAuthChallenge)(nil)).
+		Set("completed_at = ?", time.Now()).
+		Set("user_id = ?", user.ID).
+		Set("linking_key = ?", linkingKey).
+		Where("k1 = ? AND completed_at IS NULL", k1).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrLnurlAuthChallengeNotFound
+	}
+	if challenge.Action == LnurlAuthActionLogin {
+		svc.recordAuditEventOrLog(ctx, &models.AuditEvent{
+			Type:    models.AuditEventLoginSucceeded,
+			UserID:  user.ID,
+			Login:   user.Login,
+			Details: map[string]interface{}{"method": "lnurl_auth", "linking_key": linkingKey},
+		})
+	}
+	return nil
+}
+
+// LnurlAuthResult returns if the challenge was completed. The tokens of a completed login are only returned once,
+// the challenge is removed when they are collected.
+func (svc *LndhubService) LnurlAuthResult(ctx context.Context, k1, secret string) (*LnurlAuthResult, error) {
+	challenge := &models.LnurlAuthChallenge{}
+	err := svc.DB.NewSelect().Model(challenge).
+		Where("k1 = ? AND secret_hash = ? AND expires_at > ?", k1, hashLnurlAuthSecret(secret), time.Now()).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrLnurlAuthChallengeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if challenge.CompletedAt.IsZero() {
+		return &LnurlAuthResult{}, nil
+	}
+	// concurrent polls of the same challenge only get the tokens once
+	result, err := svc.DB.NewDelete().Model((*models.LnurlAuthChallenge)(nil)).Where("k1 = ?", k1).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, ErrLnurlAuthChallengeNotFound
+	}
+	if challenge.Action != LnurlAuthActionLogin {
+		return &LnurlAuthResult{Completed: true}, nil
+	}
+	user, err := svc.FindUser(ctx, challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Deactivated || user.Deleted {
+		return nil, fmt.Errorf(responses.AccountDeactivatedError.Message)
+	}
+	accessToken, refreshToken, err := svc.generateUserTokens(user)
+	if err != nil {
+		return nil, err
+	}
+	return &LnurlAuthResult{Completed: true, AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// LnurlAuthKeys returns the linking keys of the wallets that can log in as the user
+func (svc *LndhubService) LnurlAuthKeys(ctx context.Context, userId int64) ([]models.LnurlAuthKey, error) {
+	keys := []models.LnurlAuthKey{}
+	err := svc.DB.NewSelect().Model(&keys).Where("user_id = ?", userId).OrderExpr("created_at ASC").Scan(ctx)
+	return keys, err
+}
+
+// UnlinkLnurlAuthKey removes a linking key of the user, the wallet can't log in anymore
+func (svc *LndhubService) UnlinkLnurlAuthKey(ctx context.Context, userId int64, linkingKey string) error {
+	return svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		result, err := tx.NewDelete().Model((*models.LnurlAuthKey)(nil)).
+			Where("user_id = ? AND linking_key = ?", userId, strings.ToLower(linkingKey)).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return ErrLnurlAuthKeyNotFound
+		}
+		return svc.recordAuditEvent(ctx, tx, &models.AuditEvent{
+			Type:    models.AuditEventLnurlAuthUnlinked,
+			UserID:  userId,
+			Details: map[string]interface{}{"linking_key": linkingKey},
+		})
+	})
+}
+
+// lnurlAuthUser returns the user of a linking key, or creates one if account creation is open
+func (svc *LndhubService) lnurlAuthUser(ctx context.Context, linkingKey string) (*models.User, error) {
+	key := &models.LnurlAuthKey{}
+	err := svc.DB.NewSelect().Model(key).Where("linking_key = ?", linkingKey).Scan(ctx)
+	if err == nil {
+		return svc.FindUser(ctx, key.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	// with an admin token only admins create accounts, see AdminMiddleware
+	if !svc.Config.AllowAccountCreation || svc.Config.AdminToken != "" {
+		return nil, ErrLnurlAuthKeyNotLinked
+	}
+	user, err := svc.CreateUser(ctx, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.linkLnurlAuthKey(ctx, user, linkingKey); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (svc *LndhubService) linkLnurlAuthKey(ctx context.Context, user *models.User, linkingKey string) error {
+	return svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		result, err := tx.NewInsert().Model(&models.LnurlAuthKey{LinkingKey: linkingKey, UserID: user.ID}).
+			On("CONFLICT (linking_key) DO NOTHING").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return ErrLnurlAuthKeyAlreadyLinked
+		}
+		return svc.recordAuditEvent(ctx, tx, &models.AuditEvent{
+			Type:    models.AuditEventLnurlAuthLinked,
+			UserID:  user.ID,
+			Login:   user.Login,
+			Details: map[string]interface{}{"linking_key": linkingKey},
+		})
+	})
+}
+
+// VerifyLnurlAuthSignature checks the DER signature of k1 by the linking key (LUD-04), all hex encoded.
+// It returns the normalized linking key.
+func VerifyLnurlAuthSignature(k1, sig, key string) (string, error) {
+	k1Bytes, err := hex.DecodeString(k1)
+	if err != nil || len(k1Bytes) != 32 {
+		return "", fmt.Errorf("%w: invalid k1", ErrInvalidLnurlAuthSignature)
+	}
+	keyBytes, err := hex.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid key", ErrInvalidLnurlAuthSignature)
+	}
+	pubKey, err := btcec.ParsePubKey(keyBytes)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid key", ErrInvalidLnurlAuthSignature)
+	}
+	sigBytes, err := hex.DecodeString(sig)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid sig", ErrInvalidLnurlAuthSignature)
+	}
+	signature, err := ecdsa.ParseDERSignature(sigBytes)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid sig", ErrInvalidLnurlAuthSignature)
+	}
+	if !signature.Verify(k1Bytes, pubKey) {
+		return "", ErrInvalidLnurlAuthSignature
+	}
+	return hex.EncodeToString(pubKey.SerializeCompressed()), nil
+}
+
+// encodeLnurlAuth returns the bech32 encoded LNURL of the callback with the k1
+func encodeLnurlAuth(callbackUrl, k1, action string) (string, error) {
+	u, err := url.Parse(callbackUrl)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("tag", "login")
+	query.Set("k1", k1)
+	query.Set("action", action)
+	u.RawQuery = query.Encode()
+	data, err := bech32.ConvertBits([]byte(u.String()), 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	lnurl, err := bech32.Encode("lnurl", data)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(lnurl), nil
+}
+
+func hashLnurlAuthSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyLnurlAuthSignature(t *testing.T) {
+	privKey, err := btcec.NewPrivateKey()
+	assert.NoError(t, err)
+	k1, err := randomHex(32)
+	assert.NoError(t, err)
+	k1Bytes, _ := hex.DecodeString(k1)
+	sig := hex.EncodeToString(ecdsa.Sign(privKey, k1Bytes).Serialize())
+	key := hex.EncodeToString(privKey.PubKey().SerializeCompressed())
+
+	linkingKey, err := VerifyLnurlAuthSignature(k1, sig, strings.ToUpper(key))
+	assert.NoError(t, err)
+	assert.Equal(t, key, linkingKey)
+
+	// a signature of another k1
+	other := sha256.Sum256(k1Bytes)
+	otherSig := hex.EncodeToString(ecdsa.Sign(privKey, other[:]).Serialize())
+	_, err = VerifyLnurlAuthSignature(k1, otherSig, key)
+	assert.ErrorIs(t, err, ErrInvalidLnurlAuthSignature)
+
+	_, err = VerifyLnurlAuthSignature(k1, "not hex", key)
+	assert.ErrorIs(t, err, ErrInvalidLnurlAuthSignature)
+	_, err = VerifyLnurlAuthSignature(k1, sig, "02")
+	assert.ErrorIs(t, err, ErrInvalidLnurlAuthSignature)
+	_, err = VerifyLnurlAuthSignature("abcd", sig, key)
+	assert.ErrorIs(t, err, ErrInvalidLnurlAuthSignature)
+}
+
+func TestEncodeLnurlAuth(t *testing.T) {
+	lnurl, err := encodeLnurlAuth("https://example.com/v2/lnurlauth/callback", "e2af6254a8df433264fa23f67eb8188635d15ce883e8fc020989d5f82ae6f11e", LnurlAuthActionLogin)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(lnurl, "LNURL1"))
+	hrp, data, err := bech32.DecodeNoLimit(strings.ToLower(lnurl))
+	assert.NoError(t, err)
+	assert.Equal(t, "lnurl", hrp)
+	decoded, err := bech32.ConvertBits(data, 5, 8, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/v2/lnurlauth/callback?action=login&k1=e2af6254a8df433264fa23f67eb8188635d15ce883e8fc020989d5f82ae6f11e&tag=login", string(decoded))
+}
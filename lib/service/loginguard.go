@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/lockout"
+	"github.com/labstack/gommon/log"
+	"github.com/uptrace/bun"
+)
+
+// loginGuardPruneInterval is how often forgotten login failures are removed from the store
+const loginGuardPruneInterval = time.Hour
+
+var (
+	ErrLoginLocked        = errors.New("too many failed login attempts")
+	ErrLoginGuardDisabled = errors.New("login lockout is disabled")
+)
+
+// LoginAttemptError is a failed or locked out login.
+// Clients should ask the user to solve a captcha before they retry if CaptchaRequired is set.
+type LoginAttemptError struct {
+	Locked          bool
+	RetryAfter      time.Duration
+	CaptchaRequired bool
+}
+
+func (e *LoginAttemptError) Error() string {
+	if e.Locked {
+		return ErrLoginLocked.Error()
+	}
+	return "bad auth"
+}
+
+func (e *LoginAttemptError) Is(target error) bool {
+	return e.Locked && target == ErrLoginLocked
+}
+
+// InitLoginGuard creates the brute-force protection of /auth with the configured store.
+// It returns nil if AUTH_LOCKOUT_STORE is none and failed logins are not limited.
+func InitLoginGuard(c *Config, db *bun.DB) (*lockout.Guard, error) {
+	var store lockout.Store
+	switch c.AuthLockoutStore {
+	case "postgres":
+		store = lockout.NewPostgresStore(db)
+	case "memory":
+		store = lockout.NewMemoryStore()
+	case "none", "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown auth lockout store %q", c.AuthLockoutStore)
+	}
+	policy := lockout.Policy{
+		MaxFailures:  c.AuthMaxFailedLogins,
+		Lockout:      time.Duration(c.AuthLockoutDuration) * time.Second,
+		MaxLockout:   time.Duration(c.AuthMaxLockoutDuration) * time.Second,
+		Reset:        time.Duration(c.AuthFailedLoginsReset) * time.Second,
+		CaptchaAfter: c.AuthCaptchaAfter,
+	}
+	ipPolicy := policy
+	ipPolicy.MaxFailures = c.AuthMaxFailedLoginsPerIP
+	ipPolicy.CaptchaAfter = 0
+	return lockout.NewGuard(store, policy, ipPolicy), nil
+}
+
+// checkLogin rejects attempts of locked out logins and IPs before the password is checked
+func (svc *LndhubService) checkLogin(ctx context.Context, login string) error {
+	if svc.LoginGuard == nil {
+		return nil
+	}
+	status, err := svc.LoginGuard.Check(ctx, login, ClientIPFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if now := time.Now(); status.Locked(now) {
+		return &LoginAttemptError{Locked: true, RetryAfter: status.LockedUntil.Sub(now), CaptchaRequired: status.CaptchaRequired}
+	}
+	return nil
+}
+
+// failLogin records a failed login and returns the error for it, which tells if the login is locked now
+func (svc *LndhubService) failLogin(ctx context.Context, login string, user *models.User, reason string) error {
+	svc.recordLoginAttempt(ctx, login, user, reason)
+	if svc.LoginGuard == nil {
+		return fmt.Errorf("bad auth")
+	}
+	status, err := svc.LoginGuard.Fail(ctx, login, ClientIPFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if !status.Locked(now) {
+		return &LoginAttemptError{CaptchaRequired: status.CaptchaRequired}
+	}
+	event := &models.AuditEvent{
+		Type:    models.AuditEventLoginLocked,
+		Login:   login,
+		Details: map[string]interface{}{"failures": status.Failures, "locked_until": status.LockedUntil},
+	}
+	if user != nil {
+		event.UserID = user.ID
+	}
+	svc.recordAuditEventOrLog(ctx, event)
+	return &LoginAttemptError{Locked: true, RetryAfter: status.LockedUntil.Sub(now), CaptchaRequired: status.CaptchaRequired}
+}
+
+// succeedLogin records a successful login and forgets the failures of the login
+func (svc *LndhubService) succeedLogin(ctx context.Context, login string, user *models.User) error {
+	svc.recordLoginAttempt(ctx, login, user, "")
+	if svc.LoginGuard == nil {
+		return nil
+	}
+	return svc.LoginGuard.Succeed(ctx, login)
+}
+
+// UnlockLogin lifts the lockout of a login and/or a client IP and forgets their failed attempts
+func (svc *LndhubService) UnlockLogin(ctx context.Context, login, ip string) error {
+	if svc.LoginGuard == nil {
+		return ErrLoginGuardDisabled
+	}
+	if err := svc.LoginGuard.Unlock(ctx, login, ip); err != nil {
+		return err
+	}
+	event := &models.AuditEvent{Type: models.AuditEventLoginUnlocked, Login: login, Details: map[string]interface{}{"ip": ip}}
+	if login != "" {
+		if user, err := svc.FindUserByLogin(ctx, login); err == nil {
+			event.UserID = user.ID
+		}
+	}
+	if err := svc.RecordAuditEvent(ctx, event); err != nil {
+		return err
+	}
+	target := login
+	if target == "" {
+		target = ip
+	}
+	return svc.RecordAdminAction(ctx, "unlock_login", event.UserID, target, nil, map[string]interface{}{"login": login, "ip": ip})
+}
+
+// StartLoginGuardPruneRoutine periodically removes failed logins that are forgotten anyway from the store
+func (svc *LndhubService) StartLoginGuardPruneRoutine(ctx context.Context) error {
+	if svc.LoginGuard == nil {
+		return nil
+	}
+	ticker := time.NewTicker(loginGuardPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := svc.LoginGuard.Prune(ctx); err != nil {
+				svc.Logger.Errorj(log.JSON{"message": "failed to prune login failures", "error": err})
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/tokens"
+	"github.com/uptrace/bun"
+)
+
+var (
+	ErrNostrAuthDisabled        = errors.New("nostr auth is disabled")
+	ErrNostrPubkeyNotLinked     = errors.New("nostr pubkey is not linked to an account")
+	ErrNostrPubkeyAlreadyLinked = errors.New("nostr pubkey is already linked to an account")
+	ErrNostrPubkeyNotFound      = errors.New("nostr pubkey not found")
+)
+
+// NostrAuth returns the verifier of NIP-98 events of requests to the PUBLIC_URL of the hub
+func (svc *LndhubService) NostrAuth() *tokens.NostrAuth {
+	return &tokens.NostrAuth{BaseURL: svc.Config.PublicUrl, Seen: svc.NostrEventSeen}
+}
+
+// NostrEventSeen records the id of a NIP-98 event in the database, so that it can't be replayed against any instance
+func (svc *LndhubService) NostrEventSeen(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	// expired events are removed when new ones are recorded, there are only the events of the last minutes
+	_, err := svc.DB.NewDelete().Model((*models.NostrAuthEvent)(nil)).Where("expires_at < ?", time.Now()).Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	result, err := svc.DB.NewInsert().Model(&models.NostrAuthEvent{ID: id, ExpiresAt: expiresAt}).
+		On("CONFLICT (id) DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 0, err
+}
+
+// NostrAuthUser returns the id of the user a pubkey is linked to, it is the lookup of the nostr auth middleware
+func (svc *LndhubService) NostrAuthUser(ctx context.Context, pubkey string) (int64, error) {
+	if !svc.Config.NostrAuthEnabled {
+		return 0, ErrNostrAuthDisabled
+	}
+	key := &models.NostrPubkey{}
+	err := svc.DB.NewSelect().Model(key).Where("pubkey = ?", strings.ToLower(pubkey)).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNostrPubkeyNotLinked
+	}
+	if err != nil {
+		return 0, err
+	}
+	return key.UserID, nil
+}
+
+// NostrPubkeys returns the pubkeys that can authenticate requests of the user
+func (svc *LndhubService) NostrPubkeys(ctx context.Context, userId int64) ([]models.NostrPubkey, error) {
+	keys := []models.NostrPubkey{}
+	err := svc.DB.NewSelect().Model(&keys).Where("user_id = ?", userId).OrderExpr("created_at ASC").Scan(ctx)
+	return keys, err
+}
+
+// LinkNostrPubkey links a pubkey to the user. The caller must have verified a NIP-98 event of the pubkey.
+func (svc *LndhubService) LinkNostrPubkey(ctx context.Context, userId int64, pubkey string) error {
+	if !svc.Config.NostrAuthEnabled {
+		return ErrNostrAuthDisabled
+	}
+	user, err := svc.FindUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	pubkey = strings.ToLower(pubkey)
+	return svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		result, err := tx.NewInsert().Model(&models.NostrPubkey{Pubkey: pubkey, UserID: user.ID}).
+			On("CONFLICT (pubkey) DO NOTHING").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return ErrNostrPubkeyAlreadyLinked
+		}
+		return svc.recordAuditEvent(ctx, tx, &models.AuditEvent{
+			Type:    models.AuditEventNostrLinked,
+			UserID:  user.ID,
+			Login:   user.Login,
+			Details: map[string]interface{}{"pubkey": pubkey},
+		})
+	})
+}
+
+// UnlinkNostrPubkey removes a pubkey of the user, its events are not accepted anymore
+func (svc *LndhubService) UnlinkNostrPubkey(ctx context.Context, userId int64, pubkey string) error {
+	pubkey = strings.ToLower(pubkey)
+	return svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		result, err := tx.NewDelete().Model((*models.NostrPubkey)(nil)).
+			Where("user_id = ? AND pubkey = ?", userId, pubkey).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return ErrNostrPubkeyNotFound
+		}
+		return svc.recordAuditEvent(ctx, tx, &models.AuditEvent{
+			Type:    models.AuditEventNostrUnlinked,
+			UserID:  userId,
+			Details: map[string]interface{}{"pubkey": pubkey},
+		})
+	})
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getAlby/lndhub.go/lib/ratelimit"
+	"github.com/labstack/gommon/log"
+	"github.com/redis/go-redis/v9"
+	"github.com/uptrace/bun"
+)
+
+const (
+	// rateLimitPruneInterval is how often full buckets are removed from stores that don't expire them
+	rateLimitPruneInterval = time.Hour
+	// rateLimitRedisPrefix namespaces the keys of the rate limits in a shared redis
+	rateLimitRedisPrefix = "lndhub:ratelimit:"
+)
+
+// InitRateLimiter creates the rate limiter of the API with the store configured by RATE_LIMIT_STORE.
+// The memory store is per process, replicas need postgres or redis to share their limits.
+func InitRateLimiter(c *Config, db *bun.DB) (*ratelimit.Limiter, error) {
+	var store ratelimit.Store
+	switch c.RateLimitStore {
+	case "memory", "":
+		store = ratelimit.NewMemoryStore()
+	case "postgres":
+		store = ratelimit.NewPostgresStore(db)
+	case "redis":
+		if c.RedisUrl == "" {
+			return nil, errors.New("REDIS_URL is required for the redis rate limit store")
+		}
+		opts, err := redis.ParseURL(c.RedisUrl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		store = ratelimit.NewRedisStore(redis.NewClient(opts), rateLimitRedisPrefix)
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", c.RateLimitStore)
+	}
+	return ratelimit.NewLimiter(store), nil
+}
+
+// StartRateLimitPruneRoutine periodically removes the buckets that are full again from the rate limit store
+func (svc *LndhubService) StartRateLimitPruneRoutine(ctx context.Context) error {
+	if svc.RateLimiter == nil {
+		return nil
+	}
+	ticker := time.NewTicker(rateLimitPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := svc.RateLimiter.Prune(ctx); err != nil {
+				svc.Logger.Errorj(log.JSON{"message": "failed to prune rate limits", "error": err})
+			}
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/getsentry/sentry-go"
+	"github.com/labstack/gommon/log"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/robfig/cron/v3"
+	"github.com/uptrace/bun"
+)
+
+const (
+	ScheduledPaymentTypeKeysend   = "keysend"
+	ScheduledPaymentTypeLnAddress = "lnaddress"
+
+	ScheduledPaymentStateActive    = "active"
+	ScheduledPaymentStatePaused    = "paused"
+	ScheduledPaymentStateCompleted = "completed"
+
+	ScheduledPaymentRunStateSettled = "settled"
+	ScheduledPaymentRunStateFailed  = "failed"
+	// ScheduledPaymentRunStatePending is a run whose payment may still be in flight, its invoice is resolved by reconciliation
+	ScheduledPaymentRunStatePending = "pending"
+
+	scheduledPaymentSucceededRoutingKey = "scheduled_payment.succeeded"
+	scheduledPaymentFailedRoutingKey    = "scheduled_payment.failed"
+	scheduledPaymentPendingRoutingKey   = "scheduled_payment.pending"
+	scheduledPaymentCompletedRoutingKey = "scheduled_payment.completed"
+)
+
+var (
+	ErrScheduledPaymentNotFound  = errors.New("scheduled payment not found")
+	ErrScheduledPaymentCompleted = errors.New("scheduled payment is completed")
+	ErrInvalidSchedule           = errors.New("invalid schedule")
+	ErrInvalidScheduledPayment   = errors.New("invalid scheduled payment destination")
+)
+
+// ScheduledPaymentEvent is published to the message broker after every run of a scheduled payment
+type ScheduledPaymentEvent struct {
+	ScheduledPayment *models.ScheduledPayment    `json:"scheduled_payment"`
+	Run              *models.ScheduledPaymentRun `json:"run,omitempty"`
+}
+
+// ParseSchedule parses a cron expression with 5 fields (minute hour day-of-month month day-of-week),
+// a descriptor like @daily or @weekly, or an interval like @every 1h
+func ParseSchedule(schedule string) (cron.Schedule, error) {
+	parsed, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+	return parsed, nil
+}
+
+func (svc *LndhubService) validateScheduledPayment(payment *models.ScheduledPayment) (cron.Schedule, error) {
+	schedule, err := ParseSchedule(payment.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	switch payment.Type {
+	case ScheduledPaymentTypeKeysend:
+		if _, err := hex.DecodeString(payment.Destination); err != nil || len(payment.Destination) != common.DestinationPubkeyHexSize {
+			return nil, ErrInvalidScheduledPayment
+		}
+		if svc.LndClient.IsIdentityPubkey(payment.Destination) && len(payment.CustomRecords[TLV_WALLET_ID]) == 0 {
+			return nil, fmt.Errorf("%w: internal keysend payments require the custom record %d to be present", ErrInvalidScheduledPayment, TLV_WALLET_ID)
+		}
+	case ScheduledPaymentTypeLnAddress:
+		if i := strings.LastIndex(payment.Destination, "@"); i <= 0 || i == len(payment.Destination)-1 {
+			return nil, ErrInvalidLightningAddress
+		}
+	default:
+		return nil, ErrInvalidScheduledPayment
+	}
+	return schedule, nil
+}
+
+func (svc *LndhubService) CreateScheduledPayment(ctx context.Context, payment *models.ScheduledPayment) error {
+	schedule, err := svc.validateScheduledPayment(payment)
+	if err != nil {
+		return err
+	}
+	payment.WalletID = WalletIDFromContext(ctx)
+	payment.State = ScheduledPaymentStateActive
+	payment.NextRunAt = schedule.Next(time.Now())
+	_, err = svc.DB.NewInsert().Model(payment).Exec(ctx)
+	return err
+}
+
+func (svc *LndhubService) ScheduledPaymentsFor(ctx context.Context, userId int64) ([]models.ScheduledPayment, error) {
+	payments := []models.ScheduledPayment{}
+	query := svc.DB.NewSelect().Model(&payments).Where("user_id = ?", userId)
+	if walletId := WalletIDFromContext(ctx); walletId != 0 {
+		query.Where("wallet_id = ?", walletId)
+	} else {
+		query.Where("wallet_id IS NULL")
+	}
+	err := query.Order("id").Scan(ctx)
+	return payments, err
+}
+
+func (svc *LndhubService) FindScheduledPayment(ctx context.Context, userId, id int64) (*models.ScheduledPayment, error) {
+	var payment models.ScheduledPayment
+	query := svc.DB.NewSelect().Model(&payment).Where("id = ? AND user_id = ?", id, userId)
+	if walletId := WalletIDFromContext(ctx); walletId != 0 {
+		query.Where("wallet_id = ?", walletId)
+	} else {
+		query.Where("wallet_id IS NULL")
+	}
+	err := query.Limit(1).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrScheduledPaymentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// UpdateScheduledPayment saves changes of the amount, memo, custom records, schedule and budget.
+// A changed schedule takes effect from now on.
+func (svc *LndhubService) UpdateScheduledPayment(ctx context.Context, payment *models.ScheduledPayment, scheduleChanged bool) error {
+	schedule, err := svc.validateScheduledPayment(payment)
+	if err != nil {
+		return err
+	}
+	if scheduleChanged {
+		payment.NextRunAt = schedule.Next(time.Now())
+	}
+	_, err = svc.DB.NewUpdate().Model(payment).Column("amount", "memo", "custom_records", "schedule", "budget", "next_run_at", "updated_at").WherePK().Exec(ctx)
+	return err
+}
+
+func (svc *LndhubService) DeleteScheduledPayment(ctx context.Context, userId, id int64) error {
+	query := svc.DB.NewDelete().Model((*models.ScheduledPayment)(nil)).Where("id = ? AND user_id = ?", id, userId)
+	if walletId := WalletIDFromContext(ctx); walletId != 0 {
+		query.Where("wallet_id = ?", walletId)
+	} else {
+		query.Where("wallet_id IS NULL")
+	}
+	result, err := query.Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrScheduledPaymentNotFound
+	}
+	return nil
+}
+
+func (svc *LndhubService) PauseScheduledPayment(ctx context.Context, userId, id int64) (*models.ScheduledPayment, error) {
+	payment, err := svc.FindScheduledPayment(ctx, userId, id)
+	if err != nil {
+		return nil, err
+	}
+	if payment.State == ScheduledPaymentStateCompleted {
+		return nil, ErrScheduledPaymentCompleted
+	}
+	payment.State = ScheduledPaymentStatePaused
+	_, err = svc.DB.NewUpdate().Model(payment).Column("state", "updated_at").WherePK().Exec(ctx)
+	return payment, err
+}
+
+// ResumeScheduledPayment reactivates a paused payment, runs that were missed while it was paused are skipped
+func (svc *LndhubService) ResumeScheduledPayment(ctx context.Context, userId, id int64) (*models.ScheduledPayment, error) {
+	payment, err := svc.FindScheduledPayment(ctx, userId, id)
+	if err != nil {
+		return nil, err
+	}
+	if payment.State == ScheduledPaymentStateCompleted {
+		return nil, ErrScheduledPaymentCompleted
+	}
+	schedule, err := ParseSchedule(payment.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	payment.State = ScheduledPaymentStateActive
+	payment.Retries = 0
+	payment.NextRunAt = schedule.Next(time.Now())
+	_, err = svc.DB.NewUpdate().Model(payment).Column("state", "retries", "next_run_at", "updated_at").WherePK().Exec(ctx)
+	return payment, err
+}
+
+func (svc *LndhubService) ScheduledPaymentRunsFor(ctx context.Context, scheduledPaymentId int64) ([]models.ScheduledPaymentRun, error) {
+	runs := []models.ScheduledPaymentRun{}
+	err := svc.DB.NewSelect().Model(&runs).Relation("Invoice").Where("scheduled_payment_run.scheduled_payment_id = ?", scheduledPaymentId).Order("scheduled_payment_run.id DESC").Scan(ctx)
+	return runs, err
+}
+
+// StartScheduledPaymentRoutine periodically executes the scheduled payments that are due
+func (svc *LndhubService) StartScheduledPaymentRoutine(ctx context.Context) (err error) {
+	if svc.Config.ScheduledPaymentInterval <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(time.Duration(svc.Config.ScheduledPaymentInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		count, err := svc.RunDueScheduledPayments(ctx)
+		if err != nil {
+			sentry.CaptureException(err)
+			svc.Logger.Errorf("Error running scheduled payments: %v", err)
+		}
+		if count > 0 {
+			svc.Logger.Infof("Ran %d scheduled payments", count)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDueScheduledPayments executes all active scheduled payments whose next run is due
+func (svc *LndhubService) RunDueScheduledPayments(ctx context.Context) (count int, err error) {
+	payments := []models.ScheduledPayment{}
+	err = svc.DB.NewSelect().
+		Model(&payments).
+		Where("state = ?", ScheduledPaymentStateActive).
+		Where("next_run_at <= ?", time.Now()).
+		Order("next_run_at").
+		Limit(sweepBatchSize).
+		Scan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, payment := range payments {
+		payment := payment
+		claimed, err := svc.claimScheduledPayment(ctx, &payment)
+		if err != nil {
+			return count, err
+		}
+		// another instance is already running this payment
+		if !claimed {
+			continue
+		}
+		err = svc.runScheduledPayment(ctx, &payment)
+		if err != nil {
+			sentry.CaptureException(err)
+			svc.Logger.Errorf("Error running scheduled payment id:%v user_id:%v: %v", payment.ID, payment.UserID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// claimScheduledPayment moves the next run of the payment to the next occurrence of its schedule.
+// The condition on next_run_at makes sure that only one instance makes the payment.
+func (svc *LndhubService) claimScheduledPayment(ctx context.Context, payment *models.ScheduledPayment) (bool, error) {
+	schedule, err := ParseSchedule(payment.Schedule)
+	if err != nil {
+		return false, err
+	}
+	dueAt := payment.NextRunAt
+	payment.NextRunAt = schedule.Next(time.Now())
+	payment.LastRunAt = bun.NullTime{Time: time.Now()}
+	result, err := svc.DB.NewUpdate().
+		Model(payment).
+		Column("next_run_at", "last_run_at", "updated_at").
+		WherePK().
+		Where("state = ?", ScheduledPaymentStateActive).
+		Where("next_run_at = ?", dueAt).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (svc *LndhubService) runScheduledPayment(ctx context.Context, payment *models.ScheduledPayment) error {
+	if payment.Budget > 0 && payment.TotalPaid+payment.Amount > payment.Budget {
+		payment.State = ScheduledPaymentStateCompleted
+		_, err := svc.DB.NewUpdate().Model(payment).Column("state", "updated_at").WherePK().Exec(ctx)
+		if err != nil {
+			return err
+		}
+		svc.publishScheduledPaymentEvent(scheduledPaymentCompletedRoutingKey, payment, nil)
+		return nil
+	}
+
+	run := &models.ScheduledPaymentRun{
+		ScheduledPaymentID: payment.ID,
+		Amount:             payment.Amount,
+	}
+	// the payment is made from the wallet it was created for
+	invoice, transient, err := svc.payScheduledPayment(WithWallet(ctx, payment.WalletID), payment)
+	if invoice != nil {
+		run.InvoiceID = invoice.ID
+	}
+	if err != nil && PaymentOutcomeUnknown(err) {
+		// a retry could pay twice, the amount counts towards the budget until the invoice is resolved
+		svc.Logger.Errorj(log.JSON{
+			"message":              "scheduled payment outcome unknown",
+			"scheduled_payment_id": payment.ID,
+			"lndhub_user_id":       payment.UserID,
+			"error":                err,
+		})
+		run.State = ScheduledPaymentRunStatePending
+		run.ErrorMessage = err.Error()
+		payment.TotalPaid += payment.Amount
+		payment.Retries = 0
+		payment.LastError = err.Error()
+	} else if err != nil {
+		svc.Logger.Errorj(log.JSON{
+			"message":              "scheduled payment failed",
+			"scheduled_payment_id": payment.ID,
+			"lndhub_user_id":       payment.UserID,
+			"retries":              payment.Retries,
+			"error":                err,
+		})
+		run.State = ScheduledPaymentRunStateFailed
+		run.ErrorMessage = err.Error()
+		payment.LastError = err.Error()
+		if transient && payment.Retries < svc.Config.ScheduledPaymentMaxRetries {
+			// retry with an exponential backoff, unless the next regular run comes first
+			retryAt := time.Now().Add(time.Duration(svc.Config.ScheduledPaymentRetryDelay<<payment.Retries) * time.Second)
+			if retryAt.Before(payment.NextRunAt) {
+				payment.NextRunAt = retryAt
+			}
+			payment.Retries++
+		} else {
+			payment.Retries = 0
+		}
+	} else {
+		run.State = ScheduledPaymentRunStateSettled
+		payment.TotalPaid += payment.Amount
+		payment.Retries = 0
+		payment.LastError = ""
+	}
+
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewInsert().Model(run).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewUpdate().Model(payment).Column("total_paid", "retries", "next_run_at", "last_error", "updated_at").WherePK().Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	switch run.State {
+	case ScheduledPaymentRunStateSettled:
+		svc.publishScheduledPaymentEvent(scheduledPaymentSucceededRoutingKey, payment, run)
+	case ScheduledPaymentRunStatePending:
+		svc.publishScheduledPaymentEvent(scheduledPaymentPendingRoutingKey, payment, run)
+	default:
+		svc.publishScheduledPaymentEvent(scheduledPaymentFailedRoutingKey, payment, run)
+	}
+	return nil
+}
+
+// payScheduledPayment makes a single payment through the same checks as payments made by the API.
+// Scheduled payments can't send a TOTP code, so they fail above the payment threshold of the user.
+// transient is false for failures that would happen again on a retry, e.g. a too low balance.
+// Errors for which PaymentOutcomeUnknown is true must not be retried.
+func (svc *LndhubService) payScheduledPayment(ctx context.Context, payment *models.ScheduledPayment) (invoice *models.Invoice, transient bool, err error) {
+	user, err := svc.FindUser(ctx, payment.UserID)
+	if err != nil {
+		return nil, true, err
+	}
+	if user.Deactivated || user.Deleted {
+		return nil, false, errors.New(responses.AccountDeactivatedError.Message)
+	}
+	lnPayReq := &lnd.LNPayReq{
+		PayReq: &lnrpc.PayReq{
+			Destination: payment.Destination,
+			NumSatoshis: payment.Amount,
+			Description: payment.Memo,
+		},
+		Keysend: true,
+	}
+	paymentRequest := ""
+	if payment.Type == ScheduledPaymentTypeLnAddress {
+		paymentRequest, err = svc.FetchLightningAddressInvoice(ctx, payment.Destination, payment.Amount, payment.Memo)
+		if err != nil {
+			return nil, !errors.Is(err, ErrTransferRecipientNotFound), err
+		}
+		decoded, err := svc.DecodePaymentRequest(ctx, paymentRequest)
+		if err != nil {
+			return nil, true, err
+		}
+		if decoded.NumSatoshis != payment.Amount {
+			return nil, true, fmt.Errorf("invoice amount %d does not match the payment amount", decoded.NumSatoshis)
+		}
+		lnPayReq = &lnd.LNPayReq{PayReq: decoded}
+	}
+
+	// scheduled payments can't be created with tokens that are bound to a budget
+	errResp, err := svc.checkOutgoingPaymentsAllowed(ctx, svc.scheduledPaymentLimits(payment), 0, "", []*lnd.LNPayReq{lnPayReq}, payment.UserID)
+	if err != nil {
+		return nil, true, err
+	}
+	if errResp != nil {
+		return nil, false, errors.New(errResp.Message)
+	}
+	invoice, errResp = svc.AddOutgoingInvoice(ctx, payment.UserID, paymentRequest, lnPayReq)
+	if errResp != nil {
+		return nil, true, errors.New(errResp.Message)
+	}
+	if payment.Type == ScheduledPaymentTypeKeysend {
+		invoice.DestinationCustomRecords = payment.CustomRecords
+	}
+	_, err = svc.PayInvoice(ctx, invoice)
+	return invoice, true, err
+}
+
+// scheduledPaymentLimits returns the configured limits with the send limits stored with the payment
+func (svc *LndhubService) scheduledPaymentLimits(payment *models.ScheduledPayment) *Limits {
+	limits := svc.DefaultLimits()
+	if payment.MaxSendAmount != nil {
+		limits.MaxSendAmount = *payment.MaxSendAmount
+	}
+	if payment.MaxSendVolume != nil {
+		limits.MaxSendVolume = *payment.MaxSendVolume
+	}
+	return limits
+}
+
+func (svc *LndhubService) publishScheduledPaymentEvent(routingKey string, payment *models.ScheduledPayment, run *models.ScheduledPaymentRun) {
+	if svc.EventsClient == nil {
+		return
+	}
+	err := svc.EventsClient.PublishScheduledPaymentEvent(context.Background(), routingKey, &ScheduledPaymentEvent{
+		ScheduledPayment: payment,
+		Run:              run,
+	})
+	if err != nil {
+		sentry.CaptureException(err)
+		svc.Logger.Errorj(log.JSON{
+			"message":              "error publishing scheduled payment event",
+			"scheduled_payment_id": payment.ID,
+			"routing_key":          routingKey,
+			"error":                err,
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2024, 6, 13, 12, 30, 0, 0, time.UTC)
+
+	schedule, err := ParseSchedule("0 9 * * 1")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 6, 17, 9, 0, 0, 0, time.UTC), schedule.Next(now))
+
+	schedule, err = ParseSchedule("@daily")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC), schedule.Next(now))
+
+	schedule, err = ParseSchedule("@every 12h")
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(12*time.Hour), schedule.Next(now))
+
+	_, err = ParseSchedule("every tuesday")
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+}
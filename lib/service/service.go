@@ -2,32 +2,36 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 
-	"github.com/getAlby/lndhub.go/rabbitmq"
-
 	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/events"
+	"github.com/getAlby/lndhub.go/lib/lockout"
+	"github.com/getAlby/lndhub.go/lib/ratelimit"
 	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/getAlby/lndhub.go/lib/security"
 	"github.com/getAlby/lndhub.go/lib/tokens"
 	"github.com/getAlby/lndhub.go/lnd"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/random"
 	"github.com/uptrace/bun"
 	"github.com/ziflex/lecho/v3"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const alphaNumBytes = random.Alphanumeric
 
 type LndhubService struct {
-	Config         *Config
-	DB             *bun.DB
-	LndClient      lnd.LightningClientWrapper
-	RabbitMQClient rabbitmq.Client
-	Logger         *lecho.Logger
-	InvoicePubSub  *Pubsub
+	Config        *Config
+	DB            *bun.DB
+	LndClient     lnd.LightningClientWrapper
+	EventsClient  events.Client
+	Logger        *lecho.Logger
+	InvoicePubSub *Pubsub
+	LoginGuard    *lockout.Guard
+	RateLimiter   *ratelimit.Limiter
 }
 
 func (svc *LndhubService) GenerateToken(ctx context.Context, login, password, inRefreshToken string) (accessToken, refreshToken string, err error) {
@@ -36,11 +40,29 @@ func (svc *LndhubService) GenerateToken(ctx context.Context, login, password, in
 	switch {
 	case login != "" || password != "":
 		{
+			// locked out logins are rejected before the expensive password check
+			if err := svc.checkLogin(ctx, login); err != nil {
+				return "", "", err
+			}
 			if err := svc.DB.NewSelect().Model(&user).Where("login = ?", login).Scan(ctx); err != nil {
-				return "", "", fmt.Errorf("bad auth")
+				return "", "", svc.failLogin(ctx, login, nil, "unknown login")
 			}
-			if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
-				return "", "", fmt.Errorf("bad auth")
+			if !security.CheckPassword(user.Password, password) {
+				return "", "", svc.failLogin(ctx, login, &user, "wrong password")
+			}
+			if user.Deactivated || user.Deleted {
+				svc.recordLoginAttempt(ctx, login, &user, "deactivated")
+				return "", "", fmt.Errorf(responses.AccountDeactivatedError.Message)
+			}
+			// users that enrolled TOTP also need a code, guessed codes count as failed logins
+			if err := svc.RequireTOTP(ctx, user.ID, TOTPCodeFromContext(ctx), TOTPActionLogin); err != nil {
+				if errors.Is(err, ErrInvalidTOTPCode) {
+					return "", "", svc.failLogin(ctx, login, &user, "invalid totp code")
+				}
+				return "", "", err
+			}
+			if err := svc.succeedLogin(ctx, login, &user); err != nil {
+				return "", "", err
 			}
 		}
 	case inRefreshToken != "":
@@ -53,6 +75,21 @@ func (svc *LndhubService) GenerateToken(ctx context.Context, login, password, in
 			if err := svc.DB.NewSelect().Model(&user).Where("id = ?", userId).Scan(ctx); err != nil {
 				return "", "", fmt.Errorf("bad auth")
 			}
+			// tokens that are bound to a budget are renewed as long as the budget is not revoked
+			budgetId, err := tokens.GetBudgetIdFromToken(svc.Config.JWTSecret, inRefreshToken)
+			if err != nil {
+				return "", "", fmt.Errorf("bad auth")
+			}
+			if budgetId != 0 {
+				if user.Deactivated || user.Deleted {
+					return "", "", fmt.Errorf(responses.AccountDeactivatedError.Message)
+				}
+				budget, err := svc.FindBudget(ctx, user.ID, budgetId)
+				if err != nil {
+					return "", "", fmt.Errorf("bad auth")
+				}
+				return svc.GenerateBudgetTokens(ctx, budget)
+			}
 		}
 	default:
 		{
@@ -64,12 +101,17 @@ func (svc *LndhubService) GenerateToken(ctx context.Context, login, password, in
 		return "", "", fmt.Errorf(responses.AccountDeactivatedError.Message)
 	}
 
-	accessToken, err = tokens.GenerateAccessToken(svc.Config.JWTSecret, svc.Config.JWTAccessTokenExpiry, &user)
+	return svc.generateUserTokens(&user)
+}
+
+// generateUserTokens returns the access and refresh token of a user that authenticated
+func (svc *LndhubService) generateUserTokens(user *models.User) (accessToken, refreshToken string, err error) {
+	accessToken, err = tokens.GenerateAccessToken(svc.Config.JWTSecret, svc.Config.JWTAccessTokenExpiry, user)
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshToken, err = tokens.GenerateRefreshToken(svc.Config.JWTSecret, svc.Config.JWTRefreshTokenExpiry, &user)
+	refreshToken, err = tokens.GenerateRefreshToken(svc.Config.JWTSecret, svc.Config.JWTRefreshTokenExpiry, user)
 	if err != nil {
 		return "", "", err
 	}
@@ -113,6 +155,17 @@ func (svc *LndhubService) ValidateUserMiddleware() echo.MiddlewareFunc {
 					"message": "bad auth",
 				})
 			}
+			// payments made in the request are charged to the budget of the token
+			if budgetId := svc.GetBudgetID(c); budgetId != 0 {
+				if _, err := svc.FindBudget(c.Request().Context(), userId, budgetId); err != nil {
+					return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+						"error":   true,
+						"code":    1,
+						"message": "bad auth",
+					})
+				}
+				c.SetRequest(c.Request().WithContext(WithBudget(c.Request().Context(), budgetId)))
+			}
 			return next(c)
 		}
 	}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lnd"
+	"github.com/getsentry/sentry-go"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// invoices are swept in batches so a large backlog doesn't block the routine for too long
+const sweepBatchSize = 1000
+
+var ErrPaymentNotInitiated = errors.New("payment never reached the lightning node")
+
+// StartInvoiceSweepRoutine periodically expires open incoming invoices and cleans up
+// outgoing payments that never reached LND, e.g. because LndHub crashed while paying
+func (svc *LndhubService) StartInvoiceSweepRoutine(ctx context.Context) (err error) {
+	if svc.Config.InvoiceSweepInterval <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(time.Duration(svc.Config.InvoiceSweepInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		svc.SweepInvoices(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepInvoices runs a single pass of the invoice sweeper
+func (svc *LndhubService) SweepInvoices(ctx context.Context) {
+	expired, err := svc.ExpireOpenInvoices(ctx)
+	if err != nil {
+		sentry.CaptureException(err)
+		svc.Logger.Errorf("Error expiring open invoices: %v", err)
+	}
+	if expired > 0 {
+		svc.Logger.Infof("Expired %d open invoices", expired)
+	}
+	reconciled, err := svc.ReconcileOrphanedPayments(ctx)
+	if err != nil {
+		sentry.CaptureException(err)
+		svc.Logger.Errorf("Error reconciling orphaned payments: %v", err)
+	}
+	if reconciled > 0 {
+		svc.Logger.Infof("Reverted %d orphaned payments", reconciled)
+	}
+}
+
+// ExpireOpenInvoices moves open incoming invoices past their expiry to the expired state
+func (svc *LndhubService) ExpireOpenInvoices(ctx context.Context) (count int, err error) {
+	invoices := []models.Invoice{}
+	err = svc.DB.NewSelect().
+		Model(&invoices).
+		Where("type = ?", common.InvoiceTypeIncoming).
+		Where("state = ?", common.InvoiceStateOpen).
+		Where("expires_at < ?", time.Now()).
+		Order("id").
+		Limit(sweepBatchSize).
+		Scan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, invoice := range invoices {
+		invoice.State = common.InvoiceStateExpired
+		// the state check makes sure we don't overwrite an invoice that just got paid
+		result, err := svc.DB.NewUpdate().Model(&invoice).Column("state", "updated_at").WherePK().Where("state = ?", common.InvoiceStateOpen).Exec(ctx)
+		if err != nil {
+			return count, err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			continue
+		}
+		count++
+		svc.InvoicePubSub.Publish(strconv.FormatInt(invoice.UserID, 10), invoice)
+		svc.InvoicePubSub.Publish(common.InvoiceTypeIncoming, invoice)
+	}
+	return count, nil
+}
+
+// ReconcileOrphanedPayments looks for outgoing payments that are still initialized after
+// ORPHANED_PAYMENT_TIMEOUT. If LND does not know the payment, it was never sent and the
+// amount and fees that were taken from the user's balance are reverted.
+// Payments that are known to LND are finalized by the pending payment routine.
+func (svc *LndhubService) ReconcileOrphanedPayments(ctx context.Context) (count int, err error) {
+	invoices := []models.Invoice{}
+	err = svc.DB.NewSelect().
+		Model(&invoices).
+		Where("type = ?", common.InvoiceTypeOutgoing).
+		Where("state = ?", common.InvoiceStateInitialized).
+		Where("created_at < ?", time.Now().Add(-time.Duration(svc.Config.OrphanedPaymentTimeout)*time.Second)).
+		Order("id").
+		Limit(sweepBatchSize).
+		Scan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, invoice := range invoices {
+		invoice := invoice
+		orphaned, err := svc.reconcileOrphanedPayment(ctx, &invoice)
+		if err != nil {
+			sentry.CaptureException(err)
+			svc.Logger.Errorf("Error reconciling payment invoice_id:%v r_hash:%s: %v", invoice.ID, invoice.RHash, err)
+			continue
+		}
+		if orphaned {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (svc *LndhubService) reconcileOrphanedPayment(ctx context.Context, invoice *models.Invoice) (orphaned bool, err error) {
+	entry, err := svc.GetTransactionEntryByInvoiceId(ctx, invoice.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the balance was never touched, we only have to close the invoice
+		invoice.State = common.InvoiceStateError
+		invoice.ErrorMessage = ErrPaymentNotInitiated.Error()
+		_, err = svc.DB.NewUpdate().Model(invoice).Column("state", "error_message", "updated_at").WherePK().Exec(ctx)
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if svc.LndClient.IsIdentityPubkey(invoice.DestinationPubkeyHex) {
+		// internal payments never reach LND, the receiving invoice tells us if the payment went through
+		var incomingInvoice models.Invoice
+		err = svc.DB.NewSelect().Model(&incomingInvoice).Where("type = ? AND r_hash = ? AND state = ? AND internal", common.InvoiceTypeIncoming, invoice.RHash, common.InvoiceStateSettled).Limit(1).Scan(ctx)
+		if err == nil {
+			invoice.Preimage = incomingInvoice.Preimage
+			return false, svc.HandleSuccessfulPayment(ctx, invoice, entry)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return false, err
+		}
+	} else {
+		known, err := svc.isPaymentKnownToLnd(ctx, invoice)
+		if err != nil || known {
+			return false, err
+		}
+	}
+
+	err = svc.HandleFailedPayment(ctx, invoice, entry, ErrPaymentNotInitiated)
+	if err != nil {
+		return false, err
+	}
+	svc.InvoicePubSub.Publish(common.InvoiceTypeOutgoing, *invoice)
+	return true, nil
+}
+
+func (svc *LndhubService) isPaymentKnownToLnd(ctx context.Context, invoice *models.Invoice) (bool, error) {
+	if invoice.RHash == "" {
+		return false, nil
+	}
+	rawHash, err := hex.DecodeString(invoice.RHash)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	// we want the current state of the payment, in-flight payments would block until they are done otherwise
+	paymentTracker, err := lnd.SubscribePayment(ctx, svc.LndClient, invoice.NodePubkey, &routerrpc.TrackPaymentRequest{
+		PaymentHash:       rawHash,
+		NoInflightUpdates: false,
+	})
+	if err == nil {
+		_, err = paymentTracker.Recv()
+	}
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,389 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/uptrace/bun"
+)
+
+const (
+	// TOTPCodeHeader carries the TOTP or recovery code of requests that may require one, e.g. payments
+	TOTPCodeHeader = "X-TOTP-Code"
+
+	TOTPActionLogin          = "login"
+	TOTPActionPayment        = "payment"
+	TOTPActionPasswordChange = "password_change"
+	TOTPActionSettings       = "settings"
+
+	totpPeriod = 30
+	// totpSkew accepts the codes of the previous and the next period to allow for clock drift
+	totpSkew              = 1
+	totpSecretSize        = 20
+	totpRecoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPNotEnrolled     = errors.New("totp is not enrolled")
+	ErrTOTPAlreadyEnrolled = errors.New("totp is already enrolled")
+	ErrTOTPRequired        = errors.New("totp code required")
+	ErrInvalidTOTPCode     = errors.New("invalid totp code")
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+type totpCodeContextKey struct{}
+
+// WithTOTPCode returns a context with the TOTP or recovery code that was sent with a login
+func WithTOTPCode(ctx context.Context, code string) context.Context {
+	return context.WithValue(ctx, totpCodeContextKey{}, code)
+}
+
+// TOTPCodeFromContext returns the TOTP code of ctx, empty if there is none
+func TOTPCodeFromContext(ctx context.Context) string {
+	code, _ := ctx.Value(totpCodeContextKey{}).(string)
+	return code
+}
+
+// TOTPEnrolment is a new TOTP secret, URL is the otpauth:// URL that authenticator apps scan as a QR code
+type TOTPEnrolment struct {
+	Secret string
+	URL    string
+}
+
+// TOTPCredential returns the TOTP credential of a user, also if it was not verified yet
+func (svc *LndhubService) TOTPCredential(ctx context.Context, userId int64) (*models.TOTPCredential, error) {
+	credential := &models.TOTPCredential{}
+	err := svc.DB.NewSelect().Model(credential).Where("user_id = ?", userId).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTOTPNotEnrolled
+	}
+	return credential, err
+}
+
+// EnrolTOTP creates a new TOTP secret for a user. It replaces a previous enrolment that was not verified,
+// codes are only required once the user verified the secret with VerifyTOTP.
+func (svc *LndhubService) EnrolTOTP(ctx context.Context, userId int64) (*TOTPEnrolment, error) {
+	user, err := svc.FindUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := svc.TOTPCredential(ctx, userId)
+	if err != nil && !errors.Is(err, ErrTOTPNotEnrolled) {
+		return nil, err
+	}
+	if credential != nil && credential.Verified() {
+		return nil, ErrTOTPAlreadyEnrolled
+	}
+	secret, err := randomBase32(totpSecretSize)
+	if err != nil {
+		return nil, err
+	}
+	credential = &models.TOTPCredential{UserID: userId, Secret: secret}
+	_, err = svc.DB.NewInsert().Model(credential).
+		On("CONFLICT (user_id) DO UPDATE").
+		Set("secret = EXCLUDED.secret").
+		Set("last_used_step = 0").
+		Set("updated_at = current_timestamp").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &TOTPEnrolment{Secret: secret, URL: svc.totpURL(user.Login, secret)}, nil
+}
+
+// TOTPEnrolmentURL returns the otpauth:// URL of a TOTP enrolment that was not verified yet
+func (svc *LndhubService) TOTPEnrolmentURL(ctx context.Context, userId int64) (string, error) {
+	user, err := svc.FindUser(ctx, userId)
+	if err != nil {
+		return "", err
+	}
+	credential, err := svc.TOTPCredential(ctx, userId)
+	if err != nil {
+		return "", err
+	}
+	if credential.Verified() {
+		return "", ErrTOTPAlreadyEnrolled
+	}
+	return svc.totpURL(user.Login, credential.Secret), nil
+}
+
+// VerifyTOTP completes the enrolment with a code of the new secret and returns the recovery codes.
+// They are only stored hashed, so this is the only time the user can see them.
+func (svc *LndhubService) VerifyTOTP(ctx context.Context, userId int64, code string) ([]string, error) {
+	credential, err := svc.TOTPCredential(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if credential.Verified() {
+		return nil, ErrTOTPAlreadyEnrolled
+	}
+	step, ok := matchTOTPCode(credential, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	codes := []string{}
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		credential.VerifiedAt = bun.NullTime{Time: time.Now()}
+		credential.LastUsedStep = step
+		if _, err := tx.NewUpdate().Model(credential).Column("verified_at", "last_used_step", "updated_at").WherePK().Exec(ctx); err != nil {
+			return err
+		}
+		codes, err = svc.replaceRecoveryCodes(ctx, tx, userId)
+		if err != nil {
+			return err
+		}
+		return svc.recordAuditEvent(ctx, tx, &models.AuditEvent{Type: models.AuditEventTOTPEnabled, UserID: userId})
+	})
+	return codes, err
+}
+
+// UpdateTOTPSettings changes when a code is required besides logins.
+// A paymentThreshold of 0 doesn't require a code for payments.
+func (svc *LndhubService) UpdateTOTPSettings(ctx context.Context, userId int64, paymentThreshold int64, requireOnPasswordChange bool) (*models.TOTPCredential, error) {
+	credential, err := svc.verifiedTOTPCredential(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	credential.PaymentThreshold = paymentThreshold
+	credential.RequireOnPasswordChange = requireOnPasswordChange
+	_, err = svc.DB.NewUpdate().Model(credential).Column("payment_threshold", "require_on_password_change", "updated_at").WherePK().Exec(ctx)
+	return credential, err
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user that enrolled TOTP
+func (svc *LndhubService) RegenerateRecoveryCodes(ctx context.Context, userId int64) ([]string, error) {
+	if _, err := svc.verifiedTOTPCredential(ctx, userId); err != nil {
+		return nil, err
+	}
+	codes := []string{}
+	err := svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) (err error) {
+		codes, err = svc.replaceRecoveryCodes(ctx, tx, userId)
+		return err
+	})
+	return codes, err
+}
+
+// DisableTOTP removes the TOTP secret and the recovery codes of a user
+func (svc *LndhubService) DisableTOTP(ctx context.Context, userId int64) error {
+	return svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		result, err := tx.NewDelete().Model((*models.TOTPCredential)(nil)).Where("user_id = ?", userId).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return ErrTOTPNotEnrolled
+		}
+		if _, err := tx.NewDelete().Model((*models.TOTPRecoveryCode)(nil)).Where("user_id = ?", userId).Exec(ctx); err != nil {
+			return err
+		}
+		return svc.recordAuditEvent(ctx, tx, &models.AuditEvent{Type: models.AuditEventTOTPDisabled, UserID: userId})
+	})
+}
+
+// RequireTOTP checks the code of a user that enrolled TOTP, users without it don't need one
+func (svc *LndhubService) RequireTOTP(ctx context.Context, userId int64, code, action string) error {
+	return svc.requireTOTP(ctx, userId, code, action, func(credential *models.TOTPCredential) bool { return true })
+}
+
+// RequireTOTPForPayment checks the code if the amount is above the payment threshold of the user
+func (svc *LndhubService) RequireTOTPForPayment(ctx context.Context, userId int64, code string, amount int64) error {
+	return svc.requireTOTP(ctx, userId, code, TOTPActionPayment, func(credential *models.TOTPCredential) bool {
+		return credential.PaymentThreshold > 0 && amount > credential.PaymentThreshold
+	})
+}
+
+// RequireTOTPForPasswordChange checks the code if the user wants to be asked for one on password changes
+func (svc *LndhubService) RequireTOTPForPasswordChange(ctx context.Context, userId int64, code string) error {
+	return svc.requireTOTP(ctx, userId, code, TOTPActionPasswordChange, func(credential *models.TOTPCredential) bool {
+		return credential.RequireOnPasswordChange
+	})
+}
+
+// requireTOTP checks the code if the user has a verified TOTP secret and required returns true.
+// Invalid codes count as failed logins, so codes can't be guessed through other endpoints than /auth either.
+func (svc *LndhubService) requireTOTP(ctx context.Context, userId int64, code, action string, required func(credential *models.TOTPCredential) bool) error {
+	credential, err := svc.TOTPCredential(ctx, userId)
+	if errors.Is(err, ErrTOTPNotEnrolled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !credential.Verified() || !required(credential) {
+		return nil
+	}
+	if code == "" {
+		return ErrTOTPRequired
+	}
+	user, err := svc.FindUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if err := svc.checkLogin(ctx, user.Login); err != nil {
+		return err
+	}
+	ok, err := svc.useTOTPCode(ctx, credential, code)
+	if err != nil || ok {
+		return err
+	}
+	if action == TOTPActionLogin {
+		// GenerateToken records the failed login
+		return ErrInvalidTOTPCode
+	}
+	svc.recordAuditEventOrLog(ctx, &models.AuditEvent{
+		Type:    models.AuditEventTOTPFailed,
+		UserID:  user.ID,
+		Login:   user.Login,
+		Details: map[string]interface{}{"action": action},
+	})
+	if svc.LoginGuard != nil {
+		status, err := svc.LoginGuard.Fail(ctx, user.Login, ClientIPFromContext(ctx))
+		if err != nil {
+			return err
+		}
+		if now := time.Now(); status.Locked(now) {
+			return &LoginAttemptError{Locked: true, RetryAfter: status.LockedUntil.Sub(now), CaptchaRequired: status.CaptchaRequired}
+		}
+	}
+	return ErrInvalidTOTPCode
+}
+
+// useTOTPCode accepts a TOTP code that was not used before, or an unused recovery code
+func (svc *LndhubService) useTOTPCode(ctx context.Context, credential *models.TOTPCredential, code string) (bool, error) {
+	if step, ok := matchTOTPCode(credential, code, time.Now()); ok {
+		// a concurrent request with the same code only succeeds once
+		result, err := svc.DB.NewUpdate().Model((*models.TOTPCredential)(nil)).
+			Set("last_used_step = ?", step).
+			Where("user_id = ? AND last_used_step < ?", credential.UserID, step).
+			Exec(ctx)
+		if err != nil {
+			return false, err
+		}
+		rows, _ := result.RowsAffected()
+		return rows == 1, nil
+	}
+	result, err := svc.DB.NewUpdate().Model((*models.TOTPRecoveryCode)(nil)).
+		Set("used_at = current_timestamp").
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", credential.UserID, hashRecoveryCode(code)).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return false, nil
+	}
+	svc.recordAuditEventOrLog(ctx, &models.AuditEvent{Type: models.AuditEventRecoveryCodeUsed, UserID: credential.UserID})
+	return true, nil
+}
+
+func (svc *LndhubService) verifiedTOTPCredential(ctx context.Context, userId int64) (*models.TOTPCredential, error) {
+	credential, err := svc.TOTPCredential(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !credential.Verified() {
+		return nil, ErrTOTPNotEnrolled
+	}
+	return credential, nil
+}
+
+func (svc *LndhubService) replaceRecoveryCodes(ctx context.Context, tx bun.Tx, userId int64) ([]string, error) {
+	if _, err := tx.NewDelete().Model((*models.TOTPRecoveryCode)(nil)).Where("user_id = ?", userId).Exec(ctx); err != nil {
+		return nil, err
+	}
+	codes := make([]string, totpRecoveryCodeCount)
+	recoveryCodes := make([]models.TOTPRecoveryCode, totpRecoveryCodeCount)
+	for i := range codes {
+		code, err := randomBase32(10)
+		if err != nil {
+			return nil, err
+		}
+		// e.g. abcdefgh-ijklmnop, the dash is optional when the code is used
+		codes[i] = strings.ToLower(code[:8] + "-" + code[8:16])
+		recoveryCodes[i] = models.TOTPRecoveryCode{UserID: userId, CodeHash: hashRecoveryCode(codes[i])}
+	}
+	_, err := tx.NewInsert().Model(&recoveryCodes).Exec(ctx)
+	return codes, err
+}
+
+func (svc *LndhubService) totpURL(login, secret string) string {
+	issuer := svc.Config.Branding.Title
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", totpOpts.Algorithm.String())
+	query.Set("digits", totpOpts.Digits.String())
+	query.Set("period", fmt.Sprint(totpPeriod))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + login,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// totpErrorResponse returns the response for a missing or invalid TOTP code, other errors are returned as they are
+func totpErrorResponse(err error) (*responses.ErrorResponse, error) {
+	switch {
+	case errors.Is(err, ErrTOTPRequired):
+		return &responses.TOTPRequiredError, nil
+	case errors.Is(err, ErrInvalidTOTPCode):
+		return &responses.InvalidTOTPCodeError, nil
+	case errors.Is(err, ErrLoginLocked):
+		return &responses.LoginLockedError, nil
+	}
+	return nil, err
+}
+
+// matchTOTPCode returns the time step of the code if it is valid at now and newer than the last used one
+func matchTOTPCode(credential *models.TOTPCredential, code string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= credential.LastUsedStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(credential.Secret, time.Unix(step*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// hashRecoveryCode hashes a normalized recovery code, they are random enough that a fast hash is fine
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomBase32(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchTOTPCode(t *testing.T) {
+	now := time.Date(2024, 6, 21, 12, 0, 10, 0, time.UTC)
+	secret, err := randomBase32(totpSecretSize)
+	assert.NoError(t, err)
+	credential := &models.TOTPCredential{Secret: secret}
+	step := now.Unix() / totpPeriod
+
+	code, err := totp.GenerateCode(secret, now)
+	assert.NoError(t, err)
+	matched, ok := matchTOTPCode(credential, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, step, matched)
+
+	// codes of the neighbouring periods are accepted for clock drift
+	code, err = totp.GenerateCode(secret, now.Add(-totpPeriod*time.Second))
+	assert.NoError(t, err)
+	matched, ok = matchTOTPCode(credential, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, step-1, matched)
+	code, err = totp.GenerateCode(secret, now.Add(-2*totpPeriod*time.Second))
+	assert.NoError(t, err)
+	_, ok = matchTOTPCode(credential, code, now)
+	assert.False(t, ok)
+
+	// codes can't be used again
+	credential.LastUsedStep = step
+	code, err = totp.GenerateCode(secret, now)
+	assert.NoError(t, err)
+	_, ok = matchTOTPCode(credential, code, now)
+	assert.False(t, ok)
+	code, err = totp.GenerateCode(secret, now.Add(totpPeriod*time.Second))
+	assert.NoError(t, err)
+	_, ok = matchTOTPCode(credential, code, now)
+	assert.True(t, ok)
+
+	_, ok = matchTOTPCode(credential, "", now)
+	assert.False(t, ok)
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	assert.Equal(t, hashRecoveryCode("abcdefgh-ijklmnop"), hashRecoveryCode("ABCDEFGHIJKLMNOP"))
+	assert.NotEqual(t, hashRecoveryCode("abcdefgh-ijklmnop"), hashRecoveryCode("abcdefgh-ijklmnoq"))
+}
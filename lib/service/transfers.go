@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/schema"
+)
+
+var (
+	ErrTransferRecipientNotFound = errors.New("recipient not found")
+	ErrTransferToSelf            = errors.New("can't transfer to your own account")
+)
+
+// FindTransferRecipient looks up the user that receives an internal transfer by login
+// or by a Lightning Address (login@LIGHTNING_ADDRESS_DOMAIN) on this hub
+func (svc *LndhubService) FindTransferRecipient(ctx context.Context, recipient string) (*models.User, error) {
+	login := recipient
+	if i := strings.LastIndex(recipient, "@"); i >= 0 {
+		domain := recipient[i+1:]
+		if svc.Config.LightningAddressDomain == "" || !strings.EqualFold(domain, svc.Config.LightningAddressDomain) {
+			return nil, ErrTransferRecipientNotFound
+		}
+		login = recipient[:i]
+	}
+	user, err := svc.FindUserByLogin(ctx, login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransferRecipientNotFound
+		}
+		return nil, err
+	}
+	if user.Deactivated || user.Deleted {
+		return nil, ErrTransferRecipientNotFound
+	}
+	return user, nil
+}
+
+// InternalTransfer moves amount from the sender to the recipient without involving LND.
+// Both users get a settled invoice for their history and all ledger entries are written in one DB transaction.
+// The optional customRecords are stored on both invoices like the custom records of a keysend payment.
+// The amount is taken from the wallet of ctx and goes to the default wallet of the recipient.
+func (svc *LndhubService) InternalTransfer(ctx context.Context, senderID int64, recipient *models.User, amount int64, memo string, metadata map[string]string, customRecords map[uint64][]byte) (outgoing *models.Invoice, err error) {
+	if recipient.ID == senderID {
+		return nil, ErrTransferToSelf
+	}
+	return svc.internalTransfer(ctx, senderID, WalletIDFromContext(ctx), recipient.ID, 0, amount, svc.CalcServiceFee(amount), memo, metadata, customRecords)
+}
+
+func (svc *LndhubService) internalTransfer(ctx context.Context, senderID, senderWalletID, recipientID, recipientWalletID, amount, serviceFee int64, memo string, metadata map[string]string, customRecords map[uint64][]byte) (outgoing *models.Invoice, err error) {
+	preimage, err := makePreimageHex()
+	if err != nil {
+		return nil, err
+	}
+	paymentHash := sha256.Sum256(preimage)
+	now := time.Now()
+
+	outgoing = &models.Invoice{
+		Type:                 common.InvoiceTypeOutgoing,
+		UserID:               senderID,
+		Amount:               amount,
+		Fee:                  serviceFee,
+		ServiceFee:           serviceFee,
+		Memo:                 memo,
+		Metadata:             metadata,
+		DestinationPubkeyHex: svc.LndClient.GetMainPubkey(),
+		RHash:                hex.EncodeToString(paymentHash[:]),
+		Preimage:             hex.EncodeToString(preimage),
+		Internal:             true,
+		State:                common.InvoiceStateSettled,
+		SettledAt:            schema.NullTime{Time: now},
+	}
+	incoming := &models.Invoice{
+		Type:                 common.InvoiceTypeIncoming,
+		UserID:               recipientID,
+		Amount:               amount,
+		Memo:                 memo,
+		Metadata:             metadata,
+		DestinationPubkeyHex: svc.LndClient.GetMainPubkey(),
+		RHash:                outgoing.RHash,
+		Preimage:             outgoing.Preimage,
+		Internal:             true,
+		State:                common.InvoiceStateSettled,
+		SettledAt:            schema.NullTime{Time: now},
+	}
+	if customRecords != nil {
+		outgoing.DestinationCustomRecords = customRecords
+		incoming.DestinationCustomRecords = customRecords
+	}
+	outgoing.BudgetID = BudgetIDFromContext(ctx)
+	outgoing.WalletID = senderWalletID
+	incoming.WalletID = recipientWalletID
+
+	senderCurrent, err := svc.WalletAccountFor(ctx, common.AccountTypeCurrent, senderID, senderWalletID)
+	if err != nil {
+		return nil, err
+	}
+	senderOutgoing, err := svc.WalletAccountFor(ctx, common.AccountTypeOutgoing, senderID, senderWalletID)
+	if err != nil {
+		return nil, err
+	}
+	senderFees, err := svc.WalletAccountFor(ctx, common.AccountTypeFees, senderID, senderWalletID)
+	if err != nil {
+		return nil, err
+	}
+	recipientCurrent, err := svc.WalletAccountFor(ctx, common.AccountTypeCurrent, recipientID, recipientWalletID)
+	if err != nil {
+		return nil, err
+	}
+	recipientIncoming, err := svc.WalletAccountFor(ctx, common.AccountTypeIncoming, recipientID, recipientWalletID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The DB constraints make sure the sender actually has enough balance for the transfer
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		if err := svc.chargeBudget(ctx, tx, outgoing, amount+serviceFee); err != nil {
+			return err
+		}
+		if _, err := tx.NewInsert().Model(outgoing).Exec(ctx); err != nil {
+			return err
+		}
+		if _, err := tx.NewInsert().Model(incoming).Exec(ctx); err != nil {
+			return err
+		}
+		senderEntry := models.TransactionEntry{
+			UserID:          senderID,
+			InvoiceID:       outgoing.ID,
+			CreditAccountID: senderOutgoing.ID,
+			DebitAccountID:  senderCurrent.ID,
+			Amount:          amount,
+			EntryType:       models.EntryTypeOutgoing,
+		}
+		if _, err := tx.NewInsert().Model(&senderEntry).Exec(ctx); err != nil {
+			return err
+		}
+		if serviceFee != 0 {
+			serviceFeeEntry := models.TransactionEntry{
+				UserID:          senderID,
+				InvoiceID:       outgoing.ID,
+				CreditAccountID: senderFees.ID,
+				DebitAccountID:  senderCurrent.ID,
+				Amount:          serviceFee,
+				EntryType:       models.EntryTypeServiceFee,
+				ParentID:        senderEntry.ID,
+			}
+			if _, err := tx.NewInsert().Model(&serviceFeeEntry).Exec(ctx); err != nil {
+				return err
+			}
+		}
+		recipientEntry := models.TransactionEntry{
+			UserID:          recipientID,
+			InvoiceID:       incoming.ID,
+			CreditAccountID: recipientCurrent.ID,
+			DebitAccountID:  recipientIncoming.ID,
+			Amount:          amount,
+			EntryType:       models.EntryTypeIncoming,
+		}
+		_, err := tx.NewInsert().Model(&recipientEntry).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		svc.Logger.Errorf("Internal transfer failed sender_id:%v recipient_id:%v amount:%v error: %v", senderID, recipientID, amount, err)
+		return nil, err
+	}
+
+	svc.InvoicePubSub.Publish(strconv.FormatInt(incoming.UserID, 10), *incoming)
+	svc.InvoicePubSub.Publish(common.InvoiceTypeIncoming, *incoming)
+	svc.InvoicePubSub.Publish(common.InvoiceTypeOutgoing, *outgoing)
+	return outgoing, nil
+}
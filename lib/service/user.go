@@ -3,7 +3,6 @@ package service
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"math"
 	"time"
 
@@ -15,7 +14,6 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
 	"github.com/uptrace/bun"
-	passwordvalidator "github.com/wagslane/go-password-validator"
 )
 
 func (svc *LndhubService) CreateUser(ctx context.Context, login string, password string) (user *models.User, err error) {
@@ -39,11 +37,8 @@ func (svc *LndhubService) CreateUser(ctx context.Context, login string, password
 		}
 		password = string(randPasswordBytes)
 	} else {
-		if svc.Config.MinPasswordEntropy > 0 {
-			entropy := passwordvalidator.GetEntropy(password)
-			if entropy < float64(svc.Config.MinPasswordEntropy) {
-				return nil, fmt.Errorf("password entropy is too low (%f), required is %d", entropy, svc.Config.MinPasswordEntropy)
-			}
+		if err := security.ValidatePassword(password, svc.Config.MinPasswordEntropy); err != nil {
+			return nil, err
 		}
 	}
 
@@ -58,39 +53,45 @@ func (svc *LndhubService) CreateUser(ctx context.Context, login string, password
 		if _, err := tx.NewInsert().Model(user).Exec(ctx); err != nil {
 			return err
 		}
-		accountTypes := []string{
-			common.AccountTypeIncoming,
-			common.AccountTypeCurrent,
-			common.AccountTypeOutgoing,
-			common.AccountTypeFees,
-		}
-		for _, accountType := range accountTypes {
-			account := models.Account{UserID: user.ID, Type: accountType}
-			if _, err := tx.NewInsert().Model(&account).Exec(ctx); err != nil {
-				return err
-			}
+		if err := createAccounts(ctx, tx, user.ID, 0); err != nil {
+			return err
 		}
-		return nil
+		return svc.recordAdminAction(ctx, tx, "create_user", user.ID, "", nil, userSnapshot(user))
 	})
 	//return actual password in the response, not the hashed one
 	user.Password = password
 	return user, err
 }
 
+// createAccounts creates the accounts of a wallet, walletId is 0 for the default wallet of the user
+func createAccounts(ctx context.Context, tx bun.Tx, userId, walletId int64) error {
+	accountTypes := []string{
+		common.AccountTypeIncoming,
+		common.AccountTypeCurrent,
+		common.AccountTypeOutgoing,
+		common.AccountTypeFees,
+	}
+	for _, accountType := range accountTypes {
+		account := models.Account{UserID: userId, WalletID: walletId, Type: accountType}
+		if _, err := tx.NewInsert().Model(&account).Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (svc *LndhubService) UpdateUser(ctx context.Context, userId int64, login *string, password *string, deactivated *bool, deleted *bool) (user *models.User, err error) {
 	user, err = svc.FindUser(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
+	before := userSnapshot(user)
 	if login != nil {
 		user.Login = *login
 	}
 	if password != nil {
-		if svc.Config.MinPasswordEntropy > 0 {
-			entropy := passwordvalidator.GetEntropy(*password)
-			if entropy < float64(svc.Config.MinPasswordEntropy) {
-				return nil, fmt.Errorf("password entropy is too low (%f), required is %d", entropy, svc.Config.MinPasswordEntropy)
-			}
+		if err := security.ValidatePassword(*password, svc.Config.MinPasswordEntropy); err != nil {
+			return nil, err
 		}
 		hashedPassword := security.HashPassword(*password)
 		user.Password = hashedPassword
@@ -106,7 +107,19 @@ func (svc *LndhubService) UpdateUser(ctx context.Context, userId int64, login *s
 			user.Deleted = true
 		}
 	}
-	_, err = svc.DB.NewUpdate().Model(user).WherePK().Exec(ctx)
+	after := userSnapshot(user)
+	if password != nil {
+		after["password_changed"] = true
+	}
+	err = svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewUpdate().Model(user).WherePK().Exec(ctx); err != nil {
+			return err
+		}
+		if err := svc.recordAdminAction(ctx, tx, "update_user", user.ID, "", before, after); err != nil {
+			return err
+		}
+		return svc.recordUserChanges(ctx, tx, user, before, after)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -134,24 +147,64 @@ func (svc *LndhubService) FindUserByLogin(ctx context.Context, login string) (*m
 }
 
 func (svc *LndhubService) CheckOutgoingPaymentAllowed(c echo.Context, lnpayReq *lnd.LNPayReq, userId int64) (result *responses.ErrorResponse, err error) {
-	limits := svc.GetLimits(c)
+	return svc.CheckOutgoingPaymentsAllowed(c, []*lnd.LNPayReq{lnpayReq}, userId)
+}
+
+// CheckOutgoingPaymentsAllowed checks the limits of every payment and if the balance is enough to make all of them.
+// Exceeded limits are recorded as audit events and lock the payments of the user once LOCKOUT_LIMIT_BREACHES is reached.
+func (svc *LndhubService) CheckOutgoingPaymentsAllowed(c echo.Context, lnpayReqs []*lnd.LNPayReq, userId int64) (result *responses.ErrorResponse, err error) {
+	ctx := WithClientIP(c.Request().Context(), c.RealIP())
+	return svc.checkOutgoingPaymentsAllowed(ctx, svc.GetLimits(c), svc.GetBudgetID(c), c.Request().Header.Get(TOTPCodeHeader), lnpayReqs, userId)
+}
+
+// checkOutgoingPaymentsAllowed does the checks of CheckOutgoingPaymentsAllowed for payments that are not made by a request,
+// like scheduled payments
+func (svc *LndhubService) checkOutgoingPaymentsAllowed(ctx context.Context, limits *Limits, budgetId int64, totpCode string, lnpayReqs []*lnd.LNPayReq, userId int64) (result *responses.ErrorResponse, err error) {
+	locked, err := svc.PaymentsLocked(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		return &responses.PaymentsLockedError, nil
+	}
+	amount := int64(0)
+	for _, lnpayReq := range lnpayReqs {
+		amount += lnpayReq.PayReq.NumSatoshis
+	}
+	if err := svc.RequireTOTPForPayment(ctx, userId, totpCode, amount); err != nil {
+		return totpErrorResponse(err)
+	}
+	result, err = svc.CheckOutgoingLimits(ctx, limits, lnpayReqs, userId)
+	if result != nil && (result == &responses.SendExceededError || result == &responses.TooMuchVolumeError) {
+		svc.recordLimitBreach(ctx, userId, result.Message)
+	}
+	if result != nil || err != nil {
+		return result, err
+	}
+	return svc.CheckBudget(ctx, budgetId, lnpayReqs, userId)
+}
+
+// CheckOutgoingLimits checks the payments against limits, e.g. for payments that are not made in a request
+func (svc *LndhubService) CheckOutgoingLimits(ctx context.Context, limits *Limits, lnpayReqs []*lnd.LNPayReq, userId int64) (result *responses.ErrorResponse, err error) {
 	if limits.MaxSendAmount >= 0 {
-		if lnpayReq.PayReq.NumSatoshis > limits.MaxSendAmount {
-			svc.Logger.Warnj(
-				log.JSON{
-					"message":        "max send amount exceeded",
-					"user_id":        userId,
-					"lndhub_user_id": userId,
-					"amount":         lnpayReq.PayReq.NumSatoshis,
-					"limit":          limits.MaxSendAmount,
-				},
-			)
-			return &responses.SendExceededError, nil
+		for _, lnpayReq := range lnpayReqs {
+			if lnpayReq.PayReq.NumSatoshis > limits.MaxSendAmount {
+				svc.Logger.Warnj(
+					log.JSON{
+						"message":        "max send amount exceeded",
+						"user_id":        userId,
+						"lndhub_user_id": userId,
+						"amount":         lnpayReq.PayReq.NumSatoshis,
+						"limit":          limits.MaxSendAmount,
+					},
+				)
+				return &responses.SendExceededError, nil
+			}
 		}
 	}
 
 	if limits.MaxSendVolume >= 0 {
-		volume, err := svc.GetVolumeOverPeriod(c.Request().Context(), userId, common.InvoiceTypeOutgoing, time.Duration(svc.Config.MaxVolumePeriod*int64(time.Second)))
+		volume, err := svc.GetVolumeOverPeriod(ctx, userId, common.InvoiceTypeOutgoing, time.Duration(svc.Config.MaxVolumePeriod*int64(time.Second)))
 		if err != nil {
 			svc.Logger.Errorj(
 				log.JSON{
@@ -175,7 +228,7 @@ func (svc *LndhubService) CheckOutgoingPaymentAllowed(c echo.Context, lnpayReq *
 		}
 	}
 
-	currentBalance, err := svc.CurrentUserBalance(c.Request().Context(), userId)
+	currentBalance, err := svc.CurrentUserBalance(ctx, userId)
 	if err != nil {
 		svc.Logger.Errorj(
 			log.JSON{
@@ -187,12 +240,15 @@ func (svc *LndhubService) CheckOutgoingPaymentAllowed(c echo.Context, lnpayReq *
 		return nil, err
 	}
 
-	minimumBalance := lnpayReq.PayReq.NumSatoshis
-	if svc.Config.FeeReserve {
-		minimumBalance += svc.CalcFeeLimit(lnpayReq.PayReq.Destination, lnpayReq.PayReq.NumSatoshis)
-	}
-	if svc.Config.ServiceFee != 0 {
-		minimumBalance += svc.CalcServiceFee(lnpayReq.PayReq.NumSatoshis)
+	var minimumBalance int64
+	for _, lnpayReq := range lnpayReqs {
+		minimumBalance += lnpayReq.PayReq.NumSatoshis
+		if svc.Config.FeeReserve {
+			minimumBalance += svc.CalcFeeLimit(lnpayReq.PayReq.Destination, lnpayReq.PayReq.NumSatoshis)
+		}
+		if svc.Config.ServiceFee != 0 {
+			minimumBalance += svc.CalcServiceFee(lnpayReq.PayReq.NumSatoshis)
+		}
 	}
 	if currentBalance < minimumBalance {
 		return &responses.NotEnoughBalanceError, nil
@@ -202,7 +258,11 @@ func (svc *LndhubService) CheckOutgoingPaymentAllowed(c echo.Context, lnpayReq *
 }
 
 func (svc *LndhubService) CheckIncomingPaymentAllowed(c echo.Context, amount, userId int64) (result *responses.ErrorResponse, err error) {
-	limits := svc.GetLimits(c)
+	return svc.CheckIncomingLimits(c.Request().Context(), svc.GetLimits(c), amount, userId)
+}
+
+// CheckIncomingLimits checks if the user can receive amount with the given limits
+func (svc *LndhubService) CheckIncomingLimits(ctx context.Context, limits *Limits, amount, userId int64) (result *responses.ErrorResponse, err error) {
 	if limits.MaxReceiveAmount >= 0 {
 		if amount > limits.MaxReceiveAmount {
 			svc.Logger.Warnj(
@@ -219,7 +279,7 @@ func (svc *LndhubService) CheckIncomingPaymentAllowed(c echo.Context, amount, us
 	}
 
 	if limits.MaxReceiveVolume >= 0 {
-		volume, err := svc.GetVolumeOverPeriod(c.Request().Context(), userId, common.InvoiceTypeIncoming, time.Duration(svc.Config.MaxVolumePeriod*int64(time.Second)))
+		volume, err := svc.GetVolumeOverPeriod(ctx, userId, common.InvoiceTypeIncoming, time.Duration(svc.Config.MaxVolumePeriod*int64(time.Second)))
 		if err != nil {
 			svc.Logger.Errorj(
 				log.JSON{
@@ -244,7 +304,8 @@ func (svc *LndhubService) CheckIncomingPaymentAllowed(c echo.Context, amount, us
 	}
 
 	if limits.MaxAccountBalance >= 0 {
-		currentBalance, err := svc.CurrentUserBalance(c.Request().Context(), userId)
+		// the max balance applies to the user as a whole, not to every wallet
+		currentBalance, err := svc.TotalUserBalance(ctx, userId)
 		if err != nil {
 			svc.Logger.Errorj(
 				log.JSON{
@@ -295,10 +356,15 @@ func (svc *LndhubService) CalcFeeLimit(destination string, amount int64) int64 {
 	return limit
 }
 
+// CurrentUserBalance returns the balance of the wallet of ctx, the default wallet if there is none
 func (svc *LndhubService) CurrentUserBalance(ctx context.Context, userId int64) (int64, error) {
+	return svc.WalletBalance(ctx, userId, WalletIDFromContext(ctx))
+}
+
+func (svc *LndhubService) WalletBalance(ctx context.Context, userId, walletId int64) (int64, error) {
 	var balance int64
 
-	account, err := svc.AccountFor(ctx, common.AccountTypeCurrent, userId)
+	account, err := svc.WalletAccountFor(ctx, common.AccountTypeCurrent, userId, walletId)
 	if err != nil {
 		return balance, err
 	}
@@ -306,22 +372,63 @@ func (svc *LndhubService) CurrentUserBalance(ctx context.Context, userId int64)
 	return balance, err
 }
 
+// TotalUserBalance returns the sum of the balances of the default wallet and all other wallets of the user
+func (svc *LndhubService) TotalUserBalance(ctx context.Context, userId int64) (int64, error) {
+	balance, err := svc.WalletBalance(ctx, userId, 0)
+	if err != nil {
+		return balance, err
+	}
+	wallets, err := svc.WalletsFor(ctx, userId)
+	if err != nil {
+		return balance, err
+	}
+	for _, wallet := range wallets {
+		walletBalance, err := svc.WalletBalance(ctx, userId, wallet.ID)
+		if err != nil {
+			return balance, err
+		}
+		balance += walletBalance
+	}
+	return balance, nil
+}
+
+// AccountFor returns an account of the default wallet of the user
 func (svc *LndhubService) AccountFor(ctx context.Context, accountType string, userId int64) (models.Account, error) {
+	return svc.WalletAccountFor(ctx, accountType, userId, 0)
+}
+
+// WalletAccountFor returns an account of a wallet of the user, walletId is 0 for the default wallet
+func (svc *LndhubService) WalletAccountFor(ctx context.Context, accountType string, userId, walletId int64) (models.Account, error) {
 	account := models.Account{}
-	err := svc.DB.NewSelect().Model(&account).Where("user_id = ? AND type= ?", userId, accountType).Limit(1).Scan(ctx)
+	query := svc.DB.NewSelect().Model(&account).Where("user_id = ? AND type= ?", userId, accountType)
+	if walletId == 0 {
+		query.Where("wallet_id IS NULL")
+	} else {
+		query.Where("wallet_id = ?", walletId)
+	}
+	err := query.Limit(1).Scan(ctx)
 	return account, err
 }
 
 func (svc *LndhubService) TransactionEntriesFor(ctx context.Context, userId int64) ([]models.TransactionEntry, error) {
 	transactionEntries := []models.TransactionEntry{}
-	err := svc.DB.NewSelect().Model(&transactionEntries).Where("user_id = ?", userId).Scan(ctx)
+	err := svc.transactionEntriesQuery(&transactionEntries, userId).Scan(ctx)
 	return transactionEntries, err
 }
 
+func (svc *LndhubService) transactionEntriesQuery(transactionEntries *[]models.TransactionEntry, userId int64) *bun.SelectQuery {
+	return svc.DB.NewSelect().Model(transactionEntries).Where("user_id = ?", userId)
+}
+
 func (svc *LndhubService) InvoicesFor(ctx context.Context, userId int64, invoiceType string) ([]models.Invoice, error) {
 	var invoices []models.Invoice
 
 	query := svc.DB.NewSelect().Model(&invoices).Where("user_id = ?", userId)
+	if walletId := WalletIDFromContext(ctx); walletId != 0 {
+		query.Where("wallet_id = ?", walletId)
+	} else {
+		query.Where("wallet_id IS NULL")
+	}
 	if invoiceType != "" {
 		query.Where("type = ? AND state NOT IN(?, ?)", invoiceType, common.InvoiceStateInitialized, common.InvoiceStateError)
 	}
@@ -347,14 +454,19 @@ func (svc *LndhubService) GetVolumeOverPeriod(ctx context.Context, userId int64,
 	return result, nil
 }
 
-func (svc *LndhubService) GetLimits(c echo.Context) (limits *Limits) {
-	limits = &Limits{
+// DefaultLimits are the configured limits, for users that don't have limits in their token
+func (svc *LndhubService) DefaultLimits() *Limits {
+	return &Limits{
 		MaxSendVolume:     svc.Config.MaxSendVolume,
 		MaxSendAmount:     svc.Config.MaxSendAmount,
 		MaxReceiveVolume:  svc.Config.MaxReceiveVolume,
 		MaxReceiveAmount:  svc.Config.MaxReceiveAmount,
 		MaxAccountBalance: svc.Config.MaxAccountBalance,
 	}
+}
+
+func (svc *LndhubService) GetLimits(c echo.Context) (limits *Limits) {
+	limits = svc.DefaultLimits()
 	if val, ok := c.Get("MaxSendVolume").(*int64); ok && val != nil {
 		limits.MaxSendVolume = *val
 	}
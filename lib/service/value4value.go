@@ -0,0 +1,105 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+const (
+	ValueRecipientTypeNode      = "node"
+	ValueRecipientTypeLnAddress = "lnaddress"
+)
+
+var (
+	ErrInvalidValueSplits = errors.New("invalid value splits")
+	ErrValueSplitTooSmall = errors.New("split amount is less than 1 sat")
+)
+
+// ValueRecipient is a podcast:valueRecipient of a podcast:value block.
+// cfr. https://github.com/Podcastindex-org/podcast-namespace/blob/main/value/value.md
+type ValueRecipient struct {
+	Name        string `json:"name"`
+	Type        string `json:"type" validate:"required,oneof=node lnaddress"`
+	Address     string `json:"address" validate:"required"`
+	Split       int64  `json:"split" validate:"gte=0"`
+	Fee         bool   `json:"fee"`
+	CustomKey   string `json:"custom_key"`
+	CustomValue string `json:"custom_value"`
+}
+
+// Boostagram is the JSON payload of the TLV_BOOSTAGRAM custom record
+type Boostagram struct {
+	Action           string      `json:"action,omitempty"`
+	AppName          string      `json:"app_name,omitempty"`
+	AppVersion       string      `json:"app_version,omitempty"`
+	BoostLink        string      `json:"boost_link,omitempty"`
+	URL              string      `json:"url,omitempty"`
+	Podcast          string      `json:"podcast,omitempty"`
+	FeedID           interface{} `json:"feedID,omitempty"`
+	ItemID           interface{} `json:"itemID,omitempty"`
+	Episode          string      `json:"episode,omitempty"`
+	EpisodeGUID      string      `json:"episode_guid,omitempty"`
+	GUID             string      `json:"guid,omitempty"`
+	RemoteFeedGUID   string      `json:"remote_feed_guid,omitempty"`
+	RemoteItemGUID   string      `json:"remote_item_guid,omitempty"`
+	Timestamp        interface{} `json:"ts,omitempty"`
+	Time             string      `json:"time,omitempty"`
+	Message          string      `json:"message,omitempty"`
+	SenderName       string      `json:"sender_name,omitempty"`
+	SenderID         string      `json:"sender_id,omitempty"`
+	Name             string      `json:"name,omitempty"`
+	ValueMsat        int64       `json:"value_msat,omitempty"`
+	ValueMsatTotal   int64       `json:"value_msat_total,omitempty"`
+	ReplyAddress     string      `json:"reply_address,omitempty"`
+	ReplyCustomKey   string      `json:"reply_custom_key,omitempty"`
+	ReplyCustomValue string      `json:"reply_custom_value,omitempty"`
+}
+
+// DecodeBoostagram returns the boostagram of a payment or nil if the custom records don't contain a valid one
+func DecodeBoostagram(customRecords map[uint64][]byte) *Boostagram {
+	record, ok := customRecords[TLV_BOOSTAGRAM]
+	if !ok {
+		return nil
+	}
+	boostagram := &Boostagram{}
+	if err := json.Unmarshal(record, boostagram); err != nil {
+		return nil
+	}
+	return boostagram
+}
+
+// CalculateValueSplits divides amount between the recipients of a value block.
+// Fee recipients get their split as a percentage of the amount, the remainder is
+// divided between the other recipients in proportion to their split.
+// Fractions of sats are rounded down and stay with the sender.
+func CalculateValueSplits(amount int64, recipients []ValueRecipient) ([]int64, error) {
+	amounts := make([]int64, len(recipients))
+	var feePercentage, totalShares int64
+	for _, recipient := range recipients {
+		if recipient.Split < 0 {
+			return nil, ErrInvalidValueSplits
+		}
+		if recipient.Fee {
+			feePercentage += recipient.Split
+		} else {
+			totalShares += recipient.Split
+		}
+	}
+	if feePercentage > 100 || (totalShares == 0 && feePercentage < 100) {
+		return nil, ErrInvalidValueSplits
+	}
+
+	remainder := amount
+	for i, recipient := range recipients {
+		if recipient.Fee {
+			amounts[i] = amount * recipient.Split / 100
+			remainder -= amounts[i]
+		}
+	}
+	for i, recipient := range recipients {
+		if !recipient.Fee && totalShares > 0 {
+			amounts[i] = remainder * recipient.Split / totalShares
+		}
+	}
+	return amounts, nil
+}
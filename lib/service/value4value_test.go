@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateValueSplits(t *testing.T) {
+	recipients := []ValueRecipient{
+		{Name: "host", Split: 90},
+		{Name: "guest", Split: 10},
+		{Name: "app", Split: 5, Fee: true},
+	}
+	amounts, err := CalculateValueSplits(1000, recipients)
+	assert.NoError(t, err)
+	// the fee is taken first, the rest is divided by shares
+	assert.Equal(t, []int64{855, 95, 50}, amounts)
+
+	// splits are shares and don't have to add up to 100
+	amounts, err = CalculateValueSplits(100, []ValueRecipient{{Split: 1}, {Split: 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{33, 66}, amounts)
+}
+
+func TestCalculateValueSplitsInvalid(t *testing.T) {
+	_, err := CalculateValueSplits(100, []ValueRecipient{{Split: 60, Fee: true}, {Split: 50, Fee: true}})
+	assert.ErrorIs(t, err, ErrInvalidValueSplits)
+	_, err = CalculateValueSplits(100, []ValueRecipient{{Split: 0}})
+	assert.ErrorIs(t, err, ErrInvalidValueSplits)
+	_, err = CalculateValueSplits(100, []ValueRecipient{{Split: -1}, {Split: 2}})
+	assert.ErrorIs(t, err, ErrInvalidValueSplits)
+}
+
+func TestDecodeBoostagram(t *testing.T) {
+	boostagram := DecodeBoostagram(map[uint64][]byte{
+		TLV_BOOSTAGRAM: []byte(`{"action":"boost","podcast":"Podcasting 2.0","feedID":920666,"message":"great show","value_msat_total":100000}`),
+	})
+	assert.NotNil(t, boostagram)
+	assert.Equal(t, "boost", boostagram.Action)
+	assert.Equal(t, "great show", boostagram.Message)
+	assert.Equal(t, float64(920666), boostagram.FeedID)
+	assert.Equal(t, int64(100000), boostagram.ValueMsatTotal)
+
+	assert.Nil(t, DecodeBoostagram(map[uint64][]byte{TLV_BOOSTAGRAM: []byte("not json")}))
+	assert.Nil(t, DecodeBoostagram(map[uint64][]byte{TLV_WALLET_ID: []byte("login")}))
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/lib/responses"
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+var (
+	ErrWalletNotFound       = errors.New("wallet not found")
+	ErrInvalidWallet        = errors.New("invalid wallet")
+	ErrTransferToSameWallet = errors.New("can't transfer to the same wallet")
+	ErrNotEnoughBalance     = errors.New("not enough balance")
+)
+
+type walletContextKey struct{}
+
+// WithWallet returns a context for invoices, payments and balances of a wallet, walletId is 0 for the default wallet
+func WithWallet(ctx context.Context, walletId int64) context.Context {
+	return context.WithValue(ctx, walletContextKey{}, walletId)
+}
+
+// WalletIDFromContext returns the id of the wallet of ctx, 0 for the default wallet
+func WalletIDFromContext(ctx context.Context) int64 {
+	walletId, _ := ctx.Value(walletContextKey{}).(int64)
+	return walletId
+}
+
+// DetachedContext returns a context that is not canceled with ctx but keeps its wallet and budget,
+// e.g. for payments that should complete regardless of if the request is canceled or not
+func DetachedContext(ctx context.Context) context.Context {
+	return WithWallet(WithBudget(context.Background(), BudgetIDFromContext(ctx)), WalletIDFromContext(ctx))
+}
+
+func (svc *LndhubService) CreateWallet(ctx context.Context, wallet *models.Wallet) error {
+	if wallet.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidWallet)
+	}
+	return svc.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(wallet).Exec(ctx); err != nil {
+			return err
+		}
+		return createAccounts(ctx, tx, wallet.UserID, wallet.ID)
+	})
+}
+
+// WalletsFor returns the additional wallets of a user, without the default wallet
+func (svc *LndhubService) WalletsFor(ctx context.Context, userId int64) ([]models.Wallet, error) {
+	wallets := []models.Wallet{}
+	err := svc.DB.NewSelect().Model(&wallets).Where("user_id = ?", userId).Order("id").Scan(ctx)
+	return wallets, err
+}
+
+func (svc *LndhubService) FindWallet(ctx context.Context, userId, id int64) (*models.Wallet, error) {
+	var wallet models.Wallet
+	err := svc.DB.NewSelect().Model(&wallet).Where("id = ? AND user_id = ?", id, userId).Limit(1).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWalletNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+func (svc *LndhubService) RenameWallet(ctx context.Context, wallet *models.Wallet, name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidWallet)
+	}
+	wallet.Name = name
+	_, err := svc.DB.NewUpdate().Model(wallet).Column("name", "updated_at").WherePK().Exec(ctx)
+	return err
+}
+
+// WalletTransfer moves amount between two wallets of a user, 0 is the default wallet.
+// Like an internal transfer both wallets get a settled invoice, no service fee is charged.
+func (svc *LndhubService) WalletTransfer(ctx context.Context, userId, fromWalletId, toWalletId, amount int64, memo string) (*models.Invoice, error) {
+	if fromWalletId == toWalletId {
+		return nil, ErrTransferToSameWallet
+	}
+	for _, walletId := range []int64{fromWalletId, toWalletId} {
+		if walletId == 0 {
+			continue
+		}
+		if _, err := svc.FindWallet(ctx, userId, walletId); err != nil {
+			return nil, err
+		}
+	}
+	// the DB constraints make sure the balance is enough, this check only gives a better error
+	balance, err := svc.WalletBalance(ctx, userId, fromWalletId)
+	if err != nil {
+		return nil, err
+	}
+	if balance < amount {
+		return nil, ErrNotEnoughBalance
+	}
+	return svc.internalTransfer(ctx, userId, fromWalletId, userId, toWalletId, amount, 0, memo, nil, nil)
+}
+
+// WalletMiddleware scopes the request to the wallet in the wallet_id query parameter,
+// requests without it use the default wallet
+func (svc *LndhubService) WalletMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			param := c.QueryParam("wallet_id")
+			if param == "" || param == "0" {
+				return next(c)
+			}
+			walletId, err := strconv.ParseInt(param, 10, 64)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, responses.BadArgumentsError)
+			}
+			userId := c.Get("UserID").(int64)
+			if _, err := svc.FindWallet(c.Request().Context(), userId, walletId); err != nil {
+				if errors.Is(err, ErrWalletNotFound) {
+					return c.JSON(http.StatusNotFound, responses.ErrorResponse{
+						Error:          true,
+						Code:           8,
+						Message:        err.Error(),
+						HttpStatusCode: http.StatusNotFound,
+					})
+				}
+				return c.JSON(http.StatusInternalServerError, responses.GeneralServerError)
+			}
+			c.SetRequest(c.Request().WithContext(WithWallet(c.Request().Context(), walletId)))
+			return next(c)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
@@ -30,33 +31,39 @@ func (svc *LndhubService) StartWebhookSubscription(ctx context.Context, url stri
 	}
 }
 func (svc *LndhubService) postToWebhook(invoice models.Invoice, url string) {
-
-	//Look up the user's login to add it to the invoice
-	user, err := svc.FindUser(context.Background(), invoice.UserID)
+	err := svc.PostInvoiceToWebhook(context.Background(), invoice, url)
 	if err != nil {
 		svc.Logger.Error(err)
-		return
 	}
+}
 
+// PostInvoiceToWebhook sends the webhook payload of the invoice to url
+func (svc *LndhubService) PostInvoiceToWebhook(ctx context.Context, invoice models.Invoice, url string) error {
 	payload := new(bytes.Buffer)
-	err = json.NewEncoder(payload).Encode(ConvertPayload(invoice, user))
+	//Look up the user's login to add it to the invoice
+	err := svc.EncodeInvoiceWithUserLogin(ctx, payload, invoice)
 	if err != nil {
-		svc.Logger.Error(err)
-		return
+		return err
 	}
 
-	resp, err := http.Post(svc.Config.WebhookUrl, "application/json", payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, payload)
 	if err != nil {
-		svc.Logger.Error(err)
-		return
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		msg, err := io.ReadAll(resp.Body)
 		if err != nil {
-			svc.Logger.Error(err)
+			return err
 		}
-		svc.Logger.Errorf("Webhook status code was %d, body: %s", resp.StatusCode, msg)
+		return fmt.Errorf("Webhook status code was %d, body: %s", resp.StatusCode, msg)
 	}
+	return nil
 }
 
 type WebhookInvoicePayload struct {
@@ -72,6 +79,7 @@ type WebhookInvoicePayload struct {
 	PaymentRequest           string            `json:"payment_request"`
 	DestinationPubkeyHex     string            `json:"destination_pubkey_hex"`
 	DestinationCustomRecords map[uint64][]byte `json:"custom_records,omitempty"`
+	Metadata                 map[string]string `json:"metadata,omitempty"`
 	RHash                    string            `json:"r_hash"`
 	Preimage                 string            `json:"preimage"`
 	Keysend                  bool              `json:"keysend"`
@@ -123,6 +131,7 @@ func ConvertPayload(invoice models.Invoice, user *models.User) (result WebhookIn
 		PaymentRequest:           invoice.PaymentRequest,
 		DestinationPubkeyHex:     invoice.DestinationPubkeyHex,
 		DestinationCustomRecords: invoice.DestinationCustomRecords,
+		Metadata:                 invoice.Metadata,
 		RHash:                    invoice.RHash,
 		Preimage:                 invoice.Preimage,
 		Keysend:                  invoice.Keysend,
@@ -22,6 +22,7 @@ type jwtCustomClaims struct {
 	MaxReceiveVolume  *int64 `json:"maxReceiveVolume,omitempty"`
 	MaxReceiveAmount  *int64 `json:"maxReceiveAmount,omitempty"`
 	MaxAccountBalance *int64 `json:"maxAccountBalance,omitempty"`
+	BudgetID          *int64 `json:"budgetId,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -52,6 +53,10 @@ func Middleware(secret []byte) echo.MiddlewareFunc {
 			c.Set("MaxReceiveAmount", claims.MaxReceiveAmount)
 			c.Set("MaxAccountBalance", claims.MaxAccountBalance)
 		}
+		// tokens that were shared with a third party can only spend within their budget
+		if claims.BudgetID != nil {
+			c.Set("BudgetID", *claims.BudgetID)
+		}
 		// pass UserID to sentry for exception notifications
 		if hub := sentryecho.GetHubFromContext(c); hub != nil {
 			hub.Scope().SetUser(sentry.User{ID: strconv.FormatInt(claims.ID, 10)})
@@ -63,30 +68,29 @@ func Middleware(secret []byte) echo.MiddlewareFunc {
 
 // GenerateAccessToken : Generate Access Token
 func GenerateAccessToken(secret []byte, expiryInSeconds int, u *models.User) (string, error) {
-	claims := &jwtCustomClaims{
-		ID:        u.ID,
-		IsRefresh: false,
-		StandardClaims: jwt.StandardClaims{
-			// one week expiration
-			ExpiresAt: time.Now().Add(time.Second * time.Duration(expiryInSeconds)).Unix(),
-		},
-	}
+	return generateToken(secret, expiryInSeconds, u, false, nil)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+// GenerateRefreshToken : Generate Refresh Token
+func GenerateRefreshToken(secret []byte, expiryInSeconds int, u *models.User) (string, error) {
+	return generateToken(secret, expiryInSeconds, u, true, nil)
+}
 
-	t, err := token.SignedString(secret)
-	if err != nil {
-		return "", err
-	}
+// GenerateBudgetAccessToken : Generate Access Token that can only spend within a budget
+func GenerateBudgetAccessToken(secret []byte, expiryInSeconds int, u *models.User, budgetId int64) (string, error) {
+	return generateToken(secret, expiryInSeconds, u, false, &budgetId)
+}
 
-	return t, nil
+// GenerateBudgetRefreshToken : Generate Refresh Token for access tokens that can only spend within a budget
+func GenerateBudgetRefreshToken(secret []byte, expiryInSeconds int, u *models.User, budgetId int64) (string, error) {
+	return generateToken(secret, expiryInSeconds, u, true, &budgetId)
 }
 
-// GenerateRefreshToken : Generate Refresh Token
-func GenerateRefreshToken(secret []byte, expiryInSeconds int, u *models.User) (string, error) {
+func generateToken(secret []byte, expiryInSeconds int, u *models.User, isRefresh bool, budgetId *int64) (string, error) {
 	claims := &jwtCustomClaims{
 		ID:        u.ID,
-		IsRefresh: true,
+		IsRefresh: isRefresh,
+		BudgetID:  budgetId,
 		StandardClaims: jwt.StandardClaims{
 			// one week expiration
 			ExpiresAt: time.Now().Add(time.Second * time.Duration(expiryInSeconds)).Unix(),
@@ -102,6 +106,7 @@ func GenerateRefreshToken(secret []byte, expiryInSeconds int, u *models.User) (s
 
 	return t, nil
 }
+
 func ParseToken(secret []byte, token string, mustBeRefresh bool) (int64, error) {
 	userIdClaim := "id"
 	isRefreshClaim := "isRefresh"
@@ -138,3 +143,21 @@ func ParseToken(secret []byte, token string, mustBeRefresh bool) (int64, error)
 func GetUserIdFromToken(secret []byte, token string) (int64, error) {
 	return ParseToken(secret, token, true)
 }
+
+// GetBudgetIdFromToken returns the id of the budget a token is bound to, 0 if the token has no budget
+func GetBudgetIdFromToken(secret []byte, token string) (int64, error) {
+	claims := &jwtCustomClaims{}
+	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	})
+	if err != nil {
+		return -1, err
+	}
+	if !parsedToken.Valid {
+		return -1, errors.New("Token is invalid")
+	}
+	if claims.BudgetID == nil {
+		return 0, nil
+	}
+	return *claims.BudgetID, nil
+}
@@ -0,0 +1,255 @@
+package tokens
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/getsentry/sentry-go"
+	sentryecho "github.com/getsentry/sentry-go/echo"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// NostrAuthScheme is the scheme of NIP-98 Authorization headers
+	NostrAuthScheme = "Nostr"
+	// NostrAuthKind is the kind of NIP-98 HTTP auth events
+	NostrAuthKind = 27235
+	// NostrAuthWindow is how far the created_at of an event may be off from the time of the request
+	NostrAuthWindow = 60 * time.Second
+	// NostrMaxBodySize is the maximum size of the body of a signed request, it is read before the signature is checked
+	NostrMaxBodySize = 250 * 1024
+)
+
+var ErrInvalidNostrAuth = errors.New("invalid nostr auth event")
+
+// NostrEvent : nostr event (NIP-01)
+type NostrEvent struct {
+	ID        string     `json:"id"`
+	Pubkey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// NostrUserLookup returns the id of the user a pubkey is linked to
+type NostrUserLookup func(ctx context.Context, pubkey string) (int64, error)
+
+// NostrEventSeen records the id of an event until expiresAt and returns true if it was recorded before.
+// It has to be shared by all replicas, so that an event can't be replayed against another one.
+type NostrEventSeen func(ctx context.Context, id string, expiresAt time.Time) (bool, error)
+
+// NostrAuth verifies NIP-98 events of requests to the hub
+type NostrAuth struct {
+	// BaseURL is the public URL of the hub, e.g. https://lndhub.example.com.
+	// The u tag of an event must be this URL with the path and query of the request,
+	// the scheme and Host header of the request are not trusted.
+	BaseURL string
+	// Seen rejects events that were already used, a signed request can't be replayed within NostrAuthWindow
+	Seen NostrEventSeen
+}
+
+// Middleware authenticates requests with a NIP-98 "Authorization: Nostr <event>" header
+// and sets the UserID of the pubkey like Middleware does for access tokens.
+// All other requests are passed to next, usually the access token middleware.
+func (auth *NostrAuth) Middleware(lookup NostrUserLookup, next echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(handler echo.HandlerFunc) echo.HandlerFunc {
+		tokenHandler := next(handler)
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			if !strings.HasPrefix(header, NostrAuthScheme+" ") {
+				return tokenHandler(c)
+			}
+			event, err := auth.VerifyRequest(c, header)
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.Is(err, ErrInvalidNostrAuth):
+				c.Logger().Error(err)
+				return nostrBadAuth()
+			case errors.As(err, &maxBytesErr):
+				return echo.ErrStatusRequestEntityTooLarge
+			case err != nil:
+				return err
+			}
+			userId, err := lookup(c.Request().Context(), event.Pubkey)
+			if err != nil {
+				c.Logger().Errorf("Failed to find user of nostr pubkey %s: %v", event.Pubkey, err)
+				return nostrBadAuth()
+			}
+			c.Set("UserID", userId)
+			c.Set("NostrPubkey", event.Pubkey)
+			// pass UserID to sentry for exception notifications
+			if hub := sentryecho.GetHubFromContext(c); hub != nil {
+				hub.Scope().SetUser(sentry.User{ID: strconv.FormatInt(userId, 10)})
+			}
+			return handler(c)
+		}
+	}
+}
+
+// VerifyRequest checks the NIP-98 event in header for the request and that it was not used before.
+// The body is put back for the handler.
+func (auth *NostrAuth) VerifyRequest(c echo.Context, header string) (*NostrEvent, error) {
+	if auth.BaseURL == "" || auth.Seen == nil {
+		return nil, fmt.Errorf("%w: nostr auth is not configured", ErrInvalidNostrAuth)
+	}
+	body, err := readBody(c)
+	if err != nil {
+		return nil, err
+	}
+	event, err := VerifyNostrAuth(header, c.Request().Method, auth.RequestURL(c), body, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	// the event is accepted until created_at is outside of the window
+	seen, err := auth.Seen(c.Request().Context(), event.ID, time.Unix(event.CreatedAt, 0).Add(NostrAuthWindow))
+	if err != nil {
+		return nil, err
+	}
+	if seen {
+		return nil, fmt.Errorf("%w: event was already used", ErrInvalidNostrAuth)
+	}
+	return event, nil
+}
+
+// RequestURL returns the absolute URL of the request under BaseURL, as signed in the u tag
+func (auth *NostrAuth) RequestURL(c echo.Context) string {
+	return strings.TrimSuffix(auth.BaseURL, "/") + c.Request().URL.RequestURI()
+}
+
+// VerifyNostrAuth checks a NIP-98 Authorization header for a request: the event id and signature,
+// the kind, the u and method tags and created_at within NostrAuthWindow of now.
+// The payload tag is required for requests with a body and must be the sha256 of the body.
+func VerifyNostrAuth(header, method, url string, body []byte, now time.Time) (*NostrEvent, error) {
+	encoded, found := strings.CutPrefix(header, NostrAuthScheme+" ")
+	if !found {
+		return nil, fmt.Errorf("%w: missing %s scheme", ErrInvalidNostrAuth, NostrAuthScheme)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid base64", ErrInvalidNostrAuth)
+	}
+	event := &NostrEvent{}
+	if err := json.Unmarshal(raw, event); err != nil {
+		return nil, fmt.Errorf("%w: invalid json", ErrInvalidNostrAuth)
+	}
+	if err := event.Verify(); err != nil {
+		return nil, err
+	}
+	if event.Kind != NostrAuthKind {
+		return nil, fmt.Errorf("%w: kind must be %d", ErrInvalidNostrAuth, NostrAuthKind)
+	}
+	createdAt := time.Unix(event.CreatedAt, 0)
+	if createdAt.Before(now.Add(-NostrAuthWindow)) || createdAt.After(now.Add(NostrAuthWindow)) {
+		return nil, fmt.Errorf("%w: created_at is outside of the time window", ErrInvalidNostrAuth)
+	}
+	if event.Tag("u") != url {
+		return nil, fmt.Errorf("%w: u tag does not match the request url", ErrInvalidNostrAuth)
+	}
+	if !strings.EqualFold(event.Tag("method"), method) {
+		return nil, fmt.Errorf("%w: method tag does not match the request method", ErrInvalidNostrAuth)
+	}
+	payload := event.Tag("payload")
+	if len(body) > 0 || payload != "" {
+		hash := sha256.Sum256(body)
+		if !strings.EqualFold(payload, hex.EncodeToString(hash[:])) {
+			return nil, fmt.Errorf("%w: payload tag does not match the request body", ErrInvalidNostrAuth)
+		}
+	}
+	return event, nil
+}
+
+// Verify checks that the id is the hash of the event and the signature of the id by the pubkey
+func (event *NostrEvent) Verify() error {
+	id, err := event.Hash()
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(id) != strings.ToLower(event.ID) {
+		return fmt.Errorf("%w: id does not match the event", ErrInvalidNostrAuth)
+	}
+	pubkeyBytes, err := hex.DecodeString(event.Pubkey)
+	if err != nil {
+		return fmt.Errorf("%w: invalid pubkey", ErrInvalidNostrAuth)
+	}
+	pubkey, err := schnorr.ParsePubKey(pubkeyBytes)
+	if err != nil {
+		return fmt.Errorf("%w: invalid pubkey", ErrInvalidNostrAuth)
+	}
+	sigBytes, err := hex.DecodeString(event.Sig)
+	if err != nil {
+		return fmt.Errorf("%w: invalid sig", ErrInvalidNostrAuth)
+	}
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return fmt.Errorf("%w: invalid sig", ErrInvalidNostrAuth)
+	}
+	if !sig.Verify(id, pubkey) {
+		return fmt.Errorf("%w: invalid sig", ErrInvalidNostrAuth)
+	}
+	event.ID = strings.ToLower(event.ID)
+	event.Pubkey = strings.ToLower(event.Pubkey)
+	return nil
+}
+
+// Hash returns the sha256 of the serialized event, which is its id (NIP-01)
+func (event *NostrEvent) Hash() ([]byte, error) {
+	tags := event.Tags
+	if tags == nil {
+		tags = [][]string{}
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode([]interface{}{0, strings.ToLower(event.Pubkey), event.CreatedAt, event.Kind, tags, event.Content})
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return hash[:], nil
+}
+
+// Tag returns the first value of the first tag with the name
+func (event *NostrEvent) Tag(name string) string {
+	for _, tag := range event.Tags {
+		if len(tag) > 1 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// readBody reads up to NostrMaxBodySize of the body to check the payload hash and puts it back for the handler
+func readBody(c echo.Context) ([]byte, error) {
+	req := c.Request()
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Response(), req.Body, NostrMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func nostrBadAuth() error {
+	return echo.NewHTTPError(http.StatusUnauthorized, echo.Map{
+		"error":   true,
+		"code":    1,
+		"message": "bad auth",
+	})
+}
@@ -0,0 +1,159 @@
+package tokens
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const testNostrUrl = "https://lndhub.example.com/v2/payments/bolt11?wallet_id=1"
+
+func signNostrEvent(t *testing.T, privKey *btcec.PrivateKey, event *NostrEvent) string {
+	event.Pubkey = hex.EncodeToString(schnorr.SerializePubKey(privKey.PubKey()))
+	id, err := event.Hash()
+	assert.NoError(t, err)
+	event.ID = hex.EncodeToString(id)
+	sig, err := schnorr.Sign(privKey, id)
+	assert.NoError(t, err)
+	event.Sig = hex.EncodeToString(sig.Serialize())
+	return encodeNostrEvent(t, event)
+}
+
+func nostrAuthEvent(createdAt time.Time, url, method string, body []byte) *NostrEvent {
+	tags := [][]string{{"u", url}, {"method", method}}
+	if body != nil {
+		hash := sha256.Sum256(body)
+		tags = append(tags, []string{"payload", hex.EncodeToString(hash[:])})
+	}
+	return &NostrEvent{CreatedAt: createdAt.Unix(), Kind: NostrAuthKind, Tags: tags}
+}
+
+func TestNostrEventHash(t *testing.T) {
+	event := &NostrEvent{
+		Pubkey:    "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+		CreatedAt: 1700000000,
+		Kind:      NostrAuthKind,
+		Tags:      [][]string{{"u", "https://example.com/?a=1&b=<2>"}, {"method", "GET"}},
+	}
+	id, err := event.Hash()
+	assert.NoError(t, err)
+	// NIP-01 serialization, html characters are not escaped
+	serialized := fmt.Sprintf(`[0,"%s",1700000000,27235,[["u","https://example.com/?a=1&b=<2>"],["method","GET"]],""]`, event.Pubkey)
+	expected := sha256.Sum256([]byte(serialized))
+	assert.Equal(t, expected[:], id)
+}
+
+func TestVerifyNostrAuth(t *testing.T) {
+	privKey, err := btcec.NewPrivateKey()
+	assert.NoError(t, err)
+	now := time.Now()
+	body := []byte(`{"invoice":"lnbc1"}`)
+
+	header := signNostrEvent(t, privKey, nostrAuthEvent(now, testNostrUrl, "POST", body))
+	event, err := VerifyNostrAuth(header, "POST", testNostrUrl, body, now)
+	assert.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(schnorr.SerializePubKey(privKey.PubKey())), event.Pubkey)
+
+	for name, tc := range map[string]struct {
+		header string
+		method string
+		url    string
+		body   []byte
+	}{
+		"other method":  {header: header, method: "PUT", url: testNostrUrl, body: body},
+		"other url":     {header: header, method: "POST", url: "https://lndhub.example.com/v2/payments/bolt11", body: body},
+		"other body":    {header: header, method: "POST", url: testNostrUrl, body: []byte(`{"invoice":"lnbc2"}`)},
+		"no payload":    {header: signNostrEvent(t, privKey, nostrAuthEvent(now, testNostrUrl, "POST", nil)), method: "POST", url: testNostrUrl, body: body},
+		"expired":       {header: signNostrEvent(t, privKey, nostrAuthEvent(now.Add(-2*NostrAuthWindow), testNostrUrl, "GET", nil)), method: "GET", url: testNostrUrl},
+		"in the future": {header: signNostrEvent(t, privKey, nostrAuthEvent(now.Add(2*NostrAuthWindow), testNostrUrl, "GET", nil)), method: "GET", url: testNostrUrl},
+		"bearer":        {header: "Bearer token", method: "GET", url: testNostrUrl},
+		"not base64":    {header: "Nostr !", method: "GET", url: testNostrUrl},
+	} {
+		_, err := VerifyNostrAuth(tc.header, tc.method, tc.url, tc.body, now)
+		assert.ErrorIs(t, err, ErrInvalidNostrAuth, name)
+	}
+
+	// other kinds are rejected
+	event = nostrAuthEvent(now, testNostrUrl, "GET", nil)
+	event.Kind = 1
+	_, err = VerifyNostrAuth(signNostrEvent(t, privKey, event), "GET", testNostrUrl, nil, now)
+	assert.ErrorIs(t, err, ErrInvalidNostrAuth)
+
+	// events that were changed after signing are rejected
+	event = nostrAuthEvent(now, testNostrUrl, "GET", nil)
+	signNostrEvent(t, privKey, event)
+	event.Tags[0][1] = "https://lndhub.example.com/v2/balance"
+	_, err = VerifyNostrAuth(encodeNostrEvent(t, event), "GET", event.Tags[0][1], nil, now)
+	assert.ErrorIs(t, err, ErrInvalidNostrAuth)
+
+	// events with an id that was signed by another key are rejected
+	other, err := btcec.NewPrivateKey()
+	assert.NoError(t, err)
+	event = nostrAuthEvent(now, testNostrUrl, "GET", nil)
+	signNostrEvent(t, privKey, event)
+	id, _ := hex.DecodeString(event.ID)
+	sig, err := schnorr.Sign(other, id)
+	assert.NoError(t, err)
+	event.Sig = hex.EncodeToString(sig.Serialize())
+	_, err = VerifyNostrAuth(encodeNostrEvent(t, event), "GET", testNostrUrl, nil, now)
+	assert.ErrorIs(t, err, ErrInvalidNostrAuth)
+}
+
+func encodeNostrEvent(t *testing.T, event *NostrEvent) string {
+	raw, err := json.Marshal(event)
+	assert.NoError(t, err)
+	return NostrAuthScheme + " " + base64.StdEncoding.EncodeToString(raw)
+}
+
+func TestNostrAuthRequestURL(t *testing.T) {
+	auth := &NostrAuth{BaseURL: "https://lndhub.example.com/"}
+	req := httptest.NewRequest(http.MethodPost, "http://evil.example/v2/payments/bolt11?wallet_id=1", nil)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	// the Host header and scheme of the request are ignored
+	assert.Equal(t, testNostrUrl, auth.RequestURL(c))
+}
+
+func TestNostrAuthVerifyRequest(t *testing.T) {
+	privKey, err := btcec.NewPrivateKey()
+	assert.NoError(t, err)
+	seen := map[string]time.Time{}
+	auth := &NostrAuth{BaseURL: "https://lndhub.example.com", Seen: func(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+		_, ok := seen[id]
+		seen[id] = expiresAt
+		return ok, nil
+	}}
+	body := []byte(`{"invoice":"lnbc1"}`)
+	now := time.Now()
+	header := signNostrEvent(t, privKey, nostrAuthEvent(now, testNostrUrl, http.MethodPost, body))
+	request := func(header string, body []byte) error {
+		req := httptest.NewRequest(http.MethodPost, testNostrUrl, bytes.NewReader(body))
+		_, err := auth.VerifyRequest(echo.New().NewContext(req, httptest.NewRecorder()), header)
+		return err
+	}
+
+	assert.NoError(t, request(header, body))
+	// the event is kept until created_at is outside of the window
+	for _, expiresAt := range seen {
+		assert.Equal(t, time.Unix(now.Unix(), 0).Add(NostrAuthWindow), expiresAt)
+	}
+	// a replayed event is rejected
+	assert.ErrorIs(t, request(header, body), ErrInvalidNostrAuth)
+
+	// bodies are only read up to NostrMaxBodySize
+	large := bytes.Repeat([]byte("a"), NostrMaxBodySize+1)
+	var maxBytesErr *http.MaxBytesError
+	assert.ErrorAs(t, request(signNostrEvent(t, privKey, nostrAuthEvent(now, testNostrUrl, http.MethodPost, large)), large), &maxBytesErr)
+}
@@ -4,12 +4,15 @@ import (
 	"embed"
 	"fmt"
 	"log"
+	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	cache "github.com/SporkHubr/echo-http-cache"
 	"github.com/SporkHubr/echo-http-cache/adapter/memory"
 	"github.com/getAlby/lndhub.go/lib"
+	"github.com/getAlby/lndhub.go/lib/ratelimit"
 	"github.com/getAlby/lndhub.go/lib/responses"
 	"github.com/getAlby/lndhub.go/lib/service"
 	sentryecho "github.com/getsentry/sentry-go/echo"
@@ -36,6 +39,11 @@ func InitEcho(c *service.Config, logger *lecho.Logger) (e *echo.Echo) {
 
 	e.HTTPErrorHandler = responses.HTTPErrorHandler
 	e.Validator = &lib.CustomValidator{Validator: validator.New()}
+	ipExtractor, err := CreateIPExtractor(c.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Error parsing TRUSTED_PROXIES: %v", err)
+	}
+	e.IPExtractor = ipExtractor
 
 	e.Use(middleware.Recover())
 	e.Use(middleware.BodyLimit("250K"))
@@ -53,6 +61,29 @@ func InitEcho(c *service.Config, logger *lecho.Logger) (e *echo.Echo) {
 	return e
 }
 
+// CreateIPExtractor returns how the client IP of a request is determined. Without trusted proxies it is the
+// address of the connection, otherwise the X-Forwarded-For header is followed back through the trusted proxies.
+// The client IP is used for rate limits, login lockouts and audit events, so it must not be taken from headers
+// that clients can set themselves.
+func CreateIPExtractor(trustedProxies string) (echo.IPExtractor, error) {
+	if trustedProxies == "" {
+		return echo.ExtractIPDirect(), nil
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, cidr := range strings.Split(trustedProxies, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
 func CreateLoggingMiddleware(logger *lecho.Logger) echo.MiddlewareFunc {
 	return lecho.Middleware(lecho.Config{
 		Logger: logger,
@@ -62,32 +93,55 @@ func CreateLoggingMiddleware(logger *lecho.Logger) echo.MiddlewareFunc {
 	})
 }
 
-func CreateRateLimitMiddleware(requestsPerSecond int, burst int) echo.MiddlewareFunc {
-	config := middleware.RateLimiterConfig{
-		Store: middleware.NewRateLimiterMemoryStoreWithConfig(
-			middleware.RateLimiterMemoryStoreConfig{Rate: rate.Limit(requestsPerSecond), Burst: burst},
-		),
-		IdentifierExtractor: func(ctx echo.Context) (string, error) {
-			userId := ctx.Get("UserID")
-			id := ctx.RealIP()
-			if userId != nil {
-				userIdAsInt64 := ctx.Get("UserID").(int64)
-				id = strconv.FormatInt(userIdAsInt64, 10)
+// CreateRateLimitMiddleware limits the requests of an endpoint class per API key (budget token), user or client IP,
+// in that order of preference. The state of the limit is returned in the RateLimit-* headers.
+// It does nothing if limiter is nil or the limit is disabled.
+func CreateRateLimitMiddleware(limiter *ratelimit.Limiter, class string, limit ratelimit.Limit) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if limiter == nil || !limit.Enabled() {
+				return next(c)
+			}
+			result, err := limiter.Take(c.Request().Context(), class+":"+rateLimitIdentifier(c), limit)
+			if err != nil {
+				// an unavailable store must not take the API down with it
+				c.Logger().Errorf("failed to check %s rate limit: %v", class, err)
+				return next(c)
 			}
+			header := c.Response().Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+			if !result.Allowed {
+				header.Set(echo.HeaderRetryAfter, strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				res := responses.TooManyRequestsError
+				return c.JSON(res.HttpStatusCode, res)
+			}
+			return next(c)
+		}
+	}
+}
 
-			return id, nil
-		},
-		ErrorHandler: func(context echo.Context, err error) error {
-			res := responses.TooManyRequestsError
-			return context.JSON(res.HttpStatusCode, res)
-		},
-		DenyHandler: func(context echo.Context, identifier string,err error) error {
-			res := responses.TooManyRequestsError
-			return context.JSON(res.HttpStatusCode, res)
-		},
+// createAuthRateLimitMiddleware limits the unauthenticated requests that create tokens per client IP
+func createAuthRateLimitMiddleware(svc *service.LndhubService) echo.MiddlewareFunc {
+	return CreateRateLimitMiddleware(svc.RateLimiter, ratelimit.ClassAuth, ratelimit.Limit{
+		Rate:  float64(svc.Config.AuthRateLimit),
+		Burst: svc.Config.AuthBurstRateLimit,
+	})
+}
+
+func rateLimitIdentifier(c echo.Context) string {
+	if budgetId, ok := c.Get("BudgetID").(int64); ok {
+		return "budget:" + strconv.FormatInt(budgetId, 10)
 	}
+	if userId, ok := c.Get("UserID").(int64); ok {
+		return "user:" + strconv.FormatInt(userId, 10)
+	}
+	return "ip:" + c.RealIP()
+}
 
-	return middleware.RateLimiterWithConfig(config)
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
 }
 
 func createCacheClient() *cache.Client {
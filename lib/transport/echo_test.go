@@ -0,0 +1,38 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateIPExtractor(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.1, 198.51.100.7")
+	req.Header.Set(echo.HeaderXRealIP, "203.0.113.1")
+
+	// without trusted proxies the headers are ignored
+	extractor, err := CreateIPExtractor("")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.5", extractor(req))
+
+	// the header is followed back through the trusted proxies only
+	extractor, err = CreateIPExtractor("10.0.0.0/24")
+	assert.NoError(t, err)
+	assert.Equal(t, "198.51.100.7", extractor(req))
+	extractor, err = CreateIPExtractor("10.0.0.0/24, 198.51.100.0/24")
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.1", extractor(req))
+
+	// a private address that is not configured is not trusted
+	extractor, err = CreateIPExtractor("192.168.0.0/16")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.5", extractor(req))
+
+	_, err = CreateIPExtractor("10.0.0.5")
+	assert.Error(t, err)
+}
@@ -4,19 +4,19 @@ import (
 	"net/http"
 
 	"github.com/getAlby/lndhub.go/controllers"
+	"github.com/getAlby/lndhub.go/lib/ratelimit"
 	"github.com/getAlby/lndhub.go/lib/service"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"golang.org/x/time/rate"
 )
 
-func RegisterLegacyEndpoints(svc *service.LndhubService, e *echo.Echo, secured *echo.Group, securedWithStrictRateLimit *echo.Group, strictRateLimitMiddleware echo.MiddlewareFunc, adminMw echo.MiddlewareFunc, logMw echo.MiddlewareFunc) {
+func RegisterLegacyEndpoints(svc *service.LndhubService, e *echo.Echo, secured *echo.Group, securedWithStrictRateLimit *echo.Group, strictRateLimitMiddleware echo.MiddlewareFunc, logMw echo.MiddlewareFunc) {
 	// Public endpoints for account creation and authentication
-	e.POST("/auth", controllers.NewAuthController(svc).Auth, logMw)
+	e.POST("/auth", controllers.NewAuthController(svc).Auth, createAuthRateLimitMiddleware(svc), logMw)
 	if svc.Config.AllowAccountCreation {
-		e.POST("/create", controllers.NewCreateUserController(svc).CreateUser, strictRateLimitMiddleware, adminMw, logMw)
+		e.POST("/create", controllers.NewCreateUserController(svc).CreateUser, strictRateLimitMiddleware, svc.AdminMiddleware(service.PermissionManageUsers), logMw)
 	}
-	e.POST("/invoice/:user_login", controllers.NewInvoiceController(svc).Invoice, middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(rate.Limit(svc.Config.DefaultRateLimit))), logMw)
+	e.POST("/invoice/:user_login", controllers.NewInvoiceController(svc).Invoice, CreateRateLimitMiddleware(svc.RateLimiter, ratelimit.ClassPublic, ratelimit.Limit{Rate: float64(svc.Config.DefaultRateLimit), Burst: svc.Config.DefaultRateLimit}), logMw)
 
 	// Secured endpoints which require a Authorization token (JWT)
 	secured.POST("/addinvoice", controllers.NewAddInvoiceController(svc).AddInvoice)
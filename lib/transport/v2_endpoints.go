@@ -3,27 +3,125 @@ package transport
 import (
 	v2controllers "github.com/getAlby/lndhub.go/controllers_v2"
 	"github.com/getAlby/lndhub.go/lib/service"
+	"github.com/getAlby/lndhub.go/lnd"
 	"github.com/labstack/echo/v4"
 )
 
-func RegisterV2Endpoints(svc *service.LndhubService, e *echo.Echo, secured *echo.Group, securedWithStrictRateLimit *echo.Group, strictRateLimitMiddleware echo.MiddlewareFunc, adminMw echo.MiddlewareFunc, logMw echo.MiddlewareFunc) {
+func RegisterV2Endpoints(svc *service.LndhubService, e *echo.Echo, secured *echo.Group, securedWithStrictRateLimit *echo.Group, strictRateLimitMiddleware echo.MiddlewareFunc, logMw echo.MiddlewareFunc) {
 	// TODO: v2 auth endpoint: generalized oauth token generation
 	// e.POST("/auth", controllers.NewAuthController(svc).Auth)
 	if svc.Config.AllowAccountCreation {
-		e.POST("/v2/users", v2controllers.NewCreateUserController(svc).CreateUser, strictRateLimitMiddleware, adminMw, logMw)
+		e.POST("/v2/users", v2controllers.NewCreateUserController(svc).CreateUser, strictRateLimitMiddleware, svc.AdminMiddleware(service.PermissionManageUsers), logMw)
 	}
-	//require admin token for update user endpoint
+	if svc.Config.LnurlAuthEnabled {
+		lnurlAuthCtrl := v2controllers.NewLnurlAuthController(svc)
+		authRateLimitMiddleware := createAuthRateLimitMiddleware(svc)
+		e.POST("/v2/lnurlauth", lnurlAuthCtrl.CreateLnurlAuth, authRateLimitMiddleware, logMw)
+		e.GET("/v2/lnurlauth/callback", lnurlAuthCtrl.LnurlAuthCallback, authRateLimitMiddleware, logMw)
+		e.GET("/v2/lnurlauth/status", lnurlAuthCtrl.LnurlAuthStatus, logMw)
+	}
+	//admin endpoints require the admin token or an admin principal with a role that grants the permission
 	if svc.Config.AdminToken != "" {
-		e.PUT("/v2/admin/users", v2controllers.NewUpdateUserController(svc).UpdateUser, strictRateLimitMiddleware, adminMw)
+		readMw := svc.AdminMiddleware(service.PermissionRead)
+		manageUsersMw := svc.AdminMiddleware(service.PermissionManageUsers)
+		e.PUT("/v2/admin/users", v2controllers.NewUpdateUserController(svc).UpdateUser, strictRateLimitMiddleware, manageUsersMw)
+		adminCtrl := v2controllers.NewAdminController(svc)
+		e.GET("/v2/admin/users", adminCtrl.SearchUsers, readMw)
+		e.GET("/v2/admin/users/:id", adminCtrl.GetUser, readMw)
+		e.GET("/v2/admin/users/:id/invoices", adminCtrl.GetUserInvoices, readMw)
+		e.GET("/v2/admin/users/:id/transactions", adminCtrl.GetUserTransactionEntries, readMw)
+		e.POST("/v2/admin/users/deactivate", adminCtrl.DeactivateUsers, strictRateLimitMiddleware, manageUsersMw, logMw)
+		e.POST("/v2/admin/users/reactivate", adminCtrl.ReactivateUsers, strictRateLimitMiddleware, manageUsersMw, logMw)
+		e.POST("/v2/admin/logins/unlock", adminCtrl.UnlockLogin, strictRateLimitMiddleware, manageUsersMw, logMw)
+		adjustmentCtrl := v2controllers.NewAdjustmentController(svc)
+		e.GET("/v2/admin/adjustments", adjustmentCtrl.GetAdjustments, readMw)
+		e.POST("/v2/admin/adjustments", adjustmentCtrl.AdjustBalance, strictRateLimitMiddleware, svc.AdminMiddleware(service.PermissionAdjustBalance), logMw)
+		e.POST("/v2/admin/refunds", adjustmentCtrl.RefundInvoice, strictRateLimitMiddleware, svc.AdminMiddleware(service.PermissionRefund), logMw)
+		manageAdminsMw := svc.AdminMiddleware(service.PermissionManageAdmins)
+		principalCtrl := v2controllers.NewAdminPrincipalController(svc)
+		e.GET("/v2/admin/principals", principalCtrl.GetAdminPrincipals, manageAdminsMw)
+		e.POST("/v2/admin/principals", principalCtrl.CreateAdminPrincipal, strictRateLimitMiddleware, manageAdminsMw, logMw)
+		e.DELETE("/v2/admin/principals/:id", principalCtrl.RevokeAdminPrincipal, strictRateLimitMiddleware, manageAdminsMw, logMw)
+		e.GET("/v2/admin/audit", principalCtrl.GetAdminAuditLog, manageAdminsMw)
+		auditEventCtrl := v2controllers.NewAuditEventController(svc)
+		e.GET("/v2/admin/audit/events", auditEventCtrl.GetAuditEvents, manageAdminsMw)
+		e.GET("/v2/admin/audit/events/export", auditEventCtrl.ExportAuditEvents, manageAdminsMw)
+		//cluster admin endpoints are only available when running an LND cluster
+		if cluster, ok := svc.LndClient.(lnd.ClusterAdmin); ok {
+			manageClusterMw := svc.AdminMiddleware(service.PermissionManageCluster)
+			clusterCtrl := v2controllers.NewClusterController(svc, cluster)
+			e.GET("/v2/admin/cluster/nodes", clusterCtrl.GetNodes, readMw)
+			e.POST("/v2/admin/cluster/nodes/:pubkey/activate", clusterCtrl.ActivateNode, strictRateLimitMiddleware, manageClusterMw, logMw)
+			e.POST("/v2/admin/cluster/nodes/:pubkey/drain", clusterCtrl.DrainNode, strictRateLimitMiddleware, manageClusterMw, logMw)
+			e.DELETE("/v2/admin/cluster/nodes/:pubkey/drain", clusterCtrl.UndrainNode, strictRateLimitMiddleware, manageClusterMw, logMw)
+		}
 	}
+	// wallet-scoped endpoints use the wallet in the wallet_id query parameter, the default wallet without it
+	walletMw := svc.WalletMiddleware()
 	invoiceCtrl := v2controllers.NewInvoiceController(svc)
 	keysendCtrl := v2controllers.NewKeySendController(svc)
-	secured.POST("/v2/invoices", invoiceCtrl.AddInvoice)
-	secured.GET("/v2/invoices/incoming", invoiceCtrl.GetIncomingInvoices)
-	secured.GET("/v2/invoices/outgoing", invoiceCtrl.GetOutgoingInvoices)
-	secured.GET("/v2/invoices/:payment_hash", invoiceCtrl.GetInvoice)
-	securedWithStrictRateLimit.POST("/v2/payments/bolt11", v2controllers.NewPayInvoiceController(svc).PayInvoice)
-	securedWithStrictRateLimit.POST("/v2/payments/keysend", keysendCtrl.KeySend)
-	securedWithStrictRateLimit.POST("/v2/payments/keysend/multi", keysendCtrl.MultiKeySend)
-	secured.GET("/v2/balance", v2controllers.NewBalanceController(svc).Balance)
+	secured.POST("/v2/invoices", invoiceCtrl.AddInvoice, walletMw)
+	secured.GET("/v2/invoices/incoming", invoiceCtrl.GetIncomingInvoices, walletMw)
+	secured.GET("/v2/invoices/outgoing", invoiceCtrl.GetOutgoingInvoices, walletMw)
+	secured.GET("/v2/invoices/:payment_hash", invoiceCtrl.GetInvoice, walletMw)
+	secured.DELETE("/v2/invoices/:payment_hash", invoiceCtrl.CancelInvoice, walletMw)
+	holdInvoiceCtrl := v2controllers.NewHoldInvoiceController(svc)
+	secured.POST("/v2/invoices/hold", holdInvoiceCtrl.AddHoldInvoice, walletMw)
+	secured.POST("/v2/invoices/hold/:payment_hash/settle", holdInvoiceCtrl.SettleHoldInvoice, walletMw)
+	secured.POST("/v2/invoices/hold/:payment_hash/cancel", holdInvoiceCtrl.CancelHoldInvoice, walletMw)
+	payInvoiceCtrl := v2controllers.NewPayInvoiceController(svc)
+	securedWithStrictRateLimit.POST("/v2/payments/bolt11", payInvoiceCtrl.PayInvoice, walletMw)
+	securedWithStrictRateLimit.POST("/v2/payments/bolt11/multi", payInvoiceCtrl.MultiPayInvoice, walletMw)
+	securedWithStrictRateLimit.POST("/v2/payments/keysend", keysendCtrl.KeySend, walletMw)
+	securedWithStrictRateLimit.POST("/v2/payments/keysend/multi", keysendCtrl.MultiKeySend, walletMw)
+	securedWithStrictRateLimit.POST("/v2/payments/value4value", keysendCtrl.ValueForValue, walletMw)
+	securedWithStrictRateLimit.POST("/v2/payments/internal", v2controllers.NewTransferController(svc).InternalTransfer, walletMw)
+	// scheduled payments are made without the token later, so tokens that are bound to a budget can't manage them.
+	// They are paid from the wallet they were created for.
+	fullAccessMw := svc.RequireFullAccessMiddleware()
+	scheduledPaymentCtrl := v2controllers.NewScheduledPaymentController(svc)
+	secured.POST("/v2/payments/scheduled", scheduledPaymentCtrl.CreateScheduledPayment, fullAccessMw, walletMw)
+	secured.GET("/v2/payments/scheduled", scheduledPaymentCtrl.GetScheduledPayments, fullAccessMw, walletMw)
+	secured.GET("/v2/payments/scheduled/:id", scheduledPaymentCtrl.GetScheduledPayment, fullAccessMw, walletMw)
+	secured.GET("/v2/payments/scheduled/:id/runs", scheduledPaymentCtrl.GetScheduledPaymentRuns, fullAccessMw, walletMw)
+	secured.PUT("/v2/payments/scheduled/:id", scheduledPaymentCtrl.UpdateScheduledPayment, fullAccessMw, walletMw)
+	secured.DELETE("/v2/payments/scheduled/:id", scheduledPaymentCtrl.DeleteScheduledPayment, fullAccessMw, walletMw)
+	secured.POST("/v2/payments/scheduled/:id/pause", scheduledPaymentCtrl.PauseScheduledPayment, fullAccessMw, walletMw)
+	secured.POST("/v2/payments/scheduled/:id/resume", scheduledPaymentCtrl.ResumeScheduledPayment, fullAccessMw, walletMw)
+	budgetCtrl := v2controllers.NewBudgetController(svc)
+	secured.POST("/v2/budgets", budgetCtrl.CreateBudget, fullAccessMw)
+	secured.GET("/v2/budgets", budgetCtrl.GetBudgets, fullAccessMw)
+	secured.GET("/v2/budgets/current", budgetCtrl.GetCurrentBudget)
+	secured.GET("/v2/budgets/:id", budgetCtrl.GetBudget, fullAccessMw)
+	secured.DELETE("/v2/budgets/:id", budgetCtrl.RevokeBudget, fullAccessMw)
+	totpCtrl := v2controllers.NewTOTPController(svc)
+	secured.GET("/v2/totp", totpCtrl.GetTOTP, fullAccessMw)
+	secured.POST("/v2/totp", totpCtrl.EnrolTOTP, fullAccessMw)
+	secured.GET("/v2/totp/qr", totpCtrl.TOTPQR, fullAccessMw)
+	securedWithStrictRateLimit.POST("/v2/totp/verify", totpCtrl.VerifyTOTP, fullAccessMw)
+	securedWithStrictRateLimit.PUT("/v2/totp", totpCtrl.UpdateTOTP, fullAccessMw)
+	securedWithStrictRateLimit.POST("/v2/totp/recovery-codes", totpCtrl.RegenerateRecoveryCodes, fullAccessMw)
+	securedWithStrictRateLimit.DELETE("/v2/totp", totpCtrl.DisableTOTP, fullAccessMw)
+	accountCtrl := v2controllers.NewAccountController(svc)
+	securedWithStrictRateLimit.PUT("/v2/account/password", accountCtrl.ChangePassword, fullAccessMw)
+	securedWithStrictRateLimit.PUT("/v2/account/login", accountCtrl.ChangeLogin, fullAccessMw)
+	securedWithStrictRateLimit.POST("/v2/account/delete", accountCtrl.DeleteAccount, fullAccessMw)
+	if svc.Config.LnurlAuthEnabled {
+		lnurlAuthCtrl := v2controllers.NewLnurlAuthController(svc)
+		secured.POST("/v2/lnurlauth/link", lnurlAuthCtrl.LinkLnurlAuth, fullAccessMw)
+		secured.GET("/v2/lnurlauth/keys", lnurlAuthCtrl.GetLnurlAuthKeys, fullAccessMw)
+		secured.DELETE("/v2/lnurlauth/keys/:key", lnurlAuthCtrl.UnlinkLnurlAuthKey, fullAccessMw)
+	}
+	if svc.Config.NostrAuthEnabled {
+		nostrAuthCtrl := v2controllers.NewNostrAuthController(svc)
+		secured.POST("/v2/nostr/pubkeys", nostrAuthCtrl.LinkNostrPubkey, fullAccessMw)
+		secured.GET("/v2/nostr/pubkeys", nostrAuthCtrl.GetNostrPubkeys, fullAccessMw)
+		secured.DELETE("/v2/nostr/pubkeys/:pubkey", nostrAuthCtrl.UnlinkNostrPubkey, fullAccessMw)
+	}
+	walletCtrl := v2controllers.NewWalletController(svc)
+	secured.POST("/v2/wallets", walletCtrl.CreateWallet, fullAccessMw)
+	secured.GET("/v2/wallets", walletCtrl.GetWallets)
+	secured.PUT("/v2/wallets/:id", walletCtrl.UpdateWallet, fullAccessMw)
+	securedWithStrictRateLimit.POST("/v2/wallets/transfer", walletCtrl.WalletTransfer, fullAccessMw)
+	secured.GET("/v2/balance", v2controllers.NewBalanceController(svc).Balance, walletMw)
 }
@@ -0,0 +1,201 @@
+package lnd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// the active node went down and the next healthy node took over
+	SWITCH_REASON_FAILOVER = "failover"
+	// an operator made another node the active node
+	SWITCH_REASON_FORCED = "forced"
+	// the active node is drained for maintenance
+	SWITCH_REASON_DRAINED = "drained"
+)
+
+var (
+	ErrNodeNotFound   = errors.New("node is not part of the cluster")
+	ErrNodeUnhealthy  = errors.New("node is not healthy")
+	ErrNodeDraining   = errors.New("node is draining")
+	ErrNoHealthyNodes = errors.New("no other healthy node available")
+)
+
+var (
+	clusterSwitches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lndhub_lnd_cluster_switches_total",
+		Help: "Number of times another node became the active node of the LND cluster",
+	}, []string{"reason"})
+	clusterNodeActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lndhub_lnd_cluster_node_active",
+		Help: "1 if the node is the active node of the LND cluster",
+	}, []string{"pubkey"})
+	clusterNodeHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lndhub_lnd_cluster_node_healthy",
+		Help: "1 if the node is reachable and has enough active channels",
+	}, []string{"pubkey"})
+	clusterNodeDraining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lndhub_lnd_cluster_node_draining",
+		Help: "1 if the node is drained for maintenance",
+	}, []string{"pubkey"})
+	clusterNodeActiveChannelRatio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lndhub_lnd_cluster_node_active_channel_ratio",
+		Help: "Ratio of active channels of the node at the last liveness check",
+	}, []string{"pubkey"})
+)
+
+// NodeSwitch describes a change of the active node
+type NodeSwitch struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// SwitchHook is called after the active node changed, e.g. to publish an event
+type SwitchHook = func(NodeSwitch)
+
+// ClusterAdmin is implemented by clients that let operators look into and steer the cluster
+type ClusterAdmin interface {
+	NodeStatuses() []NodeStatus
+	// SwitchActiveNode makes the node the active node until it becomes unhealthy
+	SwitchActiveNode(pubkey string) error
+	// DrainNode stops sending payments and invoices to the node, it keeps processing invoice updates
+	DrainNode(pubkey string) error
+	UndrainNode(pubkey string) error
+	SetSwitchHook(hook SwitchHook)
+}
+
+func (cluster *LNDCluster) SetSwitchHook(hook SwitchHook) {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	cluster.switchHook = hook
+}
+
+func (cluster *LNDCluster) SwitchActiveNode(pubkey string) error {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	status, err := cluster.status(pubkey)
+	if err != nil {
+		return err
+	}
+	if cluster.draining[pubkey] {
+		return ErrNodeDraining
+	}
+	if !status.Healthy {
+		return ErrNodeUnhealthy
+	}
+	cluster.pinned = pubkey
+	cluster.setActiveNode(status, SWITCH_REASON_FORCED)
+	return nil
+}
+
+func (cluster *LNDCluster) DrainNode(pubkey string) error {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	_, err := cluster.status(pubkey)
+	if err != nil {
+		return err
+	}
+	if cluster.ActiveNode != nil && cluster.ActiveNode.GetMainPubkey() == pubkey {
+		candidates := cluster.candidates(pubkey)
+		if len(candidates) == 0 {
+			return ErrNoHealthyNodes
+		}
+		cluster.setActiveNode(candidates[0], SWITCH_REASON_DRAINED)
+	}
+	if cluster.pinned == pubkey {
+		cluster.pinned = ""
+	}
+	if cluster.draining == nil {
+		cluster.draining = map[string]bool{}
+	}
+	cluster.draining[pubkey] = true
+	clusterNodeDraining.WithLabelValues(pubkey).Set(1)
+	cluster.Logger.Infof("Draining node %s", pubkey)
+	return nil
+}
+
+func (cluster *LNDCluster) UndrainNode(pubkey string) error {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	_, err := cluster.status(pubkey)
+	if err != nil {
+		return err
+	}
+	delete(cluster.draining, pubkey)
+	clusterNodeDraining.WithLabelValues(pubkey).Set(0)
+	cluster.Logger.Infof("Stopped draining node %s", pubkey)
+	return nil
+}
+
+// status returns the last known status of the node, nodes that were not checked yet are unhealthy
+func (cluster *LNDCluster) status(pubkey string) (NodeStatus, error) {
+	for _, status := range cluster.statuses {
+		if status.Pubkey == pubkey {
+			return status, nil
+		}
+	}
+	for _, node := range cluster.Nodes {
+		if node.GetMainPubkey() == pubkey {
+			return NodeStatus{Node: node, Pubkey: pubkey}, nil
+		}
+	}
+	return NodeStatus{}, ErrNodeNotFound
+}
+
+// candidates returns the healthy nodes that are not draining, except for the excluded one
+func (cluster *LNDCluster) candidates(exclude string) []NodeStatus {
+	candidates := []NodeStatus{}
+	for _, status := range cluster.statuses {
+		if status.Healthy && !cluster.draining[status.Pubkey] && status.Pubkey != exclude {
+			candidates = append(candidates, status)
+		}
+	}
+	return candidates
+}
+
+// setActiveNode switches the active node, the caller has to hold the lock
+func (cluster *LNDCluster) setActiveNode(status NodeStatus, reason string) {
+	if cluster.ActiveNode == status.Node {
+		return
+	}
+	nodeSwitch := NodeSwitch{
+		To:     status.Pubkey,
+		Reason: reason,
+		Time:   time.Now(),
+	}
+	if cluster.ActiveNode != nil {
+		nodeSwitch.From = cluster.ActiveNode.GetMainPubkey()
+		clusterNodeActive.WithLabelValues(nodeSwitch.From).Set(0)
+	}
+	cluster.ActiveNode = status.Node
+	clusterNodeActive.WithLabelValues(nodeSwitch.To).Set(1)
+	clusterSwitches.WithLabelValues(reason).Inc()
+
+	//log & send notification to Sentry
+	message := fmt.Sprintf("Switched nodes: new node id %s, reason %s", nodeSwitch.To, reason)
+	cluster.Logger.Info(message)
+	sentry.CaptureMessage(message)
+	if cluster.switchHook != nil {
+		go cluster.switchHook(nodeSwitch)
+	}
+}
+
+func recordNodeMetrics(status NodeStatus, active bool) {
+	clusterNodeActive.WithLabelValues(status.Pubkey).Set(boolGauge(active))
+	clusterNodeHealthy.WithLabelValues(status.Pubkey).Set(boolGauge(status.Healthy))
+	clusterNodeActiveChannelRatio.WithLabelValues(status.Pubkey).Set(status.ActiveChannelRatio)
+}
+
+func boolGauge(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package lnd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterAdmin(t *testing.T) {
+	first := newRoutingNode("first", 1, 0)
+	second := newRoutingNode("second", 1, 0)
+	third := newRoutingNode("third", 0, 0)
+	third.infoErr = errors.New("connection refused")
+	cluster := newTestCluster(nil, first, second, third)
+	switches := make(chan NodeSwitch, 10)
+	cluster.SetSwitchHook(func(nodeSwitch NodeSwitch) { switches <- nodeSwitch })
+	cluster.checkClusterStatus(context.Background())
+
+	nextSwitch := func() NodeSwitch {
+		select {
+		case nodeSwitch := <-switches:
+			return nodeSwitch
+		case <-time.After(time.Second):
+			t.Fatal("no node switch")
+			return NodeSwitch{}
+		}
+	}
+
+	assert.ErrorIs(t, cluster.SwitchActiveNode("unknown"), ErrNodeNotFound)
+	assert.ErrorIs(t, cluster.SwitchActiveNode("third"), ErrNodeUnhealthy)
+
+	// a forced switch survives the next liveness check
+	require.NoError(t, cluster.SwitchActiveNode("second"))
+	nodeSwitch := nextSwitch()
+	assert.Equal(t, "first", nodeSwitch.From)
+	assert.Equal(t, "second", nodeSwitch.To)
+	assert.Equal(t, SWITCH_REASON_FORCED, nodeSwitch.Reason)
+	cluster.checkClusterStatus(context.Background())
+	assert.Equal(t, second, cluster.activeNode())
+
+	// draining the active node switches to the next healthy one
+	require.NoError(t, cluster.DrainNode("second"))
+	nodeSwitch = nextSwitch()
+	assert.Equal(t, "first", nodeSwitch.To)
+	assert.Equal(t, SWITCH_REASON_DRAINED, nodeSwitch.Reason)
+	assert.ErrorIs(t, cluster.SwitchActiveNode("second"), ErrNodeDraining)
+	assert.ErrorIs(t, cluster.DrainNode("first"), ErrNoHealthyNodes)
+	cluster.checkClusterStatus(context.Background())
+	assert.Equal(t, first, cluster.activeNode())
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, first, cluster.selectNode(RouteRequest{}))
+	}
+
+	statuses := cluster.NodeStatuses()
+	require.Len(t, statuses, 3)
+	assert.True(t, statuses[0].Active)
+	assert.False(t, statuses[0].Draining)
+	assert.False(t, statuses[1].Active)
+	assert.True(t, statuses[1].Draining)
+	assert.False(t, statuses[2].Reachable)
+
+	// the node takes part again after draining, the first node stays active
+	require.NoError(t, cluster.UndrainNode("second"))
+	cluster.checkClusterStatus(context.Background())
+	assert.Equal(t, first, cluster.activeNode())
+	assert.False(t, cluster.NodeStatuses()[1].Draining)
+
+	// the active node goes down
+	first.infoErr = errors.New("connection refused")
+	cluster.checkClusterStatus(context.Background())
+	nodeSwitch = nextSwitch()
+	assert.Equal(t, "second", nodeSwitch.To)
+	assert.Equal(t, SWITCH_REASON_FAILOVER, nodeSwitch.Reason)
+}
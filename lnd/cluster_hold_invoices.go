@@ -0,0 +1,69 @@
+package lnd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"google.golang.org/grpc"
+)
+
+func (cluster *LNDCluster) AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error) {
+	resp, _, err := cluster.AddHoldInvoiceOnNode(ctx, req, options...)
+	return resp, err
+}
+
+func (cluster *LNDCluster) AddHoldInvoiceOnNode(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, string, error) {
+	node := cluster.selectNode(RouteRequest{
+		Kind:   RouteInvoice,
+		Amount: req.Value,
+	})
+	resp, err := node.AddHoldInvoice(ctx, req, options...)
+	return resp, node.GetMainPubkey(), err
+}
+
+// SettleInvoice settles a hold invoice on whichever node knows it
+func (cluster *LNDCluster) SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error) {
+	return cluster.SettleInvoiceOnNode(ctx, "", req, options...)
+}
+
+func (cluster *LNDCluster) SettleInvoiceOnNode(ctx context.Context, nodePubkey string, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (resp *invoicesrpc.SettleInvoiceResp, err error) {
+	err = cluster.onInvoiceNode(nodePubkey, func(node LightningClientWrapper) error {
+		resp, err = node.SettleInvoice(ctx, req, options...)
+		return err
+	})
+	return resp, err
+}
+
+// CancelInvoice cancels an invoice on whichever node knows it
+func (cluster *LNDCluster) CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error) {
+	return cluster.CancelInvoiceOnNode(ctx, "", req, options...)
+}
+
+func (cluster *LNDCluster) CancelInvoiceOnNode(ctx context.Context, nodePubkey string, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (resp *invoicesrpc.CancelInvoiceResp, err error) {
+	err = cluster.onInvoiceNode(nodePubkey, func(node LightningClientWrapper) error {
+		resp, err = node.CancelInvoice(ctx, req, options...)
+		return err
+	})
+	return resp, err
+}
+
+// onInvoiceNode calls fn with the node that issued an invoice. Invoices of unknown
+// nodes, e.g. created before node pubkeys were recorded, are tried on every node.
+func (cluster *LNDCluster) onInvoiceNode(nodePubkey string, fn func(node LightningClientWrapper) error) error {
+	var lastErr error
+	for _, node := range cluster.Nodes {
+		if nodePubkey != "" && node.GetMainPubkey() != nodePubkey {
+			continue
+		}
+		err := fn(node)
+		if err == nil {
+			return nil
+		}
+		lastErr = fmt.Errorf("node id %s: %w", node.GetMainPubkey(), err)
+	}
+	if lastErr == nil {
+		return ErrNodeNotFound
+	}
+	return lastErr
+}
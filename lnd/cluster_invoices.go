@@ -0,0 +1,137 @@
+package lnd
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"google.golang.org/grpc"
+)
+
+// number of invoice updates remembered to filter out duplicates
+const clusterDedupCacheSize = 10000
+
+// time to wait before resubscribing to a node after its invoice stream broke
+var clusterResubscribeDelay = 5 * time.Second
+
+type nodeInvoice struct {
+	invoice    *lnrpc.Invoice
+	nodePubkey string
+}
+
+// clusterInvoiceSubscription merges the invoice streams of all cluster nodes.
+// Updates are deduplicated by r_hash and state, so an update that is received
+// twice (e.g. when a node stream is replayed after reconnecting) is only passed on once.
+type clusterInvoiceSubscription struct {
+	ctx     context.Context
+	updates chan nodeInvoice
+
+	seen      map[string]struct{}
+	seenOrder []string
+}
+
+func (sub *clusterInvoiceSubscription) Recv() (*lnrpc.Invoice, error) {
+	invoice, _, err := sub.RecvFromNode()
+	return invoice, err
+}
+
+func (sub *clusterInvoiceSubscription) RecvFromNode() (*lnrpc.Invoice, string, error) {
+	for {
+		select {
+		case <-sub.ctx.Done():
+			return nil, "", sub.ctx.Err()
+		case update := <-sub.updates:
+			key := fmt.Sprintf("%s:%s", hex.EncodeToString(update.invoice.RHash), update.invoice.State.String())
+			if _, ok := sub.seen[key]; ok {
+				continue
+			}
+			sub.seen[key] = struct{}{}
+			sub.seenOrder = append(sub.seenOrder, key)
+			if len(sub.seenOrder) > clusterDedupCacheSize {
+				delete(sub.seen, sub.seenOrder[0])
+				sub.seenOrder = sub.seenOrder[1:]
+			}
+			return update.invoice, update.nodePubkey, nil
+		}
+	}
+}
+
+func (cluster *LNDCluster) NodePubkeys() []string {
+	pubkeys := make([]string, 0, len(cluster.Nodes))
+	for _, node := range cluster.Nodes {
+		pubkeys = append(pubkeys, node.GetMainPubkey())
+	}
+	return pubkeys
+}
+
+func (cluster *LNDCluster) AddInvoiceOnNode(ctx context.Context, req *lnrpc.Invoice, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, string, error) {
+	node := cluster.selectNode(RouteRequest{
+		Kind:   RouteInvoice,
+		Amount: req.Value,
+	})
+	resp, err := node.AddInvoice(ctx, req, options...)
+	return resp, node.GetMainPubkey(), err
+}
+
+// SubscribeInvoices subscribes to the invoices of all nodes with the same options
+func (cluster *LNDCluster) SubscribeInvoices(ctx context.Context, req *lnrpc.InvoiceSubscription, options ...grpc.CallOption) (SubscribeInvoicesWrapper, error) {
+	reqs := map[string]*lnrpc.InvoiceSubscription{}
+	for _, node := range cluster.Nodes {
+		reqs[node.GetMainPubkey()] = req
+	}
+	return cluster.SubscribeInvoicesOnNodes(ctx, reqs)
+}
+
+// SubscribeInvoicesOnNodes subscribes to the invoices of every node. If the stream of a
+// node breaks, e.g. because the node is restarting, it is resubscribed with the original
+// options, so no updates get lost while it was away. Updates of the other nodes keep
+// coming in the meantime.
+func (cluster *LNDCluster) SubscribeInvoicesOnNodes(ctx context.Context, reqs map[string]*lnrpc.InvoiceSubscription) (NodeSubscribeInvoicesWrapper, error) {
+	sub := &clusterInvoiceSubscription{
+		ctx:     ctx,
+		updates: make(chan nodeInvoice),
+		seen:    map[string]struct{}{},
+	}
+	for _, node := range cluster.Nodes {
+		req, ok := reqs[node.GetMainPubkey()]
+		if !ok {
+			req = &lnrpc.InvoiceSubscription{}
+		}
+		go cluster.subscribeNodeInvoices(ctx, node, req, sub.updates)
+	}
+	return sub, nil
+}
+
+func (cluster *LNDCluster) subscribeNodeInvoices(ctx context.Context, node LightningClientWrapper, req *lnrpc.InvoiceSubscription, updates chan<- nodeInvoice) {
+	pubkey := node.GetMainPubkey()
+	for {
+		stream, err := node.SubscribeInvoices(ctx, req)
+		for err == nil {
+			var invoice *lnrpc.Invoice
+			invoice, err = stream.Recv()
+			if err != nil {
+				break
+			}
+			select {
+			case updates <- nodeInvoice{invoice: invoice, nodePubkey: pubkey}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		msg := fmt.Sprintf("Error in invoice subscription, node id %s, error %s", pubkey, err.Error())
+		cluster.Logger.Error(msg)
+		sentry.CaptureMessage(msg)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(clusterResubscribeDelay):
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package lnd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ziflex/lecho/v3"
+	"google.golang.org/grpc"
+)
+
+// fakeNode replays a fixed list of updates on every subscription, the first subscription breaks after breakAfter updates
+type fakeNode struct {
+	LightningClientWrapper
+	pubkey     string
+	updates    []*lnrpc.Invoice
+	breakAfter int
+
+	mu            sync.Mutex
+	subscriptions []*lnrpc.InvoiceSubscription
+}
+
+func (node *fakeNode) GetMainPubkey() string { return node.pubkey }
+
+func (node *fakeNode) SubscribeInvoices(ctx context.Context, req *lnrpc.InvoiceSubscription, options ...grpc.CallOption) (SubscribeInvoicesWrapper, error) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.subscriptions = append(node.subscriptions, req)
+	limit := len(node.updates)
+	if len(node.subscriptions) == 1 && node.breakAfter > 0 {
+		limit = node.breakAfter
+	}
+	return &fakeStream{ctx: ctx, updates: node.updates[:limit], broken: limit < len(node.updates)}, nil
+}
+
+func (node *fakeNode) subscriptionCount() int {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return len(node.subscriptions)
+}
+
+type fakeStream struct {
+	ctx     context.Context
+	updates []*lnrpc.Invoice
+	broken  bool
+}
+
+func (stream *fakeStream) Recv() (*lnrpc.Invoice, error) {
+	if len(stream.updates) > 0 {
+		invoice := stream.updates[0]
+		stream.updates = stream.updates[1:]
+		return invoice, nil
+	}
+	if stream.broken {
+		return nil, errors.New("connection lost")
+	}
+	<-stream.ctx.Done()
+	return nil, stream.ctx.Err()
+}
+
+func TestClusterSubscribeInvoicesOnNodes(t *testing.T) {
+	clusterResubscribeDelay = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := &fakeNode{
+		pubkey: "first",
+		updates: []*lnrpc.Invoice{
+			{RHash: []byte("a"), State: lnrpc.Invoice_SETTLED},
+			{RHash: []byte("b"), State: lnrpc.Invoice_ACCEPTED},
+		},
+		// the stream breaks after the first update, everything is replayed after resubscribing
+		breakAfter: 1,
+	}
+	second := &fakeNode{
+		pubkey: "second",
+		updates: []*lnrpc.Invoice{
+			{RHash: []byte("c"), State: lnrpc.Invoice_SETTLED},
+		},
+	}
+	cluster := &LNDCluster{
+		Nodes:  []LightningClientWrapper{first, second},
+		Logger: lecho.New(io.Discard),
+	}
+
+	firstReq := &lnrpc.InvoiceSubscription{AddIndex: 41}
+	sub, err := cluster.SubscribeInvoicesOnNodes(ctx, map[string]*lnrpc.InvoiceSubscription{"first": firstReq})
+	require.NoError(t, err)
+
+	received := map[string]string{}
+	for i := 0; i < 3; i++ {
+		invoice, pubkey, err := sub.RecvFromNode()
+		require.NoError(t, err)
+		received[string(invoice.RHash)] = pubkey
+	}
+	assert.Equal(t, map[string]string{"a": "first", "b": "first", "c": "second"}, received)
+	assert.Equal(t, 2, first.subscriptionCount())
+	assert.Equal(t, []*lnrpc.InvoiceSubscription{firstReq, firstReq}, first.subscriptions)
+	assert.Equal(t, 1, second.subscriptionCount())
+
+	// the replayed update of "a" was filtered out
+	recvCtx, recvCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer recvCancel()
+	sub, err = cluster.SubscribeInvoicesOnNodes(recvCtx, nil)
+	require.NoError(t, err)
+	seen := 0
+	for {
+		_, _, err := sub.RecvFromNode()
+		if err != nil {
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			break
+		}
+		seen++
+	}
+	assert.Equal(t, 3, seen)
+}
+
+func TestAddInvoiceOnActiveNode(t *testing.T) {
+	first := &invoiceNode{fakeNode: fakeNode{pubkey: "first"}}
+	second := &invoiceNode{fakeNode: fakeNode{pubkey: "second"}}
+	cluster := &LNDCluster{
+		Nodes:      []LightningClientWrapper{first, second},
+		ActiveNode: second,
+	}
+
+	_, pubkey, err := AddInvoice(context.Background(), cluster, &lnrpc.Invoice{Value: 1000})
+	require.NoError(t, err)
+	assert.Equal(t, "second", pubkey)
+	assert.Equal(t, 0, first.added)
+	assert.Equal(t, 1, second.added)
+
+	// single nodes report their own pubkey
+	_, pubkey, err = AddInvoice(context.Background(), first, &lnrpc.Invoice{Value: 1000})
+	require.NoError(t, err)
+	assert.Equal(t, "first", pubkey)
+}
+
+type invoiceNode struct {
+	fakeNode
+	added int
+}
+
+func (node *invoiceNode) AddInvoice(ctx context.Context, req *lnrpc.Invoice, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	node.added++
+	return &lnrpc.AddInvoiceResponse{}, nil
+}
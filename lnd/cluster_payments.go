@@ -0,0 +1,74 @@
+package lnd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// clusterPaymentSubscription tracks a payment on one node after the other, until
+// a node sends an update for it. Nodes that never saw the payment fail right away.
+type clusterPaymentSubscription struct {
+	ctx     context.Context
+	nodes   []LightningClientWrapper
+	req     *routerrpc.TrackPaymentRequest
+	options []grpc.CallOption
+
+	stream SubscribePaymentWrapper
+}
+
+func (sub *clusterPaymentSubscription) Recv() (*lnrpc.Payment, error) {
+	if sub.stream != nil {
+		return sub.stream.Recv()
+	}
+	var lastErr, notFoundErr error
+	for _, node := range sub.nodes {
+		stream, err := node.SubscribePayment(sub.ctx, sub.req, sub.options...)
+		if err == nil {
+			var payment *lnrpc.Payment
+			payment, err = stream.Recv()
+			if err == nil {
+				sub.stream = stream
+				return payment, nil
+			}
+		}
+		if sub.ctx.Err() != nil {
+			return nil, sub.ctx.Err()
+		}
+		err = fmt.Errorf("node id %s: %w", node.GetMainPubkey(), err)
+		if status.Code(err) == codes.NotFound {
+			notFoundErr = err
+			continue
+		}
+		lastErr = err
+	}
+	// the payment is only unknown if no node had a different problem
+	if lastErr == nil {
+		return nil, notFoundErr
+	}
+	return nil, lastErr
+}
+
+// SubscribePaymentOnNode tracks a payment, starting with the node it was sent from.
+// If that node is unknown, all nodes are tried.
+func (cluster *LNDCluster) SubscribePaymentOnNode(ctx context.Context, nodePubkey string, req *routerrpc.TrackPaymentRequest, options ...grpc.CallOption) (SubscribePaymentWrapper, error) {
+	nodes := []LightningClientWrapper{}
+	for _, node := range cluster.Nodes {
+		if node.GetMainPubkey() == nodePubkey {
+			nodes = append([]LightningClientWrapper{node}, nodes...)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return &clusterPaymentSubscription{
+		ctx:     ctx,
+		nodes:   nodes,
+		req:     req,
+		options: options,
+	}, nil
+}
@@ -0,0 +1,195 @@
+package lnd
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+const (
+	// use the active node: the first healthy node in the order the nodes are configured, unless an operator switched nodes
+	ROUTING_STRATEGY_FAILOVER = "failover"
+	// take turns between the healthy nodes
+	ROUTING_STRATEGY_ROUND_ROBIN = "round_robin"
+	// pick a random healthy node, nodes with a higher weight are picked more often
+	ROUTING_STRATEGY_WEIGHTED = "weighted"
+	// pay from the node with the most outbound liquidity, create invoices on the node with the most inbound liquidity
+	ROUTING_STRATEGY_LIQUIDITY = "liquidity"
+	// pay from a node that has a direct channel to the destination, falls back to the liquidity strategy
+	ROUTING_STRATEGY_DESTINATION = "destination"
+)
+
+// RouteKind tells a routing strategy what a node is selected for
+type RouteKind int
+
+const (
+	RoutePayment RouteKind = iota
+	RouteInvoice
+)
+
+// RouteRequest describes the payment or invoice a node is selected for
+type RouteRequest struct {
+	Kind RouteKind
+	// Amount in sats
+	Amount int64
+	// Destination is the hex encoded pubkey of the node that gets paid, if known
+	Destination string
+}
+
+// NodeStatus is the state of a cluster node as seen by the last liveness check
+type NodeStatus struct {
+	Node               LightningClientWrapper
+	Pubkey             string
+	Reachable          bool
+	ActiveChannelRatio float64
+	// Healthy nodes are reachable and have enough active channels to be used
+	Healthy   bool
+	Active    bool
+	Draining  bool
+	LastCheck time.Time
+	Weight    int
+	// Channels holds the active channels of the node
+	Channels []*lnrpc.Channel
+}
+
+// OutboundLiquidity is the amount in sats the node can send over its active channels
+func (status NodeStatus) OutboundLiquidity() int64 {
+	var total int64
+	for _, channel := range status.Channels {
+		total += spendable(channel.LocalBalance, channel.LocalConstraints)
+	}
+	return total
+}
+
+// InboundLiquidity is the amount in sats the node can receive over its active channels
+func (status NodeStatus) InboundLiquidity() int64 {
+	var total int64
+	for _, channel := range status.Channels {
+		total += spendable(channel.RemoteBalance, channel.RemoteConstraints)
+	}
+	return total
+}
+
+// DirectLiquidity is the amount in sats the node can send to destination over direct channels
+func (status NodeStatus) DirectLiquidity(destination string) int64 {
+	var total int64
+	for _, channel := range status.Channels {
+		if channel.RemotePubkey == destination {
+			total += spendable(channel.LocalBalance, channel.LocalConstraints)
+		}
+	}
+	return total
+}
+
+func spendable(balance int64, constraints *lnrpc.ChannelConstraints) int64 {
+	if constraints != nil {
+		balance -= int64(constraints.ChanReserveSat)
+	}
+	if balance < 0 {
+		return 0
+	}
+	return balance
+}
+
+// RoutingStrategy selects the node that is used for an outgoing payment or a new invoice
+type RoutingStrategy interface {
+	// SelectNode picks one of the available nodes, nodes is never empty and starts with the active node
+	SelectNode(req RouteRequest, nodes []NodeStatus) LightningClientWrapper
+}
+
+func NewRoutingStrategy(name string) (RoutingStrategy, error) {
+	switch name {
+	case "", ROUTING_STRATEGY_FAILOVER:
+		return &FailoverStrategy{}, nil
+	case ROUTING_STRATEGY_ROUND_ROBIN:
+		return &RoundRobinStrategy{}, nil
+	case ROUTING_STRATEGY_WEIGHTED:
+		return &WeightedStrategy{}, nil
+	case ROUTING_STRATEGY_LIQUIDITY:
+		return &LiquidityStrategy{}, nil
+	case ROUTING_STRATEGY_DESTINATION:
+		return &DestinationStrategy{Fallback: &LiquidityStrategy{}}, nil
+	default:
+		return nil, fmt.Errorf("Did not recognize LND cluster routing strategy %s", name)
+	}
+}
+
+// FailoverStrategy always uses the active node
+type FailoverStrategy struct{}
+
+func (s *FailoverStrategy) SelectNode(req RouteRequest, nodes []NodeStatus) LightningClientWrapper {
+	return nodes[0].Node
+}
+
+type RoundRobinStrategy struct {
+	next uint64
+}
+
+func (s *RoundRobinStrategy) SelectNode(req RouteRequest, nodes []NodeStatus) LightningClientWrapper {
+	i := atomic.AddUint64(&s.next, 1) - 1
+	return nodes[i%uint64(len(nodes))].Node
+}
+
+type WeightedStrategy struct{}
+
+func (s *WeightedStrategy) SelectNode(req RouteRequest, nodes []NodeStatus) LightningClientWrapper {
+	total := 0
+	for _, node := range nodes {
+		total += node.Weight
+	}
+	if total <= 0 {
+		return nodes[rand.Intn(len(nodes))].Node
+	}
+	n := rand.Intn(total)
+	for _, node := range nodes {
+		if n < node.Weight {
+			return node.Node
+		}
+		n -= node.Weight
+	}
+	return nodes[len(nodes)-1].Node
+}
+
+type LiquidityStrategy struct{}
+
+func (s *LiquidityStrategy) SelectNode(req RouteRequest, nodes []NodeStatus) LightningClientWrapper {
+	liquidity := NodeStatus.OutboundLiquidity
+	if req.Kind == RouteInvoice {
+		liquidity = NodeStatus.InboundLiquidity
+	}
+	best := nodes[0]
+	for _, node := range nodes[1:] {
+		if liquidity(node) > liquidity(best) {
+			best = node
+		}
+	}
+	return best.Node
+}
+
+// DestinationStrategy pays from the node with the most liquidity in direct channels to
+// the destination, as long as that is enough for the amount. Everything else is passed on
+// to the fallback strategy.
+type DestinationStrategy struct {
+	Fallback RoutingStrategy
+}
+
+func (s *DestinationStrategy) SelectNode(req RouteRequest, nodes []NodeStatus) LightningClientWrapper {
+	if req.Kind == RoutePayment && req.Destination != "" {
+		var best LightningClientWrapper
+		var bestLiquidity int64
+		for _, node := range nodes {
+			liquidity := node.DirectLiquidity(req.Destination)
+			if liquidity >= req.Amount && liquidity > bestLiquidity {
+				best = node.Node
+				bestLiquidity = liquidity
+			}
+		}
+		if best != nil {
+			return best
+		}
+	}
+	return s.Fallback.SelectNode(req, nodes)
+}
@@ -0,0 +1,206 @@
+package lnd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ziflex/lecho/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// routingNode answers liveness checks and payments
+type routingNode struct {
+	LightningClientWrapper
+	pubkey   string
+	info     *lnrpc.GetInfoResponse
+	infoErr  error
+	channels []*lnrpc.Channel
+	paid     int
+	payments map[string]*lnrpc.Payment
+	trackErr error
+}
+
+func (node *routingNode) GetMainPubkey() string { return node.pubkey }
+
+func (node *routingNode) GetInfo(ctx context.Context, req *lnrpc.GetInfoRequest, options ...grpc.CallOption) (*lnrpc.GetInfoResponse, error) {
+	return node.info, node.infoErr
+}
+
+func (node *routingNode) ListChannels(ctx context.Context, req *lnrpc.ListChannelsRequest, options ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
+	return &lnrpc.ListChannelsResponse{Channels: node.channels}, nil
+}
+
+func (node *routingNode) SendPaymentSync(ctx context.Context, req *lnrpc.SendRequest, options ...grpc.CallOption) (*lnrpc.SendResponse, error) {
+	node.paid++
+	return &lnrpc.SendResponse{}, nil
+}
+
+func (node *routingNode) SubscribePayment(ctx context.Context, req *routerrpc.TrackPaymentRequest, options ...grpc.CallOption) (SubscribePaymentWrapper, error) {
+	if node.trackErr != nil {
+		return nil, node.trackErr
+	}
+	return &paymentStream{payment: node.payments[string(req.PaymentHash)]}, nil
+}
+
+type paymentStream struct {
+	payment *lnrpc.Payment
+}
+
+func (stream *paymentStream) Recv() (*lnrpc.Payment, error) {
+	if stream.payment == nil {
+		return nil, status.Error(codes.NotFound, "payment isn't initiated")
+	}
+	return stream.payment, nil
+}
+
+func newRoutingNode(pubkey string, active, inactive uint32, channels ...*lnrpc.Channel) *routingNode {
+	return &routingNode{
+		pubkey:   pubkey,
+		info:     &lnrpc.GetInfoResponse{IdentityPubkey: pubkey, NumActiveChannels: active, NumInactiveChannels: inactive},
+		channels: channels,
+	}
+}
+
+func newTestCluster(strategy RoutingStrategy, nodes ...LightningClientWrapper) *LNDCluster {
+	return &LNDCluster{
+		Nodes:              nodes,
+		ActiveNode:         nodes[0],
+		ActiveChannelRatio: 0.5,
+		Logger:             lecho.New(io.Discard),
+		Strategy:           strategy,
+	}
+}
+
+func TestCheckClusterStatus(t *testing.T) {
+	offline := newRoutingNode("offline", 0, 0)
+	offline.infoErr = errors.New("connection refused")
+	booting := newRoutingNode("booting", 1, 9)
+	online := newRoutingNode("online", 9, 1, &lnrpc.Channel{RemotePubkey: "peer", LocalBalance: 1000})
+	cluster := newTestCluster(nil, offline, booting, online)
+	cluster.Weights = []int{5, 0, 3}
+
+	cluster.checkClusterStatus(context.Background())
+
+	statuses := cluster.NodeStatuses()
+	require.Len(t, statuses, 3)
+	assert.False(t, statuses[0].Reachable)
+	assert.False(t, statuses[0].Healthy)
+	assert.True(t, statuses[1].Reachable)
+	assert.False(t, statuses[1].Healthy)
+	assert.InDelta(t, 0.1, statuses[1].ActiveChannelRatio, 0.0001)
+	assert.True(t, statuses[2].Healthy)
+	assert.Equal(t, int64(1000), statuses[2].OutboundLiquidity())
+	assert.Equal(t, []int{5, 0, 3}, []int{statuses[0].Weight, statuses[1].Weight, statuses[2].Weight})
+	assert.Equal(t, online, cluster.ActiveNode)
+
+	// payments only go to healthy nodes
+	_, pubkey, err := cluster.SendPaymentSyncOnNode(context.Background(), &lnrpc.SendRequest{Amt: 10}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "online", pubkey)
+}
+
+func TestRoutingStrategies(t *testing.T) {
+	first := newRoutingNode("first", 1, 0,
+		&lnrpc.Channel{RemotePubkey: "shop", LocalBalance: 500, RemoteBalance: 9000},
+	)
+	second := newRoutingNode("second", 1, 0,
+		&lnrpc.Channel{RemotePubkey: "peer", LocalBalance: 8000, RemoteBalance: 100},
+		&lnrpc.Channel{RemotePubkey: "shop", LocalBalance: 300, RemoteBalance: 100, LocalConstraints: &lnrpc.ChannelConstraints{ChanReserveSat: 200}},
+	)
+	nodes := []LightningClientWrapper{first, second}
+
+	selected := func(strategy RoutingStrategy, req RouteRequest) string {
+		cluster := newTestCluster(strategy, nodes...)
+		cluster.Weights = []int{0, 1}
+		cluster.checkClusterStatus(context.Background())
+		return cluster.selectNode(req).GetMainPubkey()
+	}
+
+	payment := RouteRequest{Kind: RoutePayment, Amount: 400, Destination: "shop"}
+	invoice := RouteRequest{Kind: RouteInvoice, Amount: 400}
+
+	failover, err := NewRoutingStrategy(ROUTING_STRATEGY_FAILOVER)
+	require.NoError(t, err)
+	assert.Equal(t, "first", selected(failover, payment))
+
+	roundRobin, err := NewRoutingStrategy(ROUTING_STRATEGY_ROUND_ROBIN)
+	require.NoError(t, err)
+	cluster := newTestCluster(roundRobin, nodes...)
+	cluster.checkClusterStatus(context.Background())
+	picked := []string{}
+	for i := 0; i < 4; i++ {
+		picked = append(picked, cluster.selectNode(payment).GetMainPubkey())
+	}
+	assert.Equal(t, []string{"first", "second", "first", "second"}, picked)
+
+	weighted, err := NewRoutingStrategy(ROUTING_STRATEGY_WEIGHTED)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "second", selected(weighted, payment))
+	}
+
+	liquidity, err := NewRoutingStrategy(ROUTING_STRATEGY_LIQUIDITY)
+	require.NoError(t, err)
+	assert.Equal(t, "second", selected(liquidity, payment))
+	assert.Equal(t, "first", selected(liquidity, invoice))
+
+	destination, err := NewRoutingStrategy(ROUTING_STRATEGY_DESTINATION)
+	require.NoError(t, err)
+	// only the first node can pay 400 sats over its direct channel to the shop
+	assert.Equal(t, "first", selected(destination, payment))
+	// too much for any direct channel, fall back to the most outbound liquidity
+	assert.Equal(t, "second", selected(destination, RouteRequest{Kind: RoutePayment, Amount: 600, Destination: "shop"}))
+	assert.Equal(t, "first", selected(destination, invoice))
+
+	_, err = NewRoutingStrategy("random")
+	assert.Error(t, err)
+}
+
+func TestSelectNodeWithoutHealthyNodes(t *testing.T) {
+	first := newRoutingNode("first", 0, 0)
+	first.infoErr = errors.New("connection refused")
+	second := newRoutingNode("second", 0, 0)
+	second.infoErr = errors.New("connection refused")
+	cluster := newTestCluster(&RoundRobinStrategy{}, first, second)
+	cluster.ActiveNode = second
+
+	// before the first check
+	assert.Equal(t, second, cluster.selectNode(RouteRequest{}))
+	cluster.checkClusterStatus(context.Background())
+	assert.Equal(t, second, cluster.selectNode(RouteRequest{}))
+}
+
+func TestClusterSubscribePayment(t *testing.T) {
+	first := newRoutingNode("first", 1, 0)
+	second := newRoutingNode("second", 1, 0)
+	second.payments = map[string]*lnrpc.Payment{"hash": {PaymentHash: "hash", Status: lnrpc.Payment_SUCCEEDED}}
+	cluster := newTestCluster(nil, first, second)
+
+	// the payment is found on the second node, even if the sending node is unknown
+	sub, err := SubscribePayment(context.Background(), cluster, "", &routerrpc.TrackPaymentRequest{PaymentHash: []byte("hash")})
+	require.NoError(t, err)
+	payment, err := sub.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, lnrpc.Payment_SUCCEEDED, payment.Status)
+
+	sub, err = SubscribePayment(context.Background(), cluster, "first", &routerrpc.TrackPaymentRequest{PaymentHash: []byte("unknown")})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// the payment is not reported as unknown if a node could not be asked
+	first.trackErr = errors.New("connection refused")
+	sub, err = SubscribePayment(context.Background(), cluster, "", &routerrpc.TrackPaymentRequest{PaymentHash: []byte("unknown")})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	assert.Error(t, err)
+	assert.NotEqual(t, codes.NotFound, status.Code(err))
+}
@@ -1,6 +1,10 @@
 package lnd
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/kelseyhightower/envconfig"
 )
 
@@ -19,7 +23,25 @@ type Config struct {
 	LNDCertHex                   string  `envconfig:"LND_CERT_HEX"`
 	LNDClusterLivenessPeriod     int     `envconfig:"LND_CLUSTER_LIVENESS_PERIOD" default:"10"`
 	LNDClusterActiveChannelRatio float64 `envconfig:"LND_CLUSTER_ACTIVE_CHANNEL_RATIO" default:"0.5"`
-	LNDClusterPubkeys            string  `envconfig:"LND_CLUSTER_PUBKEYS"` //comma-seperated list of public keys of the cluster
+	LNDClusterPubkeys            string  `envconfig:"LND_CLUSTER_PUBKEYS"`                             //comma-seperated list of public keys of the cluster
+	LNDClusterRoutingStrategy    string  `envconfig:"LND_CLUSTER_ROUTING_STRATEGY" default:"failover"` //failover, round_robin, weighted, liquidity, destination
+	LNDClusterWeights            string  `envconfig:"LND_CLUSTER_WEIGHTS"`                             //comma-seperated list of node weights for the weighted strategy
+}
+
+// ClusterWeights parses LND_CLUSTER_WEIGHTS, no weights means all nodes are weighted equally
+func (c *Config) ClusterWeights() ([]int, error) {
+	weights := []int{}
+	if c.LNDClusterWeights == "" {
+		return weights, nil
+	}
+	for _, value := range strings.Split(c.LNDClusterWeights, ",") {
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid node weight %q", value)
+		}
+		weights = append(weights, weight)
+	}
+	return weights, nil
 }
 
 func LoadConfig() (c *Config, err error) {
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/ziflex/lecho/v3"
 	"google.golang.org/grpc"
@@ -19,6 +21,9 @@ type LightningClientWrapper interface {
 	SubscribePayment(ctx context.Context, req *routerrpc.TrackPaymentRequest, options ...grpc.CallOption) (SubscribePaymentWrapper, error)
 	GetInfo(ctx context.Context, req *lnrpc.GetInfoRequest, options ...grpc.CallOption) (*lnrpc.GetInfoResponse, error)
 	DecodeBolt11(ctx context.Context, bolt11 string, options ...grpc.CallOption) (*lnrpc.PayReq, error)
+	AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error)
+	SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error)
+	CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error)
 	IsIdentityPubkey(pubkey string) (isOurPubkey bool)
 	GetMainPubkey() (pubkey string)
 }
@@ -30,6 +35,85 @@ type SubscribePaymentWrapper interface {
 	Recv() (*lnrpc.Payment, error)
 }
 
+// NodeSubscribeInvoicesWrapper is a stream of invoice updates coming from multiple nodes
+type NodeSubscribeInvoicesWrapper interface {
+	SubscribeInvoicesWrapper
+	// RecvFromNode returns the next invoice update and the pubkey of the node that sent it
+	RecvFromNode() (*lnrpc.Invoice, string, error)
+}
+
+// MultiNodeClient is implemented by clients that spread invoices over multiple nodes
+type MultiNodeClient interface {
+	// NodePubkeys returns the pubkeys of all nodes
+	NodePubkeys() []string
+	// AddInvoiceOnNode creates an invoice and returns the pubkey of the node that issued it
+	AddInvoiceOnNode(ctx context.Context, req *lnrpc.Invoice, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, string, error)
+	// SubscribeInvoicesOnNodes subscribes to the invoices of all nodes and merges the updates.
+	// reqs holds the subscription options per node pubkey, nodes without options only get new updates.
+	SubscribeInvoicesOnNodes(ctx context.Context, reqs map[string]*lnrpc.InvoiceSubscription) (NodeSubscribeInvoicesWrapper, error)
+	// SendPaymentSyncOnNode pays from the node picked for the destination and returns its pubkey
+	SendPaymentSyncOnNode(ctx context.Context, req *lnrpc.SendRequest, destination string, options ...grpc.CallOption) (*lnrpc.SendResponse, string, error)
+	// SubscribePaymentOnNode tracks a payment on the node it was sent from
+	SubscribePaymentOnNode(ctx context.Context, nodePubkey string, req *routerrpc.TrackPaymentRequest, options ...grpc.CallOption) (SubscribePaymentWrapper, error)
+	// AddHoldInvoiceOnNode creates a hold invoice and returns the pubkey of the node that issued it
+	AddHoldInvoiceOnNode(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, string, error)
+	// SettleInvoiceOnNode settles a hold invoice on the node that issued it
+	SettleInvoiceOnNode(ctx context.Context, nodePubkey string, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error)
+	// CancelInvoiceOnNode cancels an invoice on the node that issued it
+	CancelInvoiceOnNode(ctx context.Context, nodePubkey string, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error)
+}
+
+// AddInvoice creates an invoice and returns the pubkey of the node that issued it
+func AddInvoice(ctx context.Context, client LightningClientWrapper, req *lnrpc.Invoice) (*lnrpc.AddInvoiceResponse, string, error) {
+	if multiNodeClient, ok := client.(MultiNodeClient); ok {
+		return multiNodeClient.AddInvoiceOnNode(ctx, req)
+	}
+	resp, err := client.AddInvoice(ctx, req)
+	return resp, client.GetMainPubkey(), err
+}
+
+// AddHoldInvoice creates a hold invoice and returns the pubkey of the node that issued it
+func AddHoldInvoice(ctx context.Context, client LightningClientWrapper, req *invoicesrpc.AddHoldInvoiceRequest) (*invoicesrpc.AddHoldInvoiceResp, string, error) {
+	if multiNodeClient, ok := client.(MultiNodeClient); ok {
+		return multiNodeClient.AddHoldInvoiceOnNode(ctx, req)
+	}
+	resp, err := client.AddHoldInvoice(ctx, req)
+	return resp, client.GetMainPubkey(), err
+}
+
+// SettleInvoice settles a hold invoice on the node that issued it
+func SettleInvoice(ctx context.Context, client LightningClientWrapper, nodePubkey string, req *invoicesrpc.SettleInvoiceMsg) (*invoicesrpc.SettleInvoiceResp, error) {
+	if multiNodeClient, ok := client.(MultiNodeClient); ok {
+		return multiNodeClient.SettleInvoiceOnNode(ctx, nodePubkey, req)
+	}
+	return client.SettleInvoice(ctx, req)
+}
+
+// CancelInvoice cancels an invoice on the node that issued it
+func CancelInvoice(ctx context.Context, client LightningClientWrapper, nodePubkey string, req *invoicesrpc.CancelInvoiceMsg) (*invoicesrpc.CancelInvoiceResp, error) {
+	if multiNodeClient, ok := client.(MultiNodeClient); ok {
+		return multiNodeClient.CancelInvoiceOnNode(ctx, nodePubkey, req)
+	}
+	return client.CancelInvoice(ctx, req)
+}
+
+// SendPaymentSync pays from the node picked for the destination and returns the pubkey of that node
+func SendPaymentSync(ctx context.Context, client LightningClientWrapper, req *lnrpc.SendRequest, destination string) (*lnrpc.SendResponse, string, error) {
+	if multiNodeClient, ok := client.(MultiNodeClient); ok {
+		return multiNodeClient.SendPaymentSyncOnNode(ctx, req, destination)
+	}
+	resp, err := client.SendPaymentSync(ctx, req)
+	return resp, client.GetMainPubkey(), err
+}
+
+// SubscribePayment tracks a payment on the node it was sent from
+func SubscribePayment(ctx context.Context, client LightningClientWrapper, nodePubkey string, req *routerrpc.TrackPaymentRequest) (SubscribePaymentWrapper, error) {
+	if multiNodeClient, ok := client.(MultiNodeClient); ok {
+		return multiNodeClient.SubscribePaymentOnNode(ctx, nodePubkey, req)
+	}
+	return client.SubscribePayment(ctx, req)
+}
+
 func InitLNClient(c *Config, logger *lecho.Logger, ctx context.Context) (result LightningClientWrapper, err error) {
 	switch c.LNClientType {
 	case LND_CLIENT_TYPE:
@@ -84,6 +168,17 @@ func InitLNDCluster(c *Config, logger *lecho.Logger, ctx context.Context) (resul
 		n.IdentityPubkey = pubkeys[i]
 		nodes = append(nodes, n)
 	}
+	strategy, err := NewRoutingStrategy(c.LNDClusterRoutingStrategy)
+	if err != nil {
+		return nil, err
+	}
+	weights, err := c.ClusterWeights()
+	if err != nil {
+		return nil, err
+	}
+	if len(weights) > 0 && len(weights) != len(nodes) {
+		return nil, fmt.Errorf("Error parsing LND cluster config: weights array length mismatch")
+	}
 	logger.Infof("Initialized LND cluster with %d nodes", len(nodes))
 	cluster := &LNDCluster{
 		Nodes:               nodes,
@@ -91,7 +186,13 @@ func InitLNDCluster(c *Config, logger *lecho.Logger, ctx context.Context) (resul
 		ActiveNode:          nodes[0],
 		Logger:              logger,
 		LivenessCheckPeriod: c.LNDClusterLivenessPeriod,
+		Strategy:            strategy,
+		Weights:             weights,
 	}
+	//check the nodes once, so the routing strategy has something to work with
+	checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	cluster.checkClusterStatus(checkCtx)
+	cancel()
 	//start liveness check
 	go cluster.StartLivenessLoop(ctx)
 	return cluster, nil
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/lightningnetwork/lnd/macaroons"
 	"google.golang.org/grpc"
@@ -33,6 +34,7 @@ type LNDoptions struct {
 type LNDWrapper struct {
 	client         lnrpc.LightningClient
 	routerClient   routerrpc.RouterClient
+	invoicesClient invoicesrpc.InvoicesClient
 	IdentityPubkey string
 }
 
@@ -95,8 +97,9 @@ func NewLNDclient(lndOptions LNDoptions, ctx context.Context) (result *LNDWrappe
 	}
 	lnClient := lnrpc.NewLightningClient(conn)
 	return &LNDWrapper{
-		client:       lnClient,
-		routerClient: routerrpc.NewRouterClient(conn),
+		client:         lnClient,
+		routerClient:   routerrpc.NewRouterClient(conn),
+		invoicesClient: invoicesrpc.NewInvoicesClient(conn),
 	}, nil
 }
 
@@ -130,6 +133,18 @@ func (wrapper *LNDWrapper) SubscribePayment(ctx context.Context, req *routerrpc.
 	return wrapper.routerClient.TrackPaymentV2(ctx, req, options...)
 }
 
+func (wrapper *LNDWrapper) AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error) {
+	return wrapper.invoicesClient.AddHoldInvoice(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error) {
+	return wrapper.invoicesClient.SettleInvoice(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error) {
+	return wrapper.invoicesClient.CancelInvoice(ctx, req, options...)
+}
+
 func (wrapper *LNDWrapper) IsIdentityPubkey(pubkey string) (isOurPubkey bool) {
 	return pubkey == wrapper.IdentityPubkey
 }
@@ -2,7 +2,9 @@ package lnd
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/getsentry/sentry-go"
@@ -18,89 +20,194 @@ type LNDCluster struct {
 	ActiveChannelRatio  float64
 	Logger              *lecho.Logger
 	LivenessCheckPeriod int
+	// Strategy selects the node for outgoing payments and new invoices, defaults to failover
+	Strategy RoutingStrategy
+	// Weights of the nodes for the weighted strategy, in the same order as Nodes
+	Weights []int
+
+	mu         sync.RWMutex
+	statuses   []NodeStatus
+	draining   map[string]bool
+	pinned     string
+	switchHook SwitchHook
 }
 
 func (cluster *LNDCluster) StartLivenessLoop(ctx context.Context) {
 	ticker := time.NewTicker(time.Duration(cluster.LivenessCheckPeriod) * time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
 			checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-			defer cancel()
 			cluster.checkClusterStatus(checkCtx)
+			cancel()
 		}
 	}
 }
+
 func (cluster *LNDCluster) checkClusterStatus(ctx context.Context) {
+	statuses := make([]NodeStatus, 0, len(cluster.Nodes))
 	//for all nodes
-	for _, node := range cluster.Nodes {
-		//call getinfo
-		resp, err := node.GetInfo(ctx, &lnrpc.GetInfoRequest{})
-		//if we get an error here, the node is probably offline
-		//so we move to the next node
-		if err != nil {
-			msg := fmt.Sprintf("Error connecting to node, node id %s, error %s", node.GetMainPubkey(), err.Error())
-			cluster.Logger.Infof(msg)
-			sentry.CaptureMessage(msg)
-			continue
-		}
+	for i, node := range cluster.Nodes {
+		status := cluster.checkNode(ctx, node)
 		//if the context has been canceled, return
 		if ctx.Err() == context.Canceled {
 			return
 		}
-		//if num_active_channels / num_total_channels < x % (50?)
-		//not booted yet, go to next
-		nrActiveChannels := resp.NumActiveChannels
-		totalChannels := resp.NumActiveChannels + resp.NumInactiveChannels
-		activeChannelRatio := float64(nrActiveChannels) / float64(totalChannels)
-		if activeChannelRatio < cluster.ActiveChannelRatio {
-			msg := fmt.Sprintf("Node does not have enough active channels yet, node id %s, ratio %f, active channels %d, total channels %d", resp.IdentityPubkey, activeChannelRatio, nrActiveChannels, totalChannels)
-			cluster.Logger.Infof(msg)
-			sentry.CaptureMessage(msg)
-			continue
+		status.Weight = 1
+		if i < len(cluster.Weights) {
+			status.Weight = cluster.Weights[i]
+		}
+		statuses = append(statuses, status)
+	}
+
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	cluster.statuses = statuses
+	cluster.updateActiveNode()
+	for _, status := range statuses {
+		recordNodeMetrics(status, status.Node == cluster.ActiveNode)
+	}
+}
+
+// updateActiveNode makes the first healthy node the active node, unless an operator
+// switched to another node that is still healthy. The caller has to hold the lock.
+func (cluster *LNDCluster) updateActiveNode() {
+	candidates := cluster.candidates("")
+	for _, status := range candidates {
+		if status.Pubkey == cluster.pinned {
+			cluster.setActiveNode(status, SWITCH_REASON_FORCED)
+			return
 		}
-		//node is online and has enough active channels, set this node to active
-		//log & send notification to Sentry in case we're switching
-		if cluster.ActiveNode != node {
-			cluster.ActiveNode = node
-			message := fmt.Sprintf("Switched nodes: new node id %s", node.GetMainPubkey())
-			cluster.Logger.Info(message)
-			sentry.CaptureMessage(message)
+	}
+	cluster.pinned = ""
+	if len(candidates) > 0 {
+		cluster.setActiveNode(candidates[0], SWITCH_REASON_FAILOVER)
+	}
+}
+
+func (cluster *LNDCluster) checkNode(ctx context.Context, node LightningClientWrapper) NodeStatus {
+	status := NodeStatus{
+		Node:      node,
+		Pubkey:    node.GetMainPubkey(),
+		LastCheck: time.Now(),
+	}
+	//call getinfo
+	resp, err := node.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	//if we get an error here, the node is probably offline
+	if err != nil {
+		msg := fmt.Sprintf("Error connecting to node, node id %s, error %s", status.Pubkey, err.Error())
+		cluster.Logger.Infof(msg)
+		sentry.CaptureMessage(msg)
+		return status
+	}
+	status.Reachable = true
+	//if num_active_channels / num_total_channels < x % (50?)
+	//not booted yet
+	nrActiveChannels := resp.NumActiveChannels
+	totalChannels := resp.NumActiveChannels + resp.NumInactiveChannels
+	status.ActiveChannelRatio = 1
+	if totalChannels > 0 {
+		status.ActiveChannelRatio = float64(nrActiveChannels) / float64(totalChannels)
+	}
+	if status.ActiveChannelRatio < cluster.ActiveChannelRatio {
+		msg := fmt.Sprintf("Node does not have enough active channels yet, node id %s, ratio %f, active channels %d, total channels %d", resp.IdentityPubkey, status.ActiveChannelRatio, nrActiveChannels, totalChannels)
+		cluster.Logger.Infof(msg)
+		sentry.CaptureMessage(msg)
+		return status
+	}
+	status.Healthy = true
+	//the channels are used by the liquidity based routing strategies
+	channels, err := node.ListChannels(ctx, &lnrpc.ListChannelsRequest{ActiveOnly: true})
+	if err != nil {
+		cluster.Logger.Errorf("Error listing channels, node id %s, error %s", status.Pubkey, err.Error())
+		return status
+	}
+	status.Channels = channels.Channels
+	return status
+}
+
+// NodeStatuses returns the state of all nodes as seen by the last liveness check
+func (cluster *LNDCluster) NodeStatuses() []NodeStatus {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	statuses := []NodeStatus{}
+	for _, node := range cluster.Nodes {
+		status, _ := cluster.status(node.GetMainPubkey())
+		status.Active = node == cluster.ActiveNode
+		status.Draining = cluster.draining[status.Pubkey]
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (cluster *LNDCluster) activeNode() LightningClientWrapper {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	return cluster.ActiveNode
+}
+
+// selectNode asks the routing strategy for one of the healthy nodes that are not draining,
+// the active node comes first. Until the first liveness check is done, or when no node
+// is available, the active node is used.
+func (cluster *LNDCluster) selectNode(req RouteRequest) LightningClientWrapper {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	nodes := []NodeStatus{}
+	for _, status := range cluster.candidates("") {
+		if status.Node == cluster.ActiveNode {
+			nodes = append([]NodeStatus{status}, nodes...)
+			continue
 		}
-		//if we get here, break because we have an active node
-		//either the one which was already active
-		//or the new one
-		break
+		nodes = append(nodes, status)
+	}
+	if len(nodes) == 0 {
+		return cluster.ActiveNode
 	}
+	strategy := cluster.Strategy
+	if strategy == nil {
+		strategy = &FailoverStrategy{}
+	}
+	return strategy.SelectNode(req, nodes)
 }
+
 func (cluster *LNDCluster) ListChannels(ctx context.Context, req *lnrpc.ListChannelsRequest, options ...grpc.CallOption) (*lnrpc.ListChannelsResponse, error) {
-	return cluster.ActiveNode.ListChannels(ctx, req, options...)
+	return cluster.activeNode().ListChannels(ctx, req, options...)
 }
 
 func (cluster *LNDCluster) SendPaymentSync(ctx context.Context, req *lnrpc.SendRequest, options ...grpc.CallOption) (*lnrpc.SendResponse, error) {
-	return cluster.ActiveNode.SendPaymentSync(ctx, req, options...)
+	resp, _, err := cluster.SendPaymentSyncOnNode(ctx, req, hex.EncodeToString(req.Dest), options...)
+	return resp, err
 }
 
-func (cluster *LNDCluster) AddInvoice(ctx context.Context, req *lnrpc.Invoice, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
-	return cluster.ActiveNode.AddInvoice(ctx, req, options...)
+func (cluster *LNDCluster) SendPaymentSyncOnNode(ctx context.Context, req *lnrpc.SendRequest, destination string, options ...grpc.CallOption) (*lnrpc.SendResponse, string, error) {
+	node := cluster.selectNode(RouteRequest{
+		Kind:        RoutePayment,
+		Amount:      req.Amt,
+		Destination: destination,
+	})
+	resp, err := node.SendPaymentSync(ctx, req, options...)
+	return resp, node.GetMainPubkey(), err
 }
 
-func (cluster *LNDCluster) SubscribeInvoices(ctx context.Context, req *lnrpc.InvoiceSubscription, options ...grpc.CallOption) (SubscribeInvoicesWrapper, error) {
-	return nil, fmt.Errorf("not implemented")
+func (cluster *LNDCluster) AddInvoice(ctx context.Context, req *lnrpc.Invoice, options ...grpc.CallOption) (*lnrpc.AddInvoiceResponse, error) {
+	resp, _, err := cluster.AddInvoiceOnNode(ctx, req, options...)
+	return resp, err
 }
 
+// SubscribePayment tracks a payment on every node until one of them knows it
 func (cluster *LNDCluster) SubscribePayment(ctx context.Context, req *routerrpc.TrackPaymentRequest, options ...grpc.CallOption) (SubscribePaymentWrapper, error) {
-	return nil, fmt.Errorf("not implemented")
+	return cluster.SubscribePaymentOnNode(ctx, "", req, options...)
 }
 
 func (cluster *LNDCluster) GetInfo(ctx context.Context, req *lnrpc.GetInfoRequest, options ...grpc.CallOption) (*lnrpc.GetInfoResponse, error) {
-	return cluster.ActiveNode.GetInfo(ctx, req, options...)
+	return cluster.activeNode().GetInfo(ctx, req, options...)
 }
 
 func (cluster *LNDCluster) DecodeBolt11(ctx context.Context, bolt11 string, options ...grpc.CallOption) (*lnrpc.PayReq, error) {
-	return cluster.ActiveNode.DecodeBolt11(ctx, bolt11, options...)
+	return cluster.activeNode().DecodeBolt11(ctx, bolt11, options...)
 }
 
 func (cluster *LNDCluster) IsIdentityPubkey(pubkey string) (isOurPubkey bool) {
@@ -0,0 +1,262 @@
+package natsjs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/getAlby/lndhub.go/events"
+	"github.com/labstack/gommon/log"
+	"github.com/nats-io/nats.go"
+	"github.com/ziflex/lecho/v3"
+)
+
+const (
+	contentTypeJSON = "application/json"
+
+	// A safety mechanism, analogous to the RabbitMQ delivery-limit. If our code
+	// would requeue failed messages we want to limit the amount of redeliveries
+	// as to avoid infinite loops.
+	defaultMaxDeliver = 10
+
+	fetchBatchSize = 10
+	fetchMaxWait   = 5 * time.Second
+)
+
+// Broker implements events.Broker on top of NATS JetStream.
+//
+// Every topic is backed by a stream with the same name that captures the subjects "<topic>.>".
+// Routing keys are appended to the topic to form the subject, e.g. "lnd_invoice.invoice.incoming.settled".
+// Consumers are durable pull consumers, so multiple lndhub.go instances listening with the same
+// consumer name will spread the load of messages between them.
+type Broker struct {
+	conn *nats.Conn
+	js   nats.JetStreamContext
+
+	maxDeliver int
+	logger     *lecho.Logger
+}
+
+type DialOption = func(*Broker)
+
+func WithLogger(logger *lecho.Logger) DialOption {
+	return func(broker *Broker) {
+		broker.logger = logger
+	}
+}
+
+func WithMaxDeliver(maxDeliver int) DialOption {
+	return func(broker *Broker) {
+		broker.maxDeliver = maxDeliver
+	}
+}
+
+// Dial connects to the NATS server(s) at uri (comma separated for multiple servers)
+func Dial(uri string, options ...DialOption) (*Broker, error) {
+	broker := &Broker{
+		maxDeliver: defaultMaxDeliver,
+		logger: lecho.New(
+			os.Stdout,
+			lecho.WithLevel(log.DEBUG),
+			lecho.WithTimestamp(),
+		),
+	}
+	for _, opt := range options {
+		opt(broker)
+	}
+
+	conn, err := nats.Connect(uri,
+		nats.Name("lndhub.go"),
+		// keep on trying to reconnect, the consumers will resume once we are back
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				broker.logger.Errorf("nats: disconnected: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(_ *nats.Conn) {
+			broker.logger.Info("nats: succesfully reconnected")
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	broker.conn = conn
+	broker.js = js
+
+	return broker, nil
+}
+
+// NewClient creates an events client that uses NATS JetStream as message broker
+func NewClient(broker *Broker, options ...events.ClientOption) (events.Client, error) {
+	return events.NewClient(broker, options...)
+}
+
+func (broker *Broker) Declare(ctx context.Context, topic string) error {
+	_, err := broker.js.StreamInfo(topic, nats.Context(ctx))
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return err
+	}
+	_, err = broker.js.AddStream(&nats.StreamConfig{
+		Name:     topic,
+		Subjects: []string{topic + ".>"},
+		// File storage survives server restarts, like durable exchanges and queues do
+		Storage: nats.FileStorage,
+	}, nats.Context(ctx))
+	if errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+		// another instance created it in the meantime
+		return nil
+	}
+	return err
+}
+
+func (broker *Broker) Bind(ctx context.Context, topic, routingKey, consumerName string) error {
+	err := broker.Declare(ctx, topic)
+	if err != nil {
+		return err
+	}
+	_, err = broker.js.AddConsumer(topic, broker.consumerConfig(topic, routingKey, consumerName), nats.Context(ctx))
+	if errors.Is(err, nats.ErrConsumerNameAlreadyInUse) {
+		return nil
+	}
+	return err
+}
+
+// consumerConfig is the configuration of the durable consumers created by Bind and Listen
+func (broker *Broker) consumerConfig(topic, routingKey, consumerName string) *nats.ConsumerConfig {
+	return &nats.ConsumerConfig{
+		Durable:       consumerName,
+		FilterSubject: Subject(topic, routingKey),
+		AckPolicy:     nats.AckExplicitPolicy,
+		DeliverPolicy: nats.DeliverAllPolicy,
+		MaxDeliver:    broker.maxDeliver,
+	}
+}
+
+func (broker *Broker) Listen(ctx context.Context, topic, routingKey, consumerName string) (<-chan events.Delivery, error) {
+	err := broker.Bind(ctx, topic, routingKey, consumerName)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := broker.js.PullSubscribe(
+		Subject(topic, routingKey),
+		consumerName,
+		nats.BindStream(topic),
+		nats.ManualAck(),
+		nats.AckExplicit(),
+		nats.DeliverAll(),
+		nats.MaxDeliver(broker.maxDeliver),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make(chan events.Delivery)
+	go func() {
+		defer close(deliveries)
+		// Unsubscribe only removes the interest of this instance, the durable consumer stays around
+		defer sub.Unsubscribe()
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			if broker.conn.IsClosed() {
+				broker.logger.Error("nats: connection closed, stopping consumer")
+				return
+			}
+
+			fetchCtx, cancel := context.WithTimeout(ctx, fetchMaxWait)
+			msgs, err := sub.Fetch(fetchBatchSize, nats.Context(fetchCtx))
+			cancel()
+			if err != nil {
+				if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.Canceled) {
+					continue
+				}
+				// most likely we are reconnecting, wait a bit before trying again
+				broker.logger.Errorf("nats: error fetching messages with subject %s: %v", Subject(topic, routingKey), err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+
+			for i, msg := range msgs {
+				select {
+				case deliveries <- &delivery{msg: msg, topic: topic}:
+				case <-ctx.Done():
+					// hand the rest of the batch back, so it doesn't have to wait for the ack wait period
+					for _, undelivered := range msgs[i:] {
+						undelivered.Nak()
+					}
+					return
+				}
+			}
+		}
+	}()
+
+	return deliveries, nil
+}
+
+func (broker *Broker) Publish(ctx context.Context, topic, routingKey string, body []byte) error {
+	msg := nats.NewMsg(Subject(topic, routingKey))
+	msg.Header.Set("Content-Type", contentTypeJSON)
+	msg.Data = body
+
+	_, err := broker.js.PublishMsg(msg, nats.Context(ctx))
+	return err
+}
+
+func (broker *Broker) Close() error {
+	// Drain lets pending acks go out before the connection is closed
+	return broker.conn.Drain()
+}
+
+// Subject translates a topic and an AMQP style routing key into a NATS subject.
+// The AMQP "#" wildcard maps to the NATS ">" wildcard, "*" works the same in both.
+func Subject(topic, routingKey string) string {
+	words := strings.Split(routingKey, ".")
+	for i, word := range words {
+		if word == "#" {
+			words[i] = ">"
+			// ">" must be the last token of a NATS subject
+			words = words[:i+1]
+			break
+		}
+	}
+	return topic + "." + strings.Join(words, ".")
+}
+
+type delivery struct {
+	msg   *nats.Msg
+	topic string
+}
+
+func (d *delivery) Body() []byte { return d.msg.Data }
+
+func (d *delivery) RoutingKey() string { return strings.TrimPrefix(d.msg.Subject, d.topic+".") }
+
+func (d *delivery) Ack() error { return d.msg.Ack() }
+
+func (d *delivery) InProgress() error { return d.msg.InProgress() }
+
+func (d *delivery) Nack(requeue bool) error {
+	if requeue {
+		return d.msg.Nak()
+	}
+	// Term tells the server to never redeliver the message
+	return d.msg.Term()
+}
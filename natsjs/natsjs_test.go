@@ -0,0 +1,291 @@
+package natsjs_test
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getAlby/lndhub.go/common"
+	"github.com/getAlby/lndhub.go/db/models"
+	"github.com/getAlby/lndhub.go/events"
+	"github.com/getAlby/lndhub.go/natsjs"
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runJetStreamServer(t *testing.T) *server.Server {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+func TestSubject(t *testing.T) {
+	assert.Equal(t, "lnd_invoice.invoice.incoming.settled", natsjs.Subject("lnd_invoice", "invoice.incoming.settled"))
+	assert.Equal(t, "lnd_payment.payment.outgoing.*", natsjs.Subject("lnd_payment", "payment.outgoing.*"))
+	assert.Equal(t, "lndhub_invoice.invoice.>", natsjs.Subject("lndhub_invoice", "invoice.#"))
+}
+
+func TestSubscribeToLndInvoices(t *testing.T) {
+	srv := runJetStreamServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker, err := natsjs.Dial(srv.ClientURL())
+	require.NoError(t, err)
+	client, err := natsjs.NewClient(broker)
+	require.NoError(t, err)
+	defer client.Close()
+
+	rHash := []byte("69e5f0f0590be75e30f671d56afe1d55")
+	failingHash := []byte("ffff0f0590be75e30f671d56afe1d55")
+
+	require.NoError(t, broker.Declare(ctx, "lnd_invoice"))
+	// badly formatted message, should be dropped
+	require.NoError(t, broker.Publish(ctx, "lnd_invoice", "invoice.incoming.settled", []byte("{not json")))
+	// handler fails on this one, should be dropped as well
+	payload, err := json.Marshal(&lnrpc.Invoice{RHash: failingHash, State: lnrpc.Invoice_SETTLED})
+	require.NoError(t, err)
+	require.NoError(t, broker.Publish(ctx, "lnd_invoice", "invoice.incoming.settled", payload))
+	// not matching the consumer's routing key, should never reach the handler
+	payload, err = json.Marshal(&lnrpc.Invoice{RHash: []byte("unrelated"), State: lnrpc.Invoice_OPEN})
+	require.NoError(t, err)
+	require.NoError(t, broker.Publish(ctx, "lnd_invoice", "invoice.incoming.open", payload))
+	payload, err = json.Marshal(&lnrpc.Invoice{RHash: rHash, State: lnrpc.Invoice_SETTLED})
+	require.NoError(t, err)
+	require.NoError(t, broker.Publish(ctx, "lnd_invoice", "invoice.incoming.settled", payload))
+
+	received := make(chan *lnrpc.Invoice, 3)
+	go func() {
+		err := client.SubscribeToLndInvoices(ctx, func(ctx context.Context, invoice *lnrpc.Invoice) error {
+			received <- invoice
+			if string(invoice.RHash) == string(failingHash) {
+				return errors.New("handler failed")
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+	}()
+
+	for _, expected := range [][]byte{failingHash, rHash} {
+		select {
+		case invoice := <-received:
+			assert.Equal(t, hex.EncodeToString(expected), hex.EncodeToString(invoice.RHash))
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for invoice")
+		}
+	}
+
+	// everything has been acked or terminated, nothing should be redelivered
+	conn, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+	defer conn.Close()
+	js, err := conn.JetStream()
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		info, err := js.ConsumerInfo("lnd_invoice", "lnd_invoice_consumer")
+		return err == nil && info.NumAckPending == 0 && info.NumPending == 0
+	}, 5*time.Second, 100*time.Millisecond)
+	select {
+	case invoice := <-received:
+		t.Fatalf("unexpected redelivery of %s", invoice.RHash)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestNackWithRequeueRedelivers(t *testing.T) {
+	srv := runJetStreamServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker, err := natsjs.Dial(srv.ClientURL())
+	require.NoError(t, err)
+	defer broker.Close()
+
+	deliveries, err := broker.Listen(ctx, "lnd_payment", "payment.outgoing.*", "lnd_payment_consumer")
+	require.NoError(t, err)
+	require.NoError(t, broker.Publish(ctx, "lnd_payment", "payment.outgoing.succeeded", []byte("payment")))
+
+	first := <-deliveries
+	assert.Equal(t, "payment", string(first.Body()))
+	require.NoError(t, first.Nack(true))
+
+	select {
+	case second := <-deliveries:
+		assert.Equal(t, "payment", string(second.Body()))
+		require.NoError(t, second.Ack())
+	case <-time.After(10 * time.Second):
+		t.Fatal("message was not redelivered")
+	}
+}
+
+func TestStartPublishInvoices(t *testing.T) {
+	srv := runJetStreamServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker, err := natsjs.Dial(srv.ClientURL())
+	require.NoError(t, err)
+	client, err := natsjs.NewClient(broker)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+	defer conn.Close()
+	sub, err := conn.SubscribeSync("lndhub_invoice.>")
+	require.NoError(t, err)
+
+	in := make(chan models.Invoice)
+	out := make(chan models.Invoice)
+	go func() {
+		err := client.StartPublishInvoices(ctx,
+			func() (chan models.Invoice, chan models.Invoice, error) { return in, out, nil },
+			func(ctx context.Context, w io.Writer, invoice models.Invoice) error {
+				return json.NewEncoder(w).Encode(invoice)
+			},
+		)
+		assert.ErrorIs(t, err, context.Canceled)
+	}()
+
+	in <- models.Invoice{RHash: "incoming_hash", Type: common.InvoiceTypeIncoming, State: common.InvoiceStateSettled}
+	out <- models.Invoice{RHash: "outgoing_hash", Type: common.InvoiceTypeOutgoing, State: common.InvoiceStateError}
+
+	for _, expected := range []struct{ subject, rHash string }{
+		{"lndhub_invoice.invoice.incoming.settled", "incoming_hash"},
+		{"lndhub_invoice.invoice.outgoing.error", "outgoing_hash"},
+	} {
+		msg, err := sub.NextMsg(5 * time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, expected.subject, msg.Subject)
+		invoice := models.Invoice{}
+		require.NoError(t, json.Unmarshal(msg.Data, &invoice))
+		assert.Equal(t, expected.rHash, invoice.RHash)
+	}
+
+	// published messages are persisted in the stream as well
+	js, err := conn.JetStream()
+	require.NoError(t, err)
+	info, err := js.StreamInfo("lndhub_invoice")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), info.State.Msgs)
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	srv := runJetStreamServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deadLettered := make(chan events.FailedDelivery, 1)
+	broker, err := natsjs.Dial(srv.ClientURL())
+	require.NoError(t, err)
+	client, err := natsjs.NewClient(broker,
+		events.WithRetryPolicy(events.RetryPolicy{Delays: []time.Duration{100 * time.Millisecond}}),
+		events.WithDeadLetterHook(func(ctx context.Context, failed events.FailedDelivery) {
+			deadLettered <- failed
+		}),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	flakyHash := []byte("69e5f0f0590be75e30f671d56afe1d55")
+	poisonHash := []byte("ffff0f0590be75e30f671d56afe1d55")
+
+	require.NoError(t, broker.Declare(ctx, "lnd_invoice"))
+	payload, err := json.Marshal(&lnrpc.Invoice{RHash: flakyHash, State: lnrpc.Invoice_SETTLED})
+	require.NoError(t, err)
+	require.NoError(t, broker.Publish(ctx, "lnd_invoice", "invoice.incoming.settled", payload))
+	payload, err = json.Marshal(&lnrpc.Invoice{RHash: poisonHash, State: lnrpc.Invoice_SETTLED})
+	require.NoError(t, err)
+	require.NoError(t, broker.Publish(ctx, "lnd_invoice", "invoice.incoming.settled", payload))
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	healed := false
+	go func() {
+		err := client.SubscribeToLndInvoices(ctx, func(ctx context.Context, invoice *lnrpc.Invoice) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[string(invoice.RHash)]++
+			// the flaky invoice succeeds on the first retry, the poison one only after it was healed
+			if string(invoice.RHash) == string(flakyHash) && attempts[string(flakyHash)] > 1 {
+				return nil
+			}
+			if string(invoice.RHash) == string(poisonHash) && healed {
+				return nil
+			}
+			return errors.New("handler failed")
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+	}()
+
+	var failed events.FailedDelivery
+	select {
+	case failed = <-deadLettered:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for dead letter")
+	}
+	assert.Equal(t, "lnd_invoice", failed.Topic)
+	assert.Equal(t, "invoice.incoming.settled", failed.RoutingKey)
+	assert.Equal(t, "lnd_invoice_consumer", failed.Consumer)
+	assert.Equal(t, 2, failed.Attempts)
+	assert.Equal(t, "handler failed", failed.Error)
+
+	mu.Lock()
+	assert.Equal(t, 2, attempts[string(flakyHash)])
+	assert.Equal(t, 2, attempts[string(poisonHash)])
+	healed = true
+	mu.Unlock()
+
+	// inspecting keeps the dead letter around
+	seen := []string{}
+	err = client.WalkDeadLetters(ctx, time.Second, func(fd events.FailedDelivery) events.DeadLetterAction {
+		seen = append(seen, fd.ID)
+		return events.DeadLetterKeep
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{failed.ID}, seen)
+
+	seen = []string{}
+	err = client.WalkDeadLetters(ctx, time.Second, func(fd events.FailedDelivery) events.DeadLetterAction {
+		seen = append(seen, fd.ID)
+		return events.DeadLetterRequeue
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{failed.ID}, seen)
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts[string(poisonHash)] == 3
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// nothing left after the requeue
+	seen = []string{}
+	err = client.WalkDeadLetters(ctx, time.Second, func(fd events.FailedDelivery) events.DeadLetterAction {
+		seen = append(seen, fd.ID)
+		return events.DeadLetterKeep
+	})
+	require.NoError(t, err)
+	assert.Empty(t, seen)
+}
@@ -25,6 +25,7 @@ type AMQPClient interface {
 	Listen(ctx context.Context, exchange string, routingKey string, queueName string, options ...AMQPListenOptions) (<-chan amqp.Delivery, error)
 	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
 	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	DeclareQueue(exchange, routingKey, queueName string) error
 	Close() error
 }
 
@@ -277,7 +278,37 @@ func (c *defaultAMQPCLient) consume(ctx context.Context, exchange string, routin
 		opts = opt(opts)
 	}
 
-	err := c.consumeChannel.ExchangeDeclare(
+	queue, err := declareQueue(c.consumeChannel, exchange, routingKey, queueName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.consumeChannel.Consume(
+		queue.Name,
+		"",
+		opts.AutoAck,
+		opts.Exclusive,
+		false,
+		opts.Wait,
+		nil,
+	)
+}
+
+// DeclareQueue declares a durable queue bound to the exchange without consuming from it,
+// so messages published to the exchange are kept until a consumer shows up.
+func (c *defaultAMQPCLient) DeclareQueue(exchange, routingKey, queueName string) error {
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	_, err = declareQueue(ch, exchange, routingKey, queueName, ListenOptions{Durable: true})
+	return err
+}
+
+func declareQueue(ch *amqp.Channel, exchange string, routingKey string, queueName string, opts ListenOptions) (amqp.Queue, error) {
+	err := ch.ExchangeDeclare(
 		exchange,
 		// topic is a type of exchange that allows routing messages to different queue's bases on a routing key
 		"topic",
@@ -293,10 +324,10 @@ func (c *defaultAMQPCLient) consume(ctx context.Context, exchange string, routin
 		nil,
 	)
 	if err != nil {
-		return nil, err
+		return amqp.Queue{}, err
 	}
 
-	queue, err := c.consumeChannel.QueueDeclare(
+	queue, err := ch.QueueDeclare(
 		queueName,
 		// Durable and Non-Auto-Deleted queues will survive server restarts and remain
 		// declared when there are no remaining bindings.
@@ -316,10 +347,10 @@ func (c *defaultAMQPCLient) consume(ctx context.Context, exchange string, routin
 		},
 	)
 	if err != nil {
-		return nil, err
+		return amqp.Queue{}, err
 	}
 
-	err = c.consumeChannel.QueueBind(
+	err = ch.QueueBind(
 		queue.Name,
 		routingKey,
 		exchange,
@@ -329,18 +360,10 @@ func (c *defaultAMQPCLient) consume(ctx context.Context, exchange string, routin
 		nil,
 	)
 	if err != nil {
-		return nil, err
+		return amqp.Queue{}, err
 	}
 
-	return c.consumeChannel.Consume(
-		queue.Name,
-		"",
-		opts.AutoAck,
-		opts.Exclusive,
-		false,
-		opts.Wait,
-		nil,
-	)
+	return queue, nil
 }
 
 func (c *defaultAMQPCLient) PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error {
@@ -132,6 +132,20 @@ func (mr *MockAMQPClientMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockAMQPClient)(nil).Close))
 }
 
+// DeclareQueue mocks base method.
+func (m *MockAMQPClient) DeclareQueue(arg0, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeclareQueue", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeclareQueue indicates an expected call of DeclareQueue.
+func (mr *MockAMQPClientMockRecorder) DeclareQueue(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeclareQueue", reflect.TypeOf((*MockAMQPClient)(nil).DeclareQueue), arg0, arg1, arg2)
+}
+
 // ExchangeDeclare mocks base method.
 func (m *MockAMQPClient) ExchangeDeclare(arg0, arg1 string, arg2, arg3, arg4, arg5 bool, arg6 amqp091.Table) error {
 	m.ctrl.T.Helper()
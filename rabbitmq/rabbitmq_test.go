@@ -30,10 +30,21 @@ func TestFinalizedInitializedPayments(t *testing.T) {
 
 	ch := make(chan amqp.Delivery, 2)
 	amqpClient.EXPECT().
-		Listen(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Listen(gomock.Any(), gomock.Eq("lnd_payment"), gomock.Any(), gomock.Any(), gomock.Any()).
 		Times(1).
 		Return(ch, nil)
 
+	// requeued dead letters
+	amqpClient.EXPECT().
+		Listen(gomock.Any(), gomock.Eq("lndhub_retry"), gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(1).
+		Return(make(chan amqp.Delivery), nil)
+
+	amqpClient.EXPECT().
+		DeclareQueue(gomock.Eq("lndhub_dead_letter"), gomock.Any(), gomock.Any()).
+		Times(1).
+		Return(nil)
+
 	firstHash := "69e5f0f0590be75e30f671d56afe1d55"
 	secondHash := "ffff0f0590be75e30f671d56afe1d55"
 